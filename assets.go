@@ -0,0 +1,21 @@
+// Package synbioblast holds the query server's pages and static files. They
+// live at the root of the repo, next to the docs that show them, and go:embed
+// can't reach up out of cmd/synbioblast to get them.
+//
+// The Go API for embedding synbioblast is in pkg/blast, pkg/store and
+// pkg/slurp, and pkg/synbiotest stands in for its infrastructure in tests.
+package synbioblast
+
+import "embed"
+
+// Assets are the pages and static files built into the query server, so it
+// runs from anywhere.
+//
+//go:embed *.html openapi.json static
+var Assets embed.FS
+
+// DemoComponents are the components the query server's -demo mode serves,
+// a page of SPARQL XML results fetched from synbiohub.org.
+//
+//go:embed sample_sequences.xml
+var DemoComponents []byte
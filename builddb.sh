@@ -1,4 +1,5 @@
 #!/bin/bash
+set -eo pipefail
 
 SYNBIOBLASTDIR="${SYNBIOBLASTDIR:-/var/synbioblast}"
 echo "SynBioBLAST dir: $SYNBIOBLASTDIR"
@@ -9,6 +10,151 @@ echo "Storing blastdb files in $BLASTDB"
 DBNAME="${DBNAME:-SynBioHub}"
 echo "Using db name of $DBNAME"
 
+# each build gets its own name so the query server can switch to it while
+# queries against the old one are still running
+VERSION="$(date -u +%Y%m%dT%H%M%SZ)"
+echo "Building version $VERSION"
+
 TITLE="$DBNAME (generated $(date))"
 
-find "$SYNBIOBLASTDIR/fastas" -mindepth 1 -name '*.fasta' -type f -exec cat {} \; | ./makeblastdb -dbtype nucl -title "$TITLE" -out "$BLASTDB/$DBNAME" -in -
+# tell a Slack or Matrix incoming webhook how the build went, if
+# NOTIFY_WEBHOOK is set
+notify() {
+	if [ -n "$NOTIFY_WEBHOOK" ]; then
+		curl -fsS -m 10 -H 'Content-Type: application/json' -d "{\"text\": \"$1\"}" "$NOTIFY_WEBHOOK" > /dev/null ||
+			echo "Couldn't notify $NOTIFY_WEBHOOK" >&2
+	fi
+}
+trap 'notify "Building $DBNAME version $VERSION failed, see the logs"' ERR
+
+# how long each step took, written for node_exporter's textfile collector at
+# the end if METRICS_TEXTFILE_DIR is set
+METRICS=""
+STEP_START=$SECONDS
+step_done() {
+	echo "Step $1 done in $((SECONDS - STEP_START))s"
+	METRICS+="synbioblast_builddb_step_duration_seconds{step=\"$1\"} $((SECONDS - STEP_START))"$'\n'
+	STEP_START=$SECONDS
+}
+
+# fastas are sharded into subdirectories, find goes through them all
+find "$SYNBIOBLASTDIR/fastas" -mindepth 1 -name '*.fasta' -type f -exec cat {} + | ./makeblastdb -dbtype nucl -title "$TITLE" -out "$BLASTDB/$DBNAME-$VERSION" -in -
+step_done makeblastdb
+
+# the components using each sequence as the db was built, which searches of
+# this version resolve their hits against once it's no longer the current
+# one, if there's a synbioblast binary to write it with. It's configured
+# like the server, e.g. from SYNBIOBLAST_* environment variables.
+if [ -x ./synbioblast ]; then
+	echo "Snapshotting components"
+	./synbioblast admin snapshot "$BLASTDB/$DBNAME-$VERSION.uris"
+	step_done snapshot
+else
+	echo "Not snapshotting components, go build ./cmd/synbioblast to search this version once it's replaced"
+fi
+
+# the k-mer index the query server screens queries against, if buildkmers
+# has been built
+if [ -x ./buildkmers ]; then
+	echo "Building k-mer index"
+	./buildkmers -fastas.path "$SYNBIOBLASTDIR/fastas" -out "$BLASTDB/$DBNAME-$VERSION.kmi"
+	step_done buildkmers
+else
+	echo "Not building a k-mer index, go build ./cmd/buildkmers to build one"
+fi
+
+# vsearch gets its own db of the same sequences, if there's a vsearch to
+# build it with
+VSEARCH="${VSEARCH:-$(command -v vsearch || true)}"
+if [ -n "$VSEARCH" ]; then
+	echo "Building vsearch db with $VSEARCH"
+	find "$SYNBIOBLASTDIR/fastas" -mindepth 1 -name '*.fasta' -type f -exec cat {} + > "$BLASTDB/$DBNAME-$VERSION.fasta.tmp"
+	"$VSEARCH" --quiet --makeudb_usearch "$BLASTDB/$DBNAME-$VERSION.fasta.tmp" --output "$BLASTDB/$DBNAME-$VERSION.udb"
+	rm "$BLASTDB/$DBNAME-$VERSION.fasta.tmp"
+	step_done vsearch
+else
+	echo "Not building a vsearch db, set VSEARCH to the vsearch executable to build one"
+fi
+
+# with CLUSTER_IDENTITY set, e.g. to 0.97, sequences at least that identical
+# are clustered with vsearch, or cd-hit-est if there's no vsearch, and the
+# representatives get a blast db of their own, <db>.reps, for clustered
+# searches. <db>.clusters lists the other members of each cluster, a
+# representative's hash and a member's hash per line.
+CD_HIT="${CD_HIT:-$(command -v cd-hit-est || true)}"
+if [ -n "$CLUSTER_IDENTITY" ]; then
+	ALL="$BLASTDB/$DBNAME-$VERSION.all.tmp"
+	REPS="$BLASTDB/$DBNAME-$VERSION.reps.tmp"
+	find "$SYNBIOBLASTDIR/fastas" -mindepth 1 -name '*.fasta' -type f -exec cat {} + > "$ALL"
+	if [ -n "$VSEARCH" ]; then
+		echo "Clustering at $CLUSTER_IDENTITY identity with $VSEARCH"
+		"$VSEARCH" --quiet --cluster_fast "$ALL" --id "$CLUSTER_IDENTITY" --centroids "$REPS" --uc "$REPS.uc"
+		awk -F '\t' '$1 == "H" { print $10 "\t" $9 }' "$REPS.uc" > "$BLASTDB/$DBNAME-$VERSION.clusters.tmp"
+		rm "$REPS.uc"
+	elif [ -n "$CD_HIT" ]; then
+		# cd-hit-est's default word size needs an identity of 0.95 or
+		# more, lower ones need -n in CD_HIT_ARGS
+		echo "Clustering at $CLUSTER_IDENTITY identity with $CD_HIT"
+		"$CD_HIT" -i "$ALL" -o "$REPS" -c "$CLUSTER_IDENTITY" -d 0 -M 0 -T 0 $CD_HIT_ARGS > /dev/null
+		awk '
+			function flush() { for (i = 0; i < n; i++) print rep "\t" members[i]; n = 0 }
+			/^>Cluster/ { flush(); next }
+			{ name = $3; sub(/^>/, "", name); sub(/\.\.\.$/, "", name) }
+			$NF == "*" { rep = name; next }
+			{ members[n++] = name }
+			END { flush() }
+		' "$REPS.clstr" > "$BLASTDB/$DBNAME-$VERSION.clusters.tmp"
+		rm "$REPS.clstr"
+	else
+		echo "CLUSTER_IDENTITY is set but there's no vsearch or cd-hit-est to cluster with, set VSEARCH or CD_HIT" >&2
+		exit 1
+	fi
+	./makeblastdb -dbtype nucl -title "$TITLE, cluster representatives" -out "$BLASTDB/$DBNAME-$VERSION.reps" -in "$REPS"
+	# written last, the server only offers clustered searches once it's there
+	mv "$BLASTDB/$DBNAME-$VERSION.clusters.tmp" "$BLASTDB/$DBNAME-$VERSION.clusters"
+	echo "$(grep -c '^>' "$REPS") representatives of $(grep -c '^>' "$ALL") sequences"
+	rm "$ALL" "$REPS"
+	step_done cluster
+else
+	echo "Not clustering, set CLUSTER_IDENTITY to cluster near-identical sequences"
+fi
+
+# protein sequences go into a diamond db of the same name, if there's a
+# diamond to build it with
+DIAMOND="${DIAMOND:-$(command -v diamond || true)}"
+if [ -n "$DIAMOND" ] && [ -d "$SYNBIOBLASTDIR/proteins" ]; then
+	echo "Building protein db with $DIAMOND"
+	find "$SYNBIOBLASTDIR/proteins" -mindepth 1 -name '*.fasta' -type f -exec cat {} + | "$DIAMOND" makedb --quiet -d "$BLASTDB/$DBNAME-$VERSION"
+	step_done diamond
+else
+	echo "Not building a protein db, set DIAMOND to the diamond executable to build one"
+fi
+
+# point the query server at the new db, it checks every -blastdb.pollInterval
+echo "$DBNAME-$VERSION" > "$BLASTDB/$DBNAME.current.tmp"
+mv "$BLASTDB/$DBNAME.current.tmp" "$BLASTDB/$DBNAME.current"
+
+# keep the previous version for queries that were still running against it,
+# and with KEEP_VERSIONS more than 2, older ones to search again later
+KEEP_VERSIONS="${KEEP_VERSIONS:-2}"
+for OLD in $(ls "$BLASTDB" | sed -n "s/^$DBNAME-\([0-9]\{8\}T[0-9]\{6\}Z\)\..*/\1/p" | sort -u | head -n -"$KEEP_VERSIONS"); do
+	echo "Removing version $OLD"
+	rm -f "$BLASTDB/$DBNAME-$OLD".*
+done
+
+if [ -n "$METRICS_TEXTFILE_DIR" ]; then
+	{
+		echo "# HELP synbioblast_builddb_step_duration_seconds How long each step of the last db build took."
+		echo "# TYPE synbioblast_builddb_step_duration_seconds gauge"
+		printf "%s" "$METRICS"
+		echo "# HELP synbioblast_builddb_duration_seconds How long the last db build took."
+		echo "# TYPE synbioblast_builddb_duration_seconds gauge"
+		echo "synbioblast_builddb_duration_seconds $SECONDS"
+		echo "# HELP synbioblast_builddb_last_success_timestamp_seconds When the last db build finished."
+		echo "# TYPE synbioblast_builddb_last_success_timestamp_seconds gauge"
+		echo "synbioblast_builddb_last_success_timestamp_seconds $(date +%s)"
+	} > "$METRICS_TEXTFILE_DIR/synbioblast_builddb.prom.tmp"
+	mv "$METRICS_TEXTFILE_DIR/synbioblast_builddb.prom.tmp" "$METRICS_TEXTFILE_DIR/synbioblast_builddb.prom"
+fi
+
+notify "Built $DBNAME version $VERSION in ${SECONDS}s"
@@ -0,0 +1,543 @@
+// Package client is a Go client for the SynBioBLAST HTTP API.
+//
+// The types here mirror the schemas in openapi.json, which the server also
+// serves at /api/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to a single SynBioBLAST server.
+type Client struct {
+	// BaseURL is the root of the server, e.g. http://localhost:9090
+	BaseURL string
+
+	// HTTPClient is used to make requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// SearchRequest is a search to submit as a job.
+type SearchRequest struct {
+	Sequence string `json:"sequence"`
+
+	// Description limits the hits to components whose title or description
+	// contain all of these words.
+	Description string `json:"description,omitempty"`
+
+	// Roles limits the hits to components with one of these roles, named
+	// like "promoter" or "cds", or as Sequence Ontology terms.
+	Roles []string `json:"roles,omitempty"`
+
+	// Containment limits the hits to ones overlapping the query like this:
+	// "near-identical", "query-contains-part", "part-contains-query" or
+	// "partial-overlap".
+	Containment []string `json:"containment,omitempty"`
+
+	// Threads is the number of threads blastn may use, the server's default
+	// if 0.
+	Threads int `json:"threads,omitempty"`
+
+	// Aligner is "blastn" (the default), "diamond" for protein searches or
+	// "vsearch" for quick searches for close matches, if the server has
+	// them.
+	Aligner string `json:"aligner,omitempty"`
+
+	// Circular searches the sequence as a circular one, e.g. a plasmid, so
+	// hits spanning its origin are found. They have QueryTo < QueryFrom.
+	Circular bool `json:"circular,omitempty"`
+
+	// Clustered searches the representatives of clusters of near-identical
+	// sequences, and adds a hit for each other member of a hit cluster.
+	// Only servers whose db was clustered take it.
+	Clustered bool `json:"clustered,omitempty"`
+
+	// Subject is FASTA of sequences to search instead of the server's db,
+	// to compare two constructs say. Hits then have a Subject rather than
+	// a SeqHash and URIs. Only servers with makeblastdb take it.
+	Subject string `json:"subject,omitempty"`
+
+	// DBVersion searches an older version of the server's db, one of its
+	// stats' dbVersions, to reproduce a published result say. Hits are
+	// resolved to the components that version was built with.
+	DBVersion string `json:"dbVersion,omitempty"`
+
+	// Matrix, GapOpen and GapExtend set the scoring of diamond searches,
+	// diamond's defaults if empty.
+	Matrix    string `json:"matrix,omitempty"`
+	GapOpen   int    `json:"gapOpen,omitempty"`
+	GapExtend int    `json:"gapExtend,omitempty"`
+
+	// Callback is a URL the server posts to once a submitted job has
+	// finished, so there's no need to Wait for it. Search ignores it.
+	Callback string `json:"callback,omitempty"`
+
+	// Email is an address the server mails once a submitted job has
+	// finished, if it has a mail server. Search ignores it.
+	Email string `json:"email,omitempty"`
+}
+
+// QueryInput is what a query was submitted as, Query being the bare sequence
+// the server boiled it down to.
+type QueryInput struct {
+	// Format is "fasta", "genbank" or "raw"
+	Format  string `json:"format"`
+	Header  string `json:"header,omitempty"`
+	Removed int    `json:"removed,omitempty"`
+
+	// RNA is set when the query was RNA, searched with its U's as T's
+	RNA bool `json:"rna,omitempty"`
+}
+
+// Results are the results of a search.
+type Results struct {
+	Program           string      `json:"program"`
+	Version           string      `json:"version"`
+	Reference         string      `json:"reference"`
+	DB                string      `json:"db"`
+	QueryID           string      `json:"queryId"`
+	QueryDef          string      `json:"queryDef"`
+	QueryLen          int         `json:"queryLen"`
+	Results           []Hit       `json:"results"`
+	Iterations        []Iteration `json:"iterations,omitempty"`
+	Message           string      `json:"message,omitempty"`
+	Skipped           bool        `json:"skipped,omitempty"`
+	Truncated         bool        `json:"truncated,omitempty"`
+	DBNum             int         `json:"dbNum"`
+	DBLen             int         `json:"dbLen"`
+	Circular          bool        `json:"circular,omitempty"`
+	Clustered         bool        `json:"clustered,omitempty"`
+	Subjects          int         `json:"subjects,omitempty"`
+	DBVersion         string      `json:"dbVersion,omitempty"`
+	URIsUnavailable   bool        `json:"urisUnavailable,omitempty"`
+	URILookupFailures int         `json:"uriLookupFailures,omitempty"`
+	Query             string      `json:"query"`
+	Input             *QueryInput `json:"input,omitempty"`
+	DescriptionFilter string      `json:"descriptionFilter,omitempty"`
+	RoleFilter        []string    `json:"roleFilter,omitempty"`
+	ContainmentFilter []string    `json:"containmentFilter,omitempty"`
+	Error             string      `json:"error,omitempty"`
+	Warnings          []string    `json:"warnings,omitempty"`
+	Timings           Timings     `json:"timings"`
+	NumResults        int         `json:"numResults"`
+	Manifest          *Manifest   `json:"manifest,omitempty"`
+	Parameters        *Parameters `json:"parameters,omitempty"`
+}
+
+// Parameters are the settings blastn reported searching with, and the
+// statistics of its search space.
+type Parameters struct {
+	Matrix         string  `json:"matrix,omitempty"`
+	Expect         float64 `json:"expect"`
+	Match          int     `json:"match,omitempty"`
+	Mismatch       int     `json:"mismatch,omitempty"`
+	GapOpen        int     `json:"gapOpen"`
+	GapExtend      int     `json:"gapExtend"`
+	Filter         string  `json:"filter,omitempty"`
+	EntrezQuery    string  `json:"entrezQuery,omitempty"`
+	HSPLen         int     `json:"hspLen"`
+	EffectiveSpace float64 `json:"effectiveSpace"`
+	Kappa          float64 `json:"kappa"`
+	Lambda         float64 `json:"lambda"`
+	Entropy        float64 `json:"entropy"`
+}
+
+// Manifest says exactly what a search was run against and how, to quote
+// alongside its hits.
+type Manifest struct {
+	Program     string    `json:"program"`
+	Version     string    `json:"version"`
+	Args        []string  `json:"args"`
+	DB          string    `json:"db"`
+	DBVersion   string    `json:"dbVersion,omitempty"`
+	DBSequences int       `json:"dbSequences"`
+	DBLength    int       `json:"dbLength"`
+	Server      Build     `json:"server"`
+	Searched    time.Time `json:"searched"`
+}
+
+// Build identifies the build of the server that ran a search.
+type Build struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Iteration is blastn's search of one query, with its statistics and any
+// message about it, like why it wasn't searched.
+type Iteration struct {
+	Num        int         `json:"num"`
+	QueryID    string      `json:"queryId"`
+	QueryDef   string      `json:"queryDef,omitempty"`
+	QueryLen   int         `json:"queryLen"`
+	Hits       int         `json:"hits"`
+	Message    string      `json:"message,omitempty"`
+	Statistics *Statistics `json:"statistics,omitempty"`
+}
+
+// Statistics describe the search space of an Iteration.
+type Statistics struct {
+	DBNum          int     `json:"dbNum"`
+	DBLen          int     `json:"dbLen"`
+	HSPLen         int     `json:"hspLen"`
+	EffectiveSpace float64 `json:"effectiveSpace"`
+	Kappa          float64 `json:"kappa"`
+	Lambda         float64 `json:"lambda"`
+	Entropy        float64 `json:"entropy"`
+}
+
+// Timings break down how long the server spent on each phase of a search.
+// Aligner and Parse overlap for blastn, whose output is parsed as it's
+// written.
+type Timings struct {
+	Normalize   time.Duration `json:"normalize"`
+	QueueWait   time.Duration `json:"queueWait"`
+	Aligner     time.Duration `json:"aligner"`
+	Parse       time.Duration `json:"parse"`
+	ResolveURIs time.Duration `json:"resolveURIs"`
+	Total       time.Duration `json:"total"`
+}
+
+// Hit is a single database sequence matching the query.
+type Hit struct {
+	Num       int     `json:"num"`
+	ID        string  `json:"id"`
+	SeqHash   string  `json:"seqHash"`
+	Accession string  `json:"accession"`
+	Len       int     `json:"len"`
+	Iteration int     `json:"iteration,omitempty"`
+	BitScore  float64 `json:"bitScore"`
+	Score     int     `json:"score"`
+	// EValue is negative for aligners without e-values
+	EValue    float64 `json:"evalue"`
+	QueryFrom int     `json:"queryFrom"`
+	QueryTo   int     `json:"queryTo"`
+	HitFrom   int     `json:"hitFrom"`
+	HitTo     int     `json:"hitTo"`
+	Identity  int     `json:"identity"`
+	Gaps      int     `json:"gaps"`
+	AlignLen  int     `json:"alignLen"`
+
+	// QueryFrame and HitFrame are the reading frames of the alignment, 0
+	// for protein sequences. Strand is "plus" or "minus" for nucleotide
+	// hits; minus hits have HitFrom > HitTo.
+	QueryFrame int    `json:"queryFrame"`
+	HitFrame   int    `json:"hitFrame"`
+	Strand     string `json:"strand,omitempty"`
+
+	QuerySeq string   `json:"querySeq"`
+	Midline  string   `json:"midline"`
+	HitSeq   string   `json:"hitSeq"`
+	URIs     []string `json:"uris"`
+
+	// URIsUnavailable is set when the components of this hit couldn't be
+	// looked up, though those of the rest could
+	URIsUnavailable bool `json:"urisUnavailable,omitempty"`
+
+	// Roles are the Sequence Ontology terms of the components' roles, e.g.
+	// "SO:0000167" for promoters
+	Roles []string `json:"roles,omitempty"`
+
+	// Sources are the SynBioHub instances the components were slurped
+	// from, by uri, where known
+	Sources map[string]string `json:"sources,omitempty"`
+
+	// RNA is set when any of the components are RNA parts, which are
+	// aligned with their U's as T's
+	RNA bool `json:"rna,omitempty"`
+
+	// GC is the percentage of the hit's sequence that's G or C, for
+	// nucleotide hits
+	GC float64 `json:"gc,omitempty"`
+
+	// Representative is set on hits from a clustered search that weren't
+	// aligned themselves, to the hash of their cluster's representative,
+	// whose alignment they're shown with
+	Representative string `json:"representative,omitempty"`
+
+	// Subject is the name of the sequence hit when the query was searched
+	// against a SearchRequest's Subject
+	Subject string `json:"subject,omitempty"`
+
+	// QueryAmbiguous and HitAmbiguous are set when the query or the hit has
+	// ambiguity codes like N in the alignment
+	QueryAmbiguous bool `json:"queryAmbiguous,omitempty"`
+	HitAmbiguous   bool `json:"hitAmbiguous,omitempty"`
+
+	// Blocks is the alignment cut up into lines for display
+	Blocks []AlignmentBlock `json:"blocks,omitempty"`
+
+	// SimilarityClass is the server's badge for how identical the hit is,
+	// e.g. "near-identical", empty if it's below every tier
+	SimilarityClass string `json:"similarityClass,omitempty"`
+
+	// Containment is how the hit overlaps the query, see
+	// SearchRequest.Containment
+	Containment string `json:"containment,omitempty"`
+}
+
+// AlignmentBlock is up to 60 columns of a hit's alignment, with the
+// positions of the first and last residue of each row.
+type AlignmentBlock struct {
+	QueryFrom int    `json:"queryFrom"`
+	QueryTo   int    `json:"queryTo"`
+	HitFrom   int    `json:"hitFrom"`
+	HitTo     int    `json:"hitTo"`
+	QuerySeq  string `json:"querySeq"`
+	Midline   string `json:"midline"`
+	HitSeq    string `json:"hitSeq"`
+
+	// Columns has a character per column: '=' for identical residues, '+'
+	// for similar amino acids, 'x' for mismatches and '-' for gaps
+	Columns string `json:"columns"`
+}
+
+// Diff is how the hits of a query changed between two jobs, usually run
+// against different versions of the db. Hits are matched up by SeqHash;
+// ones whose alignment differs are in Changed, ignoring e-values since they
+// change with the size of the db.
+type Diff struct {
+	From      string      `json:"from"`
+	To        string      `json:"to"`
+	FromDB    string      `json:"fromDb"`
+	ToDB      string      `json:"toDb"`
+	New       []Hit       `json:"new"`
+	Gone      []Hit       `json:"gone"`
+	Changed   []HitChange `json:"changed"`
+	Unchanged int         `json:"unchanged"`
+}
+
+// HitChange is a hit both jobs found, with its scores in each.
+type HitChange struct {
+	SeqHash string   `json:"seqHash"`
+	URIs    []string `json:"uris"`
+	Before  HitStats `json:"before"`
+	After   HitStats `json:"after"`
+}
+
+// HitStats are the scores of a hit.
+type HitStats struct {
+	BitScore float64 `json:"bitScore"`
+	Score    int     `json:"score"`
+	EValue   float64 `json:"evalue"`
+	Identity int     `json:"identity"`
+	Gaps     int     `json:"gaps"`
+	AlignLen int     `json:"alignLen"`
+}
+
+// Job statuses.
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Job is a submitted search. Results is only set once Status is
+// StatusDone.
+type Job struct {
+	ID          string      `json:"id"`
+	Status      string      `json:"status"`
+	Query       string      `json:"query"`
+	Input       *QueryInput `json:"input,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Roles       []string    `json:"roles,omitempty"`
+	Containment []string    `json:"containment,omitempty"`
+	RerunOf     string      `json:"rerunOf,omitempty"`
+	RequestID   string      `json:"requestId,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Submitted   time.Time   `json:"submitted"`
+	Finished    time.Time   `json:"finished"`
+	Results     *Results    `json:"results,omitempty"`
+}
+
+// Over reports whether the job is done or failed.
+func (j *Job) Over() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed
+}
+
+// Sequence is a sequence stored by the server, under the hash hits are
+// identified by, and the components that use it.
+type Sequence struct {
+	Hash     string   `json:"hash"`
+	Sequence string   `json:"sequence"`
+	URIs     []string `json:"uris"`
+}
+
+// Error is returned when the server responds with a non-2xx status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("synbioblast: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Search runs a blast search for seq and waits for the results.
+func (c *Client) Search(ctx context.Context, seq string) (*Results, error) {
+	results := &Results{}
+	err := c.do(ctx, http.MethodPost, "/api/v1/search", map[string]string{"sequence": seq}, results)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Submit queues a search and returns the new job without waiting for it.
+func (c *Client) Submit(ctx context.Context, req *SearchRequest) (*Job, error) {
+	j := &Job{}
+	err := c.do(ctx, http.MethodPost, "/api/v1/jobs", req, j)
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// GetJob returns the current state of a job.
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	j := &Job{}
+	err := c.do(ctx, http.MethodGet, "/api/v1/jobs/"+url.PathEscape(id), nil, j)
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Wait polls a job every interval until it's finished or ctx is done.
+func (c *Client) Wait(ctx context.Context, id string, interval time.Duration) (*Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		j, err := c.GetJob(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if j.Over() {
+			return j, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Results waits for a job to finish, polling every interval, and returns
+// its results. It returns an error if the job failed.
+func (c *Client) Results(ctx context.Context, id string, interval time.Duration) (*Results, error) {
+	j, err := c.Wait(ctx, id, interval)
+	if err != nil {
+		return nil, err
+	}
+	if j.Status == StatusFailed {
+		return nil, fmt.Errorf("synbioblast: job %s failed: %s", j.ID, j.Error)
+	}
+
+	return j.Results, nil
+}
+
+// Rerun submits a job's query again, against the server's current db. Once
+// it's done, Diff shows what changed.
+func (c *Client) Rerun(ctx context.Context, id string) (*Job, error) {
+	j := &Job{}
+	err := c.do(ctx, http.MethodPost, "/api/v1/jobs/"+url.PathEscape(id)+"/rerun", nil, j)
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Diff compares the results of a job made by Rerun with those of the job it
+// re-ran.
+func (c *Client) Diff(ctx context.Context, id string) (*Diff, error) {
+	diff := &Diff{}
+	err := c.do(ctx, http.MethodGet, "/api/v1/jobs/"+url.PathEscape(id)+"/diff", nil, diff)
+	if err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+// Sequence returns the sequence stored under hash, the SeqHash of a Hit.
+func (c *Client) Sequence(ctx context.Context, hash string) (*Sequence, error) {
+	seq := &Sequence{}
+	err := c.do(ctx, http.MethodGet, "/api/v1/sequences/"+url.PathEscape(hash), nil, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	return seq, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := struct {
+			Error string `json:"error"`
+		}{}
+		// the body might not be json if something in between us and the
+		// server failed, so fall back to the status text
+		if json.NewDecoder(resp.Body).Decode(&apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		return &Error{StatusCode: resp.StatusCode, Message: apiErr.Error}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
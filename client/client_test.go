@@ -0,0 +1,166 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schnauzer/synbioblast/client"
+)
+
+// server stands in for synbioblast's API: a job is queued, running on the
+// next poll and done on the one after, or failed if its query is "fail".
+func server(t *testing.T) *httptest.Server {
+	var (
+		mu    sync.Mutex
+		polls = map[string]int{}
+		jobs  = map[string]*client.Job{}
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/search", func(w http.ResponseWriter, r *http.Request) {
+		req := client.SearchRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(client.Results{Query: req.Sequence, NumResults: 1, Results: []client.Hit{{}}})
+	})
+	mux.HandleFunc("POST /api/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("submitted with Content-Type %q, want application/json", r.Header.Get("Content-Type"))
+		}
+		req := client.SearchRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		mu.Lock()
+		defer mu.Unlock()
+		j := &client.Job{ID: req.Sequence + "-job", Status: client.StatusQueued, Query: req.Sequence, Roles: req.Roles}
+		jobs[j.ID] = j
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(j)
+	})
+	mux.HandleFunc("GET /api/v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		j, ok := jobs[r.PathValue("id")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "no job with id " + r.PathValue("id")})
+			return
+		}
+
+		polls[j.ID]++
+		switch {
+		case polls[j.ID] == 1:
+			j.Status = client.StatusRunning
+		case j.Query == "fail":
+			j.Status, j.Error = client.StatusFailed, "blastn exited with 2"
+		default:
+			j.Status, j.Results = client.StatusDone, &client.Results{Query: j.Query}
+		}
+		json.NewEncoder(w).Encode(j)
+	})
+	mux.HandleFunc("GET /api/v1/sequences/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<html>bad gateway</html>", http.StatusBadGateway)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestSearch(t *testing.T) {
+	c := client.New(server(t).URL + "/")
+
+	results, err := c.Search(context.Background(), "acgt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Query != "acgt" || results.NumResults != 1 || len(results.Results) != 1 {
+		t.Errorf("Search = %+v, want one hit for acgt", results)
+	}
+}
+
+func TestJob(t *testing.T) {
+	c := client.New(server(t).URL)
+	ctx := context.Background()
+
+	j, err := c.Submit(ctx, &client.SearchRequest{Sequence: "acgt", Roles: []string{"promoter"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != "acgt-job" || j.Status != client.StatusQueued || j.Over() || len(j.Roles) != 1 {
+		t.Errorf("Submit = %+v, want a queued job searching for promoters", j)
+	}
+
+	results, err := c.Results(ctx, j.ID, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Query != "acgt" {
+		t.Errorf("Results are for %q, want acgt", results.Query)
+	}
+
+	j, err = c.Submit(ctx, &client.SearchRequest{Sequence: "fail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Results(ctx, j.ID, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "blastn exited with 2") {
+		t.Errorf("Results of a failed job = %v, want its error", err)
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	c := client.New(server(t).URL)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	j, err := c.Submit(ctx, &client.SearchRequest{Sequence: "acgt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	_, err = c.Wait(ctx, j.ID, time.Hour)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestError(t *testing.T) {
+	c := client.New(server(t).URL)
+
+	tests := []struct {
+		name    string
+		call    func() error
+		status  int
+		message string
+	}{
+		{"api error", func() error {
+			_, err := c.GetJob(context.Background(), "missing")
+			return err
+		}, http.StatusNotFound, "no job with id missing"},
+		{"not json", func() error {
+			_, err := c.Sequence(context.Background(), "abc")
+			return err
+		}, http.StatusBadGateway, "502 Bad Gateway"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call()
+			apiErr := &client.Error{}
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("err = %v, want a *client.Error", err)
+			}
+			if apiErr.StatusCode != test.status || apiErr.Message != test.message {
+				t.Errorf("err = %d %q, want %d %q", apiErr.StatusCode, apiErr.Message, test.status, test.message)
+			}
+		})
+	}
+}
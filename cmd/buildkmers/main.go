@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io/fs"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/kmerindex"
+	"github.com/schnauzer/synbioblast/logging"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+var (
+	kmerLength = flag.Int("kmers.k", 21, "length of the k-mers to index")
+	kmerScale  = flag.Uint64("kmers.scale", 8, "index 1 in this many k-mers")
+	out        = flag.String("out", "", "file to write the index to")
+)
+
+var configRules = config.Rules{
+	"fastas.path": config.All(config.Required, config.Dir),
+	"out":         config.Required,
+	"kmers.k":     config.Positive,
+	"kmers.scale": config.Positive,
+}
+
+// builds the k-mer index the query server screens queries against, run by
+// builddb.sh next to makeblastdb
+func main() {
+	loadErr := config.Load()
+	err := logging.Setup()
+	if err != nil {
+		logging.Fatal("couldn't set up logging", "err", err)
+	}
+	if loadErr != nil {
+		logging.Fatal("couldn't load config", "err", loadErr)
+	}
+	err = config.Validate(configRules)
+	if err != nil {
+		logging.Fatal("invalid config", "err", err)
+	}
+
+	idx := kmerindex.New(*kmerLength, *kmerScale)
+	err = store.WalkFastas(*config.FastaDir, func(path, hash string, d fs.DirEntry) error {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		// the slurper writes ">hash\nsequence\n"
+		lines := strings.SplitN(strings.TrimSpace(string(b)), "\n", 2)
+		if len(lines) != 2 {
+			slog.Warn("skipping fasta with no sequence", "file", path)
+			return nil
+		}
+
+		idx.Add(strings.TrimPrefix(lines[0], ">"), strings.Replace(lines[1], "\n", "", -1))
+		return nil
+	})
+	if err != nil {
+		logging.Fatal("couldn't read fastas", "dir", *config.FastaDir, "err", err)
+	}
+	slog.Info("indexed sequences", "sequences", len(idx.SeqHashes), "kmers", len(idx.Postings))
+
+	tmp := *out + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		logging.Fatal("couldn't create index", "err", err)
+	}
+
+	w := bufio.NewWriter(file)
+	err = idx.Write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		logging.Fatal("couldn't write index", "err", err)
+	}
+
+	err = os.Rename(tmp, *out)
+	if err != nil {
+		logging.Fatal("couldn't move index into place", "err", err)
+	}
+}
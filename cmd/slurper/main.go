@@ -0,0 +1,675 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/logging"
+	"github.com/schnauzer/synbioblast/pkg/slurp"
+	"github.com/schnauzer/synbioblast/pkg/store"
+	"github.com/schnauzer/synbioblast/sdnotify"
+)
+
+var (
+	synbiohubURL      = flag.String("synbiohub.url", "https://synbiohub.org/sparql", "URL to send sparql queries to")
+	synbiohubInstance = flag.String("synbiohub.instance", "",
+		"web address people reach the SynBioHub instance -synbiohub.url belongs to at, recorded with each component so links go back to it, -synbiohub.url without /sparql if empty")
+	synbiohubGraph  = flag.String("synbiohub.graph", "public", "SynBioHub graph parameter to send with queries, the public graph or a user's, left off if empty")
+	synbiohubGraphs = flag.String("synbiohub.graphs", "",
+		"comma separated named graph URIs to fetch components from together, the endpoint's default graph if empty")
+	synbiohubService = flag.String("synbiohub.service", "",
+		"URL of a second SPARQL endpoint to fill in missing titles, descriptions and roles from through SERVICE federation, disabled if empty")
+	resultLimit = flag.Int("synbiohub.resultLimit", 100, "number of components to fetch in the first query")
+	minLimit    = flag.Int("synbiohub.minResultLimit", 10, "smallest number of components to fetch in each query")
+	maxLimit    = flag.Int("synbiohub.maxResultLimit", 1000, "largest number of components to fetch in each query")
+	fetchTarget = flag.Duration("synbiohub.targetLatency", 5*time.Second,
+		"how long a query should take, the number of components fetched is adjusted to stay near this")
+	sparqlTimeout = flag.Duration("synbiohub.timeout", 2*time.Minute,
+		"how long to wait for a query's results before giving up on it and fetching fewer components, no limit if 0")
+	maxBackoff = flag.Duration("synbiohub.maxBackoff", 30*time.Minute,
+		"longest to wait before retrying a failed query, the wait doubling from 30s with each failure in a row unless the endpoint asks for longer")
+
+	syncPerBatch = flag.Bool("fastas.syncPerBatch", false,
+		"fsync the fastas written in a batch together at the end of it, rather than each as it's written, which is quicker on slow disks")
+
+	bloomFPRate = flag.Float64("bloom.falsePositiveRate", 0.01,
+		"false positive rate of the filter of stored sequence hashes, which saves asking redis whether new sequences are stored and rewriting components that are, disabled if 0")
+
+	once = flag.Bool("sync.once", false,
+		"sync once and exit, 0 if anything was indexed, 3 if there was nothing new and 1 if it failed, for running from cron instead of sleeping between syncs")
+
+	metricsPort = flag.Int("metrics.port", 0, "port to serve prometheus metrics on, disabled if 0")
+	statusPort  = flag.Int("status.port", 0, "port to serve the slurper's status on, and take POSTs to /fetch to fetch straight away, disabled if 0")
+)
+
+var (
+	cycleSequences = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "synbioblast_slurper_cycle_sequences",
+		Help: "Components in the last batch fetched from SynBioHub, by whether they were ingested, skipped as already seen, invalid, or a duplicate row of one earlier in the batch.",
+	}, []string{"outcome"})
+	slurpedSequences = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "synbioblast_slurper_sequences_total",
+		Help: "Components fetched from SynBioHub, by whether they were ingested, skipped as already seen, invalid, or a duplicate row of one earlier in the batch.",
+	}, []string{"outcome"})
+	sparqlDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "synbioblast_slurper_sparql_duration_seconds",
+		Help:    "How long SPARQL queries to SynBioHub took, by whether they succeeded (ok), timed out, were turned away as the endpoint was overloaded or rate limiting, or failed with another error.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 10),
+	}, []string{"result"})
+	translatedORFs = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "synbioblast_slurper_orfs_total",
+		Help: "Open reading frames found in DNA components and added to the protein db.",
+	})
+	redisErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "synbioblast_slurper_redis_errors_total",
+		Help: "Redis commands that failed.",
+	})
+	fastaFiles = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "synbioblast_slurper_fasta_files",
+		Help: "Fasta files in the store, by the db they go into.",
+	}, []string{"store"})
+	fastaBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "synbioblast_slurper_fasta_bytes",
+		Help: "Size of the fasta files in the store, by the db they go into.",
+	}, []string{"store"})
+
+	// lastCreated is the unix time of the newest component seen, the cursor
+	// the slurper pages through SynBioHub with. It starts off as the newest
+	// stored by any slurper.
+	lastCreated int64
+
+	// lagging is set while the cursor lag is over -freshness.maxLag, so
+	// it's warned about once rather than every batch
+	lagging bool
+)
+
+func init() {
+	prometheus.MustRegister(cycleSequences, slurpedSequences, sparqlDuration, translatedORFs, redisErrors, fastaFiles, fastaBytes)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "synbioblast_slurper_cursor_lag_seconds",
+		Help: "How long before now the newest component seen was created.",
+	}, func() float64 {
+		created := atomic.LoadInt64(&lastCreated)
+		if created == 0 {
+			return 0
+		}
+		return time.Since(time.Unix(created, 0)).Seconds()
+	}))
+}
+
+// fastaStore is the label for the store a sequence's fasta goes in.
+func fastaStore(protein bool) string {
+	if protein {
+		return "protein"
+	}
+	return "nucleotide"
+}
+
+// measureFastas sets the fasta store size metrics from what's on disk, after
+// that they're kept up to date as files are written.
+func measureFastas() error {
+	for kind, dir := range map[string]string{"nucleotide": *config.FastaDir, "protein": *config.ProteinDir} {
+		n, size := 0, int64(0)
+		err := store.WalkFastas(dir, func(path, hash string, d fs.DirEntry) error {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			n++
+			size += info.Size()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fastaFiles.WithLabelValues(kind).Set(float64(n))
+		fastaBytes.WithLabelValues(kind).Set(float64(size))
+	}
+
+	return nil
+}
+
+// cmd runs a redis command, counting it if it fails.
+func cmd(client *redis.Client, name string, args ...interface{}) *redis.Resp {
+	resp := client.Cmd(name, args...)
+	if resp.Err != nil {
+		redisErrors.Inc()
+	}
+	return resp
+}
+
+// configRules catch bad flags at startup rather than once the loop gets to
+// them.
+var configRules = config.Rules{
+	"synbiohub.url":            config.All(config.Required, config.URL),
+	"synbiohub.instance":       config.URL,
+	"synbiohub.graphs":         config.URLs,
+	"synbiohub.service":        config.URL,
+	"synbiohub.resultLimit":    config.Positive,
+	"synbiohub.minResultLimit": config.Positive,
+	"synbiohub.maxResultLimit": config.Positive,
+	"synbiohub.targetLatency":  config.Positive,
+	"synbiohub.timeout":        config.NonNegative,
+	"synbiohub.maxBackoff":     config.Positive,
+	"orfs.minCodons":           config.NonNegative,
+	"sequences.hash":           config.OneOf("sha1", "sha256"),
+	"bloom.falsePositiveRate":  config.Between(0, 0.5),
+	"fastas.path":              config.All(config.Required, config.Dir),
+	"redis.url":                config.All(config.Required, config.HostPort),
+	"metrics.port":             config.Port,
+	"status.port":              config.Port,
+	"freshness.maxLag":         config.NonNegative,
+	"notify.webhook":           config.URL,
+	"notify.failingFor":        config.Positive,
+}
+
+func main() {
+	loadErr := config.Load()
+	err := logging.Setup()
+	if err != nil {
+		logging.Fatal("couldn't set up logging", "err", err)
+	}
+	if loadErr != nil {
+		logging.Fatal("couldn't load config", "err", loadErr)
+	}
+	err = config.Validate(configRules)
+	if err != nil {
+		logging.Fatal("invalid config", "err", err)
+	}
+
+	// protein fastas are newer than the setup instructions, so make sure
+	// there's somewhere to put them
+	err = os.MkdirAll(*config.ProteinDir, 0755)
+	if err != nil {
+		logging.Fatal("couldn't create protein fasta dir", "dir", *config.ProteinDir, "err", err)
+	}
+
+	err = measureFastas()
+	if err != nil {
+		logging.Fatal("couldn't measure fasta store", "err", err)
+	}
+
+	if *metricsPort != 0 {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			slog.Info("serving metrics", "port", *metricsPort)
+			err := http.ListenAndServe(fmt.Sprintf(":%d", *metricsPort), mux)
+			logging.Fatal("metrics server stopped", "err", err)
+		}()
+	}
+
+	if *statusPort != 0 {
+		go serveStatus(*statusPort)
+	}
+
+	st := config.Store()
+	st.SyncPerBatch = *syncPerBatch
+
+	slog.Info("connecting to redis", "url", *config.RedisURL)
+
+	client, err := redis.Dial("tcp", *config.RedisURL)
+	if err != nil {
+		logging.Fatal("couldn't dial redis", "err", err)
+	}
+	defer client.Close()
+
+	err = st.CheckHasher(client)
+	if err != nil {
+		logging.Fatal("can't slurp into the store with -sequences.hash", "hash", *config.SequenceHash, "err", err)
+	}
+
+	if *bloomFPRate > 0 {
+		start := time.Now()
+		st.Bloom, err = st.LoadBloom(client, *bloomFPRate)
+		if err != nil {
+			logging.Fatal("couldn't load bloom filter", "err", err)
+		}
+		slog.Info("loaded bloom filter", "hashes", st.Bloom.Len(), "took", time.Since(start))
+	}
+
+	newest, err := st.NewestCreated(client)
+	if err != nil {
+		slog.Warn("couldn't get newest component stored, cursor lag is unknown until the first batch", "err", err)
+	} else if !newest.IsZero() {
+		atomic.StoreInt64(&lastCreated, newest.Unix())
+	}
+
+	offset, err := cmd(client, "GET", st.Keys.Cursor).Int()
+	// this block definitely isn't horrible /s
+	if err != nil {
+		if err == redis.ErrRespNil {
+			err = cmd(client, "SET", st.Keys.Cursor, 0).Err
+			if err != nil {
+				logging.Fatal("couldn't set initial offset value", "err", err)
+			}
+			slog.Info("no offset val, setting it to 0")
+			offset = 0
+		} else {
+			logging.Fatal("couldn't get offset val", "err", err)
+		}
+	} else {
+		slog.Info("starting", "offset", offset)
+	}
+
+	instance := *synbiohubInstance
+	if instance == "" {
+		instance = strings.TrimSuffix(*synbiohubURL, "/sparql")
+	}
+	instance = strings.TrimRight(instance, "/")
+	slog.Info("slurping", "endpoint", *synbiohubURL, "instance", instance, "graphs", *synbiohubGraphs, "service", *synbiohubService)
+
+	sl := &slurper{
+		client:   client,
+		st:       st,
+		instance: instance,
+		offset:   offset,
+		sizer:    slurp.NewBatchSizer(*resultLimit, *minLimit, *maxLimit, *fetchTarget),
+		notifier: notifier{instance: instance},
+		endpoint: slurp.Endpoint{
+			URL:     *synbiohubURL,
+			Graph:   *synbiohubGraph,
+			Service: *synbiohubService,
+			Timeout: *sparqlTimeout,
+		},
+	}
+	for _, graph := range strings.Split(*synbiohubGraphs, ",") {
+		if graph = strings.TrimSpace(graph); graph != "" {
+			sl.endpoint.Graphs = append(sl.endpoint.Graphs, graph)
+		}
+	}
+
+	if *once {
+		code := sl.pass()
+		client.Close()
+		os.Exit(code)
+	}
+
+	err = sdnotify.Notify(sdnotify.Ready)
+	if err != nil {
+		slog.Warn("couldn't tell systemd we're ready", "err", err)
+	}
+
+	backoff := minBackoff
+	for {
+		alive()
+
+		b, err := sl.batch()
+		sl.notifier.observe(b, err)
+		var be *batchError
+		switch {
+		case errors.As(err, &be) && be.stage == "fetch":
+			wait := backoff
+			var se *slurp.StatusError
+			if errors.As(err, &se) && se.RetryAfter > wait {
+				wait = se.RetryAfter
+			}
+			backoff *= 2
+			if backoff > *maxBackoff {
+				backoff = *maxBackoff
+			}
+
+			slog.Warn("fetch failed, trying again in a bit", "err", err, "wait", wait)
+			sleep(wait)
+		case err != nil:
+			backoff = minBackoff
+			slog.Warn("batch failed, trying again in a bit", "err", err)
+			sleep(time.Second * 30)
+		case b.last():
+			backoff = minBackoff
+			slog.Info("got less sequences than limit, sleeping")
+			sleep(time.Hour * 4)
+		default:
+			backoff = minBackoff
+			slog.Debug("going again, but first sleeping for a bit")
+			sleep(time.Second * 2)
+		}
+	}
+}
+
+// Exit codes for -sync.once, distinct so cron and Kubernetes can tell them apart.
+// A failure that stops the slurper before it gets as far as syncing, such as
+// bad config or Redis being down, is also exitFailed.
+const (
+	exitIndexed    = 0
+	exitFailed     = 1
+	exitNothingNew = 3
+)
+
+// slurper pages through a SynBioHub endpoint into the store.
+type slurper struct {
+	client   *redis.Client
+	st       *store.Store
+	instance string
+	offset   int
+	sizer    *slurp.BatchSizer
+	endpoint slurp.Endpoint
+	notifier notifier
+}
+
+// batch is how a page of components went.
+type batch struct {
+	limit                                  int
+	ingested, skipped, invalid, duplicates int
+}
+
+// last returns whether the page came up short, so there's nothing more to
+// fetch for now.
+func (b batch) last() bool {
+	return b.ingested+b.skipped+b.invalid+b.duplicates < b.limit
+}
+
+// batchError is a batch failing at one of its stages: fetch, parse or
+// process.
+type batchError struct {
+	stage string
+	err   error
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("couldn't %s batch: %v", e.stage, e.err)
+}
+
+func (e *batchError) Unwrap() error {
+	return e.err
+}
+
+// pass syncs once, fetching pages until it's caught up, and returns the
+// exit code for how it went.
+func (sl *slurper) pass() int {
+	ingested := 0
+	for {
+		b, err := sl.batch()
+		if err != nil {
+			slog.Error("sync failed", "err", err, "ingested", ingested)
+			// runs are too far apart to tell how long it's been failing
+			notify(fmt.Sprintf("Syncing %s failed after indexing %d new components: %v", sl.instance, ingested, err))
+			return exitFailed
+		}
+		sl.notifier.observe(b, nil)
+		ingested += b.ingested
+		if b.last() {
+			break
+		}
+
+		sleep(time.Second * 2)
+	}
+
+	if ingested == 0 {
+		slog.Info("synced, nothing new")
+		return exitNothingNew
+	}
+	slog.Info("synced", "ingested", ingested)
+	return exitIndexed
+}
+
+// batch fetches, parses and processes a page of components, advancing the
+// cursor past them. Errors are a *batchError.
+func (sl *slurper) batch() (batch, error) {
+	b := batch{limit: sl.sizer.Limit()}
+	slog.Info("fetching from virtuoso", "offset", sl.offset, "limit", b.limit)
+
+	start := time.Now()
+	updateStatus(func(s *slurpStatus) {
+		s.State = "fetching"
+		s.NextFetch = nil
+		s.Offset = sl.offset
+		s.Limit = b.limit
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	body, contentType, err := sl.endpoint.Fetch(ctx, sl.offset, b.limit)
+	if err != nil {
+		sparqlDuration.WithLabelValues(fetchResult(err)).Observe(time.Since(start).Seconds())
+		sl.sizer.Failed(err)
+		failed("fetch", err)
+		return b, &batchError{"fetch", err}
+	}
+	// virtuoso has run the query by the time the headers arrive, what's
+	// left is streaming the results
+	sparqlDuration.WithLabelValues("ok").Observe(time.Since(start).Seconds())
+	sl.sizer.Succeeded(time.Since(start))
+
+	slog.Debug("fetched, parsing and processing response")
+	updateStatus(func(s *slurpStatus) {
+		s.State = "processing"
+	})
+
+	seqs := make(chan store.Component, parseBuffer)
+	var parseErr error
+	parsed := make(chan struct{})
+	go func() {
+		b.invalid, b.duplicates, parseErr = slurp.Parse(ctx, body, contentType, seqs)
+		close(parsed)
+	}()
+
+	processed, skipped, err := process(sl.client, sl.st, sl.instance, seqs)
+	cancel()
+	<-parsed
+	body.Close()
+	if err != nil {
+		failed("process", err)
+		return b, &batchError{"process", err}
+	}
+	if parseErr != nil {
+		// what was processed is stored, and is skipped when the batch is
+		// fetched again
+		failed("parse", parseErr)
+		return b, &batchError{"parse", parseErr}
+	}
+	b.ingested = processed - skipped
+	b.skipped = skipped
+
+	outcomes := map[string]int{"ingested": b.ingested, "skipped": b.skipped, "invalid": b.invalid, "duplicate": b.duplicates}
+	slog.Info("processed batch", "ingested", b.ingested, "skipped", b.skipped, "invalid", b.invalid, "duplicates", b.duplicates)
+	for outcome, n := range outcomes {
+		cycleSequences.WithLabelValues(outcome).Set(float64(n))
+		slurpedSequences.WithLabelValues(outcome).Add(float64(n))
+	}
+
+	// duplicates don't move the cursor, see slurp.Parse
+	distinct := processed + b.invalid
+	slog.Debug("incrementing offset val", "by", distinct)
+
+	sl.offset, err = cmd(sl.client, "INCRBY", sl.st.Keys.Cursor, distinct).Int()
+	if err != nil {
+		logging.Fatal("couldn't update offset with new records", "err", err)
+	}
+	updateStatus(func(s *slurpStatus) {
+		now := time.Now()
+		s.LastSuccess = &now
+		s.Offset = sl.offset
+		for outcome, n := range outcomes {
+			s.Components[outcome] += n
+		}
+	})
+	checkLag()
+
+	return b, nil
+}
+
+// checkLag warns when the newest component stored was created longer than
+// -freshness.maxLag ago, and says when it's caught up again. SynBioHub
+// going quiet for a while looks the same as the slurper falling behind.
+func checkLag() {
+	created := atomic.LoadInt64(&lastCreated)
+	if created == 0 {
+		return
+	}
+
+	newest := time.Unix(created, 0)
+	updateStatus(func(s *slurpStatus) {
+		s.NewestCreated = &newest
+	})
+	if *config.MaxCursorLag == 0 {
+		return
+	}
+
+	lag := time.Since(newest).Round(time.Second)
+	switch {
+	case lag > *config.MaxCursorLag && !lagging:
+		lagging = true
+		slog.Warn("index is stale, the newest component stored is older than -freshness.maxLag",
+			"lag", lag, "maxLag", *config.MaxCursorLag, "newestCreated", newest)
+	case lag <= *config.MaxCursorLag && lagging:
+		lagging = false
+		slog.Info("index is fresh again", "lag", lag)
+	}
+}
+
+// minBackoff is how long to wait after a query first fails.
+const minBackoff = 30 * time.Second
+
+// fetchResult is the sparqlDuration label for a failed query.
+func fetchResult(err error) string {
+	var se *slurp.StatusError
+	switch {
+	case errors.Is(err, slurp.ErrTimeout):
+		return "timeout"
+	case errors.As(err, &se) && se.Overloaded():
+		return "overloaded"
+	default:
+		return "error"
+	}
+}
+
+// alive pings systemd's watchdog. It's only called from the main loop, so if
+// a fetch or redis command hangs the pings stop and systemd restarts us.
+func alive() {
+	sdnotify.Notify(sdnotify.Watchdog)
+}
+
+// sleep sleeps between cycles, pinging the watchdog meanwhile since waiting
+// for the next cycle isn't being wedged. A POST to the status server's
+// /fetch cuts it short.
+func sleep(d time.Duration) {
+	deadline := time.Now().Add(d)
+	updateStatus(func(s *slurpStatus) {
+		s.State = "sleeping"
+		s.NextFetch = &deadline
+	})
+
+	interval := sdnotify.WatchdogInterval()
+	if interval == 0 {
+		interval = d
+	}
+
+	for remaining := d; remaining > 0; remaining = time.Until(deadline) {
+		alive()
+		select {
+		case <-time.After(min(remaining, interval)):
+		case <-fetchNow:
+			slog.Info("woken up to fetch")
+			return
+		}
+	}
+}
+
+// writeFasta writes the fasta for a sequence, keeping the store metrics up
+// to date. There's no carrying on if the disk is full or unwritable.
+func writeFasta(st *store.Store, seq *store.Component) {
+	created, size, err := st.WriteFasta(seq)
+	if err != nil {
+		logging.Fatal("couldn't write fasta", "dir", st.Dir(seq.Protein), "hash", st.Hash(seq.Sequence), "uri", seq.URI, "err", err)
+	}
+	if created {
+		fastaFiles.WithLabelValues(fastaStore(seq.Protein)).Inc()
+		fastaBytes.WithLabelValues(fastaStore(seq.Protein)).Add(float64(size))
+	}
+}
+
+// addORFs translates the open reading frames of a DNA component into the
+// protein db, recorded against the component's uri so protein searches
+// finding them find it.
+func addORFs(client *redis.Client, st *store.Store, seq *store.Component) error {
+	if seq.Protein || *config.ORFMinCodons == 0 {
+		return nil
+	}
+
+	for _, orf := range slurp.FindORFs(seq.Sequence, *config.ORFMinCodons) {
+		writeFasta(st, &store.Component{URI: seq.URI, Sequence: orf.Protein, Protein: true})
+
+		added, err := st.AddORF(client, seq.URI, orf.Protein)
+		if err != nil {
+			return err
+		}
+		if added {
+			slog.Debug("translated orf", "uri", seq.URI, "start", orf.Start, "end", orf.End)
+			translatedORFs.Inc()
+		}
+	}
+
+	return nil
+}
+
+// parseBuffer is how many of a page's components parsing can get ahead of
+// processing, so they're not handed over one fasta write at a time.
+const parseBuffer = 64
+
+// process stores the components parsed from a batch as they come in, from
+// source, until seqs is closed. It returns how many it got and how many of
+// those were already stored. Redis errors are returned rather than fatal
+// since the batch can just be processed again.
+func process(client *redis.Client, st *store.Store, source string, seqs <-chan store.Component) (processed, skipped int, err error) {
+	newURIs := 0
+	var newest time.Time
+	for c := range seqs {
+		seq := &c
+		seq.Source = source
+		processed++
+
+		writeFasta(st, seq)
+
+		added, err := st.Add(client, seq)
+		if err != nil {
+			redisErrors.Inc()
+			return processed, skipped, err
+		}
+
+		err = addORFs(client, st, seq)
+		if err != nil {
+			redisErrors.Inc()
+			return processed, skipped, err
+		}
+		if added {
+			newURIs++
+		} else {
+			skipped++
+		}
+
+		if seq.Created.After(newest) {
+			newest = seq.Created
+		}
+		if created := seq.Created.Unix(); created > atomic.LoadInt64(&lastCreated) {
+			atomic.StoreInt64(&lastCreated, created)
+		}
+	}
+
+	err = st.SyncFastas()
+	if err != nil {
+		return processed, skipped, fmt.Errorf("couldn't sync fastas: %v", err)
+	}
+
+	err = st.RecordSlurp(client, newURIs, newest)
+	if err != nil {
+		redisErrors.Inc()
+		return processed, skipped, err
+	}
+
+	return processed, skipped, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var (
+	notifyWebhook = flag.String("notify.webhook", "",
+		`Slack or Matrix incoming webhook to post {"text": ...} to when new components are indexed or slurping keeps failing, disabled if empty`)
+	notifyFailingFor = flag.Duration("notify.failingFor", time.Hour, "how long slurping has to keep failing before -notify.webhook is told")
+)
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// notify posts text to -notify.webhook, if it's set. It's for people
+// without alerting set up, so a webhook that can't be reached is only
+// logged.
+func notify(text string) {
+	if *notifyWebhook == "" {
+		return
+	}
+
+	b, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{text})
+	if err != nil {
+		slog.Error("couldn't encode notification", "err", err)
+		return
+	}
+
+	resp, err := notifyClient.Post(*notifyWebhook, "application/json", bytes.NewReader(b))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("webhook responded %s", resp.Status)
+		}
+	}
+	if err != nil {
+		slog.Warn("couldn't notify webhook", "text", text, "err", err)
+	}
+}
+
+// notifier works out what's worth notifying about as batches go by: what
+// was indexed once the slurper's caught up, rather than every batch, and
+// failures that last longer than -notify.failingFor, once, and when
+// they're over.
+type notifier struct {
+	instance string
+
+	// ingested is how many components have been indexed since the
+	// slurper last caught up
+	ingested int
+
+	failingSince time.Time
+	toldFailing  bool
+}
+
+// observe is told how each batch went.
+func (n *notifier) observe(b batch, err error) {
+	if err != nil {
+		if n.failingSince.IsZero() {
+			n.failingSince = time.Now()
+		}
+		if failing := time.Since(n.failingSince); !n.toldFailing && failing > *notifyFailingFor {
+			n.toldFailing = true
+			notify(fmt.Sprintf("Slurping %s has been failing for %s: %v", n.instance, failing.Round(time.Minute), err))
+		}
+		return
+	}
+
+	if n.toldFailing {
+		notify(fmt.Sprintf("Slurping %s is working again after failing for %s", n.instance, time.Since(n.failingSince).Round(time.Minute)))
+	}
+	n.failingSince, n.toldFailing = time.Time{}, false
+
+	n.ingested += b.ingested
+	if b.last() && n.ingested > 0 {
+		notify(fmt.Sprintf("Indexed %d new components from %s", n.ingested, n.instance))
+		n.ingested = 0
+	}
+}
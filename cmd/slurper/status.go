@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/schnauzer/synbioblast/logging"
+)
+
+// slurpStatus is what the slurper is up to, served on -status.port so
+// operators don't have to tail logs to find out.
+type slurpStatus struct {
+	Started time.Time `json:"started"`
+
+	// State is fetching, processing or sleeping
+	State     string     `json:"state"`
+	NextFetch *time.Time `json:"nextFetch,omitempty"`
+
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+
+	// NewestCreated is when the newest component stored was created, which
+	// is how stale the index is
+	NewestCreated *time.Time `json:"newestCreated,omitempty"`
+
+	// Components counts the components fetched since starting by outcome,
+	// like synbioblast_slurper_sequences_total
+	Components map[string]int `json:"components"`
+
+	// Errors counts the batches that failed since starting by whether it
+	// was fetching, parsing or processing them that failed
+	Errors map[string]int `json:"errors"`
+}
+
+var (
+	statusMu sync.Mutex
+	status   = slurpStatus{
+		Started:    time.Now(),
+		State:      "starting",
+		Components: map[string]int{},
+		Errors:     map[string]int{},
+	}
+
+	// fetchNow wakes the main loop up from sleeping, so it fetches straight
+	// away
+	fetchNow = make(chan struct{}, 1)
+)
+
+// updateStatus changes the status under its lock.
+func updateStatus(update func(s *slurpStatus)) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	update(&status)
+}
+
+// failed records a batch failing at stage.
+func failed(stage string, err error) {
+	updateStatus(func(s *slurpStatus) {
+		now := time.Now()
+		s.Errors[stage]++
+		s.LastError = fmt.Sprintf("%s: %v", stage, err)
+		s.LastErrorAt = &now
+	})
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	statusMu.Lock()
+	b, err := json.Marshal(status)
+	statusMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func fetchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST to fetch now", http.StatusMethodNotAllowed)
+		return
+	}
+
+	select {
+	case fetchNow <- struct{}{}:
+		slog.Info("fetch triggered through the status server")
+	default:
+		// one's already waiting to be picked up
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveStatus serves the status on /status and takes POSTs to /fetch.
+func serveStatus(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", statusHandler)
+	mux.HandleFunc("/fetch", fetchHandler)
+
+	slog.Info("serving status", "port", port)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
+	logging.Fatal("status server stopped", "err", err)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/logging"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/workqueue"
+)
+
+var (
+	concurrency = flag.Int("worker.concurrency", 1, "number of blast queries this worker runs at once")
+	workerName  = flag.String("worker.name", "", "name this worker reports results under, hostname:pid if empty")
+	maxOutput   = flag.Int("worker.maxOutput", 64<<20,
+		"bytes of blastn's output sent back to the server through Redis, the rest is dropped and the results marked truncated")
+)
+
+// maxBackoff is the longest a worker waits before dialling Redis again
+// after losing it.
+const maxBackoff = time.Minute
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest,
+// so blastn isn't blocked writing output that won't be sent.
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.Len(); n > room {
+		p = p[:max(room, 0)]
+		b.truncated = true
+	}
+	b.Buffer.Write(p)
+
+	return n, nil
+}
+
+// run runs blastn for a task. Any error is reported in the result rather
+// than returned since the server is waiting to hear about it either way.
+func run(blastn string, t *workqueue.Task) workqueue.Result {
+	r := workqueue.Result{ID: t.ID, Worker: *workerName}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *config.BlastTimeout)
+	defer cancel()
+
+	cmd := blast.Command(ctx, blastn, *config.BlastDBDir, t.Args...)
+	cmd.Stdin = strings.NewReader(t.Sequence)
+
+	stdout, stderr := &cappedBuffer{max: *maxOutput}, &cappedBuffer{max: *maxOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	exitErr := &exec.ExitError{}
+	if ctx.Err() == context.DeadlineExceeded {
+		r.Error = fmt.Sprintf("blast query timed out: killed after %v", *config.BlastTimeout)
+	} else if errors.As(err, &exitErr) {
+		r.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		r.Error = err.Error()
+	}
+
+	r.Stdout = stdout.String()
+	r.Stderr = stderr.String()
+	r.Truncated = stdout.truncated
+
+	return r
+}
+
+// retry runs f until it returns nil, once stop is cancelled, waiting longer
+// and longer between goes while it keeps failing, so a Redis restart or
+// network blip doesn't take the worker down.
+func retry(stop context.Context, what string, f func(stop context.Context) error) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := f(stop)
+		if err == nil || stop.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > maxBackoff {
+			backoff = time.Second
+		}
+		slog.Error("lost the work queue, retrying", "what", what, "in", backoff, "err", err)
+		select {
+		case <-stop.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// work takes tasks until stop is cancelled, finishing the one it's on. A
+// task taken when it loses Redis is handed to another worker by the
+// reaper once its lease runs out.
+func work(stop context.Context, blastn string) error {
+	client, err := redis.Dial("tcp", *config.RedisURL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for stop.Err() == nil {
+		t, raw, err := workqueue.Take(client, *config.WorkQueuePrefix, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			continue
+		}
+
+		err = workqueue.Lease(client, *config.WorkQueuePrefix, t.ID, *workerName, *config.WorkerLeaseTTL)
+		if err != nil {
+			return err
+		}
+
+		// blastn runs while the lease is renewed, on a connection of its own
+		// since this one can't be shared
+		done := make(chan workqueue.Result)
+		go func() {
+			done <- run(blastn, t)
+		}()
+
+		logger := slog.With("task", t.ID)
+		logger.Info("running task")
+		ticker := time.NewTicker(*config.WorkerLeaseTTL / 3)
+		var r workqueue.Result
+	running:
+		for {
+			select {
+			case r = <-done:
+				break running
+			case <-ticker.C:
+				err = workqueue.Lease(client, *config.WorkQueuePrefix, t.ID, *workerName, *config.WorkerLeaseTTL)
+				if err != nil {
+					logger.Error("couldn't renew lease", "err", err)
+				}
+			}
+		}
+		ticker.Stop()
+
+		err = workqueue.Finish(client, *config.WorkQueuePrefix, t, raw, r)
+		if err != nil {
+			return err
+		}
+		logger.Info("finished task", "exitCode", r.ExitCode)
+	}
+
+	return nil
+}
+
+// heartbeat lets the server know this worker is around until stop is
+// cancelled.
+func heartbeat(stop context.Context) error {
+	client, err := redis.Dial("tcp", *config.RedisURL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ticker := time.NewTicker(*config.WorkerLeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		err = workqueue.Heartbeat(client, *config.WorkQueuePrefix, *workerName)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-stop.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+var configRules = config.Rules{
+	"blastdb.path":       config.All(config.Required, config.Dir),
+	"redis.url":          config.All(config.Required, config.HostPort),
+	"blast.timeout":      config.Positive,
+	"workers.leaseTTL":   config.Positive,
+	"worker.concurrency": config.Positive,
+	"worker.maxOutput":   config.Positive,
+}
+
+func main() {
+	loadErr := config.Load()
+	err := logging.Setup()
+	if err != nil {
+		logging.Fatal("couldn't set up logging", "err", err)
+	}
+	if loadErr != nil {
+		logging.Fatal("couldn't load config", "err", loadErr)
+	}
+	err = config.Validate(configRules)
+	if err != nil {
+		logging.Fatal("invalid config", "err", err)
+	}
+
+	if *workerName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			logging.Fatal("couldn't get hostname, set -worker.name", "err", err)
+		}
+		*workerName = fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	}
+
+	blastn, err := exec.LookPath(*config.BlastBinary)
+	if err != nil {
+		logging.Fatal("blastn isn't usable, set -blast.binary to a working BLAST+ install", "binary", *config.BlastBinary, "err", err)
+	}
+
+	stop, cancel := context.WithCancel(context.Background())
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+		<-sigs
+		slog.Info("finishing running queries before exiting")
+		cancel()
+	}()
+
+	go retry(stop, "heartbeat", heartbeat)
+
+	slog.Info("worker started", "worker", *workerName, "concurrency", *concurrency, "blastn", blastn)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			retry(stop, "work", func(stop context.Context) error {
+				return work(stop, blastn)
+			})
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+const adminUsage = `usage: synbioblast [flags] admin <command> [args]
+
+Inspects and repairs what the slurper has stored in Redis, using the same
+-redis.* and -fastas.* flags as the server.
+
+commands:
+  uris <hash>            components using the sequence with this hash
+  hash <uri>             hash of the sequence a component uses
+  count                  number of distinct sequences stored
+  delete <hash>...       remove sequences, their fastas and their components
+  reset-cursor [offset]  make the slurper carry on from offset, 0 by default
+  rekey <prefix>         move the sequence sets under a new -redis.sequencePrefix,
+                         with the slurper and servers stopped
+  rehash                 move sequences stored before the current normalization
+                         or under another -sequences.hash to their new hashes,
+                         with the slurper stopped
+  verify [-repair]       find truncated fastas and ones left half written, and
+                         with -repair remove them
+  shard                  move fastas written before they were sharded into
+                         subdirectories to where they go now
+  measure                record the length and GC content of sequences stored
+                         before they were recorded as they were slurped
+  snapshot <file>        write the components using each sequence to file, for
+                         searches of the db being built to find later on`
+
+// adminCommands are the admin subcommands, each given its arguments
+var adminCommands = map[string]func(client *redis.Client, st *store.Store, args []string) error{
+	"uris":         adminURIs,
+	"hash":         adminHash,
+	"count":        adminCount,
+	"delete":       adminDelete,
+	"reset-cursor": adminResetCursor,
+	"rekey":        adminRekey,
+	"rehash":       adminRehash,
+	"verify":       adminVerify,
+	"shard":        adminShard,
+	"measure":      adminMeasure,
+	"snapshot":     adminSnapshot,
+}
+
+// runAdmin runs "synbioblast admin", saving a trip to redis-cli and
+// working out the key layout by hand.
+func runAdmin(args []string) error {
+	if len(args) == 0 || adminCommands[args[0]] == nil {
+		return errors.New(adminUsage)
+	}
+
+	client, err := redis.Dial("tcp", *config.RedisURL)
+	if err != nil {
+		return fmt.Errorf("couldn't dial redis at %s: %v", *config.RedisURL, err)
+	}
+	defer client.Close()
+
+	return adminCommands[args[0]](client, config.Store(), args[1:])
+}
+
+func adminURIs(client *redis.Client, st *store.Store, args []string) error {
+	if len(args) != 1 || !store.ValidHash(args[0]) {
+		return errors.New("usage: synbioblast admin uris <hash>")
+	}
+
+	uris, err := st.URIs(client, args)
+	if err != nil {
+		return err
+	}
+	if len(uris[0]) == 0 {
+		return fmt.Errorf("no components use %s", args[0])
+	}
+
+	for _, uri := range uris[0] {
+		fmt.Println(uri)
+	}
+	return nil
+}
+
+func adminHash(client *redis.Client, st *store.Store, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: synbioblast admin hash <uri>")
+	}
+
+	hashes, err := st.Hashes(client, args[0])
+	if err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return fmt.Errorf("no sequence is used by %s", args[0])
+	}
+
+	for _, hash := range hashes {
+		fmt.Println(hash)
+	}
+	return nil
+}
+
+func adminCount(client *redis.Client, st *store.Store, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: synbioblast admin count")
+	}
+
+	n, err := st.Count(client)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(n)
+	return nil
+}
+
+func adminDelete(client *redis.Client, st *store.Store, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: synbioblast admin delete <hash>...")
+	}
+	for _, hash := range args {
+		if !store.ValidHash(hash) {
+			return fmt.Errorf("%q isn't a sequence hash", hash)
+		}
+	}
+
+	for _, hash := range args {
+		uris, err := st.Delete(client, hash)
+		if err != nil {
+			return fmt.Errorf("couldn't delete %s: %v", hash, err)
+		}
+
+		fmt.Printf("deleted %s and %d components\n", hash, len(uris))
+	}
+
+	fmt.Println("the blast db still has them until it's next rebuilt")
+	return nil
+}
+
+func adminResetCursor(client *redis.Client, st *store.Store, args []string) error {
+	offset := 0
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		offset, err = strconv.Atoi(args[0])
+		if err != nil || offset < 0 {
+			return fmt.Errorf("%q isn't a valid offset", args[0])
+		}
+	default:
+		return errors.New("usage: synbioblast admin reset-cursor [offset]")
+	}
+
+	old, err := st.Cursor(client)
+	if err != nil {
+		return err
+	}
+
+	err = st.SetCursor(client, offset)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("cursor was %d, now %d\n", old, offset)
+	return nil
+}
+
+func adminRekey(client *redis.Client, st *store.Store, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: synbioblast admin rekey <prefix>")
+	}
+
+	n, err := st.Rekey(client, args[0])
+	if err != nil && n > 0 {
+		return fmt.Errorf("moved %d sequences, then: %v", n, err)
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("moved %d sequences, now run everything with -redis.sequencePrefix=%s\n", n, args[0])
+	return nil
+}
+
+func adminRehash(client *redis.Client, st *store.Store, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: synbioblast admin rehash")
+	}
+
+	n, err := st.Rehash(client)
+	if err != nil && n > 0 {
+		return fmt.Errorf("moved %d sequences, then: %v", n, err)
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("moved %d sequences\n", n)
+	if n > 0 {
+		fmt.Println("rebuild the blast db and k-mer index to search them under their new hashes")
+	}
+	return nil
+}
+
+func adminVerify(client *redis.Client, st *store.Store, args []string) error {
+	repair := false
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == "-repair":
+		repair = true
+	default:
+		return errors.New("usage: synbioblast admin verify [-repair]")
+	}
+
+	bad, err := st.VerifyFastas(client, repair)
+	for _, b := range bad {
+		fmt.Printf("%s: %s\n", b.Path, b.Problem)
+		for _, uri := range b.URIs {
+			fmt.Printf("  used by %s\n", uri)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(bad) == 0:
+		fmt.Println("every fasta is whole")
+	case repair:
+		fmt.Printf("removed %d files, slurp their components again to write their fastas, reset-cursor 0 does that for everything\n", len(bad))
+	default:
+		fmt.Printf("%d files need repairing, run verify -repair to remove them before the db is next built\n", len(bad))
+	}
+	return nil
+}
+
+func adminShard(client *redis.Client, st *store.Store, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: synbioblast admin shard")
+	}
+
+	n, err := st.Shard()
+	if err != nil && n > 0 {
+		return fmt.Errorf("moved %d fastas, then: %v", n, err)
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("moved %d fastas\n", n)
+	return nil
+}
+
+func adminMeasure(client *redis.Client, st *store.Store, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: synbioblast admin measure")
+	}
+
+	n, err := st.MeasureAll(client)
+	if err != nil && n > 0 {
+		return fmt.Errorf("measured %d sequences, then: %v", n, err)
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Printf("measured %d sequences\n", n)
+	return nil
+}
+
+// adminSnapshot writes the file builddb.sh keeps with each db, see
+// store.WriteSnapshot. It's written next to file and moved into place once
+// it's complete, so a server never reads half of one.
+func adminSnapshot(client *redis.Client, st *store.Store, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: synbioblast admin snapshot <file>")
+	}
+
+	f, err := os.Create(args[0] + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := st.WriteSnapshot(client, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.Name(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote the components of %d sequences\n", n)
+	return nil
+}
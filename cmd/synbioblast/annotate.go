@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schnauzer/synbioblast/logging"
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+var (
+	annotateMinIdentity = flag.Float64("annotate.minIdentity", 90, "minimum percent identity of the parts /api/v1/annotate finds in a plasmid")
+	annotateMaxOverlap  = flag.Float64("annotate.maxOverlap", 0.2,
+		"fraction of a feature that can overlap a better one before /api/v1/annotate drops it as redundant, between 0 and 1")
+)
+
+// annotateArgs tune blastn for finding whole parts in a plasmid. The word
+// size is small enough to find short parts like RBSs, and low complexity
+// stretches aren't masked since plenty of parts, terminators especially,
+// would be.
+func annotateArgs() []string {
+	return []string{
+		"-task", "blastn",
+		"-word_size", "11",
+		"-dust", "no",
+		"-soft_masking", "false",
+		"-perc_identity", strconv.FormatFloat(*annotateMinIdentity, 'g', -1, 64),
+	}
+}
+
+// annotateRequest is the JSON body accepted by the annotate API.
+type annotateRequest struct {
+	Sequence string `json:"sequence"`
+
+	// Linear annotates the sequence as given, rather than as a plasmid
+	// with features across its origin
+	Linear bool `json:"linear,omitempty"`
+
+	// Name is the LOCUS name of the GenBank record, from the fasta header
+	// or GenBank definition if empty
+	Name string `json:"name,omitempty"`
+}
+
+// feature is a part found in an annotated sequence.
+type feature struct {
+	// Start and End are where the feature is on the query, counting from
+	// 1. End is before Start for features across the origin of a plasmid.
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Strand string `json:"strand"`
+
+	// Type is the GenBank feature key for Role, misc_feature if there isn't
+	// one
+	Type string `json:"type"`
+	Role string `json:"role,omitempty"`
+
+	// Label is the display id of the first component using the part
+	Label string `json:"label"`
+
+	Identity float64 `json:"identity"`
+	EValue   float64 `json:"evalue"`
+	BitScore float64 `json:"bitScore"`
+
+	SeqHash         string   `json:"seqHash"`
+	URIs            []string `json:"uris"`
+	URIsUnavailable bool     `json:"urisUnavailable,omitempty"`
+}
+
+type annotateResponse struct {
+	Name     string    `json:"name"`
+	Sequence string    `json:"sequence"`
+	Circular bool      `json:"circular"`
+	Features []feature `json:"features"`
+
+	// SameAs are parts nearly identical to the whole query, which aren't
+	// features of it so much as what it already is
+	SameAs []feature `json:"sameAs,omitempty"`
+
+	// URIsUnavailable is set when Redis couldn't be reached, see
+	// blast.Results
+	URIsUnavailable bool `json:"urisUnavailable,omitempty"`
+
+	// Manifest says what the features were found by searching
+	Manifest *blast.Manifest `json:"manifest,omitempty"`
+
+	// GenBank is the query as a GenBank record with the features, and the
+	// manifest as its comment
+	GenBank string `json:"genbank"`
+}
+
+// apiAnnotateHandler finds the known parts in a plasmid and returns them
+// as a feature table, with no overlapping duplicates, in one call rather
+// than a search whose hits have to be picked through by hand.
+func apiAnnotateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "annotating requires POST")
+		return
+	}
+
+	req := annotateRequest{}
+	limitBody(w, r)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if msg, ok := bodyTooLarge(err); ok {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, msg)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "couldn't parse request body: "+err.Error())
+		return
+	}
+
+	search := searchRequest{
+		Sequence: req.Sequence,
+		Options:  align.Options{Circular: !req.Linear, Annotate: true},
+	}
+	err = search.validate(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !align.IsNucleotide(search.Sequence) {
+		writeAPIError(w, http.StatusBadRequest, "only nucleotide sequences can be annotated")
+		return
+	}
+
+	if !blastSlots.TryAcquire(queryWeight(search.Options)) {
+		tooBusy(w)
+		writeAPIError(w, http.StatusTooManyRequests, "all blast slots are busy, try again later")
+		return
+	}
+	defer blastSlots.Release(queryWeight(search.Options))
+
+	results, err := alignQuery(r.Context(), search.Sequence, search.Options)
+	if err != nil {
+		logging.From(r.Context()).Error("annotation search failed", "err", err, "stderr", results.Stderr())
+		status, msg := searchFailure(err)
+		writeAPIError(w, status, msg)
+		return
+	}
+
+	name, definition := req.Name, ""
+	if search.input != nil {
+		definition = search.input.Header
+	}
+	if name == "" {
+		name = definition
+	}
+
+	resp := &annotateResponse{
+		Name:            locusName(name),
+		Sequence:        search.Sequence,
+		Circular:        search.Circular,
+		URIsUnavailable: results.URIsUnavailable,
+		Manifest:        results.Manifest,
+	}
+	resp.Features, resp.SameAs = annotate(results)
+	resp.GenBank = genbankRecord(resp, definition)
+
+	setServerTimings(w, results.Timings)
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, resp)
+	case "genbank":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", resp.Name+".gb"))
+		io.WriteString(w, resp.GenBank)
+	default:
+		writeAPIError(w, http.StatusBadRequest, "unknown format "+r.URL.Query().Get("format"))
+	}
+}
+
+// annotate picks the features out of a search of a plasmid. Only hits the
+// alignment covers nearly all of are features, and where they overlap the
+// best scoring one is kept. Composite parts are only kept where there's
+// nothing more specific, or every plasmid that happens to be in SynBioHub
+// as a whole would just be one big feature.
+func annotate(r *blast.Results) (features, sameAs []feature) {
+	candidates := []blast.Hit{}
+	for _, hit := range r.Results {
+		switch hit.Containment {
+		case blast.ContainmentNearIdentical:
+			sameAs = append(sameAs, newFeature(hit))
+		case blast.ContainmentQueryContainsPart:
+			candidates = append(candidates, hit)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ci, cj := isComposite(candidates[i]), isComposite(candidates[j])
+		if ci != cj {
+			return !ci
+		}
+		return candidates[i].BitScore > candidates[j].BitScore
+	})
+
+	features = []feature{}
+	for _, hit := range candidates {
+		f := newFeature(hit)
+
+		redundant := false
+		for _, kept := range features {
+			if overlap(f, kept, r.QueryLen) > *annotateMaxOverlap*float64(featureLen(f, r.QueryLen)) {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			features = append(features, f)
+		}
+	}
+
+	sort.SliceStable(features, func(i, j int) bool {
+		return features[i].Start < features[j].Start
+	})
+
+	return features, sameAs
+}
+
+// isComposite reports whether a hit is only used by composite parts,
+// devices or constructs made of other parts.
+func isComposite(hit blast.Hit) bool {
+	for _, role := range hit.Roles {
+		if role != "SO:0000804" {
+			return false
+		}
+	}
+
+	return len(hit.Roles) > 0
+}
+
+func newFeature(hit blast.Hit) feature {
+	f := feature{
+		Start:           hit.QueryFrom,
+		End:             hit.QueryTo,
+		Strand:          hit.Strand,
+		Type:            "misc_feature",
+		Label:           hit.SeqHash,
+		Identity:        hit.IdentityPercent(),
+		EValue:          hit.EValue,
+		BitScore:        hit.BitScore,
+		SeqHash:         hit.SeqHash,
+		URIs:            hit.URIs,
+		URIsUnavailable: hit.URIsUnavailable,
+	}
+	if f.URIs == nil {
+		f.URIs = []string{}
+	}
+	if len(f.URIs) > 0 {
+		f.Label = displayID(f.URIs[0])
+	}
+
+	for _, role := range hit.Roles {
+		if key, ok := genbankKeys[role]; ok {
+			f.Role, f.Type = role, key
+			break
+		}
+	}
+	if f.Role == "" && len(hit.Roles) > 0 {
+		f.Role = hit.Roles[0]
+	}
+
+	return f
+}
+
+// genbankKeys are the GenBank feature keys of the roles with glyphs, see
+// render.SBOLGlyph.
+var genbankKeys = map[string]string{
+	"SO:0000167": "promoter",
+	"SO:0000316": "CDS",
+	"SO:0000141": "terminator",
+	"SO:0000139": "RBS",
+	"SO:0000057": "protein_bind",
+	"SO:0000627": "misc_feature",
+	"SO:0000296": "rep_origin",
+	"SO:0005850": "primer_bind",
+	"SO:0000374": "misc_RNA",
+	"SO:0000804": "misc_feature",
+}
+
+// displayID returns the display id of a SynBioHub uri, e.g. BBa_B0034
+// for https://synbiohub.org/public/igem/BBa_B0034/1.
+func displayID(uri string) string {
+	parts := strings.Split(strings.TrimRight(uri, "/"), "/")
+	id := parts[len(parts)-1]
+	if uriVersion.MatchString(id) && len(parts) > 1 {
+		id = parts[len(parts)-2]
+	}
+
+	return id
+}
+
+var uriVersion = regexp.MustCompile(`^[0-9][0-9.]*$`)
+
+// spans returns the stretches of the query a feature covers, two for one
+// across the origin.
+func spans(f feature, queryLen int) [][2]int {
+	if f.End < f.Start {
+		return [][2]int{{f.Start, queryLen}, {1, f.End}}
+	}
+
+	return [][2]int{{f.Start, f.End}}
+}
+
+func featureLen(f feature, queryLen int) int {
+	n := 0
+	for _, s := range spans(f, queryLen) {
+		n += s[1] - s[0] + 1
+	}
+
+	return n
+}
+
+// overlap returns how many positions of the query two features share.
+func overlap(a, b feature, queryLen int) float64 {
+	n := 0
+	for _, x := range spans(a, queryLen) {
+		for _, y := range spans(b, queryLen) {
+			from, to := x[0], x[1]
+			if y[0] > from {
+				from = y[0]
+			}
+			if y[1] < to {
+				to = y[1]
+			}
+			if to >= from {
+				n += to - from + 1
+			}
+		}
+	}
+
+	return float64(n)
+}
+
+// locusName makes a name fit for a LOCUS line, which can't have spaces.
+func locusName(name string) string {
+	name = strings.Join(strings.FieldsFunc(name, func(c rune) bool {
+		return !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.", c)
+	}), "_")
+	if name == "" {
+		return "annotated"
+	}
+
+	return name
+}
+
+// genbankRecord writes an annotated sequence as a GenBank flat file.
+func genbankRecord(a *annotateResponse, definition string) string {
+	topology := "linear"
+	if a.Circular {
+		topology = "circular"
+	}
+	if definition == "" {
+		definition = "Annotated by SynBioBLAST"
+	}
+	if !strings.HasSuffix(definition, ".") {
+		definition += "."
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "LOCUS       %-16s %11d bp    DNA     %-8s SYN %s\n",
+		a.Name, len(a.Sequence), topology, strings.ToUpper(time.Now().Format("02-Jan-2006")))
+	fmt.Fprintf(b, "DEFINITION  %s\n", definition)
+	if a.Manifest != nil {
+		keyword := "COMMENT"
+		for _, line := range a.Manifest.Lines() {
+			for _, wrapped := range wrapWords(line, 67) {
+				fmt.Fprintf(b, "%-12s%s\n", keyword, wrapped)
+				keyword = ""
+			}
+		}
+	}
+	b.WriteString("FEATURES             Location/Qualifiers\n")
+	for _, f := range a.Features {
+		fmt.Fprintf(b, "     %-16s%s\n", f.Type, genbankLocation(f, len(a.Sequence)))
+		writeQualifier(b, "label", f.Label)
+		note := fmt.Sprintf("%.1f%% identical to %s", f.Identity, f.SeqHash)
+		if len(f.URIs) > 0 {
+			note = fmt.Sprintf("%.1f%% identical to %s", f.Identity, f.URIs[0])
+		}
+		writeQualifier(b, "note", note)
+	}
+
+	b.WriteString("ORIGIN\n")
+	for i := 0; i < len(a.Sequence); i += 60 {
+		fmt.Fprintf(b, "%9d", i+1)
+		for j := i; j < i+60 && j < len(a.Sequence); j += 10 {
+			end := j + 10
+			if end > len(a.Sequence) {
+				end = len(a.Sequence)
+			}
+			b.WriteString(" " + a.Sequence[j:end])
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("//\n")
+
+	return b.String()
+}
+
+func genbankLocation(f feature, queryLen int) string {
+	location := fmt.Sprintf("%d..%d", f.Start, f.End)
+	if f.End < f.Start {
+		location = fmt.Sprintf("join(%d..%d,1..%d)", f.Start, queryLen, f.End)
+	}
+	if f.Strand == "minus" {
+		location = "complement(" + location + ")"
+	}
+
+	return location
+}
+
+// wrapWords breaks s into lines of at most width columns between words,
+// as long as no one word is longer.
+func wrapWords(s string, width int) []string {
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+
+	return append(lines, line)
+}
+
+// writeQualifier writes a feature qualifier, wrapped to GenBank's 79
+// columns.
+func writeQualifier(b *strings.Builder, name, value string) {
+	line := "/" + name + "=\"" + strings.ReplaceAll(value, "\"", "\"\"") + "\""
+	for len(line) > 58 {
+		fmt.Fprintf(b, "%21s%s\n", "", line[:58])
+		line = line[58:]
+	}
+	fmt.Fprintf(b, "%21s%s\n", "", line)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDisplayID(t *testing.T) {
+	tests := map[string]string{
+		"https://synbiohub.org/public/igem/BBa_B0034/1":    "BBa_B0034",
+		"https://synbiohub.org/public/igem/BBa_B0034/1.2/": "BBa_B0034",
+		"https://synbiohub.org/public/igem/BBa_B0034":      "BBa_B0034",
+		"BBa_B0034": "BBa_B0034",
+	}
+
+	for uri, want := range tests {
+		if got := displayID(uri); got != want {
+			t.Errorf("displayID(%s) = %s, want %s", uri, got, want)
+		}
+	}
+}
+
+func TestGenbankLocation(t *testing.T) {
+	tests := []struct {
+		feature  feature
+		location string
+	}{
+		{feature{Start: 5, End: 20, Strand: "plus"}, "5..20"},
+		{feature{Start: 5, End: 20, Strand: "minus"}, "complement(5..20)"},
+		{feature{Start: 90, End: 10, Strand: "plus"}, "join(90..100,1..10)"},
+		{feature{Start: 90, End: 10, Strand: "minus"}, "complement(join(90..100,1..10))"},
+	}
+
+	for _, test := range tests {
+		if got := genbankLocation(test.feature, 100); got != test.location {
+			t.Errorf("genbankLocation(%+v) = %s, want %s", test.feature, got, test.location)
+		}
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		a, b    feature
+		overlap float64
+	}{
+		{feature{Start: 1, End: 10}, feature{Start: 5, End: 20}, 6},
+		{feature{Start: 1, End: 10}, feature{Start: 11, End: 20}, 0},
+		{feature{Start: 95, End: 5}, feature{Start: 1, End: 10}, 5},
+		{feature{Start: 95, End: 5}, feature{Start: 90, End: 2}, 8},
+	}
+
+	for _, test := range tests {
+		if got := overlap(test.a, test.b, 100); got != test.overlap {
+			t.Errorf("overlap(%+v, %+v) = %v, want %v", test.a, test.b, got, test.overlap)
+		}
+	}
+	if got := featureLen(feature{Start: 95, End: 5}, 100); got != 11 {
+		t.Errorf("featureLen across the origin = %d, want 11", got)
+	}
+}
+
+func TestLocusName(t *testing.T) {
+	tests := map[string]string{
+		"pSB1C3":          "pSB1C3",
+		"my plasmid (v2)": "my_plasmid_v2",
+		"BBa_B0034.1":     "BBa_B0034.1",
+		"  ":              "annotated",
+	}
+
+	for name, want := range tests {
+		if got := locusName(name); got != want {
+			t.Errorf("locusName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestWrapWords(t *testing.T) {
+	got := wrapWords("blastn 2.15.0 against SynBioHub built 2024-01-01", 20)
+	want := []string{"blastn 2.15.0", "against SynBioHub", "built 2024-01-01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrapWords = %q, want %q", got, want)
+	}
+
+	for _, line := range wrapWords(strings.Repeat("a", 30)+" b", 20) {
+		if line != strings.Repeat("a", 30) && line != "b" {
+			t.Errorf("long word wrapped to %q, want it on a line of its own", line)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/schnauzer/synbioblast/client"
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+const benchUsage = `usage: synbioblast [flags] bench [bench flags] <corpus.fasta>
+
+Replays the queries in a fasta file, or a file of one sequence per line, and
+reports latency percentiles and throughput. Queries are sent to a running
+server with -url, otherwise blastn is run directly with the server's
+-blast.* and -blastdb.* flags.
+
+bench flags:`
+
+// runBench runs "synbioblast bench", for capacity planning and catching
+// performance regressions.
+func runBench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	url := flags.String("url", "", "base URL of a running server to send queries to, e.g. http://localhost:8080")
+	concurrency := flags.Int("concurrency", 4, "number of queries to run at once")
+	requests := flags.Int("requests", 0, "number of queries to run, going round the corpus as many times as needed, once through it if 0")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), benchUsage)
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *concurrency < 1 || *requests < 0 {
+		flags.Usage()
+		return errors.New("bench needs one corpus file, a positive -concurrency and a non-negative -requests")
+	}
+	c, err := newLiveConfig()
+	if err != nil {
+		return err
+	}
+	live.Store(c)
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	corpus, err := readCorpus(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if len(corpus) == 0 {
+		return fmt.Errorf("%s has no queries in it", flags.Arg(0))
+	}
+
+	search := benchHTTP(*url)
+	if *url == "" {
+		search, err = benchDirect()
+		if err != nil {
+			return err
+		}
+	}
+
+	n := *requests
+	if n == 0 {
+		n = len(corpus)
+	}
+
+	fmt.Fprintf(os.Stderr, "running %d queries, %d at a time\n", n, *concurrency)
+	report := bench(corpus, n, *concurrency, search)
+	return report.print(os.Stdout)
+}
+
+// readCorpus reads queries from fasta, or from one sequence per line if
+// there are no headers.
+func readCorpus(r io.Reader) ([]string, error) {
+	var queries []string
+	var current strings.Builder
+	fasta := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, ">"):
+			fasta = true
+			if current.Len() > 0 {
+				queries = append(queries, current.String())
+				current.Reset()
+			}
+		case line == "":
+		case fasta:
+			current.WriteString(line)
+		default:
+			queries = append(queries, line)
+		}
+	}
+	if current.Len() > 0 {
+		queries = append(queries, current.String())
+	}
+
+	return queries, scanner.Err()
+}
+
+// benchSearch runs one query of the benchmark.
+type benchSearch func(ctx context.Context, seq string) error
+
+// benchHTTP searches a running server through its API.
+func benchHTTP(url string) benchSearch {
+	c := client.New(url)
+	return func(ctx context.Context, seq string) error {
+		_, err := c.Search(ctx, seq)
+		return err
+	}
+}
+
+// benchDirect runs blastn against the current db without a server, to
+// measure blastn and the parser alone.
+func benchDirect() (benchSearch, error) {
+	binary, version, err := blast.Find(*config.BlastBinary)
+	if err != nil {
+		return nil, fmt.Errorf("blastn isn't usable, set -blast.binary to a working BLAST+ install: %v", err)
+	}
+
+	db, err := currentDB()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "running %s (%s) against %s\n", binary, version, db)
+
+	return func(ctx context.Context, seq string) error {
+		seq, _, err := blast.NormalizeQuery(seq, 0)
+		if err != nil {
+			return err
+		}
+
+		_, err = blast.Search{
+			Binary:  binary,
+			DBDir:   *config.BlastDBDir,
+			Args:    append(append([]string{"-db", db}, blastArgs(align.Options{})...), outputArgs(version)...),
+			Timeout: cfg().blastTimeout,
+			MaxHits: cfg().maxHits,
+		}.Run(ctx, seq)
+		return err
+	}, nil
+}
+
+// benchReport is how a benchmark went.
+type benchReport struct {
+	took      time.Duration
+	latencies []time.Duration
+	errors    []error
+}
+
+// bench runs n queries from corpus, concurrency at a time.
+func bench(corpus []string, n, concurrency int, search benchSearch) *benchReport {
+	report := &benchReport{}
+	var mu sync.Mutex
+
+	queries := make(chan string)
+	go func() {
+		for i := 0; i < n; i++ {
+			queries <- corpus[i%len(corpus)]
+		}
+		close(queries)
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+
+			for seq := range queries {
+				queryStart := time.Now()
+				err := search(context.Background(), seq)
+				took := time.Since(queryStart)
+
+				mu.Lock()
+				if err != nil {
+					report.errors = append(report.errors, err)
+				} else {
+					report.latencies = append(report.latencies, took)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	report.took = time.Since(start)
+
+	sort.Slice(report.latencies, func(a, b int) bool {
+		return report.latencies[a] < report.latencies[b]
+	})
+
+	return report
+}
+
+// percentile returns the latency p of the successful queries were at or
+// under, p being between 0 and 1.
+func (r *benchReport) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+
+	i := int(math.Ceil(p*float64(len(r.latencies)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return r.latencies[i]
+}
+
+func (r *benchReport) print(w io.Writer) error {
+	for i, err := range r.errors {
+		if i == 5 {
+			fmt.Fprintf(os.Stderr, "... and %d more errors\n", len(r.errors)-i)
+			break
+		}
+		fmt.Fprintln(os.Stderr, "query failed:", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "queries\t%d\n", len(r.latencies)+len(r.errors))
+	fmt.Fprintf(tw, "failed\t%d\n", len(r.errors))
+	fmt.Fprintf(tw, "took\t%v\n", r.took.Round(time.Millisecond))
+	fmt.Fprintf(tw, "throughput\t%.2f queries/s\n", float64(len(r.latencies))/r.took.Seconds())
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		fmt.Fprintf(tw, "p%g\t%v\n", p*100, r.percentile(p).Round(time.Millisecond))
+	}
+	fmt.Fprintf(tw, "max\t%v\n", r.percentile(1).Round(time.Millisecond))
+
+	return tw.Flush()
+}
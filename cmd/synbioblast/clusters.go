@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/schnauzer/synbioblast/config"
+)
+
+// loadClusters reads <db>.clusters, which builddb.sh writes when it
+// clusters near-identical sequences, returning nil if there isn't one. Each
+// line is a cluster representative's hash and the hash of another member of
+// its cluster, tab separated. The representatives are in their own blast
+// db, <db>.reps.
+func loadClusters(name string) (map[string][]string, error) {
+	f, err := os.Open(path.Join(os.ExpandEnv(*config.BlastDBDir), name+".clusters"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	members := map[string][]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("line %d isn't a representative and a member: %q", line, scanner.Text())
+		}
+
+		members[fields[0]] = append(members[fields[0]], fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// clustered returns the name of the db's blast db of cluster
+// representatives and the other members of each cluster, nil if it wasn't
+// clustered.
+func (d *blastDB) clustered() (name string, members map[string][]string) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.clusters == nil {
+		return "", nil
+	}
+
+	return d.name + ".reps", d.clusters
+}
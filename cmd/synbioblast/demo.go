@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/schnauzer/synbioblast"
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/slurp"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+// demoMode swaps Redis for miniredis, the db for the components bundled in
+// synbioblast.DemoComponents and blastn for demoAligner, so the server runs
+// with nothing else installed.
+var demoMode = flag.Bool("demo", false,
+	"serve a bundled miniature db with redis held in memory and searches run in-process instead of by blastn, for trying the server out or working on its pages with nothing installed")
+
+// demo is the infrastructure -demo stands up in place of the real thing.
+type demo struct {
+	redis *miniredis.Miniredis
+	dir   string
+
+	// sequences are the db's, by hash, and length their total length
+	sequences []demoSequence
+	length    int
+}
+
+type demoSequence struct {
+	hash, seq string
+}
+
+var demoDB *demo
+
+// setupDemo starts the in-memory Redis and writes the demo db to a
+// temporary directory, pointing the flags that say where they are at them
+// so the rest of startup finds them as it would the real ones. It's run
+// before the flags are validated.
+func setupDemo() (*demo, error) {
+	if *remoteWorkers {
+		return nil, errors.New("-demo runs searches itself, so it can't be used with -workers.remote")
+	}
+
+	d := &demo{}
+	var err error
+	d.redis, err = miniredis.Run()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start miniredis: %v", err)
+	}
+
+	d.dir, err = os.MkdirTemp("", "synbioblast-demo-")
+	if err != nil {
+		d.close()
+		return nil, err
+	}
+
+	dirs := map[string]string{
+		"blastdb.path":       d.dir,
+		"fastas.path":        filepath.Join(d.dir, "fastas"),
+		"fastas.proteinPath": filepath.Join(d.dir, "proteins"),
+	}
+	for name, dir := range dirs {
+		err = os.MkdirAll(dir, 0755)
+		if err == nil {
+			err = flag.Set(name, dir)
+		}
+		if err != nil {
+			d.close()
+			return nil, fmt.Errorf("couldn't set -%s: %v", name, err)
+		}
+	}
+	flag.Set("redis.url", d.redis.Addr())
+
+	err = d.load()
+	if err != nil {
+		d.close()
+		return nil, fmt.Errorf("couldn't load the demo components: %v", err)
+	}
+
+	return d, nil
+}
+
+// load stores the bundled components as the slurper would, less the ORFs,
+// and writes a db of them as builddb.sh would, bar the files blastn reads.
+func (d *demo) load() error {
+	client, err := redis.Dial("tcp", d.redis.Addr())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	st := config.Store()
+	components := make(chan store.Component)
+	var parseErr error
+	parsed := make(chan struct{})
+	go func() {
+		_, _, parseErr = slurp.Parse(context.Background(), bytes.NewReader(synbioblast.DemoComponents), slurp.ResultsXML, components)
+		close(parsed)
+	}()
+
+	newURIs := 0
+	var newest time.Time
+	seen := map[string]bool{}
+	for c := range components {
+		c.Source = "https://synbiohub.org"
+		if err == nil {
+			_, _, err = st.WriteFasta(&c)
+		}
+		if err == nil {
+			var added bool
+			added, err = st.Add(client, &c)
+			if added {
+				newURIs++
+			}
+		}
+
+		hash := st.Hash(c.Sequence)
+		if !seen[hash] && !c.Protein {
+			seen[hash] = true
+			d.sequences = append(d.sequences, demoSequence{hash, c.Sequence})
+			d.length += len(c.Sequence)
+		}
+		if c.Created.After(newest) {
+			newest = c.Created
+		}
+	}
+	<-parsed
+	if err == nil {
+		err = parseErr
+	}
+	if err == nil {
+		err = st.SyncFastas()
+	}
+	if err == nil {
+		err = st.RecordSlurp(client, newURIs, newest)
+	}
+	if err != nil {
+		return err
+	}
+
+	name := *config.BlastDBName + "-" + time.Now().UTC().Format("20060102T150405Z")
+	err = os.WriteFile(path.Join(d.dir, name+".nin"), nil, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path.Join(d.dir, *config.BlastDBName+".current"), []byte(name+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("serving the demo db", "components", newURIs, "sequences", len(d.sequences), "dir", d.dir)
+	return nil
+}
+
+// close stops the in-memory Redis and deletes the demo db.
+func (d *demo) close() {
+	d.redis.Close()
+	if d.dir != "" {
+		os.RemoveAll(d.dir)
+	}
+}
+
+// demoReadinessChecks replace readinessChecks with -demo, there being no
+// blastn to check.
+var demoReadinessChecks = []struct {
+	name     string
+	check    func(ctx context.Context) error
+	critical bool
+}{
+	{"redis", checkRedis, true},
+	{"blastdb", checkBlastDB, true},
+}
+
+// demoAligner stands in for blastn with -demo, finding the best ungapped
+// alignment of the query with each sequence in the demo db on either
+// strand, scored as blastn's megablast scores them. That's a far cry from
+// blastn, but it finds the parts a query has in it, which is enough to show
+// what the pages do with results.
+type demoAligner struct{}
+
+// the scores and Karlin-Altschul parameters of blastn's default scoring,
+// +1/-2
+const (
+	demoMatch     = 1
+	demoMismatch  = -2
+	demoLambda    = 1.28
+	demoK         = 0.46
+	demoMinScore  = 11
+	demoMaxEValue = 10
+)
+
+func (demoAligner) Align(ctx context.Context, seq string, opts align.Options) (*blast.Results, error) {
+	if opts.Clustered || opts.DBVersion != "" || opts.Subject != "" {
+		return nil, errors.New("-demo only searches the current db, unclustered")
+	}
+	start := time.Now()
+
+	query := strings.ToLower(align.Residues(seq))
+	db, _ := activeDB.get()
+	results := &blast.Results{
+		ParserVersion: blast.ParserVersion,
+		Program:       "blastn",
+		Version:       blastVersion,
+		Reference:     "synbioblast -demo, which runs ungapped alignments in-process",
+		DB:            db,
+		QueryID:       "Query_1",
+		QueryDef:      "No definition line",
+		QueryLen:      len(query),
+		DBNum:         len(demoDB.sequences),
+		DBLen:         demoDB.length,
+	}
+
+	for i, s := range demoDB.sequences {
+		if err := ctx.Err(); err != nil {
+			return &blast.Results{Query: seq}, err
+		}
+
+		hit, ok := demoAlign(query, s.seq, demoDB.length)
+		if !ok {
+			continue
+		}
+		hit.ID = "gnl|BL_ORD_ID|" + strconv.Itoa(i)
+		hit.Accession = strconv.Itoa(i)
+		hit.SeqHash = s.hash
+		results.Results = append(results.Results, hit)
+	}
+
+	sort.SliceStable(results.Results, func(i, j int) bool {
+		return results.Results[i].BitScore > results.Results[j].BitScore
+	})
+	if max := cfg().maxHits; max > 0 && len(results.Results) > max {
+		results.Results = results.Results[:max]
+		results.Truncated = true
+	}
+	for i := range results.Results {
+		results.Results[i].Num = i + 1
+		results.Results[i].Iteration = 1
+	}
+
+	iteration := blast.Iteration{
+		Num:      1,
+		QueryID:  results.QueryID,
+		QueryDef: results.QueryDef,
+		QueryLen: results.QueryLen,
+		Hits:     len(results.Results),
+	}
+	if len(results.Results) == 0 {
+		iteration.Message = blast.NoHitsMessage
+		results.Message = blast.NoHitsMessage
+	}
+	results.Iterations = []blast.Iteration{iteration}
+
+	return finishBlast(ctx, results, seq, []string{"-demo"}, start), nil
+}
+
+// demoAlign returns the best ungapped alignment of query with subject, on
+// either strand, if it's significant in a db of dbLength residues.
+func demoAlign(query, subject string, dbLength int) (blast.Hit, bool) {
+	best := blast.Hit{}
+	bestScore := 0
+	for _, minus := range []bool{false, true} {
+		target := subject
+		if minus {
+			target = reverseComplement(subject)
+		}
+
+		// each diagonal's best run, by Kadane's algorithm
+		for offset := -len(query) + 1; offset < len(target); offset++ {
+			score, runStart := 0, 0
+			for i := max(0, -offset); i < len(query) && i+offset < len(target); i++ {
+				if score <= 0 {
+					score, runStart = 0, i
+				}
+				if query[i] == target[i+offset] {
+					score += demoMatch
+				} else {
+					score += demoMismatch
+				}
+
+				if score > bestScore {
+					bestScore = score
+					best = demoHit(query[runStart:i+1], target[runStart+offset:i+offset+1], runStart, runStart+offset, len(subject), minus)
+				}
+			}
+		}
+	}
+
+	if bestScore < demoMinScore {
+		return best, false
+	}
+
+	best.Score = bestScore
+	best.BitScore = math.Round((demoLambda*float64(bestScore)-math.Log(demoK))/math.Ln2*100) / 100
+	best.EValue = float64(len(query)) * float64(dbLength) * math.Pow(2, -best.BitScore)
+
+	return best, best.EValue <= demoMaxEValue
+}
+
+// demoHit is the alignment of q, from query[queryStart:], with t, from
+// targetStart in subject or its reverse complement if minus.
+func demoHit(q, t string, queryStart, targetStart, subjectLen int, minus bool) blast.Hit {
+	hit := blast.Hit{
+		Len:        subjectLen,
+		QueryFrom:  queryStart + 1,
+		QueryTo:    queryStart + len(q),
+		HitFrom:    targetStart + 1,
+		HitTo:      targetStart + len(t),
+		QueryFrame: 1,
+		HitFrame:   1,
+		QuerySeq:   q,
+		HitSeq:     t,
+	}
+	if minus {
+		hit.HitFrom, hit.HitTo = subjectLen-targetStart, subjectLen-targetStart-len(t)+1
+		hit.HitFrame = -1
+	}
+	hit.Strand = blast.FrameStrand(hit.QueryFrame, hit.HitFrame)
+
+	midline := make([]byte, len(q))
+	for i := range q {
+		midline[i] = ' '
+		if q[i] == t[i] {
+			midline[i] = '|'
+			hit.Identity++
+		}
+	}
+	hit.Midline = string(midline)
+	hit.AlignLen = len(q)
+
+	return hit
+}
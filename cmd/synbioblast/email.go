@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/schnauzer/synbioblast/pkg/jobqueue"
+	"github.com/schnauzer/synbioblast/pkg/render"
+	"github.com/schnauzer/synbioblast/pkg/savedsearch"
+)
+
+var (
+	emailSiteURL = flag.String("email.siteURL", "",
+		"web address people reach the server at, including -http.basePath, that links in emails start with, required with -smtp.addr")
+	digestInterval = flag.Duration("email.digestInterval", 7*24*time.Hour,
+		"how often saved searches with digest set have their new hits mailed together")
+	confirmInterval = flag.Duration("email.confirmInterval", 24*time.Hour,
+		"how long to wait before asking an address that hasn't confirmed it wants emails again")
+
+	redisEmailKey = flag.String("redis.emailAddresses", "emailAddresses",
+		"Redis key for hash storing each email address's unsubscribe token and the emails it's opted out of")
+	redisDigestKey = flag.String("redis.emailDigests", "emailDigests",
+		"Redis key for hash storing the saved search alerts waiting for each email address's next digest")
+)
+
+// The kinds of email, which can each be unsubscribed from, bar
+// emailConfirm, which asks an address whether it wants the others.
+const (
+	emailJob     = "job"
+	emailAlert   = "alert"
+	emailDigest  = "digest"
+	emailAll     = "all"
+	emailConfirm = "confirm"
+)
+
+// emailTemplates are the emails, a "<kind>.subject" and "<kind>" body for
+// each kind, executed with an emailData.
+var emailTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"formatEValue": render.FormatEValue,
+	"rewriteURI":   rewriteURI,
+}).Parse(`
+{{- define "hits"}}{{range .}}
+{{printf "%.1f" .IdentityPercent}}% identical over {{.AlignLen}} bases, e-value {{formatEValue .EValue}}
+{{- range .URIs}}
+    {{rewriteURI .}}{{end}}{{end}}{{end}}
+
+{{- define "confirm.subject"}}Confirm you want emails from SynBioBLAST{{end}}
+{{- define "confirm"}}This address was given with a search on SynBioBLAST. It won't be sent
+the search's results, or anything else, until you confirm it wants them:
+
+{{.Site}}/email/confirm?{{.Data}}
+
+If it wasn't you, ignore this email and you won't hear from the server again.
+{{end}}
+
+{{- define "job.subject"}}Your SynBioBLAST search {{if eq .Data.Status "done"}}found {{.Data.NumResults}} hits{{else}}failed{{end}}{{end}}
+{{- define "job"}}Your search {{.Data.ID}}, submitted {{.Data.Submitted.Format "2 Jan 2006 15:04 MST"}}, has
+{{- if eq .Data.Status "done"}} finished with {{.Data.NumResults}} hits.{{else}} failed: {{.Data.Error}}{{end}}
+{{if .Site}}
+Results: {{.Site}}/api/v1/jobs/{{.Data.ID}}
+{{end}}{{end}}
+
+{{- define "alert.subject"}}{{len .Data.Hits}} new hits for saved search {{.Data.SavedSearch}}{{end}}
+{{- define "alert"}}The {{.Data.DBVersion}} blast db has new sequences similar to your saved search:
+{{template "hits" .Data.Hits}}
+{{end}}
+
+{{- define "digest.subject"}}New parts similar to your saved searches{{end}}
+{{- define "digest"}}These sequences similar to your saved searches turned up since your last digest.
+{{range .Data}}
+Saved search {{.SavedSearch}}, {{.DBVersion}} blast db:
+{{template "hits" .Hits}}
+{{end}}{{end}}
+`))
+
+// emailData is what the email templates are executed with.
+type emailData struct {
+	// Site is -email.siteURL
+	Site string
+	Data interface{}
+}
+
+// emailAddress is what's kept about each address emails are sent to. There
+// are no accounts, so an address opts in to emails by being given with a
+// job or saved search and then following the link in the emailConfirm email
+// that's sent it, and opts out with the link in every email.
+type emailAddress struct {
+	// Token is the secret in the address's confirm and unsubscribe links
+	Token string `json:"token"`
+
+	// Confirmed is whether the address has confirmed it wants emails
+	Confirmed bool `json:"confirmed,omitempty"`
+
+	// ConfirmSent is when the address was last asked to confirm, so
+	// giving it again and again doesn't flood it
+	ConfirmSent time.Time `json:"confirmSent,omitempty"`
+
+	// OptedOut are the kinds of email the address has unsubscribed from,
+	// or emailAll
+	OptedOut map[string]bool `json:"optedOut,omitempty"`
+
+	// LastDigest is when the address was last sent a digest, or when its
+	// first alert was queued for one
+	LastDigest time.Time `json:"lastDigest,omitempty"`
+}
+
+func (a *emailAddress) wants(kind string) bool {
+	if kind != emailConfirm && !a.Confirmed {
+		return false
+	}
+	return !a.OptedOut[kind] && !a.OptedOut[emailAll]
+}
+
+// validEmail checks an address emails can be sent to was given, and
+// returns just the address, without any name or angle brackets, which is
+// all that's kept and mailed to.
+func validEmail(address string) (string, error) {
+	if *smtpAddr == "" {
+		return "", errors.New("this server can't send email, use a webhook instead")
+	}
+
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid email address: %v", err)
+	}
+	if strings.ContainsAny(addr.Address, "\r\n") {
+		return "", errors.New("invalid email address: has a line break")
+	}
+
+	return addr.Address, nil
+}
+
+// getEmailAddress returns what's kept about an address, giving it a token
+// the first time.
+func getEmailAddress(client *redis.Client, address string) (*emailAddress, error) {
+	address = strings.ToLower(address)
+	for {
+		resp := client.Cmd("HGET", *redisEmailKey, address)
+		if !resp.IsType(redis.Nil) {
+			b, err := resp.Bytes()
+			if err != nil {
+				return nil, err
+			}
+
+			a := &emailAddress{}
+			err = json.Unmarshal(b, a)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't parse email address %s: %v", address, err)
+			}
+			return a, nil
+		}
+
+		token, err := newJobID()
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(emailAddress{Token: token})
+		if err != nil {
+			return nil, err
+		}
+
+		// another server may be giving it one at the same time, in which
+		// case theirs is read back
+		err = client.Cmd("HSETNX", *redisEmailKey, address, b).Err
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func putEmailAddress(client *redis.Client, address string, a *emailAddress) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	return client.Cmd("HSET", *redisEmailKey, strings.ToLower(address), b).Err
+}
+
+// unsubscribeLink returns the link that unsubscribes address from kind of
+// email, or nothing without -email.siteURL.
+func unsubscribeLink(address, token, kind string) string {
+	if *emailSiteURL == "" {
+		return ""
+	}
+
+	return strings.TrimRight(*emailSiteURL, "/") + "/email/unsubscribe?" + url.Values{
+		"address": {address},
+		"token":   {token},
+		"kind":    {kind},
+	}.Encode()
+}
+
+// sendEmail mails a kind of email to address, unless it's unsubscribed from
+// that kind.
+func sendEmail(address, kind string, data interface{}) error {
+	// jobs and saved searches from before addresses were stripped of their
+	// names may have one
+	address, err := validEmail(address)
+	if err != nil {
+		return err
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		return err
+	}
+	a, err := getEmailAddress(client, address)
+	redisPool.Put(client)
+	if err != nil {
+		return err
+	}
+	if !a.wants(kind) {
+		slog.Debug("not emailing unconfirmed or unsubscribed address", "kind", kind)
+		return nil
+	}
+
+	ed := emailData{Site: strings.TrimRight(*emailSiteURL, "/"), Data: data}
+	subject, body := &bytes.Buffer{}, &bytes.Buffer{}
+	err = emailTemplates.ExecuteTemplate(subject, kind+".subject", ed)
+	if err != nil {
+		return err
+	}
+	err = emailTemplates.ExecuteTemplate(body, kind, ed)
+	if err != nil {
+		return err
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n",
+		*smtpFrom, address, subject, time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n")
+	unsubscribeKind := kind
+	if kind == emailConfirm {
+		unsubscribeKind = emailAll
+	}
+	unsubscribe := unsubscribeLink(address, a.Token, unsubscribeKind)
+	if unsubscribe != "" {
+		// mail clients can unsubscribe with one click, see RFC 8058
+		fmt.Fprintf(msg, "List-Unsubscribe: <%s>\r\nList-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n", unsubscribe)
+	}
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	if unsubscribe != "" {
+		fmt.Fprintf(msg, "\r\n--\r\nTo stop getting these emails: %s\r\n", unsubscribe)
+	}
+
+	var auth smtp.Auth
+	if *smtpUsername != "" {
+		host, _, _ := net.SplitHostPort(*smtpAddr)
+		auth = smtp.PlainAuth("", *smtpUsername, *smtpPassword, host)
+	}
+
+	return smtp.SendMail(*smtpAddr, auth, *smtpFrom, []string{address}, msg.Bytes())
+}
+
+// confirmEmail asks an address given with a job or saved search to confirm
+// it wants emails, unless it already has, has unsubscribed from them all,
+// or was asked less than -email.confirmInterval ago.
+func confirmEmail(address string) {
+	err := sendConfirmEmail(address)
+	if err != nil {
+		slog.Error("couldn't ask email address to confirm", "err", err)
+	}
+}
+
+func sendConfirmEmail(address string) error {
+	client, err := redisPool.Get()
+	if err != nil {
+		return err
+	}
+	a, err := getEmailAddress(client, address)
+	if err == nil && !a.Confirmed && !a.OptedOut[emailAll] && time.Since(a.ConfirmSent) >= *confirmInterval {
+		a.ConfirmSent = time.Now()
+		err = putEmailAddress(client, address, a)
+	} else {
+		a = nil
+	}
+	redisPool.Put(client)
+	if err != nil || a == nil {
+		return err
+	}
+
+	return sendEmail(address, emailConfirm, url.Values{
+		"address": {address},
+		"token":   {a.Token},
+	}.Encode())
+}
+
+// emailFinishedJob tells whoever submitted a job with an email address that
+// it's finished.
+func emailFinishedJob(j jobqueue.Job) {
+	data := jobCallback{ID: j.ID, Status: j.Status, Error: j.Error}
+	if j.Results != nil {
+		data.NumResults = j.Results.NumResults
+	}
+
+	err := sendEmail(j.Email, emailJob, struct {
+		jobCallback
+		Submitted time.Time
+	}{data, j.Submitted})
+	if err != nil {
+		slog.With(j.LogArgs()...).Error("couldn't email that the job finished", "err", err)
+	}
+}
+
+// queueDigest saves an alert for address's next digest.
+func queueDigest(address string, alert savedsearch.Alert) error {
+	client, err := redisPool.Get()
+	if err != nil {
+		return err
+	}
+	defer redisPool.Put(client)
+
+	alerts, err := digestAlerts(client, address)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(append(alerts, alert))
+	if err != nil {
+		return err
+	}
+	err = client.Cmd("HSET", *redisDigestKey, strings.ToLower(address), b).Err
+	if err != nil {
+		return err
+	}
+
+	// the first digest goes out an interval after the first alert
+	a, err := getEmailAddress(client, address)
+	if err != nil || !a.LastDigest.IsZero() {
+		return err
+	}
+	a.LastDigest = time.Now()
+	return putEmailAddress(client, address, a)
+}
+
+func digestAlerts(client *redis.Client, address string) ([]savedsearch.Alert, error) {
+	resp := client.Cmd("HGET", *redisDigestKey, strings.ToLower(address))
+	if resp.IsType(redis.Nil) {
+		return nil, nil
+	}
+	b, err := resp.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := []savedsearch.Alert{}
+	err = json.Unmarshal(b, &alerts)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse digest of %s: %v", address, err)
+	}
+	return alerts, nil
+}
+
+// sendDigests mails the addresses whose digests are due every hour, until
+// the server exits.
+func sendDigests() {
+	for range time.Tick(time.Hour) {
+		err := sendDueDigests()
+		if err != nil {
+			slog.Error("couldn't send digests", "err", err)
+		}
+	}
+}
+
+func sendDueDigests() error {
+	client, err := redisPool.Get()
+	if err != nil {
+		return err
+	}
+	defer redisPool.Put(client)
+
+	addresses, err := client.Cmd("HKEYS", *redisDigestKey).List()
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		a, err := getEmailAddress(client, address)
+		if err != nil {
+			return err
+		}
+		if time.Since(a.LastDigest) < *digestInterval {
+			continue
+		}
+
+		alerts, err := digestAlerts(client, address)
+		if err != nil {
+			return err
+		}
+		// only one server gets to take it
+		taken, err := client.Cmd("HDEL", *redisDigestKey, address).Int()
+		if err != nil {
+			return err
+		}
+		if taken == 0 || len(alerts) == 0 {
+			continue
+		}
+
+		a.LastDigest = time.Now()
+		err = putEmailAddress(client, address, a)
+		if err != nil {
+			return err
+		}
+
+		err = sendEmail(address, emailDigest, alerts)
+		if err != nil {
+			slog.Error("couldn't email digest", "alerts", len(alerts), "err", err)
+		}
+	}
+
+	return nil
+}
+
+// confirmPage is what confirm.html is rendered with.
+type confirmPage struct {
+	Address string
+	Token   string
+	Done    bool
+}
+
+// emailConfirmHandler records that an address wants the emails it was given
+// for. Like unsubscribing, a GET asks and a POST does it.
+func emailConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeErrorPage(w, http.StatusMethodNotAllowed, "confirming takes GET or POST")
+		return
+	}
+
+	page := confirmPage{
+		Address: r.FormValue("address"),
+		Token:   r.FormValue("token"),
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		writeErrorPage(w, http.StatusServiceUnavailable, "confirming isn't working right now, please try again later")
+		return
+	}
+	defer redisPool.Put(client)
+
+	a, err := lookUpEmailAddress(client, page.Address, page.Token)
+	if err != nil {
+		slog.Error("couldn't look up email address", "err", err)
+		writeErrorPage(w, http.StatusInternalServerError, "confirming failed, the error has been logged")
+		return
+	}
+	if a == nil {
+		writeErrorPage(w, http.StatusForbidden, "this confirm link isn't valid")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		a.Confirmed = true
+		err = putEmailAddress(client, page.Address, a)
+		if err != nil {
+			slog.Error("couldn't confirm email address", "err", err)
+			writeErrorPage(w, http.StatusInternalServerError, "confirming failed, the error has been logged")
+			return
+		}
+		page.Done = true
+	}
+
+	renderPage(w, "confirm.html", page)
+}
+
+// lookUpEmailAddress returns what's kept about an address if token is its
+// token, or nil if it isn't or the server has never seen the address.
+func lookUpEmailAddress(client *redis.Client, address, token string) (*emailAddress, error) {
+	exists, err := client.Cmd("HEXISTS", *redisEmailKey, strings.ToLower(address)).Int()
+	if err != nil || exists == 0 {
+		return nil, err
+	}
+	a, err := getEmailAddress(client, address)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(a.Token), []byte(token)) != 1 {
+		return nil, nil
+	}
+
+	return a, nil
+}
+
+// unsubscribePage is what unsubscribe.html is rendered with.
+type unsubscribePage struct {
+	Address string
+	Token   string
+	Kind    string
+	Done    bool
+}
+
+// emailUnsubscribeHandler unsubscribes an address from a kind of email. A
+// GET asks first, since mail scanners follow links; a POST, from that page
+// or a mail client's one click unsubscribe, does it.
+func emailUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeErrorPage(w, http.StatusMethodNotAllowed, "unsubscribing takes GET or POST")
+		return
+	}
+
+	// the page's form can change the kind to all, the rest comes from the
+	// link
+	page := unsubscribePage{
+		Address: r.FormValue("address"),
+		Token:   r.FormValue("token"),
+		Kind:    r.FormValue("kind"),
+	}
+	switch page.Kind {
+	case emailJob, emailAlert, emailDigest, emailAll:
+	default:
+		writeErrorPage(w, http.StatusBadRequest, "unknown kind of email")
+		return
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		writeErrorPage(w, http.StatusServiceUnavailable, "unsubscribing isn't working right now, please try again later")
+		return
+	}
+	defer redisPool.Put(client)
+
+	a, err := lookUpEmailAddress(client, page.Address, page.Token)
+	if err != nil {
+		slog.Error("couldn't look up email address", "err", err)
+		writeErrorPage(w, http.StatusInternalServerError, "unsubscribing failed, the error has been logged")
+		return
+	}
+	if a == nil {
+		writeErrorPage(w, http.StatusForbidden, "this unsubscribe link isn't valid")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if a.OptedOut == nil {
+			a.OptedOut = map[string]bool{}
+		}
+		a.OptedOut[page.Kind] = true
+		err = putEmailAddress(client, page.Address, a)
+		if err != nil {
+			slog.Error("couldn't unsubscribe email address", "err", err)
+			writeErrorPage(w, http.StatusInternalServerError, "unsubscribing failed, the error has been logged")
+			return
+		}
+		page.Done = true
+	}
+
+	renderPage(w, "unsubscribe.html", page)
+}
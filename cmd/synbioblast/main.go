@@ -0,0 +1,5096 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/schnauzer/synbioblast"
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/kmerindex"
+	"github.com/schnauzer/synbioblast/logging"
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/jobqueue"
+	"github.com/schnauzer/synbioblast/pkg/jobstore"
+	"github.com/schnauzer/synbioblast/pkg/render"
+	"github.com/schnauzer/synbioblast/pkg/savedsearch"
+	"github.com/schnauzer/synbioblast/pkg/store"
+	"github.com/schnauzer/synbioblast/pkg/webhook"
+	"github.com/schnauzer/synbioblast/rpc"
+	"github.com/schnauzer/synbioblast/sdnotify"
+	"github.com/schnauzer/synbioblast/textindex"
+	"github.com/schnauzer/synbioblast/workqueue"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+var (
+	blastdbPoll = flag.Duration("blastdb.pollInterval", 30*time.Second, "how often to check whether builddb.sh has swapped in a new db")
+
+	diamondBinary = flag.String("diamond.binary", "",
+		"path to the diamond executable to run protein searches with, protein searches are disabled if empty")
+	vsearchBinary = flag.String("vsearch.binary", "",
+		"path to the vsearch executable to offer as a faster, less sensitive alternative to blastn, disabled if empty")
+	vsearchMinIdentity = flag.Float64("vsearch.minIdentity", 0.9, "minimum identity of vsearch hits, between 0 and 1")
+	maxHits            = flag.Int("blast.maxHits", 500, "stop reading blast's output after this many hits, unlimited if 0")
+	useJSONOutput      = flag.Bool("blast.jsonOutput", true,
+		"have blastn write single file JSON (-outfmt 15), which is quicker to parse, rather than XML when it's new enough to")
+
+	maxBlastCPUs = flag.Int("blast.maxCPUs", runtime.NumCPU(), "number of CPUs blast may use at once, each query takes one per thread")
+	blastThreads = flag.Int("blast.threads", 0,
+		"default number of threads for each blast query, if 0 the CPUs are split evenly between the queries -blast.maxMemoryMB allows")
+	maxBlastMemory   = flag.Int("blast.maxMemoryMB", 0, "memory available to blast queries in MB, unlimited if 0")
+	blastQueryMemory = flag.Int("blast.queryMemoryMB", 512, "memory to set aside for each running blast query in MB")
+	retryAfter       = flag.Duration("blast.retryAfter", 10*time.Second,
+		"how long clients are told to wait before retrying when all blast slots are busy")
+
+	rewriteRulesFile = flag.String("uris.rewriteRules", "",
+		"file of rules for rewriting component links, one \"<regexp> <replacement>\" per line")
+	privateInstances = flag.String("uris.privateInstances", "",
+		"comma separated web addresses of private SynBioHub instances that have been slurped, whose components are only shown to the plugin on the same instance")
+	uriCacheSize        = flag.Int("uris.cacheSize", 100000, "number of sequences whose components are cached rather than looked up in Redis for every hit, disabled if 0")
+	uriCacheTTL         = flag.Duration("uris.cacheTTL", 10*time.Minute, "how long cached components are used before they're looked up again")
+	similarityTiersFlag = flag.String("results.similarityTiers", "99:identical,95:near-identical,80:similar",
+		"badges for hits by percent identity, comma separated \"<min percent>:<name>[:<css color>]\"")
+
+	maxQueryLength  = flag.Int("query.maxLength", 200000, "longest query that can be searched in residues, unlimited if 0")
+	maxQueryRecords = flag.Int("query.maxRecords", 10, "most FASTA records a request's maxRecords can let its query have, each searched separately by blastn")
+	maxBodyBytes    = flag.Int64("http.maxBodyBytes", 4<<20, "largest request body accepted in bytes, which also caps how big a pasted record can be")
+
+	trustedProxiesFlag = flag.String("http.trustedProxies", "",
+		"comma separated IPs or CIDRs of reverse proxies whose X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers are believed")
+	basePath = flag.String("http.basePath", "", "path a reverse proxy serves the server under, e.g. /blast, which links and redirects are made relative to")
+
+	httpReadHeaderTimeout = flag.Duration("http.readHeaderTimeout", 10*time.Second, "how long clients have to send a request's headers")
+	httpReadTimeout       = flag.Duration("http.readTimeout", time.Minute, "how long clients have to send a whole request, body included")
+	httpWriteTimeout      = flag.Duration("http.writeTimeout", 0,
+		"how long a response can take from the end of the request's headers, -blast.timeout plus a minute if 0 so searches can finish")
+	httpIdleTimeout    = flag.Duration("http.idleTimeout", 2*time.Minute, "how long idle keep-alive connections are kept open")
+	httpMaxHeaderBytes = flag.Int("http.maxHeaderBytes", 64<<10, "largest request headers accepted in bytes")
+
+	templatesDir = flag.String("templates.dir", "", "directory to read the pages and static files from instead of the copies built in, for editing them")
+	devMode      = flag.Bool("dev", false, "re-parse the pages on every request and show template errors on them, reading them from the working directory unless -templates.dir is set")
+
+	port     = flag.Int("port", 9090, "default port to bind http server to")
+	grpcPort = flag.Int("grpc.port", 0, "port to bind the grpc server to, disabled if 0")
+
+	tlsCert          = flag.String("tls.cert", "", "certificate file to serve https with, plain http is served if neither this nor -autocert.domain is set")
+	tlsKey           = flag.String("tls.key", "", "private key file of -tls.cert")
+	autocertDomain   = flag.String("autocert.domain", "", "comma separated domains to get certificates for from Let's Encrypt and serve https with")
+	autocertCacheDir = flag.String("autocert.cacheDir", "/var/synbioblast/autocert", "directory to keep Let's Encrypt certificates in across restarts")
+	autocertEmail    = flag.String("autocert.email", "", "contact address Let's Encrypt sends notices about the certificates to")
+	autocertHTTPPort = flag.Int("autocert.httpPort", 80, "port to answer Let's Encrypt's http challenges on, which redirects everything else to https")
+
+	jobDir            = flag.String("jobs.dir", "", "directory to keep finished jobs and their raw blast output in, jobs are only kept in memory if empty")
+	jobReparse        = flag.Bool("jobs.reparse", false, "re-parse the raw blast output of the jobs in -jobs.dir with the current parser, then exit")
+	jobWorkers        = flag.Int("jobs.workers", 4, "number of queued blast jobs, including searches from the web UI, to run at once")
+	jobQueueSize      = flag.Int("jobs.queueSize", 100, "max number of jobs of each class waiting to run before submissions are rejected")
+	interactiveWeight = flag.Int("jobs.interactiveWeight", 4, "number of interactive jobs started for each batch job while both are waiting")
+	jobTTL            = flag.Duration("jobs.ttl", 30*24*time.Hour, "how long finished jobs are kept, forever if 0")
+	jobMaxBytes       = flag.Int64("jobs.maxBytes", 0, "max total size of -jobs.dir, the least recently read jobs are removed to stay under it, unlimited if 0")
+	jobCleanInterval  = flag.Duration("jobs.cleanInterval", 10*time.Minute, "how often expired jobs are removed and -jobs.maxBytes is enforced")
+
+	metricsPort = flag.Int("metrics.port", 0, "port to serve prometheus metrics on, disabled if 0")
+
+	remoteWorkers = flag.Bool("workers.remote", false, "send blast queries to synbioblast-worker processes through redis instead of running blastn here")
+
+	redisSavedSearchKey = flag.String("redis.savedSearches", "savedSearches", "Redis key for hash storing saved searches by id")
+	redisPoolSize       = flag.Int("redis.poolSize", 10, "number of idle Redis connections kept open, more are dialed when they're all in use")
+
+	smtpAddr     = flag.String("smtp.addr", "", "host:port of the mail server emails are sent through, emails are disabled if empty")
+	smtpFrom     = flag.String("smtp.from", "synbioblast@localhost", "address emails are sent from")
+	smtpUsername = flag.String("smtp.username", "", "username to log in to the mail server with, if it needs one")
+	smtpPassword = flag.String("smtp.password", "", "password to log in to the mail server with")
+
+	pluginInstances = flag.String("plugin.instances", "",
+		"comma separated URLs of the SynBioHub instances allowed to use the visualization plugin, the plugin is disabled if empty")
+	pluginMaxHits = flag.Int("plugin.maxHits", 10, "number of similar parts the SynBioHub plugin shows")
+
+	webhookSecret = flag.String("webhooks.secret", "",
+		"key webhook and job callback bodies are signed with (HMAC-SHA256), callbacks and webhooks are disabled if empty")
+	webhookSiteURL = flag.String("webhooks.siteURL", "",
+		"web address people reach the server at, including -http.basePath, that the links in job callbacks start with, required with -webhooks.secret")
+
+	adminToken = flag.String("admin.token", "", "bearer token for /admin endpoints, which are disabled if empty")
+
+	drainTimeout = flag.Duration("shutdown.drainTimeout", time.Minute,
+		"how long to wait for running queries to finish when shutting down")
+
+	accessLogPath        = flag.String("accessLog.path", "", "file to write an access log of every http request to, separate from the rest of the logs, - for stdout, disabled if empty")
+	accessLogFormat      = flag.String("accessLog.format", "clf", "access log format: clf (Common Log Format), combined (also the referer and user agent) or json")
+	accessLogMaxSize     = flag.Int("accessLog.maxSize", 100, "size in megabytes the access log can grow to before it's rotated")
+	accessLogRotateEvery = flag.Duration("accessLog.rotateEvery", 24*time.Hour, "how often to rotate the access log whatever its size, never if 0")
+	accessLogMaxBackups  = flag.Int("accessLog.maxBackups", 7, "number of rotated access logs to keep, all of them if 0")
+
+	tracingEndpoint    = flag.String("tracing.endpoint", "", "host:port of the OTLP/gRPC collector to send traces to, tracing is disabled if empty")
+	tracingInsecure    = flag.Bool("tracing.insecure", false, "send traces to the collector without TLS")
+	tracingSampleRatio = flag.Float64("tracing.sampleRatio", 1, "fraction of queries to trace, unless the caller already decided")
+)
+
+// tracer makes the spans for each step of a query. It does nothing unless
+// -tracing.endpoint is set.
+var tracer = otel.Tracer("github.com/schnauzer/synbioblast")
+
+// setupTracing sends spans to -tracing.endpoint. The returned func flushes
+// any that haven't been sent yet.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if *tracingEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(*tracingEndpoint)}
+	if *tracingInsecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*tracingSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "synbioblast"))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// endSpan ends span, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	span.End()
+}
+
+// newViewerData returns the results in the form used by the alignment
+// viewer, with the glyphs served from here and the hits titled by their
+// first component's rewritten URI.
+func newViewerData(r *blast.Results) *render.Viewer {
+	return render.NewViewer(r, sitePath("/static/"), rewriteURI)
+}
+
+// resultsPage is what blast.html shows.
+type resultsPage blast.Results
+
+// ViewerData is the data for the page's alignment viewer.
+func (p resultsPage) ViewerData() *render.Viewer {
+	r := blast.Results(p)
+	return newViewerData(&r)
+}
+
+// writeResults writes results in the format asked for by the "format" query
+// parameter: our own json by default, NCBI's with format=blastjson, or the
+// alignment viewer's with format=viewer.
+func writeResults(w http.ResponseWriter, r *http.Request, results *blast.Results) {
+	setServerTimings(w, results.Timings)
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, results)
+	case "blastjson":
+		writeJSON(w, http.StatusOK, render.ToBlastJSON(results))
+	case "viewer":
+		writeJSON(w, http.StatusOK, newViewerData(results))
+	default:
+		writeAPIError(w, http.StatusBadRequest, "unknown format "+r.URL.Query().Get("format"))
+	}
+}
+
+// getURIs looks up the components using each hit's sequence, their roles
+// and where they were slurped from, in cachedURIs and then Redis. Hits
+// whose components couldn't be looked up when the rest could are marked as
+// unavailable and counted in r.URILookupFailures, an error means none
+// could be. The hits of an older version of the db are resolved to the
+// components in its snapshot instead, with their roles and sources as
+// they are now.
+func getURIs(ctx context.Context, r *blast.Results) error {
+	start := time.Now()
+	generation := cachedURIs.generation()
+
+	var snapshot map[string][]string
+	if r.DBVersion != "" {
+		var err error
+		snapshot, err = snapshots.get(r.DBVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	components := make([]sequenceComponents, len(r.Results))
+
+	// the hits that weren't cached, and their sequences. The cache has the
+	// current components, so an older version's hits skip it.
+	missed := []int{}
+	hashes := []string{}
+	for i, result := range r.Results {
+		ok := false
+		if snapshot == nil {
+			components[i], ok = cachedURIs.get(result.SeqHash)
+		}
+		if !ok {
+			missed = append(missed, i)
+			hashes = append(hashes, result.SeqHash)
+		}
+	}
+
+	cached := len(r.Results) - len(missed)
+	uriCacheHits.Add(float64(cached))
+	uriCacheMisses.Add(float64(len(missed)))
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("cached", cached))
+
+	failed := map[int]bool{}
+	if len(hashes) > 0 {
+		found, failedLookups, err := lookupURIs(hashes, snapshot)
+		if err != nil {
+			return err
+		}
+
+		for j, i := range missed {
+			components[i] = found[j]
+			if failedLookups[j] {
+				failed[i] = true
+				continue
+			}
+			if snapshot == nil {
+				cachedURIs.add(generation, hashes[j], found[j])
+			}
+		}
+	}
+
+	for i := range r.Results {
+		r.Results[i].URIs = components[i].uris
+		r.Results[i].Roles = components[i].roles
+		r.Results[i].Sources = components[i].sources
+		r.Results[i].RNA = components[i].rna
+		r.Results[i].GC = components[i].gc
+		r.Results[i].URIsUnavailable = failed[i]
+	}
+	r.URILookupFailures = len(failed)
+
+	if len(failed) > 0 {
+		uriLookupFailures.Add(float64(len(failed)))
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("failed", len(failed)))
+		logging.From(ctx).Warn("couldn't look up the components of some hits", "failed", len(failed), "hits", len(r.Results))
+	}
+
+	slog.Debug("resolved uris", "hits", len(r.Results), "cached", cached, "took", time.Since(start))
+
+	return nil
+}
+
+// sequenceComponents are the components using a sequence, their roles and
+// the SynBioHub instance each was slurped from, by uri. rna is set if any
+// of them are RNA. gc is the sequence's GC content, recorded when it was
+// slurped.
+type sequenceComponents struct {
+	uris    []string
+	roles   []string
+	sources map[string]string
+	rna     bool
+	gc      float64
+}
+
+// uriRetries is how many more times looking up components is tried when
+// the connection to Redis fails, since it's usually just a blip. Each try
+// gets a new connection from redisPool, as failed ones are closed.
+const uriRetries = 2
+
+// lookupURIs looks up the components using each of hashes in Redis, or in
+// snapshot if it's set, retrying if the connection fails. failed has the
+// indexes of the hashes that couldn't be looked up when the rest could.
+func lookupURIs(hashes []string, snapshot map[string][]string) (components []sequenceComponents, failed map[int]bool, err error) {
+	for attempt := 0; ; attempt++ {
+		components, failed, err = lookupURIsOnce(hashes, snapshot)
+		if err == nil || attempt == uriRetries {
+			return components, failed, err
+		}
+
+		slog.Warn("couldn't look up uris, retrying", "attempt", attempt+1, "err", err)
+		time.Sleep(time.Duration(attempt+1) * 100 * time.Millisecond)
+	}
+}
+
+func lookupURIsOnce(hashes []string, snapshot map[string][]string) ([]sequenceComponents, map[int]bool, error) {
+	failed := map[int]bool{}
+	partial := func(err error) error {
+		var p *store.PartialError
+		if !errors.As(err, &p) {
+			return err
+		}
+
+		for _, i := range p.Failed {
+			failed[i] = true
+		}
+		return nil
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer redisPool.Put(client)
+
+	var uris [][]string
+	if snapshot != nil {
+		uris = make([][]string, len(hashes))
+		for i, hash := range hashes {
+			uris[i] = snapshot[hash]
+		}
+	} else {
+		uris, err = seqStore.URIs(client, hashes)
+		if err = partial(err); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	roles, err := seqStore.Roles(client, uris)
+	if err = partial(err); err != nil {
+		return nil, nil, err
+	}
+
+	sources, err := seqStore.Sources(client, uris)
+	if err = partial(err); err != nil {
+		return nil, nil, err
+	}
+
+	rna, err := seqStore.RNA(client, uris)
+	if err = partial(err); err != nil {
+		return nil, nil, err
+	}
+
+	measurements, err := seqStore.Measurements(client, hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	components := make([]sequenceComponents, len(hashes))
+	for i := range hashes {
+		components[i] = sequenceComponents{
+			uris:    uris[i],
+			roles:   roles[i],
+			sources: sources[i],
+			rna:     len(rna[i]) > 0,
+			gc:      measurements[i].GC,
+		}
+	}
+
+	return components, failed, nil
+}
+
+// cachedURIs saves looking up the components of popular parts in Redis
+// for every query they turn up in.
+var cachedURIs = newURICache(0, 0)
+
+var (
+	uriCacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "synbioblast_uri_cache_hits_total",
+		Help: "Hits whose components were found in the cache.",
+	})
+	uriCacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "synbioblast_uri_cache_misses_total",
+		Help: "Hits whose components had to be looked up in Redis.",
+	})
+	uriLookupFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "synbioblast_uri_lookup_failures_total",
+		Help: "Hits whose components couldn't be looked up when the rest of their query's could.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(uriCacheHits, uriCacheMisses, uriLookupFailures)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "synbioblast_cursor_lag_seconds",
+		Help: "How long before now the newest component in the index was created, as recorded by the slurpers.",
+	}, cursorLag))
+}
+
+// cursorLag is how stale the index is, for synbioblast_cursor_lag_seconds.
+// It's 0 until something has been slurped, and NaN if Redis can't say.
+func cursorLag() float64 {
+	client, err := redisPool.Get()
+	if err != nil {
+		return math.NaN()
+	}
+	defer redisPool.Put(client)
+
+	newest, err := seqStore.NewestCreated(client)
+	if err != nil {
+		return math.NaN()
+	} else if newest.IsZero() {
+		return 0
+	}
+	return time.Since(newest).Seconds()
+}
+
+// uriCache is an LRU cache of the components using each sequence, by its
+// hash. Entries expire after a while so components the
+// slurper adds turn up, and the whole cache is emptied when the db
+// changes. A cache of size 0 caches nothing.
+type uriCache struct {
+	mu   sync.Mutex
+	size int
+	ttl  time.Duration
+
+	// entries holds the elements of order, whose front is the most
+	// recently used
+	order   *list.List
+	entries map[string]*list.Element
+
+	// gen is bumped by purge, so lookups that started before it can't
+	// add what they found afterwards
+	gen int
+}
+
+type uriCacheEntry struct {
+	hash       string
+	components sequenceComponents
+	expires    time.Time
+}
+
+func newURICache(size int, ttl time.Duration) *uriCache {
+	return &uriCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// get returns the components cached for hash. They're shared, so mustn't
+// be modified.
+func (c *uriCache) get(hash string) (sequenceComponents, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[hash]
+	if !ok {
+		return sequenceComponents{}, false
+	}
+
+	entry := e.Value.(*uriCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(e)
+		delete(c.entries, hash)
+		return sequenceComponents{}, false
+	}
+
+	c.order.MoveToFront(e)
+	return entry.components, true
+}
+
+// generation returns the cache's current generation, to pass to add.
+func (c *uriCache) generation() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// add caches what was looked up for hash, unless the cache has been
+// purged since generation.
+func (c *uriCache) add(generation int, hash string, components sequenceComponents) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size == 0 || generation != c.gen {
+		return
+	}
+
+	entry := &uriCacheEntry{hash: hash, components: components, expires: time.Now().Add(c.ttl)}
+	if e, ok := c.entries[hash]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[hash] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*uriCacheEntry).hash)
+	}
+}
+
+// purge empties the cache.
+func (c *uriCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+	c.gen++
+}
+
+// filterByDescription looks up the components whose title and description
+// contain every word of query in the text index and filters r down to
+// them, see blast.Results.FilterByDescription.
+func filterByDescription(r *blast.Results, query string) error {
+	// without components there's nothing to filter on, and filtering now
+	// would throw away every hit, as would a query of only words too
+	// common to be indexed
+	if len(textindex.Tokens(query)) == 0 || r.URIsUnavailable {
+		return nil
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		return err
+	}
+	matching, err := textindex.Matching(client, seqStore.Keys.TextPrefix, query)
+	redisPool.Put(client)
+	if err != nil {
+		return err
+	}
+
+	r.FilterByDescription(query, matching)
+	return nil
+}
+
+// filterByRole looks up the roles of r's components in Redis and filters r
+// down to those with one of roles, see blast.Results.FilterByRole.
+func filterByRole(r *blast.Results, roles []string) error {
+	// as with descriptions, there's nothing to filter on without components
+	if len(roles) == 0 || r.URIsUnavailable {
+		return nil
+	}
+
+	uris := make([][]string, len(r.Results))
+	for i, result := range r.Results {
+		uris[i] = result.URIs
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		return err
+	}
+	componentRoles, err := seqStore.ComponentRoles(client, uris)
+	redisPool.Put(client)
+
+	// hits whose roles couldn't be looked up are left in like those whose
+	// components couldn't be
+	unknown := map[int]bool{}
+	var partial *store.PartialError
+	if errors.As(err, &partial) {
+		for _, i := range partial.Failed {
+			unknown[i] = true
+		}
+	} else if err != nil {
+		return err
+	}
+
+	r.FilterByRole(roles, componentRoles, unknown)
+	return nil
+}
+
+// filterResults applies a search's description, role and containment
+// filters.
+func filterResults(r *blast.Results, description string, roles, containments []string) error {
+	r.FilterByContainment(containments)
+
+	err := filterByDescription(r, description)
+	if err != nil {
+		return err
+	}
+
+	return filterByRole(r, roles)
+}
+
+// resolveURIs looks up the components for each hit. The hits are still
+// worth something without them, so if Redis is having a bad day the
+// results are just marked as missing their components.
+func resolveURIs(ctx context.Context, r *blast.Results) {
+	ctx, span := tracer.Start(ctx, "resolve uris", trace.WithAttributes(attribute.Int("hits", len(r.Results))))
+	err := getURIs(ctx, r)
+	endSpan(span, err)
+	if err != nil {
+		slog.Error("couldn't resolve uris, returning hashes only", "err", err)
+		r.URIsUnavailable = true
+	}
+}
+
+// parseResults parses saved blastn output, expanding the hits of a
+// clustered search to the rest of their clusters if members is set. prev
+// are the results it was parsed into before, whose Subjects and DBVersion
+// say what was searched. truncated is set if blastn was stopped before it
+// finished writing the output, so it ends early.
+func parseResults(r io.Reader, members map[string][]string, prev *blast.Results, truncated bool) (*blast.Results, error) {
+	decode := blast.DecodeOutput
+	if truncated {
+		decode = func(r io.Reader, _ int) (*blast.Results, error) { return blast.DecodePartial(r) }
+	}
+	results, err := decode(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if members != nil {
+		results.ExpandClusters(members)
+	}
+	results.DBVersion = prev.DBVersion
+
+	if prev.Subjects > 0 {
+		results.NameSubjects(prev.Subjects)
+	} else {
+		resolveURIs(context.Background(), results)
+	}
+	classify(results)
+	results.LayoutAlignments()
+
+	return results, nil
+}
+
+// searchFailure says how a failed search should be reported to whoever
+// ran it. Only timeouts and a missing db are explained, anything else might
+// be blast's stderr or a path on the server, so it's left to the logs.
+func searchFailure(err error) (status int, msg string) {
+	switch {
+	case errors.Is(err, blast.ErrTimeout):
+		return http.StatusGatewayTimeout, err.Error()
+	case errors.Is(err, blast.ErrDBUnavailable):
+		return http.StatusServiceUnavailable, "the blast database isn't available right now, please try again later"
+	case errors.Is(err, jobqueue.ErrShuttingDown):
+		return http.StatusServiceUnavailable, err.Error()
+	case errors.Is(err, jobqueue.ErrCancelled):
+		return http.StatusConflict, err.Error()
+	}
+
+	return http.StatusInternalServerError, "the search failed, the error has been logged"
+}
+
+// blastnPath and blastVersion are worked out from -blast.binary at startup.
+var (
+	blastnPath   string
+	blastVersion string
+)
+
+// blastSlots limits how many blastn processes run at once, so a burst of
+// queries can't fork enough of them to run the box out of memory. Queued
+// jobs wait for a slot, synchronous searches are turned away if there isn't
+// one free.
+var blastSlots *semaphore.Weighted
+
+// blastCapacity is the number of blast slots, one per CPU blast may use.
+// Each query takes a slot per thread, and -blast.maxMemoryMB limits the
+// number of queries through defaultThreads.
+func blastCapacity() int64 {
+	if *maxBlastCPUs < 1 {
+		return 1
+	}
+
+	return int64(*maxBlastCPUs)
+}
+
+// tooBusy tells a client to come back later since every blast slot is taken.
+func tooBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+}
+
+// defaultThreads gives each query an even share of the CPUs, so a server
+// tuned for a few big queries gives them all the cores while one tuned for
+// lots of small queries keeps them single threaded.
+func defaultThreads() int {
+	if *blastThreads > 0 {
+		return *blastThreads
+	}
+
+	queries := int(blastCapacity())
+	if *maxBlastMemory > 0 && *blastQueryMemory > 0 && *maxBlastMemory / *blastQueryMemory < queries {
+		queries = *maxBlastMemory / *blastQueryMemory
+	}
+	if queries < 1 {
+		queries = 1
+	}
+
+	threads := runtime.GOMAXPROCS(0) / queries
+	if threads < 1 {
+		threads = 1
+	}
+	if int64(threads) > blastCapacity() {
+		threads = int(blastCapacity())
+	}
+
+	return threads
+}
+
+// queryThreads is how many threads the query o is for runs with.
+func queryThreads(o align.Options) int {
+	if o.Threads > 0 {
+		return o.Threads
+	}
+
+	return defaultThreads()
+}
+
+// validateOptions checks the server can run a query with o.
+func validateOptions(o align.Options) error {
+	if o.Threads < 0 || int64(o.Threads) > blastCapacity() {
+		return fmt.Errorf("threads must be between 1 and %d", blastCapacity())
+	}
+
+	if _, ok := aligners[o.AlignerName()]; !ok {
+		if o.AlignerName() == "diamond" || o.AlignerName() == "vsearch" {
+			return fmt.Errorf("%s isn't enabled on this server", o.AlignerName())
+		}
+		return fmt.Errorf("unknown aligner %q", o.Aligner)
+	}
+
+	err := o.Scoring.Validate()
+	if err != nil {
+		return err
+	}
+
+	if !o.Scoring.IsZero() && o.AlignerName() != "diamond" {
+		return errors.New("scoring matrices only apply to protein searches, use the diamond aligner")
+	}
+
+	if o.Clustered {
+		if o.AlignerName() != "blastn" {
+			return errors.New("clustered searches only run with blastn")
+		}
+		if _, members := activeDB.clustered(); members == nil {
+			return errors.New("the current db wasn't clustered, so it can't be searched by cluster")
+		}
+	}
+
+	if o.Subject != "" {
+		_, err = subjectSequences(o)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = oldDB(o)
+	return err
+}
+
+// queryWeight is the number of blast slots the query o is for needs.
+func queryWeight(o align.Options) int64 {
+	return int64(queryThreads(o))
+}
+
+// blastArgs are the blastn arguments o asks for.
+func blastArgs(o align.Options) []string {
+	args := []string{"-num_threads", strconv.Itoa(queryThreads(o))}
+	if o.Annotate {
+		args = append(args, annotateArgs()...)
+	}
+
+	return args
+}
+
+// Blast runs a blast query with the given target sequence. blastn is killed
+// if ctx is cancelled or the query runs longer than -blast.timeout.
+func Blast(ctx context.Context, seq string, opts align.Options) (*blast.Results, error) {
+	if opts.Subject != "" {
+		return blastSubjects(ctx, seq, opts)
+	}
+	start := time.Now()
+
+	db, _ := activeDB.get()
+	old, err := oldDB(opts)
+	if err != nil {
+		return nil, err
+	}
+	if old != "" {
+		db = old
+	}
+	var members map[string][]string
+	if opts.Clustered {
+		db, members = activeDB.clustered()
+		if members == nil {
+			return nil, errors.New("the db is no longer clustered")
+		}
+	}
+	args := append([]string{"-db", db}, blastArgs(opts)...)
+	if workers != nil {
+		ctx, cancel := context.WithTimeout(ctx, cfg().blastTimeout)
+		defer cancel()
+
+		// there's no telling which blastn the workers have, but they all
+		// write XML
+		args = append(args, "-outfmt", "5")
+
+		results, err := workers.blast(ctx, seq, args, opts)
+		if err != nil {
+			return results, err
+		}
+		if members != nil {
+			results.ExpandClusters(members)
+		}
+		if old != "" {
+			results.DBVersion = opts.DBVersion
+		}
+
+		return finishBlast(ctx, results, seq, args, start), nil
+	}
+
+	args = append(args, outputArgs(blastVersion)...)
+	results, err := blast.Search{
+		Binary:  blastnPath,
+		DBDir:   *config.BlastDBDir,
+		Args:    args,
+		Timeout: cfg().blastTimeout,
+		MaxHits: cfg().maxHits,
+		Raw:     opts.Raw,
+	}.Run(ctx, seq)
+	if err != nil {
+		return results, err
+	}
+	if members != nil {
+		results.ExpandClusters(members)
+	}
+	if old != "" {
+		results.DBVersion = opts.DBVersion
+	}
+
+	return finishBlast(ctx, results, seq, args, start), nil
+}
+
+// outputArgs ask a blastn of version for the output it's quickest to
+// parse: single file JSON if it can write it and -blast.jsonOutput is set,
+// XML otherwise. JSON is parsed whole rather than a hit at a time, so
+// blastn is told to stop at -blast.maxHits itself, as workers are.
+func outputArgs(version string) []string {
+	if !*useJSONOutput || !blast.SupportsJSON(version) {
+		return []string{"-outfmt", "5"}
+	}
+
+	args := []string{"-outfmt", "15"}
+	if max := cfg().maxHits; max > 0 {
+		args = append(args, "-max_target_seqs", strconv.Itoa(max))
+	}
+
+	return args
+}
+
+// finishBlast fills in everything that isn't in blast's output. args are
+// what the aligner was run with, for the manifest.
+func finishBlast(ctx context.Context, results *blast.Results, seq string, args []string, start time.Time) *blast.Results {
+	for _, warning := range results.Warnings {
+		logging.From(ctx).Warn("aligner warning", "warning", warning)
+	}
+
+	// subjects sent with the query have no components
+	if results.Subjects == 0 {
+		resolveStart := time.Now()
+		resolveURIs(ctx, results)
+		results.Timings.ResolveURIs = time.Since(resolveStart)
+	}
+	classify(results)
+	results.LayoutAlignments()
+
+	results.Query = seq
+	results.Timings.Total = time.Since(start)
+	queryLatency.record(results.Timings.Total)
+	results.NumResults = len(results.Results)
+	results.Manifest = newManifest(results, args)
+
+	return results
+}
+
+// newManifest records what was searched to get results.
+func newManifest(results *blast.Results, args []string) *blast.Manifest {
+	m := &blast.Manifest{
+		Program:     results.Program,
+		Version:     results.Version,
+		Args:        args,
+		DB:          results.DB,
+		DBVersion:   results.DBVersion,
+		DBSequences: results.DBNum,
+		DBLength:    results.DBLen,
+		Server:      serverBuild,
+		Searched:    time.Now().UTC(),
+	}
+	if m.DBVersion == "" && results.Subjects == 0 {
+		_, m.DBVersion = activeDB.get()
+	}
+
+	return m
+}
+
+// serverBuild is the build of this binary, for manifests.
+var serverBuild = readServerBuild()
+
+func readServerBuild() blast.BuildInfo {
+	b := blast.BuildInfo{Version: "unknown", GoVersion: runtime.Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Version = info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+
+	return b
+}
+
+// pushTask queues t for the workers.
+func pushTask(t workqueue.Task) error {
+	client, err := redisPool.Get()
+	if err != nil {
+		return err
+	}
+	defer redisPool.Put(client)
+
+	return workqueue.Push(client, *config.WorkQueuePrefix, t)
+}
+
+// cancelTask takes t back off the queue if no worker has started it.
+func cancelTask(t workqueue.Task) error {
+	client, err := redisPool.Get()
+	if err != nil {
+		return err
+	}
+	defer redisPool.Put(client)
+
+	return workqueue.Cancel(client, *config.WorkQueuePrefix, t)
+}
+
+// workers is set when queries are run by synbioblast-worker processes
+// rather than by this one, see -workers.remote.
+var workers *workerPool
+
+// workerPool sends blast queries to remote workers and hands their results
+// back to whoever is waiting for them.
+type workerPool struct {
+	// blocking commands get their own connection so they don't tie up
+	// one of redisPool's
+	conn *redis.Client
+
+	// name is what this server's tasks are marked with, so their results
+	// come back to it
+	name string
+
+	mu      sync.Mutex
+	waiting map[string]chan *workqueue.Result
+}
+
+func newWorkerPool() (*workerPool, error) {
+	name, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := redis.Dial("tcp", *config.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &workerPool{conn: conn, name: name, waiting: map[string]chan *workqueue.Result{}}, nil
+}
+
+// run delivers results until the connection fails, and meanwhile requeues
+// the queries of workers that died.
+func (p *workerPool) run() error {
+	reaper := &workqueue.Reaper{Prefix: *config.WorkQueuePrefix, TTL: *config.WorkerLeaseTTL}
+	lastReap := time.Now()
+
+	for {
+		r, err := workqueue.NextResult(p.conn, *config.WorkQueuePrefix, p.name, time.Second)
+		if err != nil {
+			return err
+		}
+
+		if r != nil {
+			p.mu.Lock()
+			c, ok := p.waiting[r.ID]
+			delete(p.waiting, r.ID)
+			p.mu.Unlock()
+
+			if ok {
+				c <- r
+			} else {
+				slog.Info("dropping result nobody is waiting for anymore", "task", r.ID, "worker", r.Worker)
+			}
+		}
+
+		if time.Since(lastReap) > *config.WorkerLeaseTTL/2 {
+			n, err := reaper.Reap(p.conn)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				slog.Warn("gave abandoned queries to other workers", "queries", n)
+			}
+			lastReap = time.Now()
+		}
+	}
+}
+
+// blast queues a query for the workers and waits for its result.
+func (p *workerPool) blast(ctx context.Context, seq string, args []string, opts align.Options) (*blast.Results, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	// the output isn't streamed from workers, so blastn itself has to stop
+	// at -blast.maxHits
+	if max := cfg().maxHits; max > 0 {
+		args = append(args, "-max_target_seqs", strconv.Itoa(max))
+	}
+	task := workqueue.Task{ID: id, Sequence: seq, Args: args, Server: p.name}
+
+	c := make(chan *workqueue.Result, 1)
+	p.mu.Lock()
+	p.waiting[id] = c
+	p.mu.Unlock()
+
+	pushed := time.Now()
+	err = pushTask(task)
+	if err != nil {
+		p.mu.Lock()
+		delete(p.waiting, id)
+		p.mu.Unlock()
+		return nil, err
+	}
+
+	var r *workqueue.Result
+	select {
+	case r = <-c:
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.waiting, id)
+		p.mu.Unlock()
+
+		err = cancelTask(task)
+		if err != nil {
+			logging.From(ctx).Error("couldn't cancel query", "task", id, "err", err)
+		}
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return &blast.Results{Query: seq}, fmt.Errorf("%w: gave up after %v", blast.ErrTimeout, cfg().blastTimeout)
+		}
+		return &blast.Results{Query: seq}, ctx.Err()
+	}
+
+	if r.Error != "" {
+		return &blast.Results{Error: r.Error, Query: seq}, fmt.Errorf("worker %s couldn't run blastn: %s", r.Worker, r.Error)
+	}
+	if r.ExitCode != 0 {
+		err := fmt.Errorf("blastn on worker %s exited with %d", r.Worker, r.ExitCode)
+		return &blast.Results{Error: r.Stderr, Query: seq}, blast.ExitError(err, r.Stderr)
+	}
+
+	if opts.Raw != nil {
+		_, err = io.WriteString(opts.Raw, r.Stdout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// this includes the time the query waited for a worker, which the
+	// workers don't report
+	aligner := time.Since(pushed)
+
+	_, parse := tracer.Start(ctx, "parse blast xml")
+	parseStart := time.Now()
+	var results *blast.Results
+	if r.Truncated {
+		results, err = blast.DecodePartial(strings.NewReader(r.Stdout))
+	} else {
+		results, err = blast.Decode(strings.NewReader(r.Stdout), cfg().maxHits)
+	}
+	endSpan(parse, err)
+	if err != nil {
+		return nil, err
+	}
+	results.Warnings = blast.Warnings(r.Stderr)
+	if r.Truncated {
+		results.Truncated = true
+		results.Warnings = append(results.Warnings, fmt.Sprintf("worker %s cut blastn's output off at its -worker.maxOutput, so these are the hits before that", r.Worker))
+	}
+	results.Timings.Aligner = aligner
+	results.Timings.Parse = time.Since(parseStart)
+
+	return results, nil
+}
+
+// aligners are the aligners requests can pick between by name. diamond and
+// vsearch are only added if their binaries are set.
+var aligners = map[string]align.Aligner{
+	"blastn": blastnAligner{},
+}
+
+// alignQuery runs a query with the aligner opts asks for.
+func alignQuery(ctx context.Context, seq string, opts align.Options) (results *blast.Results, err error) {
+	ctx, span := tracer.Start(ctx, "align", trace.WithAttributes(
+		attribute.String("aligner", opts.AlignerName()),
+		attribute.Int("query.length", len(seq)),
+		attribute.Bool("circular", opts.Circular),
+		attribute.Bool("clustered", opts.Clustered),
+	))
+	defer func() {
+		if results != nil {
+			span.SetAttributes(attribute.Int("hits", len(results.Results)))
+		}
+		endSpan(span, err)
+	}()
+
+	a := aligners[opts.AlignerName()]
+	if opts.Circular {
+		return align.Circular(ctx, a, seq, opts)
+	}
+
+	return a.Align(ctx, seq, opts)
+}
+
+type blastnAligner struct{}
+
+func (blastnAligner) Align(ctx context.Context, seq string, opts align.Options) (*blast.Results, error) {
+	return Blast(ctx, seq, opts)
+}
+
+var diamondPath, diamondVersion string
+
+// diamondAligner runs protein searches with diamond, see align.Diamond.
+type diamondAligner struct{}
+
+func (diamondAligner) Align(ctx context.Context, seq string, opts align.Options) (*blast.Results, error) {
+	start := time.Now()
+
+	db, _ := activeDB.get()
+	results, args, err := align.Diamond{
+		Binary:  diamondPath,
+		Version: diamondVersion,
+		DBDir:   *config.BlastDBDir,
+		DB:      db,
+		Threads: queryThreads(opts),
+		Scoring: opts.Scoring,
+		MaxHits: cfg().maxHits,
+		Timeout: cfg().blastTimeout,
+	}.Run(ctx, seq)
+	if err != nil {
+		return results, err
+	}
+
+	return finishBlast(ctx, results, seq, args, start), nil
+}
+
+var vsearchPath, vsearchVersion string
+
+// vsearchAligner runs quick nucleotide searches with vsearch, see
+// align.Vsearch.
+type vsearchAligner struct{}
+
+func (vsearchAligner) Align(ctx context.Context, seq string, opts align.Options) (*blast.Results, error) {
+	start := time.Now()
+
+	db, _ := activeDB.get()
+	results, args, err := align.Vsearch{
+		Binary:      vsearchPath,
+		Version:     vsearchVersion,
+		DBDir:       *config.BlastDBDir,
+		DB:          db,
+		Threads:     queryThreads(opts),
+		MinIdentity: *vsearchMinIdentity,
+		MaxHits:     cfg().maxHits,
+		Timeout:     cfg().blastTimeout,
+	}.Run(ctx, seq)
+	if err != nil {
+		return results, err
+	}
+
+	return finishBlast(ctx, results, seq, args, start), nil
+}
+
+// latencyTracker keeps a running average of how long queries take.
+type latencyTracker struct {
+	mu    sync.Mutex
+	count int
+	total time.Duration
+}
+
+func (l *latencyTracker) record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count++
+	l.total += d
+}
+
+func (l *latencyTracker) average() (time.Duration, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count == 0 {
+		return 0, 0
+	}
+
+	return l.total / time.Duration(l.count), l.count
+}
+
+var queryLatency = &latencyTracker{}
+
+// Stats describes how complete and fresh the index is.
+type Stats struct {
+	Sequences       int           `json:"sequences"`
+	URIs            int           `json:"uris"`
+	LastSlurp       time.Time     `json:"lastSlurp"`
+	NewestCreated   time.Time     `json:"newestCreated"`
+	DBVersion       string        `json:"dbVersion"`
+	Aligners        []string      `json:"aligners"`
+	BlastVersion    string        `json:"blastVersion"`
+	Queries         int           `json:"queries"`
+	AvgQueryLatency time.Duration `json:"avgQueryLatency"`
+
+	// LengthHistogram counts the sequences in each range of lengths
+	LengthHistogram []store.LengthBucket `json:"lengthHistogram"`
+
+	// Clustered is set when the db's near-identical sequences were
+	// clustered, so searches can be run against just the representatives
+	Clustered bool `json:"clustered,omitempty"`
+
+	// SubjectSearch is set when queries can be searched against
+	// sequences sent with them instead of the db
+	SubjectSearch bool `json:"subjectSearch,omitempty"`
+
+	// DBVersions are the older versions of the db kept on the server that
+	// queries can search with dbVersion, oldest first
+	DBVersions []string `json:"dbVersions,omitempty"`
+
+	// CursorLag is how long ago NewestCreated was, and Stale is set when
+	// that's longer than -freshness.maxLag
+	CursorLag time.Duration `json:"cursorLag"`
+	Stale     bool          `json:"stale,omitempty"`
+
+	// Demo is set when the server is running with -demo, searching a
+	// bundled miniature db in-process rather than with blastn
+	Demo bool `json:"demo,omitempty"`
+}
+
+// MaxLengthCount is the count of the fullest bar of the length histogram,
+// for scaling the bars on the stats page.
+func (s *Stats) MaxLengthCount() int {
+	max := 0
+	for _, b := range s.LengthHistogram {
+		if b.Count > max {
+			max = b.Count
+		}
+	}
+
+	return max
+}
+
+// blastdbPath is the path of the blast db files, minus their extension.
+func blastdbPath() string {
+	db, _ := activeDB.get()
+	return path.Join(os.ExpandEnv(*config.BlastDBDir), db)
+}
+
+// blastDB is the db queries run against. builddb.sh builds each db under a
+// new name, <name>-<version>, and then points the <name>.current manifest
+// at it, so the server can switch over without a restart while queries
+// already running finish against the old one.
+type blastDB struct {
+	mu      sync.RWMutex
+	name    string
+	version string
+
+	// kmers is the db's k-mer index built by buildkmers, if it has one
+	kmers *kmerindex.Index
+
+	// clusters are the other members of each cluster of near-identical
+	// sequences by their representative, if builddb.sh clustered the db,
+	// see loadClusters
+	clusters map[string][]string
+
+	// onSwap is run in the background whenever a new db replaces the one
+	// the server started with
+	onSwap func()
+}
+
+var activeDB = &blastDB{}
+
+// get returns the db's name and version, just -blastdb.name if there's no
+// db on disk (yet).
+func (d *blastDB) get() (name, version string) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.name == "" {
+		return cfg().blastDBName, ""
+	}
+
+	return d.name, d.version
+}
+
+// currentDB reads the manifest to find the db builddb.sh built last. dbs
+// built before there was a manifest are just called -blastdb.name.
+func currentDB() (string, error) {
+	base := cfg().blastDBName
+	b, err := ioutil.ReadFile(path.Join(os.ExpandEnv(*config.BlastDBDir), base+".current"))
+	if os.IsNotExist(err) {
+		return base, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(string(b))
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		return "", fmt.Errorf("manifest names an invalid db %q", name)
+	}
+
+	return name, nil
+}
+
+// dbVersion identifies a blast db on disk. Versioned dbs carry it in their
+// name, older ones were replaced wholesale so their modification time does
+// the job.
+func dbVersion(name string) (string, error) {
+	base := path.Join(os.ExpandEnv(*config.BlastDBDir), name)
+
+	// large dbs are split into volumes tied together by a .nal alias file
+	info, err := os.Stat(base + ".nal")
+	if os.IsNotExist(err) {
+		info, err = os.Stat(base + ".nin")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if version := strings.TrimPrefix(name, cfg().blastDBName+"-"); version != name {
+		return version, nil
+	}
+
+	return info.ModTime().UTC().Format(time.RFC3339), nil
+}
+
+// reload switches to the current db if it changed.
+func (d *blastDB) reload() error {
+	name, err := currentDB()
+	if err != nil {
+		return err
+	}
+
+	version, err := dbVersion(name)
+	if err != nil {
+		return err
+	}
+
+	d.mu.RLock()
+	unchanged := name == d.name && version == d.version
+	d.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	// loaded before switching over so queries never see a db without its
+	// index
+	kmers, err := loadKmerIndex(name)
+	if err != nil {
+		slog.Error("couldn't load k-mer index, screening is disabled", "db", name, "err", err)
+	}
+	clusters, err := loadClusters(name)
+	if err != nil {
+		slog.Error("couldn't load clusters, clustered searches are disabled", "db", name, "err", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	swapped := d.name != ""
+	if swapped {
+		slog.Info("blast db changed", "from", d.name, "fromVersion", d.version, "to", name, "toVersion", version)
+	}
+	d.name, d.version, d.kmers, d.clusters = name, version, kmers, clusters
+
+	if swapped && d.onSwap != nil {
+		go d.onSwap()
+	}
+
+	return nil
+}
+
+// kmerIndex returns the k-mer index of the db, nil if it doesn't have one.
+func (d *blastDB) kmerIndex() *kmerindex.Index {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.kmers
+}
+
+// loadKmerIndex reads <db>.kmi, returning nil if there isn't one.
+func loadKmerIndex(name string) (*kmerindex.Index, error) {
+	f, err := os.Open(path.Join(os.ExpandEnv(*config.BlastDBDir), name+".kmi"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return kmerindex.Read(bufio.NewReader(f))
+}
+
+// watch reloads the db every interval.
+func (d *blastDB) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := d.reload()
+		if err != nil {
+			slog.Error("couldn't check for a new blast db", "err", err)
+		}
+	}
+}
+
+func getStats() (*Stats, error) {
+	stats := &Stats{}
+
+	var err error
+	stats.Sequences, err = redisPool.Cmd("SCARD", seqStore.Keys.Dedup).Int()
+	if err != nil {
+		return nil, err
+	}
+
+	slurpStats, err := redisPool.Cmd("HGETALL", seqStore.Keys.Stats).Map()
+	if err != nil {
+		return nil, err
+	}
+
+	if uris, ok := slurpStats["uris"]; ok {
+		stats.URIs, err = strconv.Atoi(uris)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse uri count: %v", err)
+		}
+	}
+
+	if lastSlurp, ok := slurpStats["lastSlurp"]; ok {
+		stats.LastSlurp, err = time.Parse(time.RFC3339, lastSlurp)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse last slurp time: %v", err)
+		}
+	}
+
+	if newest, ok := slurpStats["newestCreated"]; ok {
+		stats.NewestCreated, err = time.Parse(time.RFC3339, newest)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse newest creation time: %v", err)
+		}
+		stats.CursorLag = time.Since(stats.NewestCreated).Round(time.Second)
+		maxLag := cfg().maxCursorLag
+		stats.Stale = maxLag > 0 && stats.CursorLag > maxLag
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		return nil, err
+	}
+	stats.LengthHistogram, err = seqStore.LengthHistogram(client)
+	redisPool.Put(client)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get length histogram: %v", err)
+	}
+
+	_, stats.DBVersion = activeDB.get()
+	if stats.DBVersion == "" {
+		stats.DBVersion = "unknown"
+	}
+
+	stats.BlastVersion = blastVersion
+	for name := range aligners {
+		stats.Aligners = append(stats.Aligners, name)
+	}
+	sort.Strings(stats.Aligners)
+	stats.AvgQueryLatency, stats.Queries = queryLatency.average()
+	_, members := activeDB.clustered()
+	stats.Clustered = members != nil
+	stats.SubjectSearch = makeblastdbPath != "" && blastnPath != ""
+	stats.Demo = *demoMode
+	stats.DBVersions, err = retainedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// healthzHandler reports that the process is up and serving http.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readinessChecks are everything that has to work for a query to succeed.
+// Redis isn't critical since searches still return hits without it.
+var readinessChecks = []struct {
+	name     string
+	check    func(ctx context.Context) error
+	critical bool
+}{
+	{"redis", checkRedis, false},
+	{"blastn", checkBlastn, true},
+	{"blastdb", checkBlastDB, true},
+}
+
+// workerReadinessChecks replace readinessChecks with -workers.remote, since
+// blastn and the db are the workers' business then.
+var workerReadinessChecks = []struct {
+	name     string
+	check    func(ctx context.Context) error
+	critical bool
+}{
+	{"redis", checkRedis, true},
+	{"workers", checkWorkers, true},
+}
+
+func checkWorkers(ctx context.Context) error {
+	client, err := redisPool.Get()
+	if err != nil {
+		return err
+	}
+	defer redisPool.Put(client)
+
+	n, err := workqueue.LiveWorkers(client, *config.WorkQueuePrefix, *config.WorkerLeaseTTL)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("no workers have checked in lately")
+	}
+
+	return nil
+}
+
+func checkRedis(ctx context.Context) error {
+	return redisPool.Cmd("PING").Err
+}
+
+func checkBlastn(ctx context.Context) error {
+	_, err := blast.Version(ctx, blastnPath)
+	return err
+}
+
+func checkBlastDB(ctx context.Context) error {
+	base := blastdbPath()
+
+	f, err := os.Open(base + ".nal")
+	if os.IsNotExist(err) {
+		f, err = os.Open(base + ".nin")
+	}
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// readyzHandler reports whether this instance can actually answer queries,
+// so load balancers can stop sending traffic to it when it can't.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	ready := true
+	buf := &bytes.Buffer{}
+	for _, c := range readinessChecks {
+		err := c.check(ctx)
+		if err != nil && !c.critical {
+			fmt.Fprintf(buf, "%s: degraded: %v\n", c.name, err)
+		} else if err != nil {
+			ready = false
+			fmt.Fprintf(buf, "%s: %v\n", c.name, err)
+		} else {
+			fmt.Fprintf(buf, "%s: ok\n", c.name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	buf.WriteTo(w)
+}
+
+// rewriteRule rewrites links to components slurped from one place so they
+// point somewhere else, e.g. a private mirror's public address.
+type rewriteRule struct {
+	from *regexp.Regexp
+	to   string
+}
+
+// loadRewriteRules reads rules from a file with one rule per line: a regexp
+// and its replacement separated by whitespace, where the replacement can use
+// $1 etc. to refer to capture groups. Blank lines and lines starting with #
+// are ignored.
+func loadRewriteRules(filename string) ([]rewriteRule, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []rewriteRule{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<regexp> <replacement>\"", filename, i+1)
+		}
+
+		from, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, i+1, err)
+		}
+
+		rules = append(rules, rewriteRule{from: from, to: fields[1]})
+	}
+
+	return rules, nil
+}
+
+// rewriteURI applies the first rewrite rule matching uri.
+func rewriteURI(uri string) string {
+	for _, rule := range cfg().rewriteRules {
+		if rule.from.MatchString(uri) {
+			return rule.from.ReplaceAllString(uri, rule.to)
+		}
+	}
+
+	return uri
+}
+
+// componentLink is where to send people to see the component uri, which was
+// slurped from the SynBioHub instance at source. -uris.rewriteRules come
+// first. Otherwise uris minted under another host than the instance is
+// reached at, as private instances' often are, are pointed at the
+// instance.
+func componentLink(uri, source string) string {
+	if rewritten := rewriteURI(uri); rewritten != uri || source == "" {
+		return rewritten
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	instance, err := url.Parse(source)
+	if err != nil || instance.Host == "" || strings.EqualFold(u.Host, instance.Host) {
+		return uri
+	}
+
+	u.Scheme, u.Host = instance.Scheme, instance.Host
+	u.Path = strings.TrimRight(instance.Path, "/") + u.Path
+	u.RawPath = ""
+	return u.String()
+}
+
+// sameInstance reports whether a and b are addresses of the same SynBioHub
+// instance.
+func sameInstance(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// isPrivateInstance reports whether source is one of -uris.privateInstances.
+func isPrivateInstance(source string) bool {
+	for _, instance := range strings.Split(*privateInstances, ",") {
+		if strings.TrimSpace(instance) != "" && sameInstance(strings.TrimSpace(instance), source) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// similarityTier is a badge given to hits at least min percent identical.
+type similarityTier struct {
+	min   float64
+	name  string
+	color string
+}
+
+// tierColors are used for tiers that don't pick a color, best tier first
+var tierColors = []string{"#2e7d32", "#689f38", "#f9a825", "#ef6c00", "#c62828"}
+
+// parseSimilarityTiers parses -results.similarityTiers, e.g.
+// "99:identical,95:near-identical:#9e9d24,80:similar". Tiers are sorted
+// best first.
+func parseSimilarityTiers(s string) ([]similarityTier, error) {
+	tiers := []similarityTier{}
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		fields := strings.SplitN(spec, ":", 3)
+		if len(fields) < 2 || fields[1] == "" {
+			return nil, fmt.Errorf("similarity tier %q isn't \"<min percent>:<name>[:<css color>]\"", spec)
+		}
+
+		min, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || min < 0 || min > 100 {
+			return nil, fmt.Errorf("similarity tier %q needs a percentage between 0 and 100", spec)
+		}
+
+		tier := similarityTier{min: min, name: fields[1]}
+		if len(fields) == 3 {
+			tier.color = fields[2]
+		}
+		tiers = append(tiers, tier)
+	}
+
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].min > tiers[j].min
+	})
+	for i := range tiers {
+		if tiers[i].color == "" {
+			tiers[i].color = tierColors[i%len(tierColors)]
+		}
+	}
+
+	return tiers, nil
+}
+
+// classify gives each hit the badge of the best tier it reaches, works out
+// how it overlaps the query, and flags ambiguity codes in nucleotide
+// alignments.
+func classify(r *blast.Results) {
+	r.ClassifyContainment()
+
+	// diamond's alignments are protein, where N and friends are amino acids
+	nucleotide := !strings.HasPrefix(r.Program, "diamond")
+
+	for i := range r.Results {
+		r.Results[i].SimilarityClass = ""
+		r.Results[i].QueryAmbiguous = nucleotide && blast.HasAmbiguity(r.Results[i].QuerySeq)
+		r.Results[i].HitAmbiguous = nucleotide && blast.HasAmbiguity(r.Results[i].HitSeq)
+
+		identity := r.Results[i].IdentityPercent()
+		for _, tier := range cfg().similarityTiers {
+			if identity >= tier.min {
+				r.Results[i].SimilarityClass = tier.name
+				break
+			}
+		}
+	}
+}
+
+// similarityColor is the badge color of a similarity class.
+func similarityColor(class string) string {
+	for _, tier := range cfg().similarityTiers {
+		if tier.name == class {
+			return tier.color
+		}
+	}
+
+	return "gray"
+}
+
+var alignmentComplement = strings.NewReplacer(
+	"A", "T", "T", "A", "C", "G", "G", "C", "U", "A",
+	"a", "t", "t", "a", "c", "g", "g", "c", "u", "a",
+)
+
+// reverse reverses a row of an alignment.
+func reverse(s string) string {
+	b := []byte(s)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return string(b)
+}
+
+// reverseComplement flips a row of a nucleotide alignment onto the other
+// strand, leaving gaps and ambiguity codes as they are.
+func reverseComplement(s string) string {
+	return reverse(alignmentComplement.Replace(s))
+}
+
+// assetsFS is where pages and static files are read from: -templates.dir
+// when editing them, otherwise the copies built into the binary.
+func assetsFS() fs.FS {
+	return assetsDirFS(cfg().templatesDir)
+}
+
+// assetsDirFS is assetsFS with -templates.dir set to dir.
+func assetsDirFS(dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+
+	return synbioblast.Assets
+}
+
+// https://golang.org/doc/articles/wiki/
+
+func parseTemplates(assets fs.FS) (*template.Template, error) {
+	return template.New("").Funcs(template.FuncMap{
+		"rewriteURI":        rewriteURI,
+		"componentLink":     componentLink,
+		"similarityColor":   similarityColor,
+		"formatEValue":      render.FormatEValue,
+		"sbolGlyph":         render.SBOLGlyph,
+		"reverse":           reverse,
+		"reverseComplement": reverseComplement,
+		"sitePath":          sitePath,
+	}).ParseFS(assets, "*.html")
+}
+
+// devErrorPage shows template errors in -dev mode.
+var devErrorPage = template.Must(template.New("").Parse(`<html>
+    <head><title>Template error</title></head>
+    <body>
+        <h1>Template error</h1>
+        <pre style="color: red">{{.}}</pre>
+    </body>
+</html>
+`))
+
+// renderPage renders one of the pages. It's rendered into a buffer first so
+// a failing template doesn't leave half a page. In -dev mode the templates
+// are parsed again each time so edits show up without a restart, and
+// errors are shown on the page.
+func renderPage(w http.ResponseWriter, name string, data interface{}) {
+	t := cfg().templates
+	var err error
+	if *devMode {
+		t, err = parseTemplates(assetsFS())
+	}
+
+	start := time.Now()
+	page := &bytes.Buffer{}
+	if err == nil {
+		err = t.ExecuteTemplate(page, name, data)
+	}
+	addServerTiming(w, "render", time.Since(start))
+	if err != nil {
+		slog.Error("couldn't render page", "page", name, "err", err)
+		if *devMode {
+			w.WriteHeader(http.StatusInternalServerError)
+			devErrorPage.Execute(w, err.Error())
+			return
+		}
+		writeErrorPage(w, http.StatusInternalServerError, "the page couldn't be rendered, the error has been logged")
+		return
+	}
+
+	w.Write(page.Bytes())
+}
+
+// addServerTiming adds a phase to the response's Server-Timing header, so
+// it shows up in the browser's dev tools.
+func addServerTiming(w http.ResponseWriter, name string, d time.Duration) {
+	w.Header().Add("Server-Timing", fmt.Sprintf("%s;dur=%.3f", name, float64(d)/float64(time.Millisecond)))
+}
+
+// setServerTimings adds the phases of a query to the Server-Timing header.
+func setServerTimings(w http.ResponseWriter, t blast.Timings) {
+	addServerTiming(w, "normalize", t.Normalize)
+	addServerTiming(w, "queue", t.QueueWait)
+	addServerTiming(w, "aligner", t.Aligner)
+	addServerTiming(w, "parse", t.Parse)
+	addServerTiming(w, "uris", t.ResolveURIs)
+	addServerTiming(w, "total", t.Total)
+}
+
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	// the page is still usable without stats, so don't fail over them
+	stats, err := getStats()
+	if err != nil {
+		logging.From(r.Context()).Error("couldn't get stats", "err", err)
+	}
+
+	renderPage(w, "form.html", stats)
+}
+
+// limitBody makes reading more than -http.maxBodyBytes of a request fail,
+// so nobody can make us buffer a whole genome before the query length is
+// even checked.
+func limitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, cfg().maxBodyBytes)
+}
+
+// bodyTooLarge reports whether err is from reading past limitBody's limit,
+// and if so the message to send back.
+func bodyTooLarge(err error) (string, bool) {
+	tooLarge := &http.MaxBytesError{}
+	if !errors.As(err, &tooLarge) {
+		return "", false
+	}
+
+	return fmt.Sprintf("the request is larger than the %d byte limit", tooLarge.Limit), true
+}
+
+func blastHandler(w http.ResponseWriter, r *http.Request) {
+	limitBody(w, r)
+	// the form is multipart so a subject fasta can be uploaded, anything
+	// else posting here sends a plain form
+	err := r.ParseMultipartForm(cfg().maxBodyBytes)
+	if err == http.ErrNotMultipart {
+		err = nil
+	}
+	if msg, ok := bodyTooLarge(err); ok {
+		writeErrorPage(w, http.StatusRequestEntityTooLarge, msg)
+		return
+	} else if err != nil {
+		writeErrorPage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req := searchRequest{
+		Sequence:    r.FormValue("seq"),
+		Description: r.FormValue("description"),
+		Options: align.Options{
+			Aligner:   r.FormValue("aligner"),
+			Circular:  r.FormValue("circular") != "",
+			Clustered: r.FormValue("clustered") != "",
+			Subject:   r.FormValue("subject"),
+			DBVersion: r.FormValue("dbVersion"),
+		},
+	}
+	if file, _, err := r.FormFile("subjectFile"); err == nil {
+		subject, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			writeErrorPage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Subject = string(subject)
+	}
+	if role := r.FormValue("role"); role != "" {
+		req.Roles = []string{role}
+	}
+	if containment := r.FormValue("containment"); containment != "" {
+		req.Containment = []string{containment}
+	}
+
+	err = req.validate(r.Context())
+	if err != nil {
+		writeErrorPage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// searches from the page go through the queue like any other job, but
+	// ahead of the API's batches
+	search := jobRequest{searchRequest: req, class: jobqueue.Interactive}
+	j, err := search.submit(r.Context(), jobs)
+	if err == jobqueue.ErrQueueFull || err == jobqueue.ErrShuttingDown {
+		tooBusy(w)
+		writeErrorPage(w, http.StatusServiceUnavailable, "The server is busy with other queries, please try again shortly.")
+		return
+	} else if err != nil {
+		logging.From(r.Context()).Error("couldn't queue search", "err", err)
+		writeErrorPage(w, http.StatusInternalServerError, "the search failed, the error has been logged")
+		return
+	}
+
+	id := j.ID
+	j, err = jobs.Wait(r.Context(), id)
+	if err != nil {
+		// nobody's left to see the results
+		jobs.Cancel(id)
+		return
+	}
+	if j.Status == jobqueue.Failed {
+		writeErrorPage(w, j.ErrorStatus, j.Error)
+		return
+	}
+
+	setServerTimings(w, j.Results.Timings)
+	renderPage(w, "blast.html", resultsPage(*j.Results))
+}
+
+// searchRequest is the JSON body accepted by the search API, see openapi.json
+type searchRequest struct {
+	Sequence string `json:"sequence"`
+
+	// only return components whose title or description contain these words
+	Description string `json:"description,omitempty"`
+
+	// only return components with one of these roles, named like their
+	// glyphs, e.g. "promoter", or by Sequence Ontology term. validate turns
+	// them all into terms.
+	Roles []string `json:"roles,omitempty"`
+
+	// only return hits that overlap the query like this, see
+	// blast.Containments
+	Containment []string `json:"containment,omitempty"`
+
+	// MaxRecords is how many FASTA records Sequence can have, each
+	// searched separately, 1 if it's unset and at most -query.maxRecords
+	MaxRecords int `json:"maxRecords,omitempty"`
+
+	align.Options
+
+	// what the sequence was pasted as, and how long it took to
+	// normalize, set by validate
+	input      *blast.Input
+	normalized time.Duration
+}
+
+// validate checks the request, and normalizes its sequence down to the
+// residues that will actually be searched.
+func (r *searchRequest) validate(ctx context.Context) error {
+	if r.MaxRecords < 0 || r.MaxRecords > cfg().maxQueryRecords {
+		return fmt.Errorf("maxRecords must be between 1 and %d", cfg().maxQueryRecords)
+	}
+
+	_, span := tracer.Start(ctx, "normalize query")
+	start := time.Now()
+	seq, input, err := blast.NormalizeQueries(r.Sequence, cfg().maxQueryLength, r.MaxRecords)
+	r.normalized = time.Since(start)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	r.Sequence = seq
+	r.input = input
+	if input.Records > 1 && (r.AlignerName() != "blastn" || r.Circular || *demoMode) {
+		return errors.New("queries of more than one record can only be searched by blastn, and not as circular")
+	}
+
+	for i, role := range r.Roles {
+		term, ok := render.RoleTerm(role)
+		if !ok {
+			return fmt.Errorf("unknown role %q, use a Sequence Ontology term like SO:0000167 or one of the glyph names", role)
+		}
+		r.Roles[i] = term
+	}
+
+	for _, containment := range r.Containment {
+		if !slices.Contains(blast.Containments, containment) {
+			return fmt.Errorf("unknown containment %q, use one of %s", containment, strings.Join(blast.Containments, ", "))
+		}
+	}
+
+	if r.Circular && !align.IsNucleotide(r.Sequence) {
+		return errors.New("only nucleotide sequences can be searched as circular")
+	}
+	if r.Subject != "" && (r.Description != "" || len(r.Roles) > 0) {
+		return errors.New("subject sequences have no description or role to filter by")
+	}
+
+	return validateOptions(r.Options)
+}
+
+// apiError is an RFC 7807 problem details object. Type is a short name
+// for the kind of problem, relative to the API.
+type apiError struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+
+	// Error repeats Detail for clients written before problem details
+	Error string `json:"error"`
+}
+
+// problemTypes are the kinds of problem the API reports, by status.
+var problemTypes = map[int]string{
+	http.StatusBadRequest:            "invalid-input",
+	http.StatusNotFound:              "not-found",
+	http.StatusMethodNotAllowed:      "method-not-allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "too-large",
+	http.StatusTooManyRequests:       "too-busy",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+func newAPIError(status int, msg string) apiError {
+	kind, ok := problemTypes[status]
+	if !ok {
+		kind = "internal"
+	}
+
+	return apiError{
+		Type:   kind,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: msg,
+		Error:  msg,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Error("couldn't write json response", "err", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(newAPIError(status, msg))
+	if err != nil {
+		slog.Error("couldn't write json response", "err", err)
+	}
+}
+
+// writeInternalError logs err and tells the client something went wrong
+// without saying what, since it's usually about redis or the filesystem and
+// nothing they can fix.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logging.From(r.Context()).Error("couldn't handle api request", "path", r.URL.Path, "err", err)
+	writeAPIError(w, http.StatusInternalServerError, "internal error, it has been logged")
+}
+
+// writeErrorPage is writeAPIError for the pages people use.
+func writeErrorPage(w http.ResponseWriter, status int, msg string) {
+	page := &bytes.Buffer{}
+	err := cfg().templates.ExecuteTemplate(page, "error.html", newAPIError(status, msg))
+	if err != nil {
+		slog.Error("couldn't render error page", "err", err)
+		http.Error(w, msg, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(page.Bytes())
+}
+
+// staticHandler serves the static files from wherever assetsFS finds them,
+// which -templates.dir can change on SIGHUP.
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	static, err := fs.Sub(assetsFS(), "static")
+	if err != nil {
+		http.Error(w, "couldn't find static files", http.StatusInternalServerError)
+		return
+	}
+
+	http.FileServerFS(static).ServeHTTP(w, r)
+}
+
+func openapiHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFileFS(w, r, assetsFS(), "openapi.json")
+}
+
+func apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "search requires POST")
+		return
+	}
+
+	req := searchRequest{}
+	limitBody(w, r)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if msg, ok := bodyTooLarge(err); ok {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, msg)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "couldn't parse request body: "+err.Error())
+		return
+	}
+
+	err = req.validate(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !blastSlots.TryAcquire(queryWeight(req.Options)) {
+		tooBusy(w)
+		writeAPIError(w, http.StatusTooManyRequests, "all blast slots are busy, try again later or submit a job instead")
+		return
+	}
+	defer blastSlots.Release(queryWeight(req.Options))
+
+	result, err := alignQuery(r.Context(), req.Sequence, req.Options)
+	if err != nil {
+		logging.From(r.Context()).Error("search failed", "err", err, "stderr", result.Stderr())
+		status, msg := searchFailure(err)
+		writeAPIError(w, status, msg)
+		return
+	}
+	result.Input = req.input
+	result.Timings.Normalize = req.normalized
+	result.Timings.Total += req.normalized
+
+	err = filterResults(result, req.Description, req.Roles, req.Containment)
+	if err != nil {
+		logging.From(r.Context()).Error("couldn't filter results", "err", err)
+		writeAPIError(w, http.StatusInternalServerError, "the search failed, the error has been logged")
+		return
+	}
+
+	writeResults(w, r, result)
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+type requestIDKey struct{}
+
+// requestID returns the ID logRequests gave the request ctx belongs to,
+// empty if it doesn't belong to one.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// callers may pick the ID, e.g. a proxy that logs it too, as long as it
+// can't mess up the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// statusRecorder remembers how a handler responded, for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController get at the real ResponseWriter.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// newHTTPServer returns a server for h on addr with the -http.* timeouts and
+// limits, so slow clients can't tie up connections forever.
+func newHTTPServer(addr string, h http.Handler) *http.Server {
+	writeTimeout := *httpWriteTimeout
+	if writeTimeout == 0 {
+		writeTimeout = cfg().blastTimeout + time.Minute
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: *httpReadHeaderTimeout,
+		ReadTimeout:       *httpReadTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       *httpIdleTimeout,
+		MaxHeaderBytes:    *httpMaxHeaderBytes,
+	}
+}
+
+// listen serves srv over https if -tls.cert or -autocert.domain is set, and
+// over plain http if not.
+func listen(srv *http.Server) error {
+	switch {
+	case *autocertDomain != "":
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(*autocertDomain, ",")...),
+			Cache:      autocert.DirCache(*autocertCacheDir),
+			Email:      *autocertEmail,
+		}
+		srv.TLSConfig = m.TLSConfig()
+
+		go func() {
+			err := newHTTPServer(fmt.Sprintf(":%d", *autocertHTTPPort), m.HTTPHandler(nil)).ListenAndServe()
+			logging.Fatal("autocert http server stopped", "port", *autocertHTTPPort, "err", err)
+		}()
+
+		slog.Info("serving https with certificates from Let's Encrypt", "port", *port, "domains", *autocertDomain)
+		return srv.ListenAndServeTLS("", "")
+	case *tlsCert != "":
+		slog.Info("serving https", "port", *port, "cert", *tlsCert)
+		return srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	}
+
+	slog.Info("serving http", "port", *port)
+	return srv.ListenAndServe()
+}
+
+// accessLog is where requests are logged for -accessLog.path, nil if they
+// aren't.
+var accessLog io.Writer
+
+// openAccessLog opens -accessLog.path, which is rotated once it reaches
+// -accessLog.maxSize and every -accessLog.rotateEvery.
+func openAccessLog() (io.Writer, error) {
+	switch *accessLogFormat {
+	case "clf", "combined", "json":
+	default:
+		return nil, fmt.Errorf("unknown -accessLog.format %q, it must be clf, combined or json", *accessLogFormat)
+	}
+
+	if *accessLogPath == "-" {
+		return os.Stdout, nil
+	}
+
+	l := &lumberjack.Logger{
+		Filename:   *accessLogPath,
+		MaxSize:    *accessLogMaxSize,
+		MaxBackups: *accessLogMaxBackups,
+	}
+	if *accessLogRotateEvery > 0 {
+		go func() {
+			for range time.Tick(*accessLogRotateEvery) {
+				err := l.Rotate()
+				if err != nil {
+					slog.Error("couldn't rotate access log", "err", err)
+				}
+			}
+		}()
+	}
+
+	return l, nil
+}
+
+// accessLogEntry is a line of the json access log.
+type accessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Seconds   float64   `json:"seconds"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	RequestID string    `json:"requestId"`
+}
+
+// logAccess writes a request to the access log.
+func logAccess(r *http.Request, client string, rec *statusRecorder, start time.Time) {
+	var line []byte
+	if *accessLogFormat == "json" {
+		line, _ = json.Marshal(accessLogEntry{
+			Time:      start,
+			Client:    client,
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Protocol:  r.Proto,
+			Status:    rec.status,
+			Bytes:     rec.bytes,
+			Seconds:   time.Since(start).Seconds(),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			RequestID: requestID(r.Context()),
+		})
+	} else {
+		size := "-"
+		if rec.bytes > 0 {
+			size = strconv.FormatInt(rec.bytes, 10)
+		}
+
+		line = fmt.Appendf(nil, "%s - - [%s] %q %d %s", client, start.Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.RequestURI+" "+r.Proto, rec.status, size)
+		if *accessLogFormat == "combined" {
+			line = fmt.Appendf(line, " %q %q", r.Referer(), r.UserAgent())
+		}
+	}
+
+	_, err := accessLog.Write(append(line, '\n'))
+	if err != nil {
+		slog.Error("couldn't write access log", "err", err)
+	}
+}
+
+// trustedProxies are the parsed -http.trustedProxies.
+var trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses a comma separated list of IPs and CIDRs.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	proxies := []netip.Prefix{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		if !strings.Contains(p, "/") {
+			addr, err := netip.ParseAddr(p)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	for _, p := range trustedProxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fromTrustedProxy reports whether r came through one of
+// -http.trustedProxies, so its X-Forwarded headers can be believed.
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return trustedProxy(host)
+}
+
+// clientIP is the address r came from. Requests through trusted proxies are
+// from the last address in X-Forwarded-For that isn't another of them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+
+	return host
+}
+
+// requestScheme is the scheme the client used to reach the server, which
+// may not be the one the proxy in between used.
+func requestScheme(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		switch proto := r.Header.Get("X-Forwarded-Proto"); proto {
+		case "http", "https":
+			return proto
+		}
+	}
+
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestHost is the host the client asked for.
+func requestHost(r *http.Request) string {
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" && fromTrustedProxy(r) {
+		return strings.TrimSpace(strings.Split(host, ",")[0])
+	}
+
+	return r.Host
+}
+
+// sitePath returns the path clients see for one of the server's paths,
+// which is under -http.basePath.
+func sitePath(path string) string {
+	return *basePath + path
+}
+
+// stripBasePath takes -http.basePath off the requests of proxies that
+// don't, so the server works either way.
+func stripBasePath(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest, ok := strings.CutPrefix(r.URL.Path, *basePath); ok && *basePath != "" && (rest == "" || rest[0] == '/') {
+			r = r.Clone(r.Context())
+			r.URL.Path = "/" + strings.TrimPrefix(rest, "/")
+			r.URL.RawPath = ""
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// compressibleTypes are the content types worth compressing, everything
+// else served is already compressed
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/problem+json",
+	"application/xml",
+	"application/atom+xml",
+	"application/javascript",
+	"image/svg+xml",
+}
+
+func compressible(contentType string) bool {
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows enc.
+func acceptsEncoding(header, enc string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), enc) {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		return q > 0
+	}
+
+	return false
+}
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// compressWriter compresses a response once it knows from the headers that
+// it's worth it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	decided bool
+	w       flushWriteCloser // nil if the response isn't compressed
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if !c.decided {
+		c.decide(status)
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *compressWriter) decide(status int) {
+	c.decided = true
+
+	h := c.Header()
+	switch {
+	case status < 200, status == http.StatusNoContent, status == http.StatusNotModified:
+		return
+	case h.Get("Content-Encoding") != "", h.Get("Content-Range") != "":
+		return
+	case !compressible(h.Get("Content-Type")):
+		return
+	}
+
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", c.encoding)
+	if c.encoding == "gzip" {
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(c.ResponseWriter)
+		c.w = gz
+	} else {
+		// HTTP's deflate is zlib's format, not raw deflate
+		c.w = zlib.NewWriter(c.ResponseWriter)
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.decided {
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if c.w == nil {
+		return c.ResponseWriter.Write(b)
+	}
+	return c.w.Write(b)
+}
+
+// Flush sends what's been compressed so far, for responses that are
+// written bit by bit.
+func (c *compressWriter) Flush() {
+	if c.w != nil {
+		c.w.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection, for handlers
+// that stream for longer than -http.writeTimeout.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *compressWriter) close() {
+	if c.w == nil {
+		return
+	}
+
+	c.w.Close()
+	if gz, ok := c.w.(*gzip.Writer); ok {
+		gzipWriters.Put(gz)
+	}
+}
+
+// compressResponses gzips or deflates the responses of clients that accept
+// it, since results with all their alignments run to megabytes.
+func compressResponses(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := ""
+		switch accept := r.Header.Get("Accept-Encoding"); {
+		case r.Method == http.MethodHead:
+		case acceptsEncoding(accept, "gzip"):
+			encoding = "gzip"
+		case acceptsEncoding(accept, "deflate"):
+			encoding = "deflate"
+		}
+		if encoding == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		c := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer c.close()
+		h.ServeHTTP(c, r)
+	})
+}
+
+// logRequests gives each request an ID, which everything logged while
+// handling it is tagged with and which is sent back as X-Request-ID, and
+// logs the request once it's been handled.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = logging.With(ctx, "request", id)
+		if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+			ctx = logging.With(ctx, "trace", span.TraceID().String())
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		client := clientIP(r)
+
+		if accessLog != nil {
+			logAccess(r, client, rec, start)
+		}
+
+		// load balancers check these every few seconds
+		level := slog.LevelInfo
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			level = slog.LevelDebug
+		}
+		logging.From(ctx).Log(ctx, level, "handled request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"client", client,
+			"status", rec.status,
+			"took", time.Since(start),
+			"bytes", rec.bytes,
+		)
+	})
+}
+
+// jobRequest is the JSON body accepted by the jobs API, see openapi.json
+type jobRequest struct {
+	searchRequest
+
+	// Callback is posted a jobCallback once the job has finished
+	Callback string `json:"callback,omitempty"`
+
+	// Email is mailed once the job has finished
+	Email string `json:"email,omitempty"`
+
+	// the job this one runs again, see apiRerunHandler
+	rerunOf string
+
+	// how the job is scheduled, batch unless it's from the web UI
+	class jobqueue.Class
+}
+
+func (r *jobRequest) validate(ctx context.Context) error {
+	if r.Callback != "" {
+		err := webhooks.Validate(ctx, r.Callback)
+		if err != nil {
+			return fmt.Errorf("callback %v", err)
+		}
+	}
+	if r.Email != "" {
+		email, err := validEmail(r.Email)
+		if err != nil {
+			return err
+		}
+		r.Email = email
+	}
+
+	return r.searchRequest.validate(ctx)
+}
+
+// submit queues the job r asks for on q, and has its email address
+// confirmed if it came with one.
+func (r *jobRequest) submit(ctx context.Context, q *jobqueue.Queue) (jobqueue.Job, error) {
+	j, err := q.Submit(ctx, jobqueue.Job{
+		Query:       r.Sequence,
+		Description: r.Description,
+		Roles:       r.Roles,
+		Containment: r.Containment,
+		Options:     r.Options,
+		Input:       r.input,
+		Callback:    r.Callback,
+		Email:       r.Email,
+		RerunOf:     r.rerunOf,
+		Class:       r.class,
+		RequestID:   requestID(ctx),
+		Normalized:  r.normalized,
+	})
+	if err != nil {
+		return jobqueue.Job{}, err
+	}
+
+	if j.Email != "" {
+		go confirmEmail(j.Email)
+	}
+
+	return j, nil
+}
+
+// webhooks posts job callbacks and saved search alerts, signed with
+// -webhooks.secret.
+var webhooks webhook.Sender
+
+// jobCallback is what's posted to a job's callback when it's finished.
+type jobCallback struct {
+	ID         string          `json:"id"`
+	Status     jobqueue.Status `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	NumResults int             `json:"numResults"`
+
+	// Link is where to get the results, under -webhooks.siteURL
+	Link string `json:"link"`
+}
+
+// callBack tells a finished job's callback about it, retrying with backoff
+// for a while if it can't be reached.
+func callBack(j jobqueue.Job) {
+	callback := jobCallback{
+		ID:     j.ID,
+		Status: j.Status,
+		Error:  j.Error,
+		Link:   strings.TrimRight(*webhookSiteURL, "/") + "/api/v1/jobs/" + j.ID,
+	}
+	if j.Results != nil {
+		callback.NumResults = j.Results.NumResults
+	}
+
+	backoff := 5 * time.Second
+	for attempt := 1; ; attempt++ {
+		err := webhooks.Post(context.Background(), j.Callback, callback)
+		if err == nil {
+			return
+		}
+		if attempt == 5 {
+			slog.With(j.LogArgs()...).Error("giving up on calling back", "callback", j.Callback, "err", err)
+			return
+		}
+
+		slog.With(j.LogArgs()...).Warn("couldn't call back, retrying", "callback", j.Callback, "in", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// runJob waits for a free blast slot and runs the job.
+func runJob(ctx context.Context, j jobqueue.Job) (*blast.Results, error) {
+	err := blastSlots.Acquire(ctx, queryWeight(j.Options))
+	if err != nil {
+		return nil, err
+	}
+	defer blastSlots.Release(queryWeight(j.Options))
+	queueWait := time.Since(j.Submitted)
+
+	// only blastn's xml can be re-parsed later
+	opts := j.Options
+	if jobStore != nil && opts.AlignerName() == "blastn" {
+		raw, err := jobStore.CreateRaw(j.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer raw.Close()
+
+		opts.Raw = raw
+	}
+
+	results, err := alignQuery(ctx, j.Query, opts)
+	if err != nil {
+		return nil, err
+	}
+	results.Input = j.Input
+	results.Timings.Normalize = j.Normalized
+	results.Timings.QueueWait = queueWait
+	results.Timings.Total += j.Normalized + queueWait
+
+	err = filterResults(results, j.Description, j.Roles, j.Containment)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// jobFinished follows up on a finished job: it tells the job's callback and
+// email address, and keeps trying to look up any components it couldn't.
+func jobFinished(j jobqueue.Job) {
+	if j.Results != nil && (j.Results.URIsUnavailable || j.Results.URILookupFailures > 0) {
+		go resolveURIsLater(j.ID)
+	}
+	if j.Callback != "" {
+		go callBack(j)
+	}
+	if j.Email != "" {
+		go emailFinishedJob(j)
+	}
+}
+
+// resolveURIsLater keeps trying to look up the components of a job whose
+// results were made while Redis was down, or that some hits' couldn't be
+// looked up for, backing off between attempts.
+func resolveURIsLater(id string) {
+	backoff := 5 * time.Second
+	deadline := time.Now().Add(time.Hour)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(backoff)
+		if backoff < 5*time.Minute {
+			backoff *= 2
+		}
+
+		j, ok := jobs.Get(id)
+		if !ok || j.Results == nil {
+			return
+		}
+
+		// work on a copy, j.Results is shared with anyone who's read the job
+		results := *j.Results
+		results.Results = append([]blast.Hit(nil), j.Results.Results...)
+
+		err := getURIs(context.Background(), &results)
+		if err != nil {
+			continue
+		}
+		results.URIsUnavailable = false
+
+		err = filterResults(&results, j.Description, j.Roles, j.Containment)
+		if err != nil {
+			continue
+		}
+
+		// the janitor may have removed it while the lookup was retried
+		_, ok = jobs.Update(id, func(j *jobqueue.Job) {
+			j.Results = &results
+		})
+		if !ok {
+			return
+		}
+
+		if results.URILookupFailures > 0 {
+			continue
+		}
+
+		slog.Info("resolved uris for job", "job", id)
+		return
+	}
+
+	slog.Warn("gave up resolving uris for job", "job", id)
+}
+
+var (
+	cleanedJobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "synbioblast_jobs_cleaned_total",
+		Help: "Jobs removed by the janitor, by whether they expired, were evicted to stay under the quota or were left behind by a crash.",
+	}, []string{"reason"})
+	reclaimedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "synbioblast_jobs_reclaimed_bytes_total",
+		Help: "Disk space freed by the janitor, by why the jobs were removed.",
+	}, []string{"reason"})
+	storedJobBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "synbioblast_jobs_stored_bytes",
+		Help: "Size of the jobs in -jobs.dir, as of the janitor's last run.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(cleanedJobs, reclaimedBytes, storedJobBytes)
+}
+
+// janitor cleans up after finished jobs every interval until the server
+// exits, see jobqueue.Queue.Clean.
+func janitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		cleaned, stored, err := jobs.Clean(*jobTTL, *jobMaxBytes)
+		for reason, c := range cleaned {
+			cleanedJobs.WithLabelValues(reason).Add(float64(c.Jobs))
+			reclaimedBytes.WithLabelValues(reason).Add(float64(c.Bytes))
+		}
+		if err != nil {
+			slog.Error("couldn't clean up jobs", "err", err)
+			continue
+		}
+
+		if jobStore != nil {
+			storedJobBytes.Set(float64(stored))
+		}
+	}
+}
+
+// apiFeedHandler lets other services follow newly ingested components
+// without re-scraping everything: start with since=0 and keep passing back
+// the returned next cursor.
+func apiFeedHandler(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.Atoi(r.URL.Query().Get("since"))
+	if err != nil || since < 0 {
+		writeAPIError(w, http.StatusBadRequest, "since must be a non-negative integer")
+		return
+	}
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 1000 {
+			writeAPIError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer redisPool.Put(client)
+
+	page, err := seqStore.Feed(client, since, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+// atomFeedHandler serves the newest ingested components as an Atom feed.
+func atomFeedHandler(w http.ResponseWriter, r *http.Request) {
+	client, err := redisPool.Get()
+	if err != nil {
+		logging.From(r.Context()).Error("couldn't get feed", "err", err)
+		http.Error(w, "couldn't load the feed", http.StatusInternalServerError)
+		return
+	}
+	defer redisPool.Put(client)
+
+	page, err := seqStore.Feed(client, -50, 50)
+	if err != nil {
+		logging.From(r.Context()).Error("couldn't get feed", "err", err)
+		http.Error(w, "couldn't load the feed", http.StatusInternalServerError)
+		return
+	}
+
+	self := requestScheme(r) + "://" + requestHost(r) + sitePath(r.URL.Path)
+
+	feed := atomFeed{
+		ID:      self,
+		Title:   "New sequences in SynBioBLAST",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: self, Rel: "self"},
+	}
+
+	// newest first, as feed readers expect
+	for i := len(page.Entries) - 1; i >= 0; i-- {
+		e := page.Entries[i]
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      e.URI + "#" + e.Hash,
+			Title:   e.URI,
+			Updated: e.Ingested.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: rewriteURI(e.URI)},
+			Summary: "Sequence " + e.Hash + ", created " + e.Created.UTC().Format(time.RFC3339),
+		})
+	}
+	if len(page.Entries) > 0 {
+		feed.Updated = page.Entries[len(page.Entries)-1].Ingested.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml")
+	io.WriteString(w, xml.Header)
+	err = xml.NewEncoder(w).Encode(feed)
+	if err != nil {
+		logging.From(r.Context()).Error("couldn't write atom feed", "err", err)
+	}
+}
+
+// apiPartsHandler searches the titles and descriptions of indexed components.
+func apiPartsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		writeAPIError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			writeAPIError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer redisPool.Put(client)
+
+	parts, err := textindex.Search(client, seqStore.Keys.TextPrefix, q, limit)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, parts)
+}
+
+// screenRequest is the JSON body accepted by the screen API.
+type screenRequest struct {
+	Sequence string `json:"sequence"`
+
+	// MinContainment is the fraction of the query a sequence has to
+	// contain to count as a match, 0.8 if 0
+	MinContainment float64 `json:"minContainment,omitempty"`
+
+	// Limit is the max number of matches returned, 10 if 0
+	Limit int `json:"limit,omitempty"`
+
+	// Escalate runs a full blastn search if the screen finds anything
+	Escalate bool `json:"escalate,omitempty"`
+}
+
+// screenMatch is a kmerindex.Match, the components using the sequence and
+// its length and GC content.
+type screenMatch struct {
+	kmerindex.Match
+	URIs []string `json:"uris"`
+
+	store.Measurement
+
+	// URIsUnavailable is set when the match's components couldn't be
+	// looked up when the rest could
+	URIsUnavailable bool `json:"urisUnavailable,omitempty"`
+}
+
+type screenResponse struct {
+	Matches []screenMatch `json:"matches"`
+
+	// URIsUnavailable is set when Redis couldn't be reached, see blast.Results
+	URIsUnavailable bool `json:"urisUnavailable,omitempty"`
+
+	// Results are the blastn results if the request asked to escalate and
+	// there were matches
+	Results *blast.Results `json:"results,omitempty"`
+}
+
+// apiScreenHandler checks the k-mer index for sequences containing the
+// query, which is much quicker than blast when all that matters is whether
+// a part is already in the db.
+func apiScreenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "screening requires POST")
+		return
+	}
+
+	req := screenRequest{}
+	limitBody(w, r)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if msg, ok := bodyTooLarge(err); ok {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, msg)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "couldn't parse request body: "+err.Error())
+		return
+	}
+
+	req.Sequence, _, err = blast.NormalizeQuery(req.Sequence, cfg().maxQueryLength)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.MinContainment == 0 {
+		req.MinContainment = 0.8
+	}
+	if req.MinContainment < 0 || req.MinContainment > 1 {
+		writeAPIError(w, http.StatusBadRequest, "minContainment must be between 0 and 1")
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = 10
+	}
+	if req.Limit < 0 {
+		writeAPIError(w, http.StatusBadRequest, "limit must be positive")
+		return
+	}
+
+	kmers := activeDB.kmerIndex()
+	if kmers == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "the current db has no k-mer index, rebuild it with buildkmers")
+		return
+	}
+
+	resp := &screenResponse{Matches: []screenMatch{}}
+	for _, m := range kmers.Screen(req.Sequence, req.MinContainment, req.Limit) {
+		resp.Matches = append(resp.Matches, screenMatch{Match: m, URIs: []string{}})
+	}
+
+	hashes := make([]string, len(resp.Matches))
+	for i, m := range resp.Matches {
+		hashes[i] = m.SeqHash
+	}
+	client, err := redisPool.Get()
+	var uris [][]string
+	var measurements []store.Measurement
+	if err == nil {
+		uris, err = seqStore.URIs(client, hashes)
+		if err == nil {
+			measurements, err = seqStore.Measurements(client, hashes)
+		}
+		redisPool.Put(client)
+	}
+	var partial *store.PartialError
+	if errors.As(err, &partial) {
+		logging.From(r.Context()).Warn("couldn't resolve uris of some screen matches", "err", err)
+		for _, i := range partial.Failed {
+			resp.Matches[i].URIsUnavailable = true
+		}
+	} else if err != nil {
+		logging.From(r.Context()).Error("couldn't resolve uris of screen matches", "err", err)
+		resp.URIsUnavailable = true
+	}
+	if !resp.URIsUnavailable {
+		for i := range resp.Matches {
+			if uris[i] != nil {
+				resp.Matches[i].URIs = uris[i]
+			}
+			if measurements != nil {
+				resp.Matches[i].Measurement = measurements[i]
+			}
+		}
+	}
+
+	if req.Escalate && len(resp.Matches) > 0 {
+		opts := align.Options{}
+		if !blastSlots.TryAcquire(queryWeight(opts)) {
+			tooBusy(w)
+			writeAPIError(w, http.StatusTooManyRequests, "all blast slots are busy, try again later")
+			return
+		}
+		defer blastSlots.Release(queryWeight(opts))
+
+		resp.Results, err = alignQuery(r.Context(), req.Sequence, opts)
+		if err != nil {
+			logging.From(r.Context()).Error("screen escalation search failed", "err", err)
+			status, msg := searchFailure(err)
+			writeAPIError(w, status, msg)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// apiSequenceHandler returns the sequence stored under a hash, the same
+// hash hits are identified by.
+func apiSequenceHandler(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, "/api/v1/sequences/")
+	// checking it also keeps anything else from being used as a path
+	if !store.ValidHash(hash) {
+		writeAPIError(w, http.StatusBadRequest, "sequence hashes are 40 lowercase hex digits, or v2- and 64 of them")
+		return
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer redisPool.Put(client)
+
+	seq, err := seqStore.Sequence(client, hash, true)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if seq == nil {
+		writeAPIError(w, http.StatusNotFound, "no sequence with hash "+hash)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, seq)
+}
+
+// matchRequest is the JSON body accepted by the sequence match API.
+type matchRequest struct {
+	Sequence string `json:"sequence"`
+
+	// Ambiguous also matches sequences that could be the same once IUPAC
+	// ambiguity codes are taken into account, see blast.AmbiguousEqual
+	Ambiguous bool `json:"ambiguous,omitempty"`
+}
+
+// sequenceMatch is a stored sequence identical to the query.
+type sequenceMatch struct {
+	store.Sequence
+
+	// Ambiguous is set when it only matches thanks to ambiguity codes in it
+	// or the query
+	Ambiguous bool `json:"ambiguous,omitempty"`
+}
+
+type matchResponse struct {
+	Matches []sequenceMatch `json:"matches"`
+}
+
+// ambiguousCandidates is how many sequences the k-mer index is asked for
+// to compare with a query base by base when matching ambiguously.
+const ambiguousCandidates = 50
+
+// apiSequenceMatchHandler finds the stored sequences identical to the
+// query. An exact match is just a lookup of the query's hash, but a hash
+// can't see that acgn and acgt could be the same sequence, so with
+// ambiguous set the k-mer index's candidates are compared base by base too.
+func apiSequenceMatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "matching requires POST")
+		return
+	}
+
+	req := matchRequest{}
+	limitBody(w, r)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if msg, ok := bodyTooLarge(err); ok {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, msg)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "couldn't parse request body: "+err.Error())
+		return
+	}
+
+	seq, _, err := blast.NormalizeQuery(req.Sequence, cfg().maxQueryLength)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ambiguous := req.Ambiguous && align.IsNucleotide(seq)
+	kmers := activeDB.kmerIndex()
+	if ambiguous && kmers == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "the current db has no k-mer index, which ambiguous matching needs, rebuild it with buildkmers")
+		return
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer redisPool.Put(client)
+
+	resp := &matchResponse{Matches: []sequenceMatch{}}
+	exact, err := seqStore.Sequence(client, seqStore.Hash(seq), true)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if exact != nil {
+		resp.Matches = append(resp.Matches, sequenceMatch{Sequence: *exact})
+	}
+
+	if ambiguous {
+		// ambiguity codes break the k-mers they're in, so the candidates
+		// can share a lot less of the query than an exact match would
+		for _, m := range kmers.Screen(seq, 0.5, ambiguousCandidates) {
+			if exact != nil && m.SeqHash == exact.Hash {
+				continue
+			}
+
+			candidate, err := seqStore.Sequence(client, m.SeqHash, false)
+			if err != nil {
+				writeInternalError(w, r, err)
+				return
+			}
+			if candidate != nil && blast.AmbiguousEqual(seq, candidate.Sequence) {
+				resp.Matches = append(resp.Matches, sequenceMatch{Sequence: *candidate, Ambiguous: true})
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// apiRandomSequenceHandler returns a random nucleotide sequence from the
+// db, for trying out searches.
+func apiRandomSequenceHandler(w http.ResponseWriter, r *http.Request) {
+	client, err := redisPool.Get()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer redisPool.Put(client)
+
+	// the dedup set has protein sequences too, which blastn can't search,
+	// so try a few times to find a nucleotide one
+	for i := 0; i < 10; i++ {
+		resp := client.Cmd("SRANDMEMBER", seqStore.Keys.Dedup)
+		if resp.IsType(redis.Nil) {
+			break
+		}
+
+		hash, err := resp.Str()
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+
+		seq, err := seqStore.Sequence(client, hash, false)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if seq != nil {
+			writeJSON(w, http.StatusOK, seq)
+			return
+		}
+	}
+
+	writeAPIError(w, http.StatusNotFound, "couldn't find a nucleotide sequence, the db may be empty")
+}
+
+// reparseJobs parses the raw output of every job kept in s made by an
+// older parser again, keeping everything about the job that didn't come
+// from blast's output. It returns the number of jobs upgraded, and the
+// number whose output couldn't be parsed, which are logged and left as they
+// were.
+func reparseJobs(s *jobstore.Store) (upgraded, skipped int, err error) {
+	jobs, err := jobqueue.Load(s)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, j := range jobs {
+		if j.Results == nil || j.Results.ParserVersion >= blast.ParserVersion {
+			continue
+		}
+
+		raw, err := s.OpenRaw(j.ID)
+		if os.IsNotExist(err) {
+			slog.Warn("job has no raw output to re-parse, skipping", "job", j.ID)
+			continue
+		} else if err != nil {
+			return upgraded, skipped, err
+		}
+
+		// the clusters may have changed since, but it's the current
+		// db the components are looked up against too, unless an older
+		// version was searched
+		var members map[string][]string
+		if j.Options.Clustered {
+			_, members = activeDB.clustered()
+		}
+		// jobs stored before RawTruncated have only their results' say
+		truncated := j.RawTruncated || j.Results.Truncated
+		results, err := parseResults(raw, members, j.Results, truncated)
+		raw.Close()
+		if err != nil {
+			slog.Warn("couldn't re-parse job, skipping", "job", j.ID, "truncated", truncated, "err", err)
+			skipped++
+			continue
+		}
+
+		if j.Options.Circular {
+			results.UnwrapCircular(len(align.Residues(j.Query)))
+		}
+		results.Query = j.Results.Query
+		results.Input = j.Results.Input
+		results.Timings = j.Results.Timings
+		results.Manifest = j.Results.Manifest
+		results.NumResults = len(results.Results)
+
+		err = filterResults(results, j.Description, j.Roles, j.Containment)
+		if err != nil {
+			return upgraded, skipped, err
+		}
+
+		slog.Info("upgrading job", "job", j.ID, "from", j.Results.ParserVersion, "to", blast.ParserVersion)
+		j.Results = results
+
+		err = s.Save(j.ID, j)
+		if err != nil {
+			return upgraded, skipped, err
+		}
+		upgraded++
+	}
+
+	return upgraded, skipped, nil
+}
+
+func apiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := getStats()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func apiJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "submitting a job requires POST")
+		return
+	}
+
+	req := jobRequest{}
+	limitBody(w, r)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if msg, ok := bodyTooLarge(err); ok {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, msg)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "couldn't parse request body: "+err.Error())
+		return
+	}
+
+	err = req.validate(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	j, err := req.submit(r.Context(), jobs)
+	if err == jobqueue.ErrQueueFull || err == jobqueue.ErrShuttingDown {
+		writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", sitePath("/api/v1/jobs/"+j.ID))
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+func apiJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/jobs/")
+	id, action := path.Split(id)
+	if id != "" {
+		// /api/v1/jobs/{id}/{action}
+		id = strings.TrimSuffix(id, "/")
+	} else {
+		id, action = action, ""
+	}
+
+	j, ok := jobs.Get(id)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "no job with id "+id)
+		return
+	}
+
+	switch action {
+	case "":
+	case "rerun":
+		apiRerunHandler(w, r, j)
+		return
+	case "diff":
+		apiDiffHandler(w, r, j)
+		return
+	default:
+		writeAPIError(w, http.StatusNotFound, "jobs have no "+action)
+		return
+	}
+
+	if format := r.URL.Query().Get("format"); format != "" && format != "json" {
+		if j.Results == nil {
+			writeAPIError(w, http.StatusConflict, "job "+id+" has no results yet")
+			return
+		}
+
+		writeResults(w, r, j.Results)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, j)
+}
+
+// apiRerunHandler queues a job's query again, to be diffed against the
+// original once it's done.
+func apiRerunHandler(w http.ResponseWriter, r *http.Request, j jobqueue.Job) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "re-running a job requires POST")
+		return
+	}
+
+	req := jobRequest{
+		searchRequest: searchRequest{
+			Sequence:    j.Query,
+			Description: j.Description,
+			Roles:       j.Roles,
+			Containment: j.Containment,
+			Options:     j.Options,
+		},
+		rerunOf: j.ID,
+	}
+	if j.Input != nil {
+		req.MaxRecords = j.Input.Records
+	}
+	// re-runs are against the current db, whatever version the job searched
+	req.DBVersion = ""
+	// the server may have dropped an aligner since
+	err := req.validate(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusConflict, "job "+j.ID+" can't be re-run: "+err.Error())
+		return
+	}
+	// validate only sees the normalized query
+	req.input = j.Input
+
+	rerun, err := req.submit(r.Context(), jobs)
+	if err == jobqueue.ErrQueueFull || err == jobqueue.ErrShuttingDown {
+		writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", sitePath("/api/v1/jobs/"+rerun.ID))
+	writeJSON(w, http.StatusAccepted, rerun)
+}
+
+// hitChange is a hit found by both searches of a diff, but with a
+// different alignment.
+type hitChange struct {
+	SeqHash string         `json:"seqHash"`
+	URIs    []string       `json:"uris"`
+	Before  blast.HitStats `json:"before"`
+	After   blast.HitStats `json:"after"`
+}
+
+// resultsDiff is how the hits of a query changed between two searches,
+// usually of different versions of the db.
+type resultsDiff struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	FromDB string `json:"fromDb"`
+	ToDB   string `json:"toDb"`
+
+	New       []blast.Hit `json:"new"`
+	Gone      []blast.Hit `json:"gone"`
+	Changed   []hitChange `json:"changed"`
+	Unchanged int         `json:"unchanged"`
+}
+
+// diffResults compares the hits of two searches of the same query by
+// sequence. E-values are left out of the comparison, since they change
+// with the size of the db even when the alignment doesn't.
+func diffResults(from, to *blast.Results) *resultsDiff {
+	diff := &resultsDiff{
+		FromDB:  from.DB,
+		ToDB:    to.DB,
+		New:     []blast.Hit{},
+		Gone:    []blast.Hit{},
+		Changed: []hitChange{},
+	}
+
+	before := map[string]blast.Hit{}
+	for _, hit := range from.Results {
+		before[hit.SeqHash] = hit
+	}
+
+	for _, hit := range to.Results {
+		old, ok := before[hit.SeqHash]
+		if !ok {
+			diff.New = append(diff.New, hit)
+			continue
+		}
+		delete(before, hit.SeqHash)
+
+		oldStats, newStats := old.HitStats, hit.HitStats
+		oldStats.EValue, newStats.EValue = 0, 0
+		if oldStats == newStats {
+			diff.Unchanged++
+			continue
+		}
+
+		diff.Changed = append(diff.Changed, hitChange{
+			SeqHash: hit.SeqHash,
+			URIs:    hit.URIs,
+			Before:  old.HitStats,
+			After:   hit.HitStats,
+		})
+	}
+
+	// in the order they were ranked in before
+	for _, hit := range from.Results {
+		if _, ok := before[hit.SeqHash]; ok {
+			diff.Gone = append(diff.Gone, hit)
+		}
+	}
+
+	return diff
+}
+
+// apiDiffHandler compares a job's results with those of another job of the
+// same query: ?against={id}, or the job it re-ran if that's left out.
+func apiDiffHandler(w http.ResponseWriter, r *http.Request, j jobqueue.Job) {
+	againstID := r.URL.Query().Get("against")
+	if againstID == "" {
+		againstID = j.RerunOf
+	}
+	if againstID == "" {
+		writeAPIError(w, http.StatusBadRequest, "job "+j.ID+" isn't a re-run, say which job to diff it against with ?against=")
+		return
+	}
+
+	against, ok := jobs.Get(againstID)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "no job with id "+againstID)
+		return
+	}
+
+	for _, other := range []jobqueue.Job{against, j} {
+		if other.Results == nil {
+			writeAPIError(w, http.StatusConflict, "job "+other.ID+" has no results yet")
+			return
+		}
+	}
+	if against.Query != j.Query {
+		writeAPIError(w, http.StatusBadRequest, "jobs "+againstID+" and "+j.ID+" searched different queries")
+		return
+	}
+
+	diff := diffResults(against.Results, j.Results)
+	diff.From, diff.To = against.ID, j.ID
+
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// savedSearchRequest is the body accepted by POST /api/v1/saved-searches.
+type savedSearchRequest struct {
+	searchRequest
+
+	MinIdentity float64 `json:"minIdentity,omitempty"`
+	Email       string  `json:"email,omitempty"`
+	Webhook     string  `json:"webhook,omitempty"`
+	Digest      bool    `json:"digest,omitempty"`
+}
+
+func (r *savedSearchRequest) validate(ctx context.Context) error {
+	err := r.searchRequest.validate(ctx)
+	if err != nil {
+		return err
+	}
+	if r.Description != "" || len(r.Roles) > 0 || len(r.Containment) > 0 {
+		return errors.New("saved searches can't filter by description, role or containment")
+	}
+	if r.input.Records > 1 {
+		return errors.New("saved searches can only search one FASTA record")
+	}
+	if r.Subject != "" {
+		return errors.New("saved searches look for new sequences in the db, they can't have a subject")
+	}
+	if r.DBVersion != "" {
+		return errors.New("saved searches look for new sequences in the current db, they can't search an older version")
+	}
+
+	if r.MinIdentity < 0 || r.MinIdentity > 100 {
+		return errors.New("minIdentity must be a percentage between 0 and 100")
+	}
+
+	if r.Email == "" && r.Webhook == "" {
+		return errors.New("an email address or webhook to alert is required")
+	}
+	if r.Email != "" {
+		r.Email, err = validEmail(r.Email)
+		if err != nil {
+			return err
+		}
+	}
+	if r.Digest && r.Email == "" {
+		return errors.New("digest needs an email address to send it to")
+	}
+	if r.Webhook != "" {
+		err = webhooks.Validate(ctx, r.Webhook)
+		if err != nil {
+			return fmt.Errorf("webhook %v", err)
+		}
+	}
+
+	return nil
+}
+
+// savedSearches is where saved searches are kept, under
+// -redis.savedSearches.
+var savedSearches savedsearch.Store
+
+// runSavedSearch searches the current db and alerts about any new hits.
+func runSavedSearch(ctx context.Context, s *savedsearch.Search) error {
+	ctx = logging.With(ctx, "savedSearch", s.ID)
+
+	err := blastSlots.Acquire(ctx, queryWeight(s.Options))
+	if err != nil {
+		return err
+	}
+	_, version := activeDB.get()
+	results, err := alignQuery(ctx, s.Query, s.Options)
+	blastSlots.Release(queryWeight(s.Options))
+	if err != nil {
+		return err
+	}
+
+	if alert := s.Record(results, version); alert != nil {
+		logging.From(ctx).Info("saved search has new hits", "hits", len(alert.Hits))
+
+		if s.Webhook != "" {
+			err = webhooks.Post(ctx, s.Webhook, alert)
+			if err != nil {
+				logging.From(ctx).Error("couldn't post saved search alert", "err", err)
+			}
+		}
+		if s.Email != "" && s.Digest {
+			err = queueDigest(s.Email, *alert)
+			if err != nil {
+				logging.From(ctx).Error("couldn't queue saved search alert for digest", "err", err)
+			}
+		} else if s.Email != "" {
+			err = sendEmail(s.Email, emailAlert, *alert)
+			if err != nil {
+				logging.From(ctx).Error("couldn't email saved search alert", "err", err)
+			}
+		}
+	}
+
+	return savedSearches.Update(redisPool, s)
+}
+
+// savedSearchesRunning keeps a db swap that happens during a long re-run
+// from starting a second one.
+var savedSearchesRunning sync.Mutex
+
+// rerunSavedSearches runs every saved search that hasn't been run against
+// the current db yet.
+func rerunSavedSearches() {
+	savedSearchesRunning.Lock()
+	defer savedSearchesRunning.Unlock()
+
+	ids, err := savedSearches.IDs(redisPool)
+	if err != nil {
+		slog.Error("couldn't list saved searches", "err", err)
+		return
+	}
+
+	for _, id := range ids {
+		s, err := savedSearches.Get(redisPool, id)
+		if err != nil {
+			slog.Error("couldn't load saved search", "savedSearch", id, "err", err)
+			continue
+		}
+		if _, version := activeDB.get(); s == nil || s.DBVersion == version {
+			continue
+		}
+
+		err = runSavedSearch(context.Background(), s)
+		if err != nil {
+			slog.Error("couldn't re-run saved search", "savedSearch", id, "err", err)
+		}
+	}
+}
+
+func apiSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "saving a search requires POST")
+		return
+	}
+
+	req := savedSearchRequest{}
+	limitBody(w, r)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if msg, ok := bodyTooLarge(err); ok {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, msg)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "couldn't parse request body: "+err.Error())
+		return
+	}
+
+	err = req.validate(r.Context())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	s := &savedsearch.Search{
+		ID:          id,
+		Query:       req.Sequence,
+		Input:       req.input,
+		Options:     req.Options,
+		Created:     time.Now(),
+		MinIdentity: req.MinIdentity,
+		Email:       req.Email,
+		Webhook:     req.Webhook,
+		Digest:      req.Digest,
+	}
+	err = savedSearches.Put(redisPool, s)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if s.Email != "" {
+		go confirmEmail(s.Email)
+	}
+
+	// the first run records what's already there, so only hits that show up
+	// in later dbs are alerted about
+	go func() {
+		err := runSavedSearch(context.Background(), s)
+		if err != nil {
+			slog.Error("couldn't run new saved search", "savedSearch", s.ID, "err", err)
+		}
+	}()
+
+	w.Header().Set("Location", sitePath("/api/v1/saved-searches/"+id))
+	writeJSON(w, http.StatusCreated, s)
+}
+
+func apiSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/saved-searches/")
+
+	s, err := savedSearches.Get(redisPool, id)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if s == nil {
+		writeAPIError(w, http.StatusNotFound, "no saved search with id "+id)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s)
+
+	case http.MethodDelete:
+		err = savedSearches.Delete(redisPool, id)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeAPIError(w, http.StatusMethodNotAllowed, "saved searches can only be read or deleted")
+	}
+}
+
+// SynBioHub visualization plugins answer /status, /evaluate and /run, see
+// https://wiki.synbiohub.org/plugins/. Ours shows the parts similar to the
+// one being viewed.
+
+// pluginRequest is what SynBioHub posts to /evaluate and /run. The sbol and
+// genbank fields are URLs to fetch the part from.
+type pluginRequest struct {
+	CompleteSBOL string `json:"complete_sbol"`
+	ShallowSBOL  string `json:"shallow_sbol"`
+	Genbank      string `json:"genbank"`
+	TopLevel     string `json:"top_level"`
+	InstanceURL  string `json:"instanceUrl"`
+	Size         int    `json:"size"`
+	Type         string `json:"type"`
+}
+
+// onPluginInstance reports whether u is on one of the SynBioHub instances
+// allowed to use the plugin, so it can't be used to make us fetch arbitrary
+// URLs.
+func onPluginInstance(u string) bool {
+	for _, instance := range strings.Split(cfg().pluginInstances, ",") {
+		instance = strings.TrimSpace(instance)
+		if instance != "" && strings.HasPrefix(u, strings.TrimRight(instance, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pluginClient only follows redirects to the instances the plugin may
+// fetch from, or an allowed one could point it anywhere.
+var pluginClient = &http.Client{
+	Timeout: time.Minute,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if !onPluginInstance(req.URL.String()) {
+			return fmt.Errorf("redirected to %s, which isn't on an instance in -plugin.instances", req.URL)
+		}
+
+		return nil
+	},
+}
+
+// fetchGenbank downloads the part the plugin was asked to run on.
+func fetchGenbank(ctx context.Context, u string) (string, error) {
+	if !onPluginInstance(u) {
+		return "", fmt.Errorf("%s isn't on an instance in -plugin.instances", u)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := pluginClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, cfg().maxBodyBytes))
+	return string(b), err
+}
+
+func pluginStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg().pluginInstances == "" {
+		http.Error(w, "the plugin is disabled, set -plugin.instances", http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+func pluginEvaluateHandler(w http.ResponseWriter, r *http.Request) {
+	req := pluginRequest{}
+	limitBody(w, r)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "couldn't parse request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if cfg().pluginInstances == "" {
+		http.Error(w, "the plugin is disabled, set -plugin.instances", http.StatusServiceUnavailable)
+		return
+	}
+
+	// only parts have sequences to search with
+	switch req.Type {
+	case "ComponentDefinition", "Component":
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "only parts can be searched for similar ones", http.StatusUnsupportedMediaType)
+	}
+}
+
+// pluginResults is what plugin.html renders.
+type pluginResults struct {
+	Hits            []pluginHit
+	URIsUnavailable bool
+}
+
+type pluginHit struct {
+	blast.Hit
+	Components []pluginComponent
+}
+
+// pluginComponent is a similar component, as seen from the instance the
+// plugin is running on.
+type pluginComponent struct {
+	URI  string
+	Link string
+
+	// Instance is the host of the SynBioHub instance the component is on,
+	// if it's not the one the plugin is running on
+	Instance string
+}
+
+func pluginRunHandler(w http.ResponseWriter, r *http.Request) {
+	req := pluginRequest{}
+	limitBody(w, r)
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "couldn't parse request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	genbank, err := fetchGenbank(r.Context(), req.Genbank)
+	if err != nil {
+		logging.From(r.Context()).Error("plugin couldn't get part", "uri", req.TopLevel, "err", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	search := searchRequest{Sequence: genbank}
+	err = search.validate(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !blastSlots.TryAcquire(queryWeight(search.Options)) {
+		tooBusy(w)
+		http.Error(w, "The server is busy with other queries, please try again shortly.", http.StatusTooManyRequests)
+		return
+	}
+	defer blastSlots.Release(queryWeight(search.Options))
+
+	results, err := alignQuery(r.Context(), search.Sequence, search.Options)
+	if err != nil {
+		logging.From(r.Context()).Error("plugin search failed", "uri", req.TopLevel, "err", err, "stderr", results.Stderr())
+		status, msg := searchFailure(err)
+		http.Error(w, msg, status)
+		return
+	}
+
+	// the part itself is always the best hit, leave it out
+	page := pluginResults{URIsUnavailable: results.URIsUnavailable}
+	for _, hit := range results.Results {
+		components := []pluginComponent{}
+		for _, uri := range hit.URIs {
+			if uri == req.TopLevel {
+				continue
+			}
+
+			source := hit.Sources[uri]
+			switch {
+			case source == "", source == store.ExternalSource:
+				components = append(components, pluginComponent{URI: uri, Link: rewriteURI(uri)})
+			case sameInstance(source, req.InstanceURL):
+				// linked through the address the instance's users reach it at
+				components = append(components, pluginComponent{URI: uri, Link: componentLink(uri, req.InstanceURL)})
+			case !isPrivateInstance(source):
+				host := source
+				if u, err := url.Parse(source); err == nil {
+					host = u.Host
+				}
+				components = append(components, pluginComponent{URI: uri, Link: componentLink(uri, source), Instance: host})
+			}
+		}
+		if len(components) == 0 {
+			continue
+		}
+
+		page.Hits = append(page.Hits, pluginHit{Hit: hit, Components: components})
+		if len(page.Hits) == cfg().pluginMaxHits {
+			break
+		}
+	}
+
+	renderPage(w, "plugin.html", page)
+}
+
+// grpcServer implements rpc.SearchServer on top of the job queue.
+type grpcServer struct {
+	queue *jobqueue.Queue
+}
+
+func (s grpcServer) Submit(ctx context.Context, req *rpc.SubmitRequest) (*rpc.SubmitResponse, error) {
+	search := jobRequest{
+		searchRequest: searchRequest{
+			Sequence:    req.Sequence,
+			Description: req.Description,
+			Roles:       req.Roles,
+			Containment: req.Containment,
+			MaxRecords:  req.MaxRecords,
+			Options: align.Options{
+				Threads:   req.Threads,
+				Aligner:   req.Aligner,
+				Circular:  req.Circular,
+				Clustered: req.Clustered,
+				Subject:   req.Subject,
+				DBVersion: req.DBVersion,
+				Scoring: align.Scoring{
+					Matrix:    req.Matrix,
+					GapOpen:   req.GapOpen,
+					GapExtend: req.GapExtend,
+				},
+			},
+		},
+		Callback: req.Callback,
+		Email:    req.Email,
+	}
+
+	err := search.validate(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	j, err := search.submit(ctx, s.queue)
+	if err == jobqueue.ErrQueueFull {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	} else if err == jobqueue.ErrShuttingDown {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &rpc.SubmitResponse{JobID: j.ID}, nil
+}
+
+func (s grpcServer) GetResult(ctx context.Context, req *rpc.GetResultRequest) (*rpc.Job, error) {
+	j, ok := s.queue.Get(req.JobID)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no job with id %s", req.JobID)
+	}
+
+	return rpcJob(j), nil
+}
+
+func (s grpcServer) StreamHits(req *rpc.StreamHitsRequest, stream rpc.Search_StreamHitsServer) error {
+	j, ok := s.queue.Get(req.JobID)
+	if !ok {
+		return status.Errorf(codes.NotFound, "no job with id %s", req.JobID)
+	}
+
+	j, err := s.queue.Wait(stream.Context(), j.ID)
+	if err != nil {
+		return status.FromContextError(err).Err()
+	}
+
+	if j.Status == jobqueue.Failed {
+		return status.Error(codes.Internal, j.Error)
+	}
+
+	for _, hit := range rpcJob(j).Hits {
+		err = stream.Send(hit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func rpcJob(j jobqueue.Job) *rpc.Job {
+	out := &rpc.Job{
+		ID:        j.ID,
+		Status:    string(j.Status),
+		Query:     j.Query,
+		Error:     j.Error,
+		Submitted: j.Submitted,
+		Finished:  j.Finished,
+	}
+
+	if j.Results == nil {
+		return out
+	}
+
+	out.Duration = j.Results.Timings.Total
+	for _, r := range j.Results.Results {
+		out.Hits = append(out.Hits, &rpc.Hit{
+			SeqHash:  r.SeqHash,
+			BitScore: r.BitScore,
+			Score:    r.Score,
+			EValue:   r.EValue,
+			QuerySeq: r.QuerySeq,
+			Midline:  r.Midline,
+			HitSeq:   r.HitSeq,
+			URIs:     r.URIs,
+
+			Strand:          r.Strand,
+			SimilarityClass: r.SimilarityClass,
+		})
+	}
+
+	return out
+}
+
+var jobs *jobqueue.Queue
+
+// jobStore is where finished jobs are kept, nil if they're only kept in
+// memory
+var jobStore *jobstore.Store
+
+// redisPool hands each request a Redis connection of its own, as a radix
+// client's pipeline can't be shared between goroutines. Connections that
+// fail are closed rather than put back.
+var redisPool *pool.Pool
+
+// seqStore is where the slurper keeps components, read through redisPool
+var seqStore *store.Store
+
+// liveConfig is the reloadable flags' values, parsed. Requests read it
+// through cfg rather than reading the flags, which reloadConfig sets as it
+// goes, so each sees one whole config however a reload races it.
+type liveConfig struct {
+	maxQueryLength  int
+	maxQueryRecords int
+	maxBodyBytes    int64
+	maxHits         int
+	blastTimeout    time.Duration
+	pluginMaxHits   int
+	pluginInstances string
+	maxCursorLag    time.Duration
+	blastDBName     string
+	templatesDir    string
+	templates       *template.Template
+	similarityTiers []similarityTier
+	rewriteRules    []rewriteRule
+}
+
+var live atomic.Pointer[liveConfig]
+
+// cfg returns the config as last loaded or reloaded.
+func cfg() *liveConfig {
+	return live.Load()
+}
+
+// newLiveConfig parses the reloadable flags as they are now. In -dev mode
+// broken templates are shown on the page instead, so they're left nil.
+func newLiveConfig() (*liveConfig, error) {
+	c := &liveConfig{
+		maxQueryLength:  *maxQueryLength,
+		maxQueryRecords: *maxQueryRecords,
+		maxBodyBytes:    *maxBodyBytes,
+		maxHits:         *maxHits,
+		blastTimeout:    *config.BlastTimeout,
+		pluginMaxHits:   *pluginMaxHits,
+		pluginInstances: *pluginInstances,
+		maxCursorLag:    *config.MaxCursorLag,
+		blastDBName:     *config.BlastDBName,
+		templatesDir:    *templatesDir,
+		rewriteRules:    []rewriteRule{},
+	}
+
+	var err error
+	if *rewriteRulesFile != "" {
+		c.rewriteRules, err = loadRewriteRules(*rewriteRulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load uri rewrite rules: %v", err)
+		}
+	}
+
+	c.similarityTiers, err = parseSimilarityTiers(*similarityTiersFlag)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse -results.similarityTiers: %v", err)
+	}
+
+	c.templates, err = parseTemplates(assetsDirFS(c.templatesDir))
+	if err != nil && !*devMode {
+		return nil, fmt.Errorf("couldn't parse templates: %v", err)
+	}
+
+	return c, nil
+}
+
+// reloadable are the flags that are re-read from -flagfile on SIGHUP, along
+// with whatever has to happen for a new value to take effect once it's in
+// cfg. The rest are only read at startup, so changing them needs a restart.
+var reloadable = map[string]func() error{
+	"query.maxLength":         nil,
+	"query.maxRecords":        nil,
+	"http.maxBodyBytes":       nil,
+	"blast.maxHits":           nil,
+	"blast.timeout":           nil,
+	"plugin.maxHits":          nil,
+	"plugin.instances":        nil,
+	"freshness.maxLag":        nil,
+	"templates.dir":           nil,
+	"results.similarityTiers": nil,
+	"uris.rewriteRules":       nil,
+	"log.level":               logging.Reload,
+	"blastdb.name": func() error {
+		return activeDB.reload()
+	},
+}
+
+// reloadMu keeps the flags from being read while reloadConfig sets them.
+var reloadMu sync.RWMutex
+
+// lastReload is when the config was last reloaded, and how it went.
+var lastReload struct {
+	sync.Mutex
+	At    time.Time
+	Error string
+}
+
+// sameFlagValue reports whether setting f to value wouldn't change it, e.g.
+// 10m for a duration that's 10m0s.
+func sameFlagValue(f *flag.Flag, value string) bool {
+	if f.Value.String() == value {
+		return true
+	}
+
+	v, ok := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+	if !ok || v.Set(value) != nil {
+		return false
+	}
+
+	return v.String() == f.Value.String()
+}
+
+// reloadConfig re-reads -config.file and -flagfile and applies the
+// reloadable flags that changed in them. Queries that are already running
+// carry on as they were.
+func reloadConfig() (err error) {
+	defer func() {
+		lastReload.Lock()
+		lastReload.At = time.Now()
+		lastReload.Error = ""
+		if err != nil {
+			lastReload.Error = err.Error()
+		}
+		lastReload.Unlock()
+	}()
+
+	values, err := config.Values()
+	if err != nil {
+		return err
+	}
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	failed := []string{}
+	for name, v := range values {
+		f := flag.Lookup(name)
+		if sameFlagValue(f, v.Value) {
+			continue
+		}
+
+		apply, ok := reloadable[name]
+		if !ok {
+			slog.Warn("flag changed, but only takes effect after a restart", "flag", name)
+			continue
+		}
+
+		old, prev := f.Value.String(), cfg()
+		err := f.Value.Set(v.Value)
+		var next *liveConfig
+		if err == nil {
+			next, err = newLiveConfig()
+		}
+		if err == nil {
+			live.Store(next)
+			if apply != nil {
+				err = apply()
+			}
+		}
+		if err != nil {
+			slog.Error("couldn't reload flag, keeping the old value", "flag", name, "err", err)
+			f.Value.Set(old)
+			live.Store(prev)
+			failed = append(failed, name)
+			continue
+		}
+
+		logArgs := []any{"flag", name, "source", v.Source}
+		if !config.IsSecret(name) {
+			logArgs = append(logArgs, "from", old, "to", f.Value.String())
+		}
+		slog.Info("reloaded flag", logArgs...)
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("couldn't reload %s", strings.Join(failed, ", "))
+	}
+
+	slog.Info("reloaded config")
+	return nil
+}
+
+type configFlag struct {
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	Default    string `json:"default"`
+	Source     string `json:"source"`
+	Reloadable bool   `json:"reloadable"`
+}
+
+type effectiveConfig struct {
+	Flags       []configFlag `json:"flags"`
+	LastReload  *time.Time   `json:"lastReload,omitempty"`
+	ReloadError string       `json:"reloadError,omitempty"`
+}
+
+// adminOnly lets through requests bearing -admin.token. The /admin
+// endpoints don't exist at all unless it's set.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !hmac.Equal([]byte(token), []byte(*adminToken)) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "a valid -admin.token is required")
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// adminConfigHandler shows the flags the server is running with, once
+// reloads have been applied.
+func adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	c := effectiveConfig{Flags: []configFlag{}}
+	reloadMu.RLock()
+	flag.VisitAll(func(f *flag.Flag) {
+		_, ok := reloadable[f.Name]
+		value := f.Value.String()
+		if config.IsSecret(f.Name) && value != "" {
+			value = "(secret)"
+		}
+
+		c.Flags = append(c.Flags, configFlag{
+			Name:       f.Name,
+			Value:      value,
+			Default:    f.DefValue,
+			Source:     config.Source(f.Name),
+			Reloadable: ok,
+		})
+	})
+	reloadMu.RUnlock()
+
+	lastReload.Lock()
+	if !lastReload.At.IsZero() {
+		at := lastReload.At
+		c.LastReload = &at
+		c.ReloadError = lastReload.Error
+	}
+	lastReload.Unlock()
+
+	writeJSON(w, http.StatusOK, c)
+}
+
+// activeJobs are the jobs that haven't finished yet.
+type activeJobs struct {
+	Running []jobqueue.Job `json:"running"`
+	Queued  []jobqueue.Job `json:"queued"`
+}
+
+// adminJobsHandler lists the running jobs and the queue, in the order the
+// queued jobs will run.
+func adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	running, queued := jobs.Active()
+	writeJSON(w, http.StatusOK, activeJobs{Running: running, Queued: queued})
+}
+
+// adminJobHandler cancels a job with POST /admin/jobs/{id}/cancel, or moves
+// it in the queue with POST /admin/jobs/{id}/priority and a body like
+// {"priority": 10}.
+func adminJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "changing a job requires POST")
+		return
+	}
+
+	id, action := path.Split(strings.TrimPrefix(r.URL.Path, "/admin/jobs/"))
+	id = strings.TrimSuffix(id, "/")
+	if _, ok := jobs.Get(id); !ok {
+		writeAPIError(w, http.StatusNotFound, "no job with id "+id)
+		return
+	}
+
+	var j jobqueue.Job
+	var err error
+	switch action {
+	case "cancel":
+		j, err = jobs.Cancel(id)
+	case "priority":
+		req := struct {
+			Priority *int `json:"priority"`
+		}{}
+		limitBody(w, r)
+		err = json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req.Priority == nil {
+			writeAPIError(w, http.StatusBadRequest, "the body must be like {\"priority\": 10}")
+			return
+		}
+
+		j, err = jobs.SetPriority(id, *req.Priority)
+	default:
+		writeAPIError(w, http.StatusNotFound, "jobs can't be "+action)
+		return
+	}
+	if err == jobqueue.ErrFinished || err == jobqueue.ErrNotQueued {
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	logging.From(r.Context()).Info("admin changed job", "job", id, "action", action)
+	writeJSON(w, http.StatusOK, j)
+}
+
+// adminDrainHandler cancels every queued job, e.g. when someone's flooded
+// the queue. Running jobs are left to finish.
+func adminDrainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "draining the queue requires POST")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"cancelled": jobs.Drain()})
+}
+
+// configRules catch bad flags at startup rather than on the first query
+// they break.
+var configRules = config.Rules{
+	"blastdb.path":           config.All(config.Required, config.Dir),
+	"blastdb.pollInterval":   config.Positive,
+	"fastas.path":            config.Dir,
+	"sequences.hash":         config.OneOf("sha1", "sha256"),
+	"redis.url":              config.All(config.Required, config.HostPort),
+	"redis.poolSize":         config.Positive,
+	"templates.dir":          config.Dir,
+	"uris.rewriteRules":      config.File,
+	"uris.privateInstances":  config.URLs,
+	"uris.cacheSize":         config.NonNegative,
+	"uris.cacheTTL":          config.Positive,
+	"tls.cert":               config.File,
+	"tls.key":                config.File,
+	"blast.maxHits":          config.NonNegative,
+	"blast.timeout":          config.Positive,
+	"blast.maxCPUs":          config.Positive,
+	"blast.threads":          config.NonNegative,
+	"blast.maxMemoryMB":      config.NonNegative,
+	"blast.queryMemoryMB":    config.Positive,
+	"blast.retryAfter":       config.Positive,
+	"query.maxLength":        config.NonNegative,
+	"query.maxRecords":       config.Between(1, 1000),
+	"http.maxBodyBytes":      config.Positive,
+	"http.maxHeaderBytes":    config.Positive,
+	"http.readHeaderTimeout": config.NonNegative,
+	"http.readTimeout":       config.NonNegative,
+	"http.writeTimeout":      config.NonNegative,
+	"http.idleTimeout":       config.NonNegative,
+	"jobs.workers":           config.Positive,
+	"jobs.queueSize":         config.NonNegative,
+	"jobs.interactiveWeight": config.Positive,
+	"jobs.ttl":               config.NonNegative,
+	"jobs.maxBytes":          config.NonNegative,
+	"jobs.cleanInterval":     config.Positive,
+	"metrics.port":           config.Port,
+	"workers.leaseTTL":       config.Positive,
+	"port":                   config.All(config.Required, config.Between(1, 65535)),
+	"grpc.port":              config.Port,
+	"autocert.httpPort":      config.Port,
+	"smtp.addr":              config.HostPort,
+	"orfs.minCodons":         config.NonNegative,
+	"subject.maxSequences":   config.NonNegative,
+	"subject.maxLength":      config.NonNegative,
+	"rebuild.command":        config.File,
+	"email.siteURL":          config.URL,
+	"webhooks.siteURL":       config.URL,
+	"email.digestInterval":   config.Positive,
+	"email.confirmInterval":  config.Positive,
+	"plugin.instances":       config.URLs,
+	"plugin.maxHits":         config.Positive,
+	"vsearch.minIdentity":    config.Between(0, 1),
+	"annotate.minIdentity":   config.Between(0, 100),
+	"annotate.maxOverlap":    config.Between(0, 1),
+	"tracing.endpoint":       config.HostPort,
+	"tracing.sampleRatio":    config.Between(0, 1),
+	"accessLog.maxSize":      config.Positive,
+	"accessLog.rotateEvery":  config.NonNegative,
+	"accessLog.maxBackups":   config.NonNegative,
+	"freshness.maxLag":       config.NonNegative,
+	"shutdown.drainTimeout":  config.NonNegative,
+}
+
+func main() {
+	config.Command("admin", runAdmin)
+	config.Command("bench", runBench)
+	loadErr := config.Load()
+	err := logging.Setup()
+	if err != nil {
+		logging.Fatal("couldn't set up logging", "err", err)
+	}
+	if loadErr != nil {
+		logging.Fatal("couldn't load config", "err", loadErr)
+	}
+	// -demo points the redis and db flags at what it stands up
+	if *demoMode {
+		demoDB, err = setupDemo()
+		if err != nil {
+			logging.Fatal("couldn't set up -demo", "err", err)
+		}
+	}
+	err = config.Validate(configRules)
+	if err != nil {
+		logging.Fatal("invalid config", "err", err)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		logging.Fatal("couldn't set up tracing", "endpoint", *tracingEndpoint, "err", err)
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		logging.Fatal("-tls.cert and -tls.key have to be set together")
+	}
+	if *tlsCert != "" && *autocertDomain != "" {
+		logging.Fatal("-tls.cert and -autocert.domain can't both be set")
+	}
+
+	if *smtpAddr != "" && *emailSiteURL == "" {
+		logging.Fatal("-smtp.addr needs -email.siteURL for the links addresses confirm they want emails with")
+	}
+	if *webhookSecret != "" && *webhookSiteURL == "" {
+		logging.Fatal("-webhooks.secret needs -webhooks.siteURL for the links in job callbacks")
+	}
+	webhooks = webhook.Sender{Secret: *webhookSecret}
+	savedSearches = savedsearch.Store{Key: *redisSavedSearchKey}
+
+	trustedProxies, err = parseTrustedProxies(*trustedProxiesFlag)
+	if err != nil {
+		logging.Fatal("couldn't parse -http.trustedProxies", "err", err)
+	}
+	*basePath = strings.TrimSuffix(*basePath, "/")
+	if *basePath != "" && !strings.HasPrefix(*basePath, "/") {
+		*basePath = "/" + *basePath
+	}
+
+	if *accessLogPath != "" {
+		accessLog, err = openAccessLog()
+		if err != nil {
+			logging.Fatal("couldn't open access log", "path", *accessLogPath, "err", err)
+		}
+	}
+
+	if *devMode && *templatesDir == "" {
+		*templatesDir = "."
+	}
+	c, err := newLiveConfig()
+	if err != nil {
+		logging.Fatal("invalid config", "err", err)
+	}
+	live.Store(c)
+
+	seqStore = config.Store()
+	cachedURIs = newURICache(*uriCacheSize, *uriCacheTTL)
+	redisPool, err = pool.New("tcp", *config.RedisURL, *redisPoolSize)
+	if err != nil {
+		logging.Fatal("couldn't dial redis", "url", *config.RedisURL, "err", err)
+	}
+	client, err := redisPool.Get()
+	if err == nil {
+		err = seqStore.CheckHasher(client)
+		redisPool.Put(client)
+	}
+	if err != nil {
+		logging.Fatal("can't serve the store with -sequences.hash", "hash", *config.SequenceHash, "err", err)
+	}
+
+	if *jobDir != "" {
+		err = os.MkdirAll(*jobDir, 0755)
+		if err != nil {
+			logging.Fatal("couldn't create job dir", "dir", *jobDir, "err", err)
+		}
+		jobStore = &jobstore.Store{Dir: *jobDir}
+	}
+
+	if *jobReparse {
+		if jobStore == nil {
+			logging.Fatal("-jobs.reparse needs -jobs.dir")
+		}
+
+		n, skipped, err := reparseJobs(jobStore)
+		if err != nil {
+			logging.Fatal("re-parsing jobs failed", "upgraded", n, "skipped", skipped, "err", err)
+		}
+		slog.Info("upgraded jobs", "jobs", n, "skipped", skipped)
+		return
+	}
+
+	if *remoteWorkers {
+		workers, err = newWorkerPool()
+		if err != nil {
+			logging.Fatal("couldn't dial redis for the worker pool", "err", err)
+		}
+		go func() {
+			logging.Fatal("worker pool stopped", "err", workers.run())
+		}()
+
+		blastVersion = "run by workers"
+		readinessChecks = workerReadinessChecks
+		slog.Info("sending blast queries to workers")
+	} else if *demoMode {
+		aligners["blastn"] = demoAligner{}
+		blastVersion = "demo"
+		readinessChecks = demoReadinessChecks
+		slog.Warn("running searches in-process for -demo, results are only a rough stand-in for blastn's")
+	} else {
+		blastnPath, blastVersion, err = blast.Find(*config.BlastBinary)
+		if err != nil {
+			logging.Fatal("blastn isn't usable, set -blast.binary to a working BLAST+ install", "binary", *config.BlastBinary, "err", err)
+		}
+		slog.Info("using blastn", "version", blastVersion, "path", blastnPath, "outfmt", outputArgs(blastVersion)[1])
+	}
+
+	if *makeblastdbBinary != "" {
+		makeblastdbPath, err = findMakeblastdb(*makeblastdbBinary)
+		if err != nil {
+			logging.Fatal("makeblastdb isn't usable, fix -makeblastdb.binary or leave it empty to disable subject searches", "binary", *makeblastdbBinary, "err", err)
+		}
+		if blastnPath == "" {
+			slog.Warn("subject searches need blastn here, not just on the workers, so they're disabled")
+		}
+	}
+
+	if *diamondBinary != "" {
+		diamondPath, diamondVersion, err = align.FindDiamond(*diamondBinary)
+		if err != nil {
+			logging.Fatal("diamond isn't usable, fix -diamond.binary or leave it empty to disable protein searches", "binary", *diamondBinary, "err", err)
+		}
+		aligners["diamond"] = diamondAligner{}
+		slog.Info("running protein searches with diamond", "version", diamondVersion, "path", diamondPath)
+	}
+
+	if *vsearchBinary != "" {
+		vsearchPath, vsearchVersion, err = align.FindVsearch(*vsearchBinary)
+		if err != nil {
+			logging.Fatal("vsearch isn't usable, fix -vsearch.binary or leave it empty to disable it", "binary", *vsearchBinary, "err", err)
+		}
+		aligners["vsearch"] = vsearchAligner{}
+		slog.Info("offering vsearch", "version", vsearchVersion, "path", vsearchPath)
+	}
+
+	activeDB.onSwap = func() {
+		// a new db comes after another round of slurping
+		cachedURIs.purge()
+		rerunSavedSearches()
+	}
+	err = activeDB.reload()
+	if err != nil {
+		slog.Error("couldn't find the blast db", "err", err)
+	}
+	go activeDB.watch(*blastdbPoll)
+
+	blastSlots = semaphore.NewWeighted(blastCapacity())
+	slog.Info("running blast queries", "concurrency", blastCapacity())
+
+	// catch up on any db built while the server was down
+	go rerunSavedSearches()
+
+	if *smtpAddr != "" {
+		go sendDigests()
+	}
+
+	jobs, err = jobqueue.New(jobqueue.Config{
+		Workers:           *jobWorkers,
+		Size:              *jobQueueSize,
+		InteractiveWeight: *interactiveWeight,
+		Store:             jobStore,
+		Run:               runJob,
+		Failure:           searchFailure,
+		Finished:          jobFinished,
+	})
+	if err != nil {
+		logging.Fatal("couldn't load jobs", "err", err)
+	}
+	go janitor(*jobCleanInterval)
+
+	if *metricsPort != 0 {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			slog.Info("serving metrics", "port", *metricsPort)
+			err := http.ListenAndServe(fmt.Sprintf(":%d", *metricsPort), mux)
+			logging.Fatal("metrics server stopped", "err", err)
+		}()
+	}
+
+	http.HandleFunc("/", indexHandler)
+	http.HandleFunc("/blast/", blastHandler)
+	http.Handle("/static/", http.StripPrefix("/static/", http.HandlerFunc(staticHandler)))
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/api/openapi.json", openapiHandler)
+	http.HandleFunc("/api/v1/search", apiSearchHandler)
+	http.HandleFunc("/api/v1/screen", apiScreenHandler)
+	http.HandleFunc("/api/v1/annotate", apiAnnotateHandler)
+	http.HandleFunc("/api/v1/stats", apiStatsHandler)
+	http.HandleFunc("/api/v1/parts", apiPartsHandler)
+	http.HandleFunc("/api/v1/feed", apiFeedHandler)
+	http.HandleFunc("/api/v1/sequences", uploadOnly(apiUploadHandler))
+	http.HandleFunc("/api/v1/sequences/", apiSequenceHandler)
+	http.HandleFunc("/api/v1/sequences/match", apiSequenceMatchHandler)
+	http.HandleFunc("/api/v1/random-sequence", apiRandomSequenceHandler)
+	http.HandleFunc("/feed.atom", atomFeedHandler)
+	http.HandleFunc("/api/v1/jobs", apiJobsHandler)
+	http.HandleFunc("/api/v1/jobs/", apiJobHandler)
+	http.HandleFunc("/api/v1/saved-searches", apiSavedSearchesHandler)
+	http.HandleFunc("/api/v1/saved-searches/", apiSavedSearchHandler)
+	http.HandleFunc("/email/unsubscribe", emailUnsubscribeHandler)
+	http.HandleFunc("/email/confirm", emailConfirmHandler)
+	http.HandleFunc("/plugin/status", pluginStatusHandler)
+	http.HandleFunc("/plugin/evaluate", pluginEvaluateHandler)
+	http.HandleFunc("/plugin/run", pluginRunHandler)
+	http.HandleFunc("/admin/config", adminOnly(adminConfigHandler))
+	http.HandleFunc("/admin/jobs", adminOnly(adminJobsHandler))
+	http.HandleFunc("/admin/jobs/", adminOnly(adminJobHandler))
+	http.HandleFunc("/admin/queue/drain", adminOnly(adminDrainHandler))
+	http.HandleFunc("/admin/rebuild", adminOnly(adminRebuildHandler))
+	http.HandleFunc("/admin/rebuild/status", adminOnly(adminRebuildStatusHandler))
+
+	var grpcSrv *grpc.Server
+	if *grpcPort != 0 {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *grpcPort))
+		if err != nil {
+			logging.Fatal("couldn't listen for grpc", "port", *grpcPort, "err", err)
+		}
+
+		grpcSrv = grpc.NewServer(grpc.MaxRecvMsgSize(int(cfg().maxBodyBytes)))
+		rpc.RegisterSearchServer(grpcSrv, grpcServer{jobs})
+		go func() {
+			logging.Fatal("grpc server stopped", "err", grpcSrv.Serve(lis))
+		}()
+	}
+
+	srv := newHTTPServer(fmt.Sprintf(":%d", *port), otelhttp.NewHandler(logRequests(compressResponses(stripBasePath(http.DefaultServeMux))), "http"))
+	go func() {
+		err := listen(srv)
+		if err != http.ErrServerClosed {
+			logging.Fatal("http server stopped", "err", err)
+		}
+	}()
+
+	err = sdnotify.Notify(sdnotify.Ready)
+	if err != nil {
+		slog.Warn("couldn't tell systemd we're ready", "err", err)
+	}
+	if interval := sdnotify.WatchdogInterval(); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				sdnotify.Notify(sdnotify.Watchdog)
+			}
+		}()
+	}
+
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			err := reloadConfig()
+			if err != nil {
+				slog.Error("couldn't reload config", "err", err)
+			}
+		}
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-sigs
+
+	slog.Info("draining queries", "signal", sig.String(), "timeout", *drainTimeout)
+	sdnotify.Notify(sdnotify.Stopping)
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		slog.Error("couldn't shut down http server", "err", err)
+	}
+
+	err = jobs.Shutdown(ctx)
+	if err != nil {
+		slog.Error("gave up waiting for jobs to finish", "err", err)
+	}
+
+	// grpc last, since StreamHits calls are waiting on the jobs above
+	if grpcSrv != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcSrv.Stop()
+		}
+	}
+
+	err = shutdownTracing(ctx)
+	if err != nil {
+		slog.Error("couldn't send the last traces", "err", err)
+	}
+
+	if demoDB != nil {
+		demoDB.close()
+	}
+
+	slog.Info("shut down")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/jobqueue"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.168.1.1,,::ffff:172.16.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old []netip.Prefix) { trustedProxies = old }(trustedProxies)
+	trustedProxies = proxies
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		ip        string
+	}{
+		{"direct", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"forged", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"proxied", "10.1.2.3:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"through two proxies", "192.168.1.1:1234", []string{"198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"forged behind a proxy", "10.1.2.3:1234", []string{"6.6.6.6, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", "10.1.2.3:1234", []string{"198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		{"only proxies", "10.1.2.3:1234", []string{"172.16.0.1"}, "172.16.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = test.remote
+			for _, v := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if ip := clientIP(r); ip != test.ip {
+				t.Errorf("clientIP = %s, want %s", ip, test.ip)
+			}
+		})
+	}
+
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("parseTrustedProxies of a bad prefix = nil error, want one")
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"gzip", true},
+		{"deflate, GZIP", true},
+		{"br;q=1.0, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"deflate", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if ok := acceptsEncoding(test.header, "gzip"); ok != test.ok {
+			t.Errorf("acceptsEncoding(%q, gzip) = %v, want %v", test.header, ok, test.ok)
+		}
+	}
+}
+
+func TestParseSimilarityTiers(t *testing.T) {
+	tiers, err := parseSimilarityTiers("80:similar, 99:identical,95:near-identical:#9e9d24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []similarityTier{
+		{99, "identical", tierColors[0]},
+		{95, "near-identical", "#9e9d24"},
+		{80, "similar", tierColors[2]},
+	}
+	if !reflect.DeepEqual(tiers, want) {
+		t.Errorf("parseSimilarityTiers = %+v, want %+v", tiers, want)
+	}
+
+	for _, bad := range []string{"99", "99:", "101:too-much", "lots:identical"} {
+		if _, err := parseSimilarityTiers(bad); err == nil {
+			t.Errorf("parseSimilarityTiers(%q) = nil error, want one", bad)
+		}
+	}
+}
+
+func TestSearchFailure(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{fmt.Errorf("blastn: %w", blast.ErrTimeout), http.StatusGatewayTimeout},
+		{blast.ErrDBUnavailable, http.StatusServiceUnavailable},
+		{jobqueue.ErrShuttingDown, http.StatusServiceUnavailable},
+		{jobqueue.ErrCancelled, http.StatusConflict},
+		{errors.New("exit status 2: /var/synbioblast/blastdbs/SynBioHub.nsq"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		status, msg := searchFailure(test.err)
+		if status != test.status {
+			t.Errorf("searchFailure(%v) = %d, want %d", test.err, status, test.status)
+		}
+		if status == http.StatusInternalServerError && msg == test.err.Error() {
+			t.Errorf("searchFailure(%v) shows the error as is", test.err)
+		}
+	}
+}
+
+func TestReverseComplement(t *testing.T) {
+	tests := map[string]string{
+		"acgt":   "acgt",
+		"AAC-GN": "NC-GTT",
+		"acgu":   "acgt",
+	}
+
+	for s, want := range tests {
+		if got := reverseComplement(s); got != want {
+			t.Errorf("reverseComplement(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestDiffResults(t *testing.T) {
+	hit := func(hash string, score float64, evalue float64) blast.Hit {
+		return blast.Hit{SeqHash: hash, HitStats: blast.HitStats{BitScore: score, EValue: evalue}}
+	}
+	from := &blast.Results{DB: "v1", Results: []blast.Hit{hit("same", 10, 1e-5), hit("changed", 10, 0), hit("gone", 5, 0)}}
+	to := &blast.Results{DB: "v2", Results: []blast.Hit{hit("new", 20, 0), hit("same", 10, 1e-6), hit("changed", 12, 0)}}
+
+	diff := diffResults(from, to)
+	if diff.FromDB != "v1" || diff.ToDB != "v2" || diff.Unchanged != 1 {
+		t.Errorf("diff from %s to %s with %d unchanged, want v1 to v2 with 1", diff.FromDB, diff.ToDB, diff.Unchanged)
+	}
+	if len(diff.New) != 1 || diff.New[0].SeqHash != "new" {
+		t.Errorf("new hits %+v, want just new", diff.New)
+	}
+	if len(diff.Gone) != 1 || diff.Gone[0].SeqHash != "gone" {
+		t.Errorf("gone hits %+v, want just gone", diff.Gone)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].SeqHash != "changed" || diff.Changed[0].Before.BitScore != 10 || diff.Changed[0].After.BitScore != 12 {
+		t.Errorf("changed hits %+v, want changed from 10 to 12", diff.Changed)
+	}
+}
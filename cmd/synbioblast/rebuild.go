@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var rebuildCommand = flag.String("rebuild.command", "",
+	"builddb.sh, run in its own directory with the server's environment on POST /admin/rebuild, which is disabled if empty")
+
+// rebuildOutputLines is how much of a rebuild's output its status keeps.
+const rebuildOutputLines = 500
+
+// stepDone matches the line builddb.sh prints after each step.
+var stepDone = regexp.MustCompile(`^Step (\S+) done in (\d+)s$`)
+
+// rebuildPhase is a step of builddb.sh that's finished, and how many
+// seconds it took.
+type rebuildPhase struct {
+	Name string `json:"name"`
+	Took int    `json:"took"`
+}
+
+// rebuildStatus is what's shown by GET /admin/rebuild/status.
+type rebuildStatus struct {
+	// State is idle, running, succeeded or failed
+	State    string         `json:"state"`
+	Started  *time.Time     `json:"started,omitempty"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Phases   []rebuildPhase `json:"phases"`
+	Error    string         `json:"error,omitempty"`
+
+	// Output is the end of what builddb.sh printed
+	Output []string `json:"output"`
+}
+
+// rebuildEvent is sent to everyone following a rebuild: a line of output,
+// a phase finishing, or the rebuild ending.
+type rebuildEvent struct {
+	name string
+	data interface{}
+}
+
+// rebuilder runs builddb.sh for admins, one at a time.
+type rebuilder struct {
+	mu        sync.Mutex
+	status    rebuildStatus
+	followers map[chan rebuildEvent]bool
+}
+
+var rebuilds = &rebuilder{
+	status:    rebuildStatus{State: "idle", Phases: []rebuildPhase{}, Output: []string{}},
+	followers: map[chan rebuildEvent]bool{},
+}
+
+var errRebuilding = errors.New("a rebuild is already running")
+
+// start runs -rebuild.command in the background, unless it's already
+// running.
+func (b *rebuilder) start() (rebuildStatus, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.status.State == "running" {
+		return b.copyStatus(), errRebuilding
+	}
+
+	cmd := exec.Command(*rebuildCommand)
+	cmd.Dir = filepath.Dir(*rebuildCommand)
+	out, w := io.Pipe()
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	now := time.Now()
+	b.status = rebuildStatus{State: "running", Started: &now, Phases: []rebuildPhase{}, Output: []string{}}
+	err := cmd.Start()
+	if err != nil {
+		b.finish(err)
+		return b.copyStatus(), nil
+	}
+
+	slog.Info("rebuilding the blast db", "command", *rebuildCommand)
+	read := make(chan struct{})
+	go func() {
+		b.read(out)
+		close(read)
+	}()
+	go func() {
+		err := cmd.Wait()
+		w.Close()
+		<-read
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.finish(err)
+	}()
+
+	return b.copyStatus(), nil
+}
+
+// read records builddb.sh's output as it's printed.
+func (b *rebuilder) read(out io.Reader) {
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		b.mu.Lock()
+		b.status.Output = append(b.status.Output, line)
+		if len(b.status.Output) > rebuildOutputLines {
+			b.status.Output = b.status.Output[len(b.status.Output)-rebuildOutputLines:]
+		}
+		b.send(rebuildEvent{"line", line})
+
+		if m := stepDone.FindStringSubmatch(line); m != nil {
+			seconds, _ := strconv.Atoi(m[2])
+			phase := rebuildPhase{Name: m[1], Took: seconds}
+			b.status.Phases = append(b.status.Phases, phase)
+			b.send(rebuildEvent{"phase", phase})
+		}
+		b.mu.Unlock()
+	}
+
+	// builddb.sh would block writing the rest if it wasn't drained, say
+	// after a line too long to scan
+	if err := scanner.Err(); err != nil {
+		slog.Warn("couldn't read the rest of the rebuild's output", "err", err)
+	}
+	io.Copy(io.Discard, out)
+}
+
+// finish records how the rebuild ended and tells its followers. b.mu must
+// be held.
+func (b *rebuilder) finish(err error) {
+	now := time.Now()
+	b.status.Finished = &now
+	if err != nil {
+		b.status.State = "failed"
+		b.status.Error = err.Error()
+		slog.Error("rebuilding the blast db failed", "err", err)
+	} else {
+		b.status.State = "succeeded"
+		slog.Info("rebuilt the blast db", "took", now.Sub(*b.status.Started))
+	}
+
+	b.send(rebuildEvent{"done", b.copyStatus()})
+	for c := range b.followers {
+		close(c)
+		delete(b.followers, c)
+	}
+}
+
+// send passes an event to the followers. Ones that can't keep up miss it
+// rather than holding up the rebuild. b.mu must be held.
+func (b *rebuilder) send(e rebuildEvent) {
+	for c := range b.followers {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// copyStatus returns a copy of the status that's safe to use without b.mu,
+// which must be held.
+func (b *rebuilder) copyStatus() rebuildStatus {
+	s := b.status
+	s.Phases = append([]rebuildPhase{}, s.Phases...)
+	s.Output = append([]string{}, s.Output...)
+	return s
+}
+
+// follow returns the status and, if a rebuild is running, a channel of its
+// events that's closed when it ends.
+func (b *rebuilder) follow() (rebuildStatus, chan rebuildEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.status.State != "running" {
+		return b.copyStatus(), nil
+	}
+
+	c := make(chan rebuildEvent, 100)
+	b.followers[c] = true
+	return b.copyStatus(), c
+}
+
+func (b *rebuilder) unfollow(c chan rebuildEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.followers, c)
+}
+
+// adminRebuildHandler starts building a new db with POST /admin/rebuild,
+// e.g. after a bulk import, rather than waiting for cron. The server
+// switches to it the usual way, within -blastdb.pollInterval of it being
+// built.
+func adminRebuildHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "rebuilding requires POST")
+		return
+	}
+	if *rebuildCommand == "" {
+		writeAPIError(w, http.StatusNotFound, "rebuilding is disabled, set -rebuild.command to builddb.sh")
+		return
+	}
+
+	status, err := rebuilds.start()
+	if err == errRebuilding {
+		writeAPIError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", sitePath("/admin/rebuild/status"))
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// adminRebuildStatusHandler shows how the last rebuild went. With
+// Accept: text/event-stream it follows a running rebuild, sending the
+// status, then "line" and "phase" events as builddb.sh prints them, and a
+// "done" event with the final status when it ends.
+func adminRebuildStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Accept") != "text/event-stream" {
+		rebuilds.mu.Lock()
+		status := rebuilds.copyStatus()
+		rebuilds.mu.Unlock()
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+
+	status, events := rebuilds.follow()
+	if events != nil {
+		defer rebuilds.unfollow(events)
+	}
+
+	// a rebuild takes longer than -http.writeTimeout allows
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(e rebuildEvent) error {
+		b, err := json.Marshal(e.data)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, b)
+		if err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if events == nil {
+		write(rebuildEvent{"done", status})
+		return
+	}
+	err := write(rebuildEvent{"status", status})
+	for err == nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			err = write(e)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
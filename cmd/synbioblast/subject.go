@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+var (
+	makeblastdbBinary = flag.String("makeblastdb.binary", "",
+		"path to the makeblastdb executable, needed to search sequences sent with the query instead of the db, which is disabled if empty")
+	maxSubjectSequences = flag.Int("subject.maxSequences", 100, "most sequences a query can be searched against instead of the db, no limit if 0")
+	maxSubjectLength    = flag.Int("subject.maxLength", 100000,
+		"most residues, all together, of the sequences a query can be searched against instead of the db, no limit if 0")
+)
+
+var makeblastdbPath string
+
+// findMakeblastdb is blast.Find for makeblastdb.
+func findMakeblastdb(binary string) (string, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return "", err
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "-version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	if !strings.HasPrefix(string(out), "makeblastdb: ") {
+		return "", fmt.Errorf("unexpected output from %s -version: %q", path, strings.SplitN(string(out), "\n", 2)[0])
+	}
+
+	return path, nil
+}
+
+// subjectSequences checks the sequences the query o is for is to be
+// searched against instead of the db, and returns them.
+func subjectSequences(o align.Options) ([]blast.Subject, error) {
+	// workers only have the main db, so subjects are searched here
+	if makeblastdbPath == "" || blastnPath == "" {
+		return nil, errors.New("this server can only search its db, it can't search sequences sent with the query")
+	}
+	if o.AlignerName() != "blastn" {
+		return nil, errors.New("subject sequences can only be searched with blastn")
+	}
+	if o.Clustered {
+		return nil, errors.New("subject sequences aren't clustered, so they can't be searched by cluster")
+	}
+
+	return blast.ParseSubjects(o.Subject, *maxSubjectSequences, *maxSubjectLength)
+}
+
+// blastSubjects searches the sequences sent with the query instead of the
+// db, in a blast db made for the query and removed after it.
+func blastSubjects(ctx context.Context, seq string, opts align.Options) (*blast.Results, error) {
+	start := time.Now()
+
+	subjects, err := subjectSequences(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := tracer.Start(ctx, "makeblastdb")
+	db, err := blast.MakeSubjectDB(ctx, makeblastdbPath, subjects)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Remove()
+
+	args := append(append([]string{"-db", db.Name}, blastArgs(opts)...), outputArgs(blastVersion)...)
+	results, err := blast.Search{
+		Binary:  blastnPath,
+		DBDir:   db.Dir,
+		Args:    args,
+		Timeout: cfg().blastTimeout,
+		MaxHits: cfg().maxHits,
+		Raw:     opts.Raw,
+	}.Run(ctx, seq)
+	if err != nil {
+		return results, err
+	}
+	results.NameSubjects(len(subjects))
+
+	return finishBlast(ctx, results, seq, args, start), nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/logging"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/sequence"
+	"github.com/schnauzer/synbioblast/pkg/slurp"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+var uploadToken = flag.String("upload.token", "",
+	"bearer token for POST /api/v1/sequences, which adds sequences to the index without going through SynBioHub, disabled if empty")
+
+var uploadedComponents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "synbioblast_uploaded_components_total",
+	Help: "Components uploaded through the API, by whether they were new or already stored.",
+}, []string{"outcome"})
+
+func init() {
+	prometheus.MustRegister(uploadedComponents)
+}
+
+// uploadRequest is the JSON body accepted by POST /api/v1/sequences, see
+// openapi.json. SBOL documents are accepted too.
+type uploadRequest struct {
+	URI         string `json:"uri"`
+	Sequence    string `json:"sequence"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Role        string `json:"role,omitempty"`
+	Protein     bool   `json:"protein,omitempty"`
+}
+
+// component checks the request is something the slurper would have stored,
+// and returns the component it describes.
+func (r *uploadRequest) component() (store.Component, error) {
+	c := store.Component{
+		URI:         r.URI,
+		Sequence:    r.Sequence,
+		Created:     time.Now(),
+		Title:       strings.TrimSpace(r.Title),
+		Description: strings.TrimSpace(r.Description),
+		Protein:     r.Protein,
+	}
+	if r.Role != "" {
+		c.Roles = []string{r.Role}
+	}
+
+	return c, checkUpload(&c)
+}
+
+// checkUpload checks an uploaded component, from JSON or SBOL, is something
+// the slurper would have stored, and puts its sequence in the form it
+// would have.
+func checkUpload(c *store.Component) error {
+	u, err := url.Parse(c.URI)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("uri must be an absolute URI, like the ones SynBioHub mints")
+	}
+	for _, role := range c.Roles {
+		if !strings.HasPrefix(role, "http://identifiers.org/so/") {
+			return errors.New("role must be a Sequence Ontology term, like http://identifiers.org/so/SO:0000167")
+		}
+	}
+
+	// the same stripping and checks as a query, just without the length
+	// limit
+	seq, input, err := blast.NormalizeQuery(c.Sequence, 0)
+	if err != nil {
+		return err
+	}
+	if c.Protein {
+		if input.RNA {
+			return errors.New("the sequence is RNA, it can't be protein")
+		}
+		seq, _ = sequence.Normalize(seq, true)
+	}
+	c.Sequence = seq
+	c.RNA = c.RNA || input.RNA
+
+	return nil
+}
+
+// uploadedComponent is how storing an uploaded component went.
+type uploadedComponent struct {
+	URI  string `json:"uri"`
+	Hash string `json:"hash"`
+
+	// Added is unset if the component was already stored
+	Added bool `json:"added"`
+}
+
+type uploadResponse struct {
+	Components []uploadedComponent `json:"components"`
+}
+
+// uploadOnly lets through requests bearing -upload.token or -admin.token.
+// Uploading is disabled unless -upload.token is set.
+func uploadOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if *uploadToken == "" {
+			writeAPIError(w, http.StatusNotFound, "uploading is disabled on this server")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !(validToken(token, *uploadToken) || validToken(token, *adminToken)) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "a valid -upload.token is required")
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// validToken reports whether token is want, which is never valid if it's
+// unset.
+func validToken(token, want string) bool {
+	return want != "" && hmac.Equal([]byte(token), []byte(want))
+}
+
+// apiUploadHandler adds components straight to the index, as JSON or an
+// SBOL document, without going through SynBioHub. They're stored the way
+// the slurper stores them, ORFs and all, with store.ExternalSource as their
+// source, and are searchable once the next db is built.
+func apiUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, "uploading a sequence requires POST")
+		return
+	}
+
+	limitBody(w, r)
+	body, err := io.ReadAll(r.Body)
+	if msg, ok := bodyTooLarge(err); ok {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, msg)
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "couldn't read request body: "+err.Error())
+		return
+	}
+
+	var components []store.Component
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/rdf+xml", "application/xml", "text/xml":
+		components, err = slurp.ParseSBOL(bytes.NewReader(body))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for i := range components {
+			err = checkUpload(&components[i])
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("%s: %v", components[i].URI, err))
+				return
+			}
+			if components[i].Created.IsZero() {
+				components[i].Created = time.Now()
+			}
+		}
+
+	default:
+		req := uploadRequest{}
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "couldn't parse request body: "+err.Error())
+			return
+		}
+		c, err := req.component()
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		components = []store.Component{c}
+	}
+
+	client, err := redisPool.Get()
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	defer redisPool.Put(client)
+
+	// components slurped from SynBioHub are its to change
+	uris := []string{}
+	for _, c := range components {
+		uris = append(uris, c.URI)
+	}
+	sources, err := seqStore.Sources(client, [][]string{uris})
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	for _, uri := range uris {
+		if source, ok := sources[0][uri]; ok && source != store.ExternalSource {
+			writeAPIError(w, http.StatusConflict, fmt.Sprintf("%s was slurped from %s, it can only be changed there", uri, source))
+			return
+		}
+	}
+
+	resp := uploadResponse{Components: []uploadedComponent{}}
+	for i := range components {
+		added, err := addExternal(client, &components[i])
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+
+		resp.Components = append(resp.Components, uploadedComponent{
+			URI:   components[i].URI,
+			Hash:  seqStore.Hash(components[i].Sequence),
+			Added: added,
+		})
+	}
+
+	logging.From(r.Context()).Info("components uploaded", "components", len(resp.Components))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// addExternal stores an uploaded component like the slurper does one it's
+// fetched.
+func addExternal(client *redis.Client, c *store.Component) (bool, error) {
+	c.Source = store.ExternalSource
+
+	_, _, err := seqStore.WriteFasta(c)
+	if err != nil {
+		return false, fmt.Errorf("couldn't write fasta: %v", err)
+	}
+	added, err := seqStore.Add(client, c)
+	if err != nil {
+		return false, err
+	}
+
+	if !c.Protein && *config.ORFMinCodons > 0 {
+		for _, orf := range slurp.FindORFs(c.Sequence, *config.ORFMinCodons) {
+			_, _, err = seqStore.WriteFasta(&store.Component{URI: c.URI, Sequence: orf.Protein, Protein: true})
+			if err != nil {
+				return false, fmt.Errorf("couldn't write orf fasta: %v", err)
+			}
+			_, err = seqStore.AddORF(client, c.URI, orf.Protein)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	err = seqStore.SyncFastas()
+	if err != nil {
+		return false, fmt.Errorf("couldn't sync fastas: %v", err)
+	}
+
+	if added {
+		uploadedComponents.WithLabelValues("new").Inc()
+		return true, seqStore.RecordUpload(client, 1)
+	}
+	uploadedComponents.WithLabelValues("duplicate").Inc()
+	return false, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+// versionPattern matches the versions builddb.sh gives the dbs it builds.
+var versionPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z$`)
+
+// retainedVersions returns the versions of the db that are on disk other
+// than the current one, oldest first, which builddb.sh keeps KEEP_VERSIONS
+// of. Those without a snapshot of their components are left out, as their
+// hits can't be resolved the way they were.
+func retainedVersions() ([]string, error) {
+	dir := os.ExpandEnv(*config.BlastDBDir)
+	base := cfg().blastDBName
+	_, current := activeDB.get()
+
+	versions := []string{}
+	for _, ext := range []string{".nal", ".nin"} {
+		matches, err := filepath.Glob(path.Join(dir, base+"-*"+ext))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range matches {
+			version := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), base+"-"), ext)
+			if !versionPattern.MatchString(version) || version == current {
+				continue
+			}
+			if _, err := os.Stat(snapshotPath(version)); err != nil {
+				continue
+			}
+			versions = append(versions, version)
+		}
+	}
+
+	// split dbs have both
+	slices.Sort(versions)
+	return slices.Compact(versions), nil
+}
+
+// snapshotPath is where builddb.sh writes the snapshot of version's
+// components, see store.WriteSnapshot.
+func snapshotPath(version string) string {
+	return path.Join(os.ExpandEnv(*config.BlastDBDir), cfg().blastDBName+"-"+version+".uris")
+}
+
+// oldDB returns the name of the older version of the db the query o is for
+// is to be searched against, or "" if it's to be searched against the
+// current one, which naming the current version does too.
+func oldDB(o align.Options) (string, error) {
+	if o.DBVersion == "" {
+		return "", nil
+	}
+	if _, current := activeDB.get(); o.DBVersion == current {
+		return "", nil
+	}
+
+	if !versionPattern.MatchString(o.DBVersion) {
+		return "", fmt.Errorf("dbVersion %q isn't a db version, like 20240102T030405Z", o.DBVersion)
+	}
+	if o.AlignerName() != "blastn" {
+		return "", errors.New("older versions of the db can only be searched with blastn")
+	}
+	if o.Clustered {
+		return "", errors.New("older versions of the db can't be searched by cluster")
+	}
+	if o.Subject != "" {
+		return "", errors.New("a query can be searched against an older version of the db or subject sequences, not both")
+	}
+
+	name := cfg().blastDBName + "-" + o.DBVersion
+	_, err := dbVersion(name)
+	if err != nil {
+		return "", fmt.Errorf("version %s of the db isn't kept on this server", o.DBVersion)
+	}
+	if _, err := os.Stat(snapshotPath(o.DBVersion)); err != nil {
+		return "", fmt.Errorf("version %s of the db has no snapshot of its components, so it can't be searched", o.DBVersion)
+	}
+
+	return name, nil
+}
+
+// maxSnapshots is how many snapshots are kept in memory, each can be
+// as big as the db's components.
+const maxSnapshots = 2
+
+// snapshotCache holds the snapshots of the older versions searched
+// lately, most recent last.
+type snapshotCache struct {
+	mu       sync.Mutex
+	versions []string
+	uris     map[string]map[string][]string
+}
+
+var snapshots = &snapshotCache{uris: map[string]map[string][]string{}}
+
+// get returns the uris of the components using each sequence in version
+// of the db, as they were when it was built, loading its snapshot if it
+// isn't cached. Searches of a version wait for it to load.
+func (c *snapshotCache) get(version string) (map[string][]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, v := range c.versions {
+		if v == version {
+			c.versions = append(append(c.versions[:i:i], c.versions[i+1:]...), version)
+			return c.uris[version], nil
+		}
+	}
+
+	f, err := os.Open(snapshotPath(version))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	uris, err := store.ReadSnapshot(f)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read snapshot of %s: %v", version, err)
+	}
+
+	if len(c.versions) == maxSnapshots {
+		delete(c.uris, c.versions[0])
+		c.versions = c.versions[1:]
+	}
+	c.versions = append(c.versions, version)
+	c.uris[version] = uris
+
+	return uris, nil
+}
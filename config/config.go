@@ -0,0 +1,567 @@
+// Package config holds the flags shared by the synbioblast binaries, and
+// loads every flag from, in increasing order of precedence, a YAML file
+// (-config.file), the -flagfile, SYNBIOBLAST_* environment variables and the
+// command line.
+//
+// Flags only one binary uses are still declared next to the code using them.
+// They're loaded the same way, and each binary checks the ones it cares
+// about with Validate.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/store"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	file     = flag.String("config.file", "", "YAML file to read flags from, overridden by -flagfile, the environment and the command line")
+	flagfile = flag.String("flagfile", "", "file of name=value lines, grouped into [sections], to read flags from, overridden by the environment and the command line")
+
+	BlastDBDir = flag.String("blastdb.path", "/var/synbioblast/blastdbs",
+		"directory where blast dbs are stored")
+	BlastDBName = flag.String("blastdb.name", "SynBioHub", "name of the blast db to use")
+
+	BlastBinary  = flag.String("blast.binary", "./blastn", "path to the blastn executable, looked up in $PATH if it has no slashes")
+	BlastTimeout = flag.Duration("blast.timeout", 10*time.Minute, "max time a single blast query may run before it's killed")
+
+	RedisURL          = flag.String("redis.url", "localhost:6379", "URL of redis instance storing dedup state")
+	RedisDedupSetKey  = flag.String("redis.sequenceHashSet", "sequenceHashSet", "Redis key for set storing all seen sequence hashes")
+	RedisSeqSetPrefix = flag.String("redis.sequencePrefix", "sequence",
+		"Redis key prefix, appended with hash of sequence to store set of matching components")
+	RedisStatsKey   = flag.String("redis.stats", "stats", "Redis key for hash storing slurper statistics shown by the query server")
+	RedisFeedKey    = flag.String("redis.feed", "feed", "Redis key for list of newly ingested components, in ingestion order")
+	RedisRolesKey   = flag.String("redis.roles", "roles", "Redis key for hash storing the sbol:role of each component")
+	RedisSourcesKey = flag.String("redis.sources", "sources", "Redis key for hash storing the SynBioHub instance each component was slurped from")
+	RedisTextPrefix = flag.String("redis.textPrefix", "text",
+		"Redis key prefix for the index of component titles and descriptions")
+	RedisRNAKey    = flag.String("redis.rna", "rna", "Redis key for hash storing which components are RNA, stored with T's for U's")
+	RedisORFPrefix = flag.String("redis.orfPrefix", "orf",
+		"Redis key prefix, appended with hash of a protein to store set of DNA components with an open reading frame translating to it")
+	RedisOffsetKey          = flag.String("redis.sequenceoffset", "sequenceoffset", "Redis key for max offset fetched from synbiohub")
+	RedisLengthsKey         = flag.String("redis.lengths", "lengths", "Redis key for hash storing the length of each sequence hash")
+	RedisGCKey              = flag.String("redis.gc", "gc", "Redis key for hash storing the GC content of each nucleotide sequence hash")
+	RedisLengthHistogramKey = flag.String("redis.lengthHistogram", "lengthHistogram",
+		"Redis key for hash counting the sequences in each range of lengths, shown on the stats page")
+
+	MaxCursorLag = flag.Duration("freshness.maxLag", 0,
+		"how long after the newest component stored was created in SynBioHub the index counts as stale, 0 to never")
+
+	SequenceHash = flag.String("sequences.hash", "sha256",
+		"digest sequences are hashed with to identify them, sha1 or sha256, run synbioblast admin rehash after changing it")
+
+	FastaDir   = flag.String("fastas.path", "/var/synbioblast/fastas", "path to store fasta files in")
+	ProteinDir = flag.String("fastas.proteinPath", "/var/synbioblast/proteins", "path to store protein fasta files in")
+
+	ORFMinCodons = flag.Int("orfs.minCodons", 100,
+		"shortest open reading frame in DNA components to translate into the protein db, so protein searches find the DNA parts encoding them, disabled if 0")
+
+	WorkQueuePrefix = flag.String("redis.workQueuePrefix", "work", "Redis key prefix for the queue of blast queries sent to workers")
+	WorkerLeaseTTL  = flag.Duration("workers.leaseTTL", 30*time.Second,
+		"how long a worker can go without checking in before its query is given to another worker")
+)
+
+// Store returns the sequence store the flags describe, call it after Load.
+func Store() *store.Store {
+	return &store.Store{
+		FastaDir:   *FastaDir,
+		ProteinDir: *ProteinDir,
+		Keys: store.Keys{
+			Dedup:           *RedisDedupSetKey,
+			SeqSetPrefix:    *RedisSeqSetPrefix,
+			Stats:           *RedisStatsKey,
+			Feed:            *RedisFeedKey,
+			Roles:           *RedisRolesKey,
+			Sources:         *RedisSourcesKey,
+			TextPrefix:      *RedisTextPrefix,
+			RNA:             *RedisRNAKey,
+			ORFPrefix:       *RedisORFPrefix,
+			Cursor:          *RedisOffsetKey,
+			Lengths:         *RedisLengthsKey,
+			GC:              *RedisGCKey,
+			LengthHistogram: *RedisLengthHistogramKey,
+		},
+		Hasher: store.Hashers[*SequenceHash],
+	}
+}
+
+// Where a flag's value came from.
+const (
+	FromDefault     = "default"
+	FromYAML        = "yaml"
+	FromFlagfile    = "flagfile"
+	FromEnv         = "env"
+	FromCommandLine = "command line"
+)
+
+// sources are where each flag that isn't at its default was set
+var sources = map[string]string{}
+
+// Value is a flag's value and where it came from.
+type Value struct {
+	Value  string
+	Source string
+}
+
+// commands are the binary's subcommands, besides "config print"
+var commands = map[string]func(args []string) error{}
+
+// Command adds a subcommand to the binary. When it's run with name as its
+// first argument, Load calls run with the rest once the flags are set and
+// exits instead of returning.
+func Command(name string, run func(args []string) error) {
+	commands[name] = run
+}
+
+// Load sets the flags from all of their sources. Run with "config print",
+// the binary prints the resulting config and exits instead of carrying on,
+// and likewise for commands added with Command.
+func Load() error {
+	// parsed before the flagfile is read, to tell what's on the command
+	// line
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		sources[f.Name] = FromCommandLine
+	})
+
+	values, err := Values()
+	if err != nil {
+		return err
+	}
+
+	for name, v := range values {
+		err := flag.Set(name, v.Value)
+		if err != nil {
+			return fmt.Errorf("-%s from %s: %v", name, v.Source, err)
+		}
+		sources[name] = v.Source
+	}
+
+	switch {
+	case flag.NArg() == 0:
+	case flag.NArg() == 2 && flag.Arg(0) == "config" && flag.Arg(1) == "print":
+		err := Print(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case commands[flag.Arg(0)] != nil:
+		err := commands[flag.Arg(0)](flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	default:
+		names := []string{`"config print"`}
+		for name := range commands {
+			names = append(names, strconv.Quote(name))
+		}
+		sort.Strings(names[1:])
+		return fmt.Errorf("unknown command %q, the commands are %s", strings.Join(flag.Args(), " "), strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// Values re-reads the YAML file, flagfile and environment, and returns the
+// value each flag they set should have. Flags given on the command line are
+// left out since those win.
+func Values() (map[string]Value, error) {
+	values := map[string]Value{}
+	set := func(source string, from map[string]string) {
+		for name, value := range from {
+			if flag.Lookup(name) == nil || sources[name] == FromCommandLine {
+				// the files are shared by all the binaries, so they set
+				// flags this one doesn't have
+				continue
+			}
+			values[name] = Value{value, source}
+		}
+	}
+
+	if *file != "" {
+		yamlValues, err := ReadYAML(*file)
+		if err != nil {
+			return nil, err
+		}
+		set(FromYAML, yamlValues)
+	}
+
+	if *flagfile != "" {
+		flagfileValues, err := ReadFlagfile(*flagfile)
+		if err != nil {
+			return nil, err
+		}
+		set(FromFlagfile, flagfileValues)
+	}
+
+	envValues := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if value, ok := os.LookupEnv(EnvVar(f.Name)); ok {
+			envValues[f.Name] = value
+		}
+	})
+	set(FromEnv, envValues)
+
+	return values, nil
+}
+
+// EnvVar is the environment variable that sets a flag, e.g.
+// SYNBIOBLAST_REDIS_URL for -redis.url.
+func EnvVar(name string) string {
+	return "SYNBIOBLAST_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// Source returns where a flag's value came from.
+func Source(name string) string {
+	if source, ok := sources[name]; ok {
+		return source
+	}
+
+	return FromDefault
+}
+
+// IsSecret reports whether a flag holds a password or the like, which
+// shouldn't be shown or logged.
+func IsSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range []string{"password", "secret", "token"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Print writes every flag's value and where it came from, with secrets
+// masked.
+func Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tVALUE\tSOURCE")
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if IsSecret(f.Name) && value != "" {
+			value = "(secret)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Name, strconv.Quote(value), Source(f.Name))
+	})
+
+	return tw.Flush()
+}
+
+// ReadFlagfile reads a flagfile into the flags it sets. Keys are prefixed
+// with the [section] they're in, so path=... under [fastas] sets
+// fastas.path.
+func ReadFlagfile(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s: can't parse %q", name, line)
+			}
+
+			key = strings.TrimSpace(key)
+			if section != "" {
+				key = section + "." + key
+			}
+			values[key] = strings.TrimSpace(value)
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// ReadYAML reads the flags set by a YAML file. Sections nest like in the
+// flagfile, so
+//
+//	redis:
+//	  url: localhost:6379
+//
+// sets redis.url. Lists are joined with commas.
+func ReadYAML(name string) (map[string]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := map[string]any{}
+	err = yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	values := map[string]string{}
+	var flatten func(prefix string, v any)
+	flatten = func(prefix string, v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for key, child := range v {
+				if prefix != "" {
+					key = prefix + "." + key
+				}
+				flatten(key, child)
+			}
+		case []any:
+			items := []string{}
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[prefix] = strings.Join(items, ",")
+		case nil:
+			values[prefix] = ""
+		default:
+			values[prefix] = fmt.Sprint(v)
+		}
+	}
+	flatten("", doc)
+
+	return values, nil
+}
+
+// A Check reports what's wrong with a flag's value. All of them but Required
+// let empty values through, since that's how optional flags are left unset.
+type Check func(value string) error
+
+// Rules are the checks to run on each flag.
+type Rules map[string]Check
+
+// Validate runs rules against the loaded flags, so bad config is caught at
+// startup rather than on the first query that needs it. It reports every
+// problem at once.
+func Validate(rules Rules) error {
+	names := []string{}
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []error{}
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil {
+			errs = append(errs, fmt.Errorf("-%s: no such flag", name))
+			continue
+		}
+
+		err := rules[name](f.Value.String())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("-%s (from %s): %v", name, Source(name), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// All runs each check in turn.
+func All(checks ...Check) Check {
+	return func(value string) error {
+		for _, c := range checks {
+			err := c(value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Required fails for empty values.
+func Required(value string) error {
+	if value == "" {
+		return errors.New("must be set")
+	}
+
+	return nil
+}
+
+// Dir checks the value is an existing directory, after expanding
+// environment variables the way the db paths are.
+func Dir(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	info, err := os.Stat(os.ExpandEnv(value))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s isn't a directory", value)
+	}
+
+	return nil
+}
+
+// File checks the value is an existing file.
+func File(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	info, err := os.Stat(os.ExpandEnv(value))
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", value)
+	}
+
+	return nil
+}
+
+// URL checks the value is an absolute http(s) URL.
+func URL(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q isn't an http or https URL", value)
+	}
+
+	return nil
+}
+
+// URLs checks the value is a comma separated list of URLs.
+func URLs(value string) error {
+	for _, u := range strings.Split(value, ",") {
+		err := URL(strings.TrimSpace(u))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HostPort checks the value is a host:port address, like -redis.url.
+func HostPort(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("bad port %q", port)
+	}
+
+	return nil
+}
+
+// number parses numbers and durations alike, durations in seconds.
+func number(value string) (float64, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d.Seconds(), nil
+	}
+
+	return strconv.ParseFloat(value, 64)
+}
+
+// Positive checks the value is a number or duration above 0.
+func Positive(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	n, err := number(value)
+	if err != nil {
+		return err
+	}
+	if n <= 0 {
+		return fmt.Errorf("must be more than 0, not %s", value)
+	}
+
+	return nil
+}
+
+// NonNegative checks the value is a number or duration of at least 0, for
+// limits where 0 means unlimited.
+func NonNegative(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	n, err := number(value)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("can't be negative, not %s", value)
+	}
+
+	return nil
+}
+
+// OneOf checks the value is one of values.
+func OneOf(values ...string) Check {
+	return func(value string) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s, not %q", strings.Join(values, ", "), value)
+	}
+}
+
+// Between checks the value is a number from min to max.
+func Between(min, max float64) Check {
+	return func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		n, err := number(value)
+		if err != nil {
+			return err
+		}
+		if n < min || n > max {
+			return fmt.Errorf("must be between %v and %v, not %s", min, max, value)
+		}
+
+		return nil
+	}
+}
+
+// Port checks the value is a TCP port, or 0 for disabled.
+func Port(value string) error {
+	return Between(0, 65535)(value)
+}
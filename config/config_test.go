@@ -0,0 +1,190 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/schnauzer/synbioblast/config"
+)
+
+func TestChecks(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	err := os.WriteFile(file, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		check   config.Check
+		value   string
+		wantErr bool
+	}{
+		{"required", config.Required, "x", false},
+		{"required empty", config.Required, "", true},
+		{"dir", config.Dir, dir, false},
+		{"dir is a file", config.Dir, file, true},
+		{"dir missing", config.Dir, filepath.Join(dir, "missing"), true},
+		{"file", config.File, file, false},
+		{"file is a dir", config.File, dir, true},
+		{"url", config.URL, "https://synbiohub.org/sparql", false},
+		{"url without scheme", config.URL, "synbiohub.org", true},
+		{"url not http", config.URL, "ftp://synbiohub.org", true},
+		{"urls", config.URLs, "https://a.org, http://b.org", false},
+		{"urls with a bad one", config.URLs, "https://a.org,b.org", true},
+		{"host port", config.HostPort, "localhost:6379", false},
+		{"host port without port", config.HostPort, "localhost", true},
+		{"host port bad port", config.HostPort, "localhost:99999", true},
+		{"positive", config.Positive, "3", false},
+		{"positive duration", config.Positive, "10s", false},
+		{"positive zero", config.Positive, "0", true},
+		{"positive not a number", config.Positive, "lots", true},
+		{"non-negative zero", config.NonNegative, "0s", false},
+		{"non-negative negative", config.NonNegative, "-1", true},
+		{"one of", config.OneOf("sha1", "sha256"), "sha256", false},
+		{"not one of", config.OneOf("sha1", "sha256"), "md5", true},
+		{"between", config.Between(0, 100), "100", false},
+		{"not between", config.Between(0, 100), "101", true},
+		{"port", config.Port, "8080", false},
+		{"port too high", config.Port, "65536", true},
+		{"all", config.All(config.Required, config.Positive), "1", false},
+		{"all fails first", config.All(config.Required, config.Positive), "", true},
+		{"all fails second", config.All(config.Required, config.Positive), "-1", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.check(test.value)
+			if (err != nil) != test.wantErr {
+				t.Errorf("check(%q) = %v, want error %v", test.value, err, test.wantErr)
+			}
+		})
+	}
+
+	// all but Required let unset flags through
+	for _, check := range []config.Check{config.Dir, config.File, config.URL, config.HostPort, config.Positive, config.NonNegative, config.Port} {
+		if err := check(""); err != nil {
+			t.Errorf("check(\"\") = %v, want nil", err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := config.Validate(config.Rules{
+		"blastdb.name":  config.Required,
+		"redis.url":     config.HostPort,
+		"blast.timeout": config.Positive,
+	})
+	if err != nil {
+		t.Errorf("Validate of the defaults = %v", err)
+	}
+
+	err = config.Validate(config.Rules{
+		"blastdb.name": config.OneOf("other"),
+		"no.such.flag": config.Required,
+	})
+	if err == nil || !strings.Contains(err.Error(), "-blastdb.name (from default)") || !strings.Contains(err.Error(), "-no.such.flag: no such flag") {
+		t.Errorf("Validate = %v, want both problems reported", err)
+	}
+}
+
+func TestReadFlagfile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "synbioblast.conf")
+	err := os.WriteFile(name, []byte(`# comment
+; also a comment
+listen = :8080
+
+[redis]
+url = redis:6379
+
+[fastas]
+path=/data/fastas
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := config.ReadFlagfile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"listen": ":8080", "redis.url": "redis:6379", "fastas.path": "/data/fastas"}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("ReadFlagfile = %v, want %v", values, want)
+	}
+
+	err = os.WriteFile(name, []byte("[redis]\nurl\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.ReadFlagfile(name); err == nil {
+		t.Error("ReadFlagfile of a line without = returned no error")
+	}
+}
+
+func TestReadYAML(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "synbioblast.yaml")
+	err := os.WriteFile(name, []byte(`listen: ":8080"
+redis:
+  url: redis:6379
+blast:
+  timeout: 5m
+plugin:
+  instances:
+    - https://synbiohub.org
+    - https://example.org
+email:
+  from:
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := config.ReadYAML(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"listen":           ":8080",
+		"redis.url":        "redis:6379",
+		"blast.timeout":    "5m",
+		"plugin.instances": "https://synbiohub.org,https://example.org",
+		"email.from":       "",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("ReadYAML = %v, want %v", values, want)
+	}
+}
+
+func TestEnvVar(t *testing.T) {
+	tests := map[string]string{
+		"redis.url":             "SYNBIOBLAST_REDIS_URL",
+		"blastdb.path":          "SYNBIOBLAST_BLASTDB_PATH",
+		"redis.sequence-offset": "SYNBIOBLAST_REDIS_SEQUENCE_OFFSET",
+		"redis.workQueuePrefix": "SYNBIOBLAST_REDIS_WORKQUEUEPREFIX",
+	}
+	for name, want := range tests {
+		if got := config.EnvVar(name); got != want {
+			t.Errorf("EnvVar(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	if !config.IsSecret("webhooks.secret") || !config.IsSecret("smtp.password") || config.IsSecret("redis.url") {
+		t.Error("IsSecret doesn't pick out the secrets")
+	}
+
+	b := &strings.Builder{}
+	err := config.Print(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "redis.url") || !strings.Contains(b.String(), `"localhost:6379"`) {
+		t.Errorf("Print = %s, want -redis.url's default in it", b)
+	}
+}
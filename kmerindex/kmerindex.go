@@ -0,0 +1,156 @@
+// Package kmerindex is a k-mer containment index of the sequences in the
+// blast db, for answering "is this part already in SynBioHub?" in
+// milliseconds instead of running blast.
+//
+// Only a fixed fraction of k-mers is kept (those whose hash falls below
+// 1/Scale of the hash space, FracMinHash style), so the index stays small
+// while the fraction of a query's sampled k-mers found in a sequence still
+// estimates how much of the query that sequence contains.
+package kmerindex
+
+import (
+	"encoding/gob"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Index maps sampled k-mers to the sequences they occur in.
+type Index struct {
+	K     int
+	Scale uint64
+
+	// SeqHashes are the hashes the fastas are named after, Postings refer
+	// to sequences by their index in it
+	SeqHashes []string
+	Postings  map[uint64][]uint32
+}
+
+// Match is a sequence that shares sampled k-mers with a query.
+type Match struct {
+	SeqHash string `json:"seqHash"`
+
+	// Containment is the estimated fraction of the query's k-mers found in
+	// the sequence
+	Containment float64 `json:"containment"`
+	SharedKmers int     `json:"sharedKmers"`
+}
+
+// New returns an empty index of k-mers of length k keeping 1 in scale of
+// them.
+func New(k int, scale uint64) *Index {
+	return &Index{K: k, Scale: scale, Postings: map[uint64][]uint32{}}
+}
+
+var complement = strings.NewReplacer("a", "t", "c", "g", "g", "c", "t", "a")
+
+func reverseComplement(s string) string {
+	b := []byte(complement.Replace(s))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return string(b)
+}
+
+// sample returns the distinct sampled k-mer hashes of seq. Each k-mer is
+// hashed in whichever orientation sorts first so either strand matches.
+func (idx *Index) sample(seq string) []uint64 {
+	seq = strings.ToLower(seq)
+	max := math.MaxUint64 / idx.Scale
+
+	seen := map[uint64]bool{}
+	hashes := []uint64{}
+	for i := 0; i+idx.K <= len(seq); i++ {
+		kmer := seq[i : i+idx.K]
+		if strings.Trim(kmer, "acgt") != "" {
+			// ambiguity codes would never match anything anyway
+			continue
+		}
+		if rc := reverseComplement(kmer); rc < kmer {
+			kmer = rc
+		}
+
+		h := fnv.New64a()
+		io.WriteString(h, kmer)
+		sum := h.Sum64()
+		if sum > max || seen[sum] {
+			continue
+		}
+		seen[sum] = true
+		hashes = append(hashes, sum)
+	}
+
+	return hashes
+}
+
+// Add indexes a sequence under its hash.
+func (idx *Index) Add(seqHash, seq string) {
+	id := uint32(len(idx.SeqHashes))
+	idx.SeqHashes = append(idx.SeqHashes, seqHash)
+
+	for _, h := range idx.sample(seq) {
+		idx.Postings[h] = append(idx.Postings[h], id)
+	}
+}
+
+// Screen returns up to limit sequences estimated to contain at least
+// minContainment of query, best first. Queries shorter than K can't be
+// screened and match nothing.
+func (idx *Index) Screen(query string, minContainment float64, limit int) []Match {
+	hashes := idx.sample(query)
+	if len(hashes) == 0 {
+		return []Match{}
+	}
+
+	shared := map[uint32]int{}
+	for _, h := range hashes {
+		for _, id := range idx.Postings[h] {
+			shared[id]++
+		}
+	}
+
+	matches := []Match{}
+	for id, n := range shared {
+		containment := float64(n) / float64(len(hashes))
+		if containment < minContainment {
+			continue
+		}
+
+		matches = append(matches, Match{
+			SeqHash:     idx.SeqHashes[id],
+			Containment: containment,
+			SharedKmers: n,
+		})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Containment != matches[j].Containment {
+			return matches[i].Containment > matches[j].Containment
+		}
+		return matches[i].SeqHash < matches[j].SeqHash
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches
+}
+
+// Write saves the index.
+func (idx *Index) Write(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(idx)
+}
+
+// Read loads an index saved with Write.
+func Read(r io.Reader) (*Index, error) {
+	idx := &Index{}
+	err := gob.NewDecoder(r).Decode(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
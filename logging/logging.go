@@ -0,0 +1,81 @@
+// Package logging sets up the structured logger shared by the synbioblast
+// binaries, configured with -log.level and -log.format.
+//
+// Everything logs through log/slog. Loggers carrying fields for the request
+// or job being handled are passed around in contexts.
+package logging
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var (
+	level  = flag.String("log.level", "info", "least severe level to log: debug, info, warn or error")
+	format = flag.String("log.format", "text", "log format, text or json")
+
+	// levelVar is -log.level, which Reload can change on the fly
+	levelVar = &slog.LevelVar{}
+)
+
+// Setup makes slog's default logger, and so the log package's, follow
+// -log.level and -log.format. Call it once flags are loaded.
+func Setup() error {
+	err := Reload()
+	if err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: levelVar}
+
+	var h slog.Handler
+	switch *format {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("bad -log.format %q, it must be text or json", *format)
+	}
+
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// Reload applies -log.level again after it's been changed. The format can't
+// be changed once Setup has been called.
+func Reload() error {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(*level))
+	if err != nil {
+		return fmt.Errorf("bad -log.level: %v", err)
+	}
+
+	levelVar.Set(l)
+	return nil
+}
+
+type contextKey struct{}
+
+// With returns a context whose logger has args added to it.
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, contextKey{}, From(ctx).With(args...))
+}
+
+// From returns the logger of ctx, or the default one if it has none.
+func From(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+
+	return slog.Default()
+}
+
+// Fatal logs msg as an error and exits, for errors the binaries can't run
+// without fixing.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
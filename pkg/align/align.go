@@ -0,0 +1,280 @@
+// Package align has the options a query is searched with, and runs the
+// aligners other than blastn, translating their output into blast.Results
+// like pkg/blast does for blastn's.
+//
+// An Aligner runs a query with one kind of search tool. Diamond runs
+// protein searches and Vsearch quick nucleotide ones against the dbs
+// builddb.sh builds next to the blast db:
+//
+//	results, args, err := align.Diamond{
+//		Binary:  "/usr/bin/diamond",
+//		DBDir:   "/var/synbioblast/blastdbs",
+//		DB:      "SynBioHub",
+//		Threads: 4,
+//		Scoring: align.Scoring{Matrix: "BLOSUM62"},
+//		Timeout: time.Minute,
+//	}.Run(ctx, query)
+//
+// Circular searches a plasmid with any Aligner so hits across its origin
+// are found too.
+package align
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+// Options are the per query settings passed through to the aligner.
+type Options struct {
+	// Threads is the aligner's thread count, the server's default if 0
+	Threads int `json:"threads,omitempty"`
+
+	// Aligner is the name of the aligner to run, blastn if empty
+	Aligner string `json:"aligner,omitempty"`
+
+	// Circular searches the query as a circular sequence, e.g. a plasmid,
+	// so hits spanning its origin are found too
+	Circular bool `json:"circular,omitempty"`
+
+	// Clustered searches just the representatives of each cluster of
+	// near-identical sequences, then adds a hit for every other member of
+	// a cluster whose representative was hit. blastn only, and only when
+	// the db was clustered.
+	Clustered bool `json:"clustered,omitempty"`
+
+	// Subject is FASTA of sequences to search instead of the db, like
+	// another construct to compare the query with
+	Subject string `json:"subject,omitempty"`
+
+	// DBVersion searches an older version of the db kept on the server,
+	// e.g. to reproduce a published result, with its hits resolved to the
+	// components it was built with. blastn only.
+	DBVersion string `json:"dbVersion,omitempty"`
+
+	// protein searches only
+	Scoring
+
+	// Raw gets a copy of blastn's output if it's set
+	Raw io.Writer `json:"-"`
+
+	// Annotate tunes blastn for finding whole parts in a plasmid
+	Annotate bool `json:"-"`
+}
+
+// AlignerName is the name of the aligner o asks for.
+func (o Options) AlignerName() string {
+	if o.Aligner == "" {
+		return "blastn"
+	}
+
+	return o.Aligner
+}
+
+// ProteinMatrices are the scoring matrices blastp accepts, along with the
+// {gap open, gap extend} costs it supports for each one. Anything else makes
+// blastp bail out after we've already spawned it, so we check up front.
+var ProteinMatrices = map[string][][2]int{
+	"BLOSUM45": {{13, 3}, {12, 3}, {11, 3}, {10, 3}, {16, 2}, {15, 2}, {14, 2}, {13, 2}, {12, 2}, {19, 1}, {18, 1}, {17, 1}, {16, 1}},
+	"BLOSUM50": {{13, 3}, {12, 3}, {11, 3}, {10, 3}, {9, 3}, {16, 2}, {15, 2}, {14, 2}, {13, 2}, {12, 2}, {19, 1}, {18, 1}, {17, 1}, {16, 1}, {15, 1}},
+	"BLOSUM62": {{11, 2}, {10, 2}, {9, 2}, {8, 2}, {7, 2}, {6, 2}, {13, 1}, {12, 1}, {11, 1}, {10, 1}, {9, 1}},
+	"BLOSUM80": {{25, 2}, {13, 2}, {9, 2}, {8, 2}, {7, 2}, {6, 2}, {11, 1}, {10, 1}, {9, 1}},
+	"BLOSUM90": {{9, 2}, {8, 2}, {7, 2}, {6, 2}, {11, 1}, {10, 1}, {9, 1}},
+	"PAM30":    {{7, 2}, {6, 2}, {5, 2}, {10, 1}, {9, 1}, {8, 1}},
+	"PAM70":    {{8, 2}, {7, 2}, {6, 2}, {11, 1}, {10, 1}, {9, 1}},
+	"PAM250":   {{15, 3}, {14, 3}, {13, 3}, {12, 3}, {11, 3}, {17, 2}, {16, 2}, {15, 2}, {14, 2}, {13, 2}, {21, 1}, {20, 1}, {19, 1}, {18, 1}, {17, 1}},
+}
+
+// Scoring selects the scoring matrix and gap costs for protein searches.
+// The zero value means use blast's defaults.
+type Scoring struct {
+	Matrix    string `json:"matrix,omitempty"`
+	GapOpen   int    `json:"gapOpen,omitempty"`
+	GapExtend int    `json:"gapExtend,omitempty"`
+}
+
+// IsZero reports whether s leaves the scoring to blast.
+func (s Scoring) IsZero() bool {
+	return s == Scoring{}
+}
+
+// Validate checks s is a matrix in ProteinMatrices, and gap costs it
+// supports if there are any.
+func (s Scoring) Validate() error {
+	if s.IsZero() {
+		return nil
+	}
+
+	costs, ok := ProteinMatrices[s.Matrix]
+	if !ok {
+		return fmt.Errorf("unsupported scoring matrix %q", s.Matrix)
+	}
+
+	// only the matrix was given, blast will pick its default gap costs
+	if s.GapOpen == 0 && s.GapExtend == 0 {
+		return nil
+	}
+
+	for _, c := range costs {
+		if c[0] == s.GapOpen && c[1] == s.GapExtend {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("gap costs %d/%d are not supported with %s", s.GapOpen, s.GapExtend, s.Matrix)
+}
+
+// Args returns the diamond command line arguments for s, which are the same
+// as blastp's bar the dashes.
+func (s Scoring) Args() []string {
+	if s.IsZero() {
+		return nil
+	}
+
+	args := []string{"--matrix", s.Matrix}
+	if s.GapOpen != 0 || s.GapExtend != 0 {
+		args = append(args,
+			"--gapopen", strconv.Itoa(s.GapOpen),
+			"--gapextend", strconv.Itoa(s.GapExtend))
+	}
+
+	return args
+}
+
+// Aligner runs queries with one kind of search tool, translating its
+// output into blast.Results.
+type Aligner interface {
+	Align(ctx context.Context, seq string, opts Options) (*blast.Results, error)
+}
+
+// Circular searches seq with a as a circular sequence. A circular query
+// searched twice over has every stretch across its origin in one piece
+// somewhere, so that's what's searched, and the hits are then unwrapped
+// back onto seq.
+func Circular(ctx context.Context, a Aligner, seq string, opts Options) (*blast.Results, error) {
+	query := Residues(seq)
+	results, err := a.Align(ctx, query+query, opts)
+	if results != nil {
+		results.Query = seq
+	}
+	if err != nil {
+		return results, err
+	}
+
+	results.UnwrapCircular(len(query))
+
+	return results, nil
+}
+
+// Residues drops any fasta header lines and whitespace from seq.
+func Residues(seq string) string {
+	lines := []string{}
+	for _, line := range strings.Split(seq, "\n") {
+		if !strings.HasPrefix(line, ">") {
+			lines = append(lines, strings.Join(strings.Fields(line), ""))
+		}
+	}
+
+	return strings.Join(lines, "")
+}
+
+// IsNucleotide reports whether seq only has nucleotide codes in it, in
+// which case diamond has to translate it.
+func IsNucleotide(seq string) bool {
+	for _, c := range strings.ToLower(Residues(seq)) {
+		if !strings.ContainsRune("acgtun", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FastaQuery makes a fasta record of seq if it isn't one already, since
+// sequences pasted in are usually just the letters.
+func FastaQuery(seq string) string {
+	if strings.HasPrefix(seq, ">") {
+		return seq
+	}
+
+	return ">query\n" + seq + "\n"
+}
+
+// find is blast.Find for the other aligners: it resolves binary to an
+// absolute path and returns it along with what it printed when run with
+// versionArg.
+func find(binary, versionArg string) (string, []byte, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return "", nil, err
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, versionArg).CombinedOutput()
+	if err != nil {
+		return "", nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+
+	return path, out, nil
+}
+
+// run runs an aligner other than blastn with input on stdin, which unlike
+// blastn's output is small enough to just buffer.
+func run(ctx context.Context, binary, dbDir string, args []string, input string, timeout time.Duration) (stdout, stderr string, err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := blast.Command(ctx, binary, dbDir, args...)
+	cmd.Stdin = strings.NewReader(input)
+	out, errOut := &bytes.Buffer{}, &blast.HeadBuffer{Max: 64 * 1024}
+	cmd.Stdout = out
+	cmd.Stderr = errOut
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%w: killed after %v", blast.ErrTimeout, timeout)
+	} else if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	return out.String(), errOut.String(), err
+}
+
+// midline marks the identical residues of an alignment the way blastp does,
+// which diamond and vsearch don't output.
+func midline(query, hit string) string {
+	line := []byte(strings.Repeat(" ", len(query)))
+	for i := 0; i < len(query) && i < len(hit); i++ {
+		if query[i] == hit[i] && query[i] != '-' {
+			line[i] = query[i]
+		}
+	}
+
+	return string(line)
+}
+
+// dbPath is where an aligner finds the db named db in dbDir, which may
+// have environment variables in it like blastn's -blastdb.path.
+func dbPath(dbDir, db string) string {
+	return path.Join(os.ExpandEnv(dbDir), db)
+}
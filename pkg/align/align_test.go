@@ -0,0 +1,138 @@
+package align_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+func TestScoring(t *testing.T) {
+	tests := []struct {
+		name    string
+		scoring align.Scoring
+		wantErr bool
+		args    []string
+	}{
+		{"defaults", align.Scoring{}, false, nil},
+		{"matrix only", align.Scoring{Matrix: "BLOSUM62"}, false, []string{"--matrix", "BLOSUM62"}},
+		{"gap costs", align.Scoring{Matrix: "PAM30", GapOpen: 9, GapExtend: 1}, false,
+			[]string{"--matrix", "PAM30", "--gapopen", "9", "--gapextend", "1"}},
+		{"unknown matrix", align.Scoring{Matrix: "BLOSUM99"}, true, nil},
+		{"unsupported gap costs", align.Scoring{Matrix: "BLOSUM62", GapOpen: 1, GapExtend: 1}, true, nil},
+		{"gap costs without matrix", align.Scoring{GapOpen: 11, GapExtend: 1}, true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.scoring.Validate()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Validate() = %v, want error %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if got := test.scoring.Args(); !reflect.DeepEqual(got, test.args) {
+				t.Errorf("Args() = %q, want %q", got, test.args)
+			}
+		})
+	}
+}
+
+func TestAlignerName(t *testing.T) {
+	if got := (align.Options{}).AlignerName(); got != "blastn" {
+		t.Errorf("AlignerName() = %q, want blastn", got)
+	}
+	if got := (align.Options{Aligner: "diamond"}).AlignerName(); got != "diamond" {
+		t.Errorf("AlignerName() = %q, want diamond", got)
+	}
+}
+
+func TestResidues(t *testing.T) {
+	tests := []struct {
+		name       string
+		seq        string
+		residues   string
+		nucleotide bool
+		fasta      string
+	}{
+		{"bare", "acgt", "acgt", true, ">query\nacgt\n"},
+		{"rna", "ACGU\nn", "ACGUn", true, ">query\nACGU\nn\n"},
+		{"fasta", ">BBa_B0034\naaag agga\ngaaa\n", "aaagaggagaaa", true, ">BBa_B0034\naaag agga\ngaaa\n"},
+		{"protein", "MKVLA", "MKVLA", false, ">query\nMKVLA\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := align.Residues(test.seq); got != test.residues {
+				t.Errorf("Residues = %q, want %q", got, test.residues)
+			}
+			if got := align.IsNucleotide(test.seq); got != test.nucleotide {
+				t.Errorf("IsNucleotide = %v, want %v", got, test.nucleotide)
+			}
+			if got := align.FastaQuery(test.seq); got != test.fasta {
+				t.Errorf("FastaQuery = %q, want %q", got, test.fasta)
+			}
+		})
+	}
+}
+
+// doubledAligner finds the one hit it was made with in whatever it's asked
+// to search, remembering the query.
+type doubledAligner struct {
+	hits  []blast.Hit
+	err   error
+	query string
+}
+
+func (a *doubledAligner) Align(ctx context.Context, seq string, opts align.Options) (*blast.Results, error) {
+	a.query = seq
+	if a.err != nil {
+		return &blast.Results{Query: seq, Error: "stderr"}, a.err
+	}
+
+	return &blast.Results{Query: seq, Results: append([]blast.Hit(nil), a.hits...)}, nil
+}
+
+func TestCircular(t *testing.T) {
+	// a hit across the origin of a 10bp plasmid, and the same hit found
+	// again in the second copy, which should be dropped
+	a := &doubledAligner{hits: []blast.Hit{
+		{SeqHash: "across", QueryFrom: 8, QueryTo: 13, Strand: "plus", HitStats: blast.HitStats{BitScore: 10}},
+		{SeqHash: "across", QueryFrom: 18, QueryTo: 23, Strand: "plus", HitStats: blast.HitStats{BitScore: 9}},
+	}}
+
+	results, err := align.Circular(context.Background(), a, ">plasmid\nacgtacgt\nac\n", align.Options{Circular: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "acgtacgtacacgtacgtac"; a.query != want {
+		t.Errorf("searched %q, want the residues twice over, %q", a.query, want)
+	}
+	if results.Query != ">plasmid\nacgtacgt\nac\n" || !results.Circular || results.QueryLen != 10 {
+		t.Errorf("results are for %q, circular %v, %d long, want the query as given, circular, 10 long",
+			results.Query, results.Circular, results.QueryLen)
+	}
+	if len(results.Results) != 1 {
+		t.Fatalf("%d hits, want 1", len(results.Results))
+	}
+	if hit := results.Results[0]; hit.QueryFrom != 8 || hit.QueryTo != 3 || hit.BitScore != 10 {
+		t.Errorf("hit is %d-%d scoring %v, want 8-3 scoring 10", hit.QueryFrom, hit.QueryTo, hit.BitScore)
+	}
+}
+
+func TestCircularError(t *testing.T) {
+	failed := errors.New("failed")
+	a := &doubledAligner{err: failed}
+
+	results, err := align.Circular(context.Background(), a, "acgt", align.Options{Circular: true})
+	if err != failed {
+		t.Fatalf("err = %v, want %v", err, failed)
+	}
+	if results.Query != "acgt" || results.Error != "stderr" {
+		t.Errorf("results are for %q with error %q, want the query as given and the aligner's stderr", results.Query, results.Error)
+	}
+}
@@ -0,0 +1,166 @@
+package align
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+// FindDiamond is blast.Find for diamond.
+func FindDiamond(binary string) (string, string, error) {
+	path, out, err := find(binary, "version")
+	if err != nil {
+		return "", "", err
+	}
+
+	line := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if !strings.HasPrefix(line, "diamond version ") {
+		return "", "", fmt.Errorf("unexpected output from %s version: %q", path, line)
+	}
+
+	return path, strings.TrimPrefix(line, "diamond version "), nil
+}
+
+// Diamond is a protein search against the .dmnd db builddb.sh builds from
+// the protein fastas, with diamond blastp for protein queries and diamond
+// blastx for nucleotide ones.
+type Diamond struct {
+	// Binary is the diamond to run and Version its version, see
+	// FindDiamond
+	Binary  string
+	Version string
+
+	// DB is the db in DBDir to search
+	DBDir string
+	DB    string
+
+	Threads int
+	Scoring Scoring
+
+	// MaxHits is how many hits diamond stops at, unlimited if 0
+	MaxHits int
+
+	// Timeout is how long diamond can run before it's killed, unlimited
+	// if 0
+	Timeout time.Duration
+}
+
+// diamondFields are the columns asked of diamond's tabular output
+var diamondFields = []string{
+	"sseqid", "slen", "evalue", "bitscore", "score", "nident", "gaps", "length",
+	"qstart", "qend", "sstart", "send", "qseq_gapped", "sseq_gapped", "qframe",
+}
+
+// Run searches for query. It returns the arguments diamond was run with too,
+// for the results' manifest. If diamond fails the Results returned say what
+// it printed on stderr.
+func (d Diamond) Run(ctx context.Context, query string) (*blast.Results, []string, error) {
+	mode := "blastp"
+	if IsNucleotide(query) {
+		mode = "blastx"
+	}
+
+	args := []string{mode, "--quiet",
+		"--db", dbPath(d.DBDir, d.DB),
+		"--threads", strconv.Itoa(d.Threads),
+		"--outfmt", "6"}
+	args = append(args, diamondFields...)
+	if d.MaxHits > 0 {
+		args = append(args, "--max-target-seqs", strconv.Itoa(d.MaxHits))
+	}
+	args = append(args, d.Scoring.Args()...)
+
+	start := time.Now()
+	stdout, stderr, err := run(ctx, d.Binary, d.DBDir, args, FastaQuery(query), d.Timeout)
+	if err != nil {
+		return &blast.Results{Error: stderr, Query: query}, args, err
+	}
+	aligner := time.Since(start)
+
+	results, err := ParseDiamond(stdout)
+	if err != nil {
+		return nil, args, err
+	}
+	results.Timings.Aligner = aligner
+	results.Timings.Parse = time.Since(start) - aligner
+	results.Program = "diamond " + mode
+	results.Version = d.Version
+	results.DB = d.DB
+	results.QueryID = "query"
+	results.QueryLen = len(Residues(query))
+	results.Warnings = blast.Warnings(stderr)
+
+	return results, args, nil
+}
+
+// ParseDiamond turns diamond's tabular output into results. diamond gives a
+// line per alignment, hits only keep the best one like they do for blastn.
+func ParseDiamond(out string) (*blast.Results, error) {
+	results := &blast.Results{ParserVersion: blast.ParserVersion}
+	seen := map[string]bool{}
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+
+		cols := strings.Split(line, "\t")
+		if len(cols) != len(diamondFields) {
+			return nil, fmt.Errorf("diamond output has %d columns instead of %d: %q", len(cols), len(diamondFields), line)
+		}
+		if seen[cols[0]] {
+			continue
+		}
+		seen[cols[0]] = true
+
+		ints := make([]int, len(cols))
+		for _, i := range []int{1, 4, 5, 6, 7, 8, 9, 10, 11, 14} {
+			n, err := strconv.Atoi(cols[i])
+			if err != nil {
+				return nil, fmt.Errorf("couldn't parse diamond %s %q: %v", diamondFields[i], cols[i], err)
+			}
+			ints[i] = n
+		}
+
+		floats := make([]float64, len(cols))
+		for _, i := range []int{2, 3} {
+			f, err := strconv.ParseFloat(cols[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't parse diamond %s %q: %v", diamondFields[i], cols[i], err)
+			}
+			floats[i] = f
+		}
+
+		results.Results = append(results.Results, blast.Hit{
+			Num:     len(results.Results) + 1,
+			ID:      cols[0],
+			SeqHash: cols[0],
+			Len:     ints[1],
+			HitStats: blast.HitStats{
+				EValue:   floats[2],
+				BitScore: floats[3],
+				Score:    ints[4],
+				Identity: ints[5],
+				Gaps:     ints[6],
+				AlignLen: ints[7],
+			},
+			QueryFrom: ints[8],
+			QueryTo:   ints[9],
+			HitFrom:   ints[10],
+			HitTo:     ints[11],
+			QuerySeq:  cols[12],
+			Midline:   midline(cols[12], cols[13]),
+			HitSeq:    cols[13],
+
+			// blastx translates the query, blastp's frame is 0
+			QueryFrame: ints[14],
+			Strand:     blast.FrameStrand(ints[14], 0),
+		})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,163 @@
+package align_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+// diamondLine is a line of diamond's output with the columns align asks
+// for.
+func diamondLine(cols ...string) string {
+	return strings.Join(cols, "\t") + "\n"
+}
+
+func TestParseDiamond(t *testing.T) {
+	out := diamondLine("v2-aaaa", "120", "1.5e-20", "85.1", "200", "40", "1", "42", "4", "129", "3", "44", "MKV-LA", "MKVSLG", "2") +
+		// a worse alignment to the same sequence, dropped
+		diamondLine("v2-aaaa", "120", "0.01", "20.2", "40", "10", "0", "12", "140", "175", "60", "71", "MKVLAA", "MKVLAA", "2") +
+		diamondLine("v2-bbbb", "80", "3e-5", "40", "90", "20", "0", "20", "1", "20", "1", "20", "MKVLA", "MKVLA", "0")
+
+	results, err := align.ParseDiamond(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.ParserVersion != blast.ParserVersion {
+		t.Errorf("ParserVersion = %d, want %d", results.ParserVersion, blast.ParserVersion)
+	}
+	if len(results.Results) != 2 {
+		t.Fatalf("%d hits, want 2", len(results.Results))
+	}
+
+	hit := results.Results[0]
+	if hit.Num != 1 || hit.SeqHash != "v2-aaaa" || hit.Len != 120 {
+		t.Errorf("first hit is #%d %s, %d long, want #1 v2-aaaa, 120 long", hit.Num, hit.SeqHash, hit.Len)
+	}
+	if hit.EValue != 1.5e-20 || hit.BitScore != 85.1 || hit.Score != 200 || hit.Identity != 40 || hit.Gaps != 1 || hit.AlignLen != 42 {
+		t.Errorf("first hit's stats are %+v", hit.HitStats)
+	}
+	if hit.QueryFrom != 4 || hit.QueryTo != 129 || hit.HitFrom != 3 || hit.HitTo != 44 {
+		t.Errorf("first hit aligns %d-%d to %d-%d, want 4-129 to 3-44", hit.QueryFrom, hit.QueryTo, hit.HitFrom, hit.HitTo)
+	}
+	if hit.Midline != "MKV L " {
+		t.Errorf("first hit's midline = %q, want %q", hit.Midline, "MKV L ")
+	}
+	if hit.QueryFrame != 2 || hit.Strand != "plus" {
+		t.Errorf("first hit is frame %d, %s strand, want frame 2, plus strand", hit.QueryFrame, hit.Strand)
+	}
+
+	// blastp doesn't translate the query
+	if hit := results.Results[1]; hit.Num != 2 || hit.QueryFrame != 0 || hit.Strand != "" {
+		t.Errorf("second hit is #%d, frame %d, %q strand, want #2, frame 0, no strand", hit.Num, hit.QueryFrame, hit.Strand)
+	}
+}
+
+func TestParseDiamondErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+	}{
+		{"too few columns", "v2-aaaa\t120\n"},
+		{"bad length", diamondLine("v2-aaaa", "long", "1e-5", "85", "200", "40", "1", "42", "4", "129", "3", "44", "MKV", "MKV", "0")},
+		{"bad e-value", diamondLine("v2-aaaa", "120", "tiny", "85", "200", "40", "1", "42", "4", "129", "3", "44", "MKV", "MKV", "0")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := align.ParseDiamond(test.out); err == nil {
+				t.Errorf("ParseDiamond(%q) = nil error, want one", test.out)
+			}
+		})
+	}
+}
+
+func TestParseDiamondEmpty(t *testing.T) {
+	results, err := align.ParseDiamond("")
+	if err != nil || len(results.Results) != 0 {
+		t.Errorf("ParseDiamond(\"\") = %d hits, %v, want none", len(results.Results), err)
+	}
+}
+
+// stubAligner writes a script that prints out, whatever it's asked, and
+// returns its path.
+func stubAligner(t *testing.T, out string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "out"), []byte(out), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary := filepath.Join(dir, "aligner")
+	script := "#!/bin/sh\ncat >/dev/null\ncat '" + filepath.Join(dir, "out") + "'\n"
+	err = os.WriteFile(binary, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return binary
+}
+
+func TestDiamondRun(t *testing.T) {
+	binary := stubAligner(t, diamondLine("v2-aaaa", "120", "1e-20", "85", "200", "40", "1", "42", "1", "126", "1", "42", "MKV", "MKV", "1"))
+
+	results, args, err := align.Diamond{
+		Binary:  binary,
+		Version: "2.1.8",
+		DBDir:   "/var/synbioblast/blastdbs",
+		DB:      "synbioblast",
+		Threads: 2,
+		Scoring: align.Scoring{Matrix: "BLOSUM62"},
+		MaxHits: 10,
+		Timeout: time.Minute,
+	}.Run(context.Background(), "acgtacgt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results.Program != "diamond blastx" || results.Version != "2.1.8" || results.DB != "synbioblast" || results.QueryLen != 8 {
+		t.Errorf("results are from %s %s against %s for %d residues, want diamond blastx 2.1.8 against synbioblast for 8",
+			results.Program, results.Version, results.DB, results.QueryLen)
+	}
+	if len(results.Results) != 1 {
+		t.Errorf("%d hits, want 1", len(results.Results))
+	}
+
+	for _, want := range [][]string{
+		{"blastx", "--quiet"},
+		{"--db", "/var/synbioblast/blastdbs/synbioblast"},
+		{"--threads", "2"},
+		{"--max-target-seqs", "10"},
+		{"--matrix", "BLOSUM62"},
+	} {
+		i := slices.Index(args, want[0])
+		if i < 0 || i+1 >= len(args) || args[i+1] != want[1] {
+			t.Errorf("args %q are missing %q", args, want)
+		}
+	}
+}
+
+func TestDiamondRunFails(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "aligner")
+	err := os.WriteFile(binary, []byte("#!/bin/sh\necho 'Error: no such db' >&2\nexit 1\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, _, err := align.Diamond{Binary: binary, DB: "missing"}.Run(context.Background(), "MKVLA")
+	if err == nil {
+		t.Fatal("Run = nil error, want diamond's exit status")
+	}
+	if !strings.Contains(results.Stderr(), "no such db") {
+		t.Errorf("results' stderr = %q, want diamond's", results.Stderr())
+	}
+}
@@ -0,0 +1,159 @@
+package align
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+// FindVsearch is blast.Find for vsearch.
+func FindVsearch(binary string) (string, string, error) {
+	// vsearch prints its version to stderr
+	path, out, err := find(binary, "--version")
+	if err != nil {
+		return "", "", err
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 || fields[0] != "vsearch" {
+		return "", "", fmt.Errorf("unexpected output from %s --version: %q", path, out)
+	}
+
+	return path, strings.TrimPrefix(strings.TrimSuffix(fields[1], ","), "v"), nil
+}
+
+// Vsearch is a vsearch --usearch_global search against the .udb db
+// builddb.sh builds next to the blast db. It's much quicker than blastn on
+// big dbs but only finds hits at least MinIdentity identical, so it suits
+// "what is this close to" searches rather than sensitive ones.
+type Vsearch struct {
+	// Binary is the vsearch to run and Version its version, see
+	// FindVsearch
+	Binary  string
+	Version string
+
+	// DB is the blast db in DBDir whose .udb is searched
+	DBDir string
+	DB    string
+
+	Threads int
+
+	// MinIdentity is how identical, from 0 to 1, a hit has to be
+	MinIdentity float64
+
+	// MaxHits is how many hits vsearch stops at, unlimited if 0
+	MaxHits int
+
+	// Timeout is how long vsearch can run before it's killed, unlimited
+	// if 0
+	Timeout time.Duration
+}
+
+// vsearchFields are the columns asked of vsearch's --userout output
+var vsearchFields = []string{
+	"target", "tl", "ids", "gaps", "alnlen", "qlo", "qhi", "tlo", "thi", "raw", "qrow", "trow", "qstrand",
+}
+
+// Run searches for query. It returns the arguments vsearch was run with
+// too, for the results' manifest. If vsearch fails the Results returned
+// say what it printed on stderr.
+func (v Vsearch) Run(ctx context.Context, query string) (*blast.Results, []string, error) {
+	args := []string{
+		"--usearch_global", "-",
+		"--db", dbPath(v.DBDir, v.DB+".udb"),
+		"--id", strconv.FormatFloat(v.MinIdentity, 'f', -1, 64),
+		"--strand", "both",
+		"--threads", strconv.Itoa(v.Threads),
+		"--userout", "-",
+		"--userfields", strings.Join(vsearchFields, "+"),
+		"--quiet",
+	}
+	if v.MaxHits > 0 {
+		args = append(args, "--maxaccepts", strconv.Itoa(v.MaxHits))
+	}
+
+	start := time.Now()
+	stdout, stderr, err := run(ctx, v.Binary, v.DBDir, args, FastaQuery(query), v.Timeout)
+	if err != nil {
+		return &blast.Results{Error: stderr, Query: query}, args, err
+	}
+	aligner := time.Since(start)
+
+	results, err := ParseVsearch(stdout)
+	if err != nil {
+		return nil, args, err
+	}
+	results.Timings.Aligner = aligner
+	results.Timings.Parse = time.Since(start) - aligner
+	results.Program = "vsearch usearch_global"
+	results.Version = v.Version
+	results.DB = v.DB
+	results.QueryID = "query"
+	results.QueryLen = len(Residues(query))
+	results.Warnings = blast.Warnings(stderr)
+
+	return results, args, nil
+}
+
+// ParseVsearch turns vsearch's --userout output into results. vsearch has
+// no e-values or bit scores, so hits only have the raw alignment score.
+func ParseVsearch(out string) (*blast.Results, error) {
+	results := &blast.Results{ParserVersion: blast.ParserVersion}
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+
+		cols := strings.Split(line, "\t")
+		if len(cols) != len(vsearchFields) {
+			return nil, fmt.Errorf("vsearch output has %d columns instead of %d: %q", len(cols), len(vsearchFields), line)
+		}
+
+		// vsearch reverse complements the query to match minus strand hits
+		hitFrame := 1
+		if cols[12] == "-" {
+			hitFrame = -1
+		}
+
+		ints := make([]int, len(cols))
+		for i := 1; i <= 9; i++ {
+			n, err := strconv.Atoi(cols[i])
+			if err != nil {
+				return nil, fmt.Errorf("couldn't parse vsearch %s %q: %v", vsearchFields[i], cols[i], err)
+			}
+			ints[i] = n
+		}
+
+		results.Results = append(results.Results, blast.Hit{
+			Num:     len(results.Results) + 1,
+			ID:      cols[0],
+			SeqHash: cols[0],
+			Len:     ints[1],
+			HitStats: blast.HitStats{
+				Score:    ints[9],
+				EValue:   -1,
+				Identity: ints[2],
+				Gaps:     ints[3],
+				AlignLen: ints[4],
+			},
+			QueryFrom: ints[5],
+			QueryTo:   ints[6],
+			HitFrom:   ints[7],
+			HitTo:     ints[8],
+			QuerySeq:  cols[10],
+			Midline:   midline(cols[10], cols[11]),
+			HitSeq:    cols[11],
+
+			QueryFrame: 1,
+			HitFrame:   hitFrame,
+			Strand:     blast.FrameStrand(1, hitFrame),
+		})
+	}
+
+	return results, nil
+}
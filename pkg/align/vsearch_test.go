@@ -0,0 +1,60 @@
+package align_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/schnauzer/synbioblast/pkg/align"
+)
+
+func TestParseVsearch(t *testing.T) {
+	out := strings.Join([]string{
+		"v2-aaaa\t12\t11\t0\t12\t1\t12\t1\t12\t50\taaagaggagaaa\taaagaggtgaaa\t+",
+		"v2-bbbb\t20\t12\t1\t13\t1\t12\t3\t15\t48\taaaga-ggagaaa\taaagatggagaaa\t-",
+	}, "\n") + "\n"
+
+	results, err := align.ParseVsearch(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Results) != 2 {
+		t.Fatalf("%d hits, want 2", len(results.Results))
+	}
+
+	hit := results.Results[0]
+	if hit.Num != 1 || hit.SeqHash != "v2-aaaa" || hit.Len != 12 {
+		t.Errorf("first hit is #%d %s, %d long, want #1 v2-aaaa, 12 long", hit.Num, hit.SeqHash, hit.Len)
+	}
+	if hit.Score != 50 || hit.EValue != -1 || hit.Identity != 11 || hit.AlignLen != 12 {
+		t.Errorf("first hit's stats are %+v, want score 50, no e-value, 11 of 12 identical", hit.HitStats)
+	}
+	if hit.Midline != "aaagagg gaaa" || hit.Strand != "plus" {
+		t.Errorf("first hit's midline is %q on the %s strand, want %q on the plus strand", hit.Midline, hit.Strand, "aaagagg gaaa")
+	}
+
+	hit = results.Results[1]
+	if hit.Gaps != 1 || hit.HitFrom != 3 || hit.HitTo != 15 {
+		t.Errorf("second hit has %d gaps aligned to %d-%d, want 1 aligned to 3-15", hit.Gaps, hit.HitFrom, hit.HitTo)
+	}
+	if hit.HitFrame != -1 || hit.Strand != "minus" {
+		t.Errorf("second hit is hit frame %d on the %s strand, want -1 on the minus strand", hit.HitFrame, hit.Strand)
+	}
+}
+
+func TestParseVsearchErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+	}{
+		{"too few columns", "v2-aaaa\t12\t11\n"},
+		{"bad score", "v2-aaaa\t12\t11\t0\t12\t1\t12\t1\t12\thigh\taaa\taaa\t+\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := align.ParseVsearch(test.out); err == nil {
+				t.Errorf("ParseVsearch(%q) = nil error, want one", test.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,1258 @@
+// Package blast runs blastn and parses what it finds.
+//
+// Search runs blastn against a db and returns its hits as Results, parsing
+// its XML output (-outfmt 5) as it's written so big result sets needn't be
+// held in memory twice:
+//
+//	query, _, err := blast.NormalizeQuery(pasted, 0)
+//	...
+//	results, err := blast.Search{
+//		Binary:  "/usr/bin/blastn",
+//		DBDir:   "/var/synbioblast/blastdbs",
+//		Args:    []string{"-db", "SynBioHub", "-outfmt", "5"},
+//		Timeout: time.Minute,
+//	}.Run(ctx, query)
+//
+// Results of aligners with other output formats can be built by hand, and
+// stored results decoded again with Decode.
+package blast
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/schnauzer/synbioblast/logging"
+	"github.com/schnauzer/synbioblast/pkg/sequence"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+)
+
+// tracer traces parsing blast's output, which is a good part of a search
+// when there are lots of hits. It does nothing unless the program using
+// the package set up tracing.
+var tracer = otel.Tracer("github.com/schnauzer/synbioblast/pkg/blast")
+
+// Results are what a search found: the hits blast's output lists, and what
+// whoever ran the search filled in about them.
+type Results struct {
+	Program   string `json:"program"`
+	Version   string `json:"version"`
+	Reference string `json:"reference"`
+	DB        string `json:"db"`
+
+	QueryID  string `json:"queryId"`
+	QueryDef string `json:"queryDef"`
+	QueryLen int    `json:"queryLen"`
+
+	// Parameters are the settings blast says it searched with, blastn
+	// only
+	Parameters *Parameters `json:"parameters,omitempty"`
+
+	Results []Hit `json:"results"`
+
+	// Iterations are blast's searches of each query, with its statistics
+	// and any message about it. Searches here only have the one query.
+	Iterations []Iteration `json:"iterations,omitempty"`
+
+	// Message is what blast said about a query it found no hits for,
+	// NoHitsMessage if it searched it, or why it didn't, in which case
+	// Skipped is set too
+	Message string `json:"message,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+
+	// Truncated is set when blast found more than the max hits it was
+	// allowed and was stopped early. DBNum and DBLen are unknown when it is.
+	Truncated bool `json:"truncated,omitempty"`
+
+	DBNum int `json:"dbNum"`
+	DBLen int `json:"dbLen"`
+
+	// ParserVersion is the version of Decode that produced these results
+	ParserVersion int `json:"parserVersion"`
+
+	// Circular is set when the query was searched as a circular sequence.
+	// Hits spanning its origin have QueryTo < QueryFrom.
+	Circular bool `json:"circular,omitempty"`
+
+	// Clustered is set when the query was searched against cluster
+	// representatives and their hits expanded to the rest of their
+	// clusters, see ExpandClusters
+	Clustered bool `json:"clustered,omitempty"`
+
+	// Subjects is set when the query was searched against sequences sent
+	// with it rather than the db, to how many there were. Hits have the
+	// Subject they're in instead of a SeqHash and URIs.
+	Subjects int `json:"subjects,omitempty"`
+
+	// DBVersion is set when an older version of the db than the current
+	// one was searched, to that version. Its hits were resolved to the
+	// components it was built with.
+	DBVersion string `json:"dbVersion,omitempty"`
+
+	// URIsUnavailable is set when Redis couldn't be reached to look up the
+	// components for each hit, so hits only have their sequence hashes
+	URIsUnavailable bool `json:"urisUnavailable,omitempty"`
+
+	// URILookupFailures is the number of hits whose components couldn't be
+	// looked up when the rest could, see Hit.URIsUnavailable
+	URILookupFailures int `json:"uriLookupFailures,omitempty"`
+
+	// Query is the sequence that was searched, Input what it was pasted as
+	Query string `json:"query"`
+	Input *Input `json:"input,omitempty"`
+
+	DescriptionFilter string   `json:"descriptionFilter,omitempty"`
+	RoleFilter        []string `json:"roleFilter,omitempty"`
+	ContainmentFilter []string `json:"containmentFilter,omitempty"`
+	Error             string   `json:"error,omitempty"`
+	Warnings          []string `json:"warnings,omitempty"`
+	Timings           Timings  `json:"timings"`
+	NumResults        int      `json:"numResults"`
+
+	// Manifest says exactly what was searched, see Manifest
+	Manifest *Manifest `json:"manifest,omitempty"`
+}
+
+// Timings break down where a query's time went. Phases a query didn't go
+// through are 0.
+type Timings struct {
+	// Normalize is cleaning up the query before it's searched
+	Normalize time.Duration `json:"normalize"`
+	// QueueWait is waiting for a worker and a free blast slot
+	QueueWait time.Duration `json:"queueWait"`
+	// Aligner is blastn, or whichever aligner was used, running
+	Aligner time.Duration `json:"aligner"`
+	// Parse is parsing the aligner's output. blastn's is parsed as it's
+	// written, so this is only the time spent parsing rather than waiting
+	// for more output, and overlaps Aligner.
+	Parse time.Duration `json:"parse"`
+	// ResolveURIs is looking up the components using each hit in Redis
+	ResolveURIs time.Duration `json:"resolveURIs"`
+	// Total is from when the query arrived until its results were ready
+	Total time.Duration `json:"total"`
+}
+
+// Iteration is blast's search of one query.
+type Iteration struct {
+	Num      int    `xml:"Iteration_iter-num" json:"num"`
+	QueryID  string `xml:"Iteration_query-ID" json:"queryId"`
+	QueryDef string `xml:"Iteration_query-def" json:"queryDef,omitempty"`
+	QueryLen int    `xml:"Iteration_query-len" json:"queryLen"`
+
+	// Hits is how many hits blast reported for the query, before any
+	// were filtered out or added from clusters
+	Hits int `json:"hits"`
+
+	// Message is blast's note on the query, NoHitsMessage or why it
+	// wasn't searched, e.g. because it was all masked as low complexity
+	Message string `xml:"Iteration_message" json:"message,omitempty"`
+
+	Statistics *Statistics `json:"statistics,omitempty"`
+}
+
+// NoHitsMessage is the Iteration message of a query blast searched
+// without finding anything.
+const NoHitsMessage = "No hits found"
+
+// Statistics describe the search space of an iteration. HSPLen is how
+// much the query and db lengths were shortened by to get the effective
+// search space EffectiveSpace, and Kappa, Lambda and Entropy are the
+// Karlin-Altschul statistics for the scoring.
+type Statistics struct {
+	DBNum          int     `xml:"Statistics_db-num" json:"dbNum"`
+	DBLen          int     `xml:"Statistics_db-len" json:"dbLen"`
+	HSPLen         int     `xml:"Statistics_hsp-len" json:"hspLen"`
+	EffectiveSpace float64 `xml:"Statistics_eff-space" json:"effectiveSpace"`
+	Kappa          float64 `xml:"Statistics_kappa" json:"kappa"`
+	Lambda         float64 `xml:"Statistics_lambda" json:"lambda"`
+	Entropy        float64 `xml:"Statistics_entropy" json:"entropy"`
+}
+
+// Parameters are the search settings blast reports in its output's
+// BlastOutput_param, and the statistics of the search space it worked out
+// from the db.
+type Parameters struct {
+	// Matrix is the scoring matrix of protein searches
+	Matrix string `xml:"Parameters_matrix" json:"matrix,omitempty"`
+
+	Expect float64 `xml:"Parameters_expect" json:"expect"`
+
+	// Match and Mismatch are the scores of nucleotide searches' aligned
+	// bases
+	Match    int `xml:"Parameters_sc-match" json:"match,omitempty"`
+	Mismatch int `xml:"Parameters_sc-mismatch" json:"mismatch,omitempty"`
+
+	// GapOpen and GapExtend are the gap costs, both 0 for megablast's
+	// default linear costs
+	GapOpen   int `xml:"Parameters_gap-open" json:"gapOpen"`
+	GapExtend int `xml:"Parameters_gap-extend" json:"gapExtend"`
+
+	// Filter is the low complexity and repeat masking applied, e.g. "L;m;"
+	// for DUST with masking only for lookups
+	Filter string `xml:"Parameters_filter" json:"filter,omitempty"`
+
+	EntrezQuery string `xml:"Parameters_entrez-query" json:"entrezQuery,omitempty"`
+
+	// HSPLen is how much the query and db lengths were shortened by to
+	// get the effective search space EffectiveSpace, and Kappa, Lambda and
+	// Entropy are the Karlin-Altschul statistics for the scoring
+	HSPLen         int     `xml:"-" json:"hspLen"`
+	EffectiveSpace float64 `xml:"-" json:"effectiveSpace"`
+	Kappa          float64 `xml:"-" json:"kappa"`
+	Lambda         float64 `xml:"-" json:"lambda"`
+	Entropy        float64 `xml:"-" json:"entropy"`
+}
+
+// readTimer counts how long reads from r spent blocked.
+type readTimer struct {
+	r       io.Reader
+	blocked time.Duration
+}
+
+func (t *readTimer) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.blocked += time.Since(start)
+	return n, err
+}
+
+// Hit is a sequence in the db that matched the query. Only its best
+// scoring alignment (HSP) is kept.
+type Hit struct {
+	Num       int    `xml:"Hit_num" json:"num"`
+	ID        string `xml:"Hit_id" json:"id"`
+	SeqHash   string `xml:"Hit_def" json:"seqHash"`
+	Accession string `xml:"Hit_accession" json:"accession"`
+	Len       int    `xml:"Hit_len" json:"len"`
+
+	// Iteration is the Num of the Iteration the hit was found in
+	Iteration int `json:"iteration,omitempty"`
+
+	HitStats
+
+	QueryFrom int `xml:"Hit_hsps>Hsp>Hsp_query-from" json:"queryFrom"`
+	QueryTo   int `xml:"Hit_hsps>Hsp>Hsp_query-to" json:"queryTo"`
+	HitFrom   int `xml:"Hit_hsps>Hsp>Hsp_hit-from" json:"hitFrom"`
+	HitTo     int `xml:"Hit_hsps>Hsp>Hsp_hit-to" json:"hitTo"`
+
+	QueryFrame int `xml:"Hit_hsps>Hsp>Hsp_query-frame" json:"queryFrame"`
+	HitFrame   int `xml:"Hit_hsps>Hsp>Hsp_hit-frame" json:"hitFrame"`
+
+	// Strand is "plus" if the hit matches the query as given, "minus" if it
+	// matches its reverse complement. For minus hits HitFrom > HitTo, and
+	// HitSeq is the reverse complement of the hit so it lines up with the
+	// query.
+	Strand string `json:"strand,omitempty"`
+
+	QuerySeq string `xml:"Hit_hsps>Hsp>Hsp_qseq" json:"querySeq"`
+	Midline  string `xml:"Hit_hsps>Hsp>Hsp_midline" json:"midline"`
+	HitSeq   string `xml:"Hit_hsps>Hsp>Hsp_hseq" json:"hitSeq"`
+
+	// Blocks is the alignment cut up for display, see LayoutAlignments
+	Blocks []AlignmentBlock `json:"blocks,omitempty"`
+
+	URIs []string `json:"uris"`
+
+	// URIsUnavailable is set when the components using the hit's sequence
+	// couldn't be looked up, so it only has its SeqHash
+	URIsUnavailable bool `json:"urisUnavailable,omitempty"`
+
+	// Roles are the Sequence Ontology terms of the components' roles, like
+	// "SO:0000167" for promoters
+	Roles []string `json:"roles,omitempty"`
+
+	// Sources are the web addresses of the SynBioHub instances the
+	// components were slurped from, by uri, where known
+	Sources map[string]string `json:"sources,omitempty"`
+
+	// RNA is set when any of the components are RNA parts, which are
+	// stored and aligned with their U's as T's
+	RNA bool `json:"rna,omitempty"`
+
+	// GC is the percentage of the hit's sequence that's G or C, for
+	// nucleotide hits measured when they were slurped
+	GC float64 `json:"gc,omitempty"`
+
+	// Representative is set on hits added by ExpandClusters, to the hash of
+	// the cluster representative the query was actually aligned with. The
+	// alignment, scores and Len are the representative's.
+	Representative string `json:"representative,omitempty"`
+
+	// Subject is the name of the sequence hit, in searches of sequences
+	// sent with the query, see Results.Subjects
+	Subject string `json:"subject,omitempty"`
+
+	// SimilarityClass is the name of the first similarity tier the hit's
+	// identity reaches, if any
+	SimilarityClass string `json:"similarityClass,omitempty"`
+
+	// Containment is how the hit and the query overlap, one of the
+	// Containment* classes, see ClassifyContainment
+	Containment string `json:"containment,omitempty"`
+
+	// QueryAmbiguous and HitAmbiguous are set when the query or the hit has
+	// IUPAC ambiguity codes like N or R in the aligned stretch, so its
+	// identity may be understated. Only set for nucleotide alignments.
+	QueryAmbiguous bool `json:"queryAmbiguous,omitempty"`
+	HitAmbiguous   bool `json:"hitAmbiguous,omitempty"`
+}
+
+// HitStats are the numbers saying how good a hit is.
+type HitStats struct {
+	BitScore float64 `xml:"Hit_hsps>Hsp>Hsp_bit-score" json:"bitScore"`
+	Score    int     `xml:"Hit_hsps>Hsp>Hsp_score" json:"score"`
+
+	// EValue is negative if the aligner doesn't compute them (vsearch)
+	EValue float64 `xml:"Hit_hsps>Hsp>Hsp_evalue" json:"evalue"`
+
+	Identity int `xml:"Hit_hsps>Hsp>Hsp_identity" json:"identity"`
+	Gaps     int `xml:"Hit_hsps>Hsp>Hsp_gaps" json:"gaps"`
+	AlignLen int `xml:"Hit_hsps>Hsp>Hsp_align-len" json:"alignLen"`
+}
+
+// UnmarshalJSON also reads the string e-values of jobs stored before they
+// were parsed as numbers, which were empty or null for aligners that don't
+// compute them.
+func (r *Hit) UnmarshalJSON(b []byte) error {
+	type plainResult Hit
+	aux := struct {
+		*plainResult
+		EValue json.RawMessage `json:"evalue"`
+	}{plainResult: (*plainResult)(r)}
+
+	err := json.Unmarshal(b, &aux)
+	if err != nil {
+		return err
+	}
+	if len(aux.EValue) == 0 {
+		return nil
+	}
+
+	evalue := string(aux.EValue)
+	if strings.HasPrefix(evalue, `"`) {
+		evalue, err = strconv.Unquote(evalue)
+		if err != nil {
+			return err
+		}
+	}
+	if evalue == "" || evalue == "null" {
+		r.EValue = -1
+		return nil
+	}
+
+	r.EValue, err = strconv.ParseFloat(evalue, 64)
+	return err
+}
+
+// IdentityPercent is how much of the alignment is identical.
+func (r Hit) IdentityPercent() float64 {
+	if r.AlignLen == 0 {
+		return 0
+	}
+
+	return 100 * float64(r.Identity) / float64(r.AlignLen)
+}
+
+// The containment classes, by how much of the query and of the hit their
+// alignment spans.
+const (
+	// ContainmentNearIdentical hits are aligned along nearly all of both
+	ContainmentNearIdentical = "near-identical"
+	// ContainmentQueryContainsPart hits are a part found inside the query,
+	// e.g. a promoter in a plasmid
+	ContainmentQueryContainsPart = "query-contains-part"
+	// ContainmentPartContainsQuery hits contain the whole query, e.g. a
+	// plasmid a promoter is used in
+	ContainmentPartContainsQuery = "part-contains-query"
+	// ContainmentPartialOverlap hits only share a stretch with the query
+	ContainmentPartialOverlap = "partial-overlap"
+)
+
+// Containments are the containment classes, for validating filters.
+var Containments = []string{
+	ContainmentNearIdentical,
+	ContainmentQueryContainsPart,
+	ContainmentPartContainsQuery,
+	ContainmentPartialOverlap,
+}
+
+// ContainedCoverage is how much of a sequence an alignment has to span for
+// the sequence to count as contained in the other one, allowing for ragged
+// ends the aligner didn't extend to.
+const ContainedCoverage = 0.95
+
+// ClassifyContainment sets the Containment of each hit, from how much of the
+// query and of the hit the alignment spans. It needs QueryLen, so for
+// circular queries UnwrapCircular calls it again once that's known. Hits
+// of queries of more than one record are measured against the length of
+// their own record, their Iteration's.
+func (r *Results) ClassifyContainment() {
+	queryLens := map[int]int{}
+	for _, it := range r.Iterations {
+		queryLens[it.Num] = it.QueryLen
+	}
+
+	for i := range r.Results {
+		hit := &r.Results[i]
+		hit.Containment = ""
+		queryLen := r.QueryLen
+		if l := queryLens[hit.Iteration]; l > 0 {
+			queryLen = l
+		}
+		if queryLen == 0 || hit.Len == 0 {
+			continue
+		}
+
+		querySpan := hit.QueryTo - hit.QueryFrom + 1
+		if hit.QueryTo < hit.QueryFrom {
+			if r.Circular {
+				// across the origin
+				querySpan = queryLen - hit.QueryFrom + 1 + hit.QueryTo
+			} else {
+				querySpan = hit.QueryFrom - hit.QueryTo + 1
+			}
+		}
+		hitSpan := hit.HitTo - hit.HitFrom + 1
+		if hit.HitTo < hit.HitFrom {
+			hitSpan = hit.HitFrom - hit.HitTo + 1
+		}
+
+		queryCovered := float64(querySpan)/float64(queryLen) >= ContainedCoverage
+		hitCovered := float64(hitSpan)/float64(hit.Len) >= ContainedCoverage
+		switch {
+		case queryCovered && hitCovered:
+			hit.Containment = ContainmentNearIdentical
+		case hitCovered:
+			hit.Containment = ContainmentQueryContainsPart
+		case queryCovered:
+			hit.Containment = ContainmentPartContainsQuery
+		default:
+			hit.Containment = ContainmentPartialOverlap
+		}
+	}
+}
+
+// FrameStrand is the strand of a hit on the given query and hit frames. A
+// frame is 0 for a protein sequence, so a protein alignment has no strand.
+func FrameStrand(queryFrame, hitFrame int) string {
+	sign := queryFrame * hitFrame
+	if queryFrame == 0 || hitFrame == 0 {
+		sign = queryFrame + hitFrame
+	}
+
+	switch {
+	case sign > 0:
+		return "plus"
+	case sign < 0:
+		return "minus"
+	}
+	return ""
+}
+
+// Stderr is what the aligner said before it failed, if it got that far.
+func (r *Results) Stderr() string {
+	if r == nil {
+		return ""
+	}
+
+	return r.Error
+}
+
+// alignmentWidth is the number of columns in each block of an alignment,
+// the same as blast's own reports.
+const alignmentWidth = 60
+
+// AlignmentBlock is up to alignmentWidth columns of an alignment, along with
+// the positions of the first and last residue of each row in it.
+type AlignmentBlock struct {
+	QueryFrom int    `json:"queryFrom"`
+	QueryTo   int    `json:"queryTo"`
+	HitFrom   int    `json:"hitFrom"`
+	HitTo     int    `json:"hitTo"`
+	QuerySeq  string `json:"querySeq"`
+	Midline   string `json:"midline"`
+	HitSeq    string `json:"hitSeq"`
+
+	// Columns has a character per column: "=" for identical residues, "+"
+	// for similar amino acids, "x" for mismatches and "-" for gaps
+	Columns string `json:"columns"`
+}
+
+// AlignmentCell is a column of an AlignmentBlock, for coloring it.
+type AlignmentCell struct {
+	Query, Hit string
+	Class      string
+}
+
+var columnClasses = map[byte]string{'=': "match", '+': "positive", 'x': "mismatch", '-': "gap"}
+
+// Cells splits the block into its columns.
+func (b AlignmentBlock) Cells() []AlignmentCell {
+	cells := make([]AlignmentCell, len(b.Columns))
+	for i := range cells {
+		cells[i] = AlignmentCell{
+			Query: b.QuerySeq[i : i+1],
+			Hit:   b.HitSeq[i : i+1],
+			Class: columnClasses[b.Columns[i]],
+		}
+	}
+
+	return cells
+}
+
+// alignmentRow tracks the position of a row of an alignment as it's cut
+// into blocks.
+type alignmentRow struct {
+	pos, dir, step int
+}
+
+func newAlignmentRow(from, to int, seq string) *alignmentRow {
+	r := &alignmentRow{pos: from, dir: 1, step: 1}
+	if to < from {
+		r.dir = -1
+	}
+
+	// translated rows cover three bases per residue
+	n := len(seq) - strings.Count(seq, "-")
+	if span := (to-from)*r.dir + 1; n > 0 && span == 3*n {
+		r.step = 3
+	}
+
+	return r
+}
+
+// advance moves the row past seq, returning the positions of its first and
+// last residue.
+func (r *alignmentRow) advance(seq string) (from, to int) {
+	n := len(seq) - strings.Count(seq, "-")
+	if n == 0 {
+		// like blast, a block of only gaps is shown at the last position
+		return r.pos - r.dir, r.pos - r.dir
+	}
+
+	from = r.pos
+	to = from + r.dir*(n*r.step-1)
+	r.pos = to + r.dir
+
+	return from, to
+}
+
+// layoutBlocks cuts the alignment into blocks.
+func (r *Hit) layoutBlocks() {
+	r.Blocks = nil
+	if len(r.QuerySeq) != len(r.HitSeq) {
+		return
+	}
+
+	query := newAlignmentRow(r.QueryFrom, r.QueryTo, r.QuerySeq)
+	hit := newAlignmentRow(r.HitFrom, r.HitTo, r.HitSeq)
+	for start := 0; start < len(r.QuerySeq); start += alignmentWidth {
+		end := start + alignmentWidth
+		if end > len(r.QuerySeq) {
+			end = len(r.QuerySeq)
+		}
+
+		b := AlignmentBlock{
+			QuerySeq: r.QuerySeq[start:end],
+			HitSeq:   r.HitSeq[start:end],
+		}
+		if end <= len(r.Midline) {
+			b.Midline = r.Midline[start:end]
+		}
+		b.QueryFrom, b.QueryTo = query.advance(b.QuerySeq)
+		b.HitFrom, b.HitTo = hit.advance(b.HitSeq)
+
+		columns := make([]byte, len(b.QuerySeq))
+		for i := range columns {
+			q, h := b.QuerySeq[i], b.HitSeq[i]
+			switch {
+			case q == '-' || h == '-':
+				columns[i] = '-'
+			case unicode.ToUpper(rune(q)) == unicode.ToUpper(rune(h)):
+				columns[i] = '='
+			case i < len(b.Midline) && b.Midline[i] == '+':
+				columns[i] = '+'
+			default:
+				columns[i] = 'x'
+			}
+		}
+		b.Columns = string(columns)
+
+		r.Blocks = append(r.Blocks, b)
+	}
+}
+
+// LayoutAlignments cuts every hit's alignment into blocks, so the page and
+// API clients can show it a line at a time with positions and colors
+// rather than as one long string.
+func (r *Results) LayoutAlignments() {
+	for i := range r.Results {
+		r.Results[i].layoutBlocks()
+	}
+}
+
+// ExpandClusters follows each hit of a search against cluster
+// representatives with a hit for every other member of its cluster, a copy
+// of the representative's with Representative set. members are the other
+// members of each cluster, by the representative's hash. Call it before
+// LayoutAlignments, so each copy gets its own blocks.
+func (r *Results) ExpandClusters(members map[string][]string) {
+	r.Clustered = true
+
+	expanded := make([]Hit, 0, len(r.Results))
+	for _, hit := range r.Results {
+		expanded = append(expanded, hit)
+		for _, member := range members[hit.SeqHash] {
+			copied := hit
+			copied.SeqHash = member
+			copied.Representative = hit.SeqHash
+			expanded = append(expanded, copied)
+		}
+	}
+
+	r.Results = expanded
+}
+
+// UnwrapCircular maps the hits of a circular query that was searched twice
+// over back onto the query. A hit spanning the whole circle or more is
+// clamped to it, and hits of the same sequence that land on the same
+// stretch of the query, found in both copies of it, are cut down to the
+// best scoring one.
+func (r *Results) UnwrapCircular(queryLen int) {
+	r.Circular = true
+	r.QueryLen = queryLen
+	if queryLen == 0 {
+		return
+	}
+	for i := range r.Iterations {
+		r.Iterations[i].QueryLen = queryLen
+	}
+
+	type span struct {
+		seqHash, subject string
+		from, to         int
+		strand           string
+	}
+	best := map[span]int{}
+	unwrapped := r.Results[:0]
+	for _, hit := range r.Results {
+		length := hit.QueryTo - hit.QueryFrom + 1
+		if hit.QueryTo < hit.QueryFrom {
+			length = hit.QueryFrom - hit.QueryTo + 1
+		}
+		if length >= queryLen {
+			hit.QueryFrom, hit.QueryTo = 1, queryLen
+		} else {
+			hit.QueryFrom = (hit.QueryFrom-1)%queryLen + 1
+			hit.QueryTo = (hit.QueryTo-1)%queryLen + 1
+		}
+
+		for j := range hit.Blocks {
+			b := &hit.Blocks[j]
+			b.QueryFrom = (b.QueryFrom-1)%queryLen + 1
+			b.QueryTo = (b.QueryTo-1)%queryLen + 1
+		}
+
+		key := span{hit.SeqHash, hit.Subject, hit.QueryFrom, hit.QueryTo, hit.Strand}
+		if i, ok := best[key]; ok {
+			if hit.BitScore > unwrapped[i].BitScore {
+				unwrapped[i] = hit
+			}
+			continue
+		}
+		best[key] = len(unwrapped)
+		unwrapped = append(unwrapped, hit)
+	}
+	r.Results = unwrapped
+
+	r.ClassifyContainment()
+}
+
+// Input describes what a query was submitted as before NormalizeQuery
+// boiled it down to a bare sequence.
+type Input struct {
+	// Format is "fasta", "genbank" or "raw"
+	Format string `json:"format"`
+
+	// Header is the fasta header or genbank definition line, if there was
+	// one
+	Header string `json:"header,omitempty"`
+
+	// Removed is how many whitespace, digit and gap characters were dropped
+	// from the sequence
+	Removed int `json:"removed,omitempty"`
+
+	// RNA is set when the query was RNA, which is searched with its U's as
+	// T's
+	RNA bool `json:"rna,omitempty"`
+
+	// Records is how many FASTA records the query had, if more than one,
+	// see NormalizeQueries
+	Records int `json:"records,omitempty"`
+}
+
+// residueCodes are the characters blast accepts in a sequence: IUPAC
+// nucleotide and amino acid codes, and stop codons.
+const residueCodes = "ABCDEFGHIKLMNOPQRSTUVWXYZabcdefghiklmnopqrstuvwxyz*"
+
+// NormalizeQuery strips whatever a pasted query has besides the sequence
+// (fasta headers, genbank annotations, line numbers, whitespace, gaps),
+// checks what's left is something blast can search, and no longer than
+// maxLength residues unless that's 0, and puts it in canonical form with
+// sequence.Normalize.
+func NormalizeQuery(query string, maxLength int) (string, *Input, error) {
+	return NormalizeQueries(query, maxLength, 1)
+}
+
+// NormalizeQueries is NormalizeQuery for FASTA of up to maxRecords
+// records, which blast searches one after another, each its own Iteration.
+// A query of one record is normalized to its bare sequence, as
+// NormalizeQuery does it. Those of more have each record normalized and
+// are put back together as FASTA, with their headers, and maxLength limits
+// all their residues together.
+func NormalizeQueries(query string, maxLength, maxRecords int) (string, *Input, error) {
+	query = strings.TrimSpace(strings.Replace(query, "\r\n", "\n", -1))
+
+	var records []string
+	if strings.HasPrefix(query, ">") {
+		for _, record := range strings.Split("\n"+query, "\n>")[1:] {
+			records = append(records, ">"+record)
+		}
+	}
+	if len(records) > max(maxRecords, 1) {
+		if maxRecords <= 1 {
+			// blastn would search each record separately, but only the
+			// first one's results are parsed
+			return "", nil, fmt.Errorf("the query has %d FASTA records, only one can be searched at a time", len(records))
+		}
+		return "", nil, fmt.Errorf("the query has %d FASTA records, at most %d can be searched at a time", len(records), maxRecords)
+	}
+	if len(records) <= 1 {
+		return normalizeRecord(query, maxLength)
+	}
+
+	input := &Input{Format: "fasta", Records: len(records)}
+	fasta := strings.Builder{}
+	length := 0
+	for i, record := range records {
+		seq, recordInput, err := normalizeRecord(record, 0)
+		if err != nil {
+			return "", nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		if i == 0 {
+			input.Header = recordInput.Header
+		}
+		input.Removed += recordInput.Removed
+		input.RNA = input.RNA || recordInput.RNA
+		length += len(seq)
+
+		fmt.Fprintf(&fasta, ">%s\n%s\n", recordInput.Header, seq)
+	}
+	if maxLength > 0 && length > maxLength {
+		return "", nil, fmt.Errorf("the query's records are %d residues long together, searches are limited to %d", length, maxLength)
+	}
+
+	return fasta.String(), input, nil
+}
+
+// normalizeRecord is NormalizeQuery for a query of no more than one FASTA
+// record.
+func normalizeRecord(query string, maxLength int) (string, *Input, error) {
+	input := &Input{Format: "raw"}
+
+	lines := strings.Split(query, "\n")
+	switch {
+	case strings.HasPrefix(query, ">"):
+		input.Format = "fasta"
+		input.Header = strings.TrimSpace(strings.TrimPrefix(lines[0], ">"))
+		lines = lines[1:]
+
+		for i, line := range lines {
+			// some tools put comments after the header
+			if strings.HasPrefix(line, ";") {
+				lines[i] = ""
+			}
+		}
+
+	case strings.HasPrefix(query, "LOCUS"):
+		input.Format = "genbank"
+
+		origin := -1
+		for i, line := range lines {
+			if strings.HasPrefix(line, "DEFINITION") {
+				input.Header = strings.TrimSpace(strings.TrimPrefix(line, "DEFINITION"))
+			}
+			if strings.HasPrefix(line, "ORIGIN") {
+				origin = i
+				break
+			}
+		}
+		if origin == -1 {
+			return "", nil, errors.New("the genbank record has no sequence, it needs an ORIGIN section")
+		}
+
+		lines = lines[origin+1:]
+		for i, line := range lines {
+			if strings.HasPrefix(line, "//") {
+				lines = lines[:i]
+				break
+			}
+		}
+	}
+
+	seq := strings.Builder{}
+	for _, line := range lines {
+		for _, c := range line {
+			switch {
+			case strings.ContainsRune(residueCodes, c):
+				seq.WriteRune(c)
+			case unicode.IsSpace(c) || unicode.IsDigit(c) || c == '-' || c == '.':
+				input.Removed++
+			default:
+				return "", nil, fmt.Errorf("the query has %q in it, which isn't a nucleotide or amino acid code", c)
+			}
+		}
+	}
+
+	if seq.Len() == 0 {
+		return "", nil, errors.New("sequence is required")
+	}
+	if maxLength > 0 && seq.Len() > maxLength {
+		return "", nil, fmt.Errorf("the query is %d residues long, searches are limited to %d", seq.Len(), maxLength)
+	}
+
+	// the same form the slurper stores sequences in, so the hash of a
+	// query is the hash of the same sequence in the db
+	normalized, rna := sequence.Normalize(seq.String(), false)
+	input.RNA = rna
+
+	return normalized, input, nil
+}
+
+// iupacBases are the bases each IUPAC nucleotide code could be, a bit each.
+var iupacBases = map[byte]uint8{
+	'a': 1, 'c': 2, 'g': 4, 't': 8, 'u': 8,
+	'r': 1 | 4, 'y': 2 | 8, 's': 2 | 4, 'w': 1 | 8, 'k': 4 | 8, 'm': 1 | 2,
+	'b': 2 | 4 | 8, 'd': 1 | 4 | 8, 'h': 1 | 2 | 8, 'v': 1 | 2 | 4,
+	'n': 1 | 2 | 4 | 8,
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// HasAmbiguity reports whether a nucleotide sequence has any IUPAC
+// ambiguity codes, those standing for more than one base, in it.
+func HasAmbiguity(seq string) bool {
+	for i := 0; i < len(seq); i++ {
+		switch bases := iupacBases[lower(seq[i])]; bases {
+		case 0, 1, 2, 4, 8:
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// AmbiguousEqual reports whether two nucleotide sequences could be the
+// same, each ambiguity code matching any of the bases it stands for, so
+// acgn matches acgt and acgr but not acgc or acg.
+func AmbiguousEqual(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := 0; i < len(a); i++ {
+		x, y := lower(a[i]), lower(b[i])
+		if x != y && iupacBases[x]&iupacBases[y] == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParserVersion must be bumped whenever Decode starts extracting more from
+// blast's output, so -jobs.reparse knows which stored
+// jobs would benefit from being parsed again.
+const ParserVersion = 6
+
+// Decode reads blast's XML output (-outfmt 5) a hit at a time rather
+// than buffering the whole document, which can run to hundreds of MB for
+// big queries. It stops reading after maxHits hits if maxHits > 0.
+func Decode(r io.Reader, maxHits int) (*Results, error) {
+	return decode(r, maxHits, false)
+}
+
+// decode is Decode, which if partial keeps what it read of output cut off
+// partway through rather than failing, see DecodePartial.
+func decode(r io.Reader, maxHits int, partial bool) (*Results, error) {
+	results := &Results{ParserVersion: ParserVersion}
+
+	// the elements of the header we care about, everything else is skipped
+	header := map[string]interface{}{
+		"BlastOutput_program":   &results.Program,
+		"BlastOutput_version":   &results.Version,
+		"BlastOutput_reference": &results.Reference,
+		"BlastOutput_db":        &results.DB,
+		"BlastOutput_query-ID":  &results.QueryID,
+		"BlastOutput_query-def": &results.QueryDef,
+		"BlastOutput_query-len": &results.QueryLen,
+	}
+
+	// the iteration being read, whose elements are read one at a time
+	// rather than all at once so its hits can be streamed
+	var iteration *Iteration
+	iterationFields := map[string]func(*Iteration) interface{}{
+		"Iteration_iter-num":  func(it *Iteration) interface{} { return &it.Num },
+		"Iteration_query-ID":  func(it *Iteration) interface{} { return &it.QueryID },
+		"Iteration_query-def": func(it *Iteration) interface{} { return &it.QueryDef },
+		"Iteration_query-len": func(it *Iteration) interface{} { return &it.QueryLen },
+		"Iteration_message":   func(it *Iteration) interface{} { return &it.Message },
+	}
+
+	d := xml.NewDecoder(r)
+	sawRoot := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if partial && cutOff(err) {
+			results.Truncated = true
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch name := start.Name.Local; {
+		case name == "BlastOutput":
+			sawRoot = true
+		case header[name] != nil:
+			err = d.DecodeElement(header[name], &start)
+		case name == "Parameters":
+			results.Parameters = &Parameters{}
+			err = d.DecodeElement(results.Parameters, &start)
+		case name == "Iteration":
+			results.Iterations = append(results.Iterations, Iteration{})
+			iteration = &results.Iterations[len(results.Iterations)-1]
+		case iteration != nil && iterationFields[name] != nil:
+			err = d.DecodeElement(iterationFields[name](iteration), &start)
+		case name == "Hit":
+			hit := Hit{}
+			err = d.DecodeElement(&hit, &start)
+			if err != nil {
+				// the hit is only part read
+				break
+			}
+			hit.Strand = FrameStrand(hit.QueryFrame, hit.HitFrame)
+			if iteration != nil {
+				hit.Iteration = iteration.Num
+				iteration.Hits++
+			}
+			results.Results = append(results.Results, hit)
+
+			if maxHits > 0 && len(results.Results) >= maxHits {
+				results.Truncated = true
+				results.summarizeIterations()
+				return results, nil
+			}
+		case name == "Statistics":
+			stats := &Statistics{}
+			err = d.DecodeElement(stats, &start)
+			if iteration != nil {
+				iteration.Statistics = stats
+			}
+			// the first query's, which is the only one here
+			if results.DBNum == 0 {
+				results.DBNum, results.DBLen = stats.DBNum, stats.DBLen
+				if p := results.Parameters; p != nil {
+					p.HSPLen, p.EffectiveSpace = stats.HSPLen, stats.EffectiveSpace
+					p.Kappa, p.Lambda, p.Entropy = stats.Kappa, stats.Lambda, stats.Entropy
+				}
+			}
+		}
+		if partial && cutOff(err) {
+			results.Truncated = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !sawRoot {
+		return nil, errors.New("blast output has no BlastOutput element")
+	}
+	results.summarizeIterations()
+
+	return results, nil
+}
+
+// summarizeIterations sets Message and Skipped from the iterations that
+// found nothing, a query blast didn't search outweighing one it found no
+// hits for.
+func (r *Results) summarizeIterations() {
+	for _, it := range r.Iterations {
+		if it.Hits > 0 || it.Message == "" || r.Skipped {
+			continue
+		}
+
+		r.Message = it.Message
+		r.Skipped = it.Message != NoHitsMessage
+	}
+}
+
+// ErrTimeout is returned when blast was killed for taking too long.
+var ErrTimeout = errors.New("blast query took too long")
+
+// ErrDBUnavailable is returned when blast can't open the db, e.g. while
+// it's being rebuilt by hand.
+var ErrDBUnavailable = errors.New("the blast db isn't available")
+
+// Warnings splits blastn's stderr into its non-empty lines.
+func Warnings(stderr string) []string {
+	var lines []string
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// HeadBuffer keeps the first Max bytes written to it and drops the rest,
+// for keeping what an aligner says on stderr without it being able to run
+// us out of memory.
+type HeadBuffer struct {
+	bytes.Buffer
+	Max int
+}
+
+func (h *HeadBuffer) Write(p []byte) (int, error) {
+	if room := h.Max - h.Len(); room > 0 {
+		if len(p) > room {
+			h.Buffer.Write(p[:room])
+		} else {
+			h.Buffer.Write(p)
+		}
+	}
+
+	return len(p), nil
+}
+
+// Version runs blastn -version, which prints something like
+//
+//	blastn: 2.7.1+
+//	 Package: blast 2.7.1, build Oct 18 2017 19:57:24
+//
+// and returns the version from the first line.
+func Version(ctx context.Context, binary string) (string, error) {
+	out, err := exec.CommandContext(ctx, binary, "-version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+
+	line := strings.SplitN(string(out), "\n", 2)[0]
+	if !strings.HasPrefix(line, "blastn: ") {
+		return "", fmt.Errorf("unexpected output from %s -version: %q", binary, line)
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(line, "blastn: ")), nil
+}
+
+// Find resolves the blastn binary to an absolute path and makes sure it
+// actually runs, so a bad install is caught at startup rather than on the
+// first query. It returns the path and blastn's version.
+func Find(binary string) (string, string, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return "", "", err
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	version, err := Version(ctx, path)
+	if err != nil {
+		return "", "", err
+	}
+
+	return path, version, nil
+}
+
+// Command sets up blastn or diamond to run against the blast dbs in dbDir
+// until ctx is done. Environment variables in dbDir are expanded.
+func Command(ctx context.Context, binary, dbDir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, binary, args...)
+	blastdb := "BLASTDB=" + os.ExpandEnv(dbDir)
+	cmd.Env = append(os.Environ(), blastdb)
+	logging.From(ctx).Debug("running aligner", "binary", binary, "blastdb", dbDir)
+
+	// blastn can fork helpers, so it gets its own process group and the
+	// whole group is killed on cancellation rather than just blastn
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	return cmd
+}
+
+// ExitError is the error for blastn having failed with err, marked as
+// ErrDBUnavailable if its stderr says it couldn't open the db.
+func ExitError(err error, stderr string) error {
+	if strings.Contains(stderr, "BLAST Database error") {
+		return fmt.Errorf("%w: %v", ErrDBUnavailable, err)
+	}
+
+	return err
+}
+
+// Search is a blastn run.
+type Search struct {
+	// Binary is the blastn to run, DBDir the directory it finds dbs in
+	Binary string
+	DBDir  string
+
+	// Args are passed to blastn as is, and have to ask for XML output
+	// (-outfmt 5), or single file JSON (-outfmt 15) if blastn supports it,
+	// see SupportsJSON
+	Args []string
+
+	// Timeout is how long blastn can run before it's killed, unlimited if
+	// 0
+	Timeout time.Duration
+
+	// MaxHits stops blastn once it has found that many hits, unlimited if
+	// 0. JSON output is only cut down to it once blastn is done, see
+	// DecodeJSON.
+	MaxHits int
+
+	// Raw gets a copy of blastn's output if it's set
+	Raw io.Writer
+}
+
+// Run searches for query, which is fed to blastn on stdin. blastn is killed
+// if ctx is cancelled or it runs longer than s.Timeout. If it fails the
+// Results returned say what it printed on stderr.
+func (s Search) Run(ctx context.Context, query string) (*Results, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := Command(ctx, s.Binary, s.DBDir, s.Args...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer stdin.Close()
+		io.WriteString(stdin, query)
+	}()
+
+	// parse the output as it's written instead of buffering all of it.
+	// stderr is kept apart so warnings don't end up in the middle of the
+	// output, it's the error message if blastn fails.
+	pr, pw := io.Pipe()
+	stderr := &HeadBuffer{Max: 64 * 1024}
+	cmd.Stdout = pw
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	// ran is set before waitErr is sent
+	var ran time.Duration
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		ran = time.Since(start)
+		pw.Close()
+		waitErr <- err
+	}()
+
+	out := &readTimer{r: pr}
+	if s.Raw != nil {
+		out.r = io.TeeReader(pr, s.Raw)
+	}
+
+	_, span := tracer.Start(ctx, "parse blast output")
+	parseStart := time.Now()
+	results, parseErr := DecodeOutput(out, s.MaxHits)
+	parsing := time.Since(parseStart) - out.blocked
+	if parseErr != nil {
+		span.RecordError(parseErr)
+		span.SetStatus(otelcodes.Error, parseErr.Error())
+	}
+	span.End()
+	if results != nil && results.Truncated {
+		logging.From(ctx).Info("stopping blastn early", "hits", s.MaxHits)
+		cancel()
+	}
+	// unblocks blastn if we stopped reading before it was done writing
+	pr.Close()
+
+	err = <-waitErr
+	if results != nil && results.Truncated {
+		err = nil
+	} else if errors.Is(ctx.Err(), context.DeadlineExceeded) && s.Timeout > 0 {
+		return &Results{Query: query}, fmt.Errorf("%w: killed after %v", ErrTimeout, s.Timeout)
+	} else if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &Results{Query: query}, ErrTimeout
+	} else if ctx.Err() != nil {
+		return &Results{Query: query}, ctx.Err()
+	}
+	if err != nil {
+		return &Results{Error: stderr.String(), Query: query}, ExitError(err, stderr.String())
+	}
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	// blastn exited 0, so anything it had to say was just a warning
+	results.Warnings = Warnings(stderr.String())
+	results.Timings.Aligner = ran
+	results.Timings.Parse = parsing
+
+	return results, nil
+}
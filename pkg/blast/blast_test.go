@@ -0,0 +1,474 @@
+package blast_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/render"
+	"github.com/schnauzer/synbioblast/pkg/synbiotest"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		maxLength int
+		want      string
+		input     blast.Input
+		err       string
+	}{
+		{
+			name:  "raw",
+			query: "ACGT acgt\n",
+			want:  "acgtacgt",
+			input: blast.Input{Format: "raw", Removed: 1},
+		},
+		{
+			name:  "numbered with gaps",
+			query: "1 acg-t\r\n6 ac.gt",
+			want:  "acgtacgt",
+			input: blast.Input{Format: "raw", Removed: 6},
+		},
+		{
+			name:  "fasta",
+			query: ">BBa_B0034 RBS\n;a comment\naaagag\ngagaaa\n",
+			want:  "aaagaggagaaa",
+			input: blast.Input{Format: "fasta", Header: "BBa_B0034 RBS"},
+		},
+		{
+			name:  "genbank",
+			query: "LOCUS       BBa_B0034  12 bp\nDEFINITION  RBS.\nFEATURES\nORIGIN\n        1 aaagaggaga aa\n//\n",
+			want:  "aaagaggagaaa",
+			input: blast.Input{Format: "genbank", Header: "RBS.", Removed: 11},
+		},
+		{
+			name:  "rna",
+			query: "AAAGAGGAGAAA" + "UU",
+			want:  "aaagaggagaaatt",
+			input: blast.Input{Format: "raw", RNA: true},
+		},
+		{
+			name:  "protein",
+			query: "MKV*",
+			want:  "mkv*",
+			input: blast.Input{Format: "raw"},
+		},
+		{
+			name:      "at the limit",
+			query:     "acgt",
+			maxLength: 4,
+			want:      "acgt",
+			input:     blast.Input{Format: "raw"},
+		},
+		{
+			name:      "too long",
+			query:     "acgta",
+			maxLength: 4,
+			err:       "searches are limited to 4",
+		},
+		{
+			name:  "two records",
+			query: ">a\nacgt\n>b\nacgt",
+			err:   "has 2 FASTA records",
+		},
+		{
+			name:  "genbank without a sequence",
+			query: "LOCUS       BBa_B0034\nDEFINITION  RBS.\n//",
+			err:   "needs an ORIGIN section",
+		},
+		{
+			name:  "not residues",
+			query: "acgt!",
+			err:   "'!'",
+		},
+		{
+			name:  "empty",
+			query: " \n",
+			err:   "sequence is required",
+		},
+		{
+			name:  "header only",
+			query: ">BBa_B0034",
+			err:   "sequence is required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, input, err := blast.NormalizeQuery(test.query, test.maxLength)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("err = %v, want one containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("seq = %q, want %q", got, test.want)
+			}
+			if *input != test.input {
+				t.Errorf("input = %+v, want %+v", *input, test.input)
+			}
+		})
+	}
+}
+
+func TestNormalizeQueries(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		maxLength  int
+		maxRecords int
+		want       string
+		input      blast.Input
+		err        string
+	}{
+		{
+			name:       "one record",
+			query:      ">BBa_B0034 RBS\naaagag gagaaa\n",
+			maxRecords: 3,
+			want:       "aaagaggagaaa",
+			input:      blast.Input{Format: "fasta", Header: "BBa_B0034 RBS", Removed: 1},
+		},
+		{
+			name:       "two records",
+			query:      ">BBa_B0034 RBS\naaagaggagaaa\n>BBa_B0030\r\nattaaagaggagaaa\n",
+			maxRecords: 2,
+			want:       ">BBa_B0034 RBS\naaagaggagaaa\n>BBa_B0030\nattaaagaggagaaa\n",
+			input:      blast.Input{Format: "fasta", Header: "BBa_B0034 RBS", Records: 2},
+		},
+		{
+			name:       "rna record",
+			query:      ">a\nacgt\n>b\nacgu",
+			maxRecords: 2,
+			want:       ">a\nacgt\n>b\nacgt\n",
+			input:      blast.Input{Format: "fasta", Header: "a", RNA: true, Records: 2},
+		},
+		{
+			name:       "too many records",
+			query:      ">a\nacgt\n>b\nacgt\n>c\nacgt",
+			maxRecords: 2,
+			err:        "at most 2 can be searched",
+		},
+		{
+			name:  "more than one unasked for",
+			query: ">a\nacgt\n>b\nacgt",
+			err:   "only one can be searched",
+		},
+		{
+			name:       "too long together",
+			query:      ">a\nacgt\n>b\nacgt",
+			maxLength:  6,
+			maxRecords: 2,
+			err:        "8 residues long together",
+		},
+		{
+			name:       "bad record",
+			query:      ">a\nacgt\n>b\nac!gt",
+			maxRecords: 2,
+			err:        "record 2",
+		},
+		{
+			name:       "empty record",
+			query:      ">a\nacgt\n>b\n",
+			maxRecords: 2,
+			err:        "sequence is required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, input, err := blast.NormalizeQueries(test.query, test.maxLength, test.maxRecords)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("err = %v, want one containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("seq = %q, want %q", got, test.want)
+			}
+			if *input != test.input {
+				t.Errorf("input = %+v, want %+v", *input, test.input)
+			}
+		})
+	}
+}
+
+const query = "aaagaggagaaa"
+
+var hashes = []string{
+	"v2-48752e49fec8b5ae25860bf7c4f4a0f01cc3f12e142ba4bff4914e4f68374cde",
+	"9482340281b5fc8f2a298dbbd6b82fe42159b6c5",
+}
+
+func TestDecode(t *testing.T) {
+	xml := synbiotest.ResultsXML(query, hashes...)
+	tests := []struct {
+		name      string
+		output    []byte
+		maxHits   int
+		hits      []string
+		truncated bool
+		message   string
+		err       bool
+	}{
+		{name: "hits", output: xml, hits: hashes},
+		{name: "under maxHits", output: xml, maxHits: 3, hits: hashes},
+		{name: "over maxHits", output: xml, maxHits: 1, hits: hashes[:1], truncated: true},
+		{name: "no hits", output: synbiotest.ResultsXML(query), message: blast.NoHitsMessage},
+		{name: "cut off", output: xml[:len(xml)/2], err: true},
+		{name: "not blast", output: []byte("<html></html>"), err: true},
+		{name: "not xml", output: []byte("BLASTN 2.7.1+"), err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := blast.Decode(bytes.NewReader(test.output), test.maxHits)
+			if test.err {
+				if err == nil {
+					t.Fatal("err = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkHits(t, results, test.hits)
+			if results.Truncated != test.truncated {
+				t.Errorf("Truncated = %v, want %v", results.Truncated, test.truncated)
+			}
+			if results.Message != test.message {
+				t.Errorf("Message = %q, want %q", results.Message, test.message)
+			}
+			if results.QueryLen != len(query) || results.Program != "blastn" {
+				t.Errorf("header = %q, %d, want blastn, %d", results.Program, results.QueryLen, len(query))
+			}
+		})
+	}
+}
+
+// checkHits checks results has a hit of each of hashes, in order, as
+// ResultsXML writes them.
+func checkHits(t *testing.T, results *blast.Results, hashes []string) {
+	t.Helper()
+
+	if len(results.Results) != len(hashes) {
+		t.Fatalf("%d hits, want %d", len(results.Results), len(hashes))
+	}
+	for i, hit := range results.Results {
+		if hit.SeqHash != hashes[i] {
+			t.Errorf("hit %d is of %q, want %q", i, hit.SeqHash, hashes[i])
+		}
+		if hit.QueryFrom != 1 || hit.QueryTo != len(query) || hit.Identity != len(query) || hit.EValue != 1e-10 {
+			t.Errorf("hit %d = %+v, want all of the query, identical", i, hit)
+		}
+		if hit.Strand != "plus" {
+			t.Errorf("hit %d is on %q, want plus", i, hit.Strand)
+		}
+	}
+}
+
+func TestDecodeOutput(t *testing.T) {
+	xml := synbiotest.ResultsXML(query, hashes...)
+	fromXML, err := blast.Decode(bytes.NewReader(xml), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := json.Marshal(render.ToBlastJSON(fromXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		output  []byte
+		maxHits int
+		hits    []string
+	}{
+		{"xml", xml, 0, hashes},
+		{"json", fromJSON, 0, hashes},
+		{"json with whitespace first", append([]byte("\n  "), fromJSON...), 0, hashes},
+		{"json over maxHits", fromJSON, 1, hashes[:1]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := blast.DecodeOutput(bytes.NewReader(test.output), test.maxHits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkHits(t, results, test.hits)
+			if results.DBNum != len(hashes) {
+				t.Errorf("DBNum = %d, want %d", results.DBNum, len(hashes))
+			}
+		})
+	}
+}
+
+func TestDecodePartial(t *testing.T) {
+	xml := synbiotest.ResultsXML(query, hashes...)
+	// just after the first hit
+	firstHit := bytes.Index(xml, []byte("</Hit>")) + len("</Hit>")
+
+	tests := []struct {
+		name      string
+		output    []byte
+		hits      []string
+		truncated bool
+	}{
+		{"whole", xml, hashes, false},
+		{"after a hit", xml[:firstHit], hashes[:1], true},
+		{"in a hit", xml[:firstHit+100], hashes[:1], true},
+		{"in the header", xml[:200], nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := blast.DecodePartial(bytes.NewReader(test.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkHits(t, results, test.hits)
+			if results.Truncated != test.truncated {
+				t.Errorf("Truncated = %v, want %v", results.Truncated, test.truncated)
+			}
+		})
+	}
+}
+
+func TestHitUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json   string
+		evalue float64
+	}{
+		{`{"evalue": 1e-10}`, 1e-10},
+		{`{"evalue": 0}`, 0},
+		{`{"evalue": -1}`, -1},
+		{`{"evalue": "1e-10"}`, 1e-10},
+		{`{"evalue": "0.0"}`, 0},
+		{`{"evalue": ""}`, -1},
+		{`{"evalue": null}`, -1},
+		{`{}`, 0},
+	}
+
+	for _, test := range tests {
+		hit := blast.Hit{}
+		err := json.Unmarshal([]byte(test.json), &hit)
+		if err != nil {
+			t.Errorf("%s: %v", test.json, err)
+			continue
+		}
+		if hit.EValue != test.evalue {
+			t.Errorf("%s: EValue = %g, want %g", test.json, hit.EValue, test.evalue)
+		}
+	}
+
+	hit := blast.Hit{}
+	if err := json.Unmarshal([]byte(`{"evalue": "lots"}`), &hit); err == nil {
+		t.Error(`"lots": err = nil, want an error`)
+	}
+}
+
+func TestSupportsJSON(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"2.4.0+", false},
+		{"2.5.0+", true},
+		{"2.7.1+", true},
+		{"2.10.0+", true},
+		{"3.0.0", true},
+		{"1.9.9", false},
+		{"2.5", false},
+		{"", false},
+		{"2.x.0", false},
+	}
+
+	for _, test := range tests {
+		if got := blast.SupportsJSON(test.version); got != test.want {
+			t.Errorf("SupportsJSON(%q) = %v, want %v", test.version, got, test.want)
+		}
+	}
+}
+
+func TestUnwrapCircular(t *testing.T) {
+	type span struct{ from, to int }
+	tests := []struct {
+		name string
+		hits []blast.Hit
+		want []span
+	}{
+		{
+			name: "in the first copy",
+			hits: []blast.Hit{{SeqHash: "a", QueryFrom: 2, QueryTo: 5}},
+			want: []span{{2, 5}},
+		},
+		{
+			name: "across the origin",
+			hits: []blast.Hit{{SeqHash: "a", QueryFrom: 8, QueryTo: 13}},
+			want: []span{{8, 3}},
+		},
+		{
+			name: "the whole circle",
+			hits: []blast.Hit{{SeqHash: "a", QueryFrom: 4, QueryTo: 13}},
+			want: []span{{1, 10}},
+		},
+		{
+			name: "more than the whole circle",
+			hits: []blast.Hit{{SeqHash: "a", QueryFrom: 3, QueryTo: 18}},
+			want: []span{{1, 10}},
+		},
+		{
+			name: "in both copies",
+			hits: []blast.Hit{
+				{SeqHash: "a", QueryFrom: 2, QueryTo: 5, HitStats: blast.HitStats{BitScore: 10}},
+				{SeqHash: "a", QueryFrom: 12, QueryTo: 15, HitStats: blast.HitStats{BitScore: 20}},
+			},
+			want: []span{{2, 5}},
+		},
+		{
+			name: "different sequences in both copies",
+			hits: []blast.Hit{
+				{SeqHash: "a", QueryFrom: 2, QueryTo: 5},
+				{SeqHash: "b", QueryFrom: 12, QueryTo: 15},
+			},
+			want: []span{{2, 5}, {2, 5}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &blast.Results{Results: test.hits}
+			r.UnwrapCircular(10)
+
+			got := []span{}
+			for _, hit := range r.Results {
+				got = append(got, span{hit.QueryFrom, hit.QueryTo})
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("spans = %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	r := &blast.Results{Results: []blast.Hit{
+		{SeqHash: "a", QueryFrom: 2, QueryTo: 5, HitStats: blast.HitStats{BitScore: 10}},
+		{SeqHash: "a", QueryFrom: 12, QueryTo: 15, HitStats: blast.HitStats{BitScore: 20}},
+	}}
+	r.UnwrapCircular(10)
+	if r.Results[0].BitScore != 20 {
+		t.Errorf("kept the hit scoring %g, want the best, 20", r.Results[0].BitScore)
+	}
+}
@@ -0,0 +1,97 @@
+package blast
+
+import "slices"
+
+// FilterByDescription drops the components of each hit that aren't in
+// matching, those whose title and description contain every word of query,
+// and then any hits left without components. Hits whose components couldn't
+// be looked up are left in, as there's no telling whether they match.
+func (r *Results) FilterByDescription(query string, matching map[string]bool) {
+	results := r.Results[:0]
+	for _, result := range r.Results {
+		if result.URIsUnavailable {
+			results = append(results, result)
+			continue
+		}
+
+		uris := []string{}
+		for _, uri := range result.URIs {
+			if matching[uri] {
+				uris = append(uris, uri)
+			}
+		}
+
+		if len(uris) > 0 {
+			result.URIs = uris
+			results = append(results, result)
+		}
+	}
+
+	r.Results = results
+	r.NumResults = len(results)
+	r.DescriptionFilter = query
+}
+
+// FilterByRole drops the components of each hit without any of roles,
+// Sequence Ontology terms, and then any hits left without components.
+// componentRoles are the roles of the components of each hit by URI, and
+// unknown the hits whose roles couldn't be looked up, which are left in
+// like those whose components couldn't be.
+func (r *Results) FilterByRole(roles []string, componentRoles []map[string][]string, unknown map[int]bool) {
+	wanted := map[string]bool{}
+	for _, role := range roles {
+		wanted[role] = true
+	}
+
+	results := r.Results[:0]
+	for i, result := range r.Results {
+		if result.URIsUnavailable || unknown[i] {
+			results = append(results, result)
+			continue
+		}
+
+		uris := []string{}
+		hitRoles := []string{}
+		for _, uri := range result.URIs {
+			if !slices.ContainsFunc(componentRoles[i][uri], func(role string) bool { return wanted[role] }) {
+				continue
+			}
+
+			uris = append(uris, uri)
+			for _, role := range componentRoles[i][uri] {
+				if !slices.Contains(hitRoles, role) {
+					hitRoles = append(hitRoles, role)
+				}
+			}
+		}
+
+		if len(uris) > 0 {
+			result.URIs = uris
+			result.Roles = hitRoles
+			results = append(results, result)
+		}
+	}
+
+	r.Results = results
+	r.NumResults = len(results)
+	r.RoleFilter = roles
+}
+
+// FilterByContainment drops the hits whose Containment isn't one of
+// containments, doing nothing if there are none.
+func (r *Results) FilterByContainment(containments []string) {
+	if len(containments) == 0 {
+		return
+	}
+
+	results := r.Results[:0]
+	for _, result := range r.Results {
+		if slices.Contains(containments, result.Containment) {
+			results = append(results, result)
+		}
+	}
+
+	r.Results = results
+	r.NumResults = len(results)
+	r.ContainmentFilter = containments
+}
@@ -0,0 +1,110 @@
+package blast_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+// filterResults are three hits, the second's components unavailable.
+func filterResults() *blast.Results {
+	return &blast.Results{Results: []blast.Hit{
+		{SeqHash: "a", URIs: []string{"a1", "a2"}, Containment: blast.ContainmentQueryContainsPart},
+		{SeqHash: "b", URIsUnavailable: true, Containment: blast.ContainmentPartContainsQuery},
+		{SeqHash: "c", URIs: []string{"c1"}, Containment: blast.ContainmentPartialOverlap},
+	}}
+}
+
+// hitURIs are the hashes of r's hits and their components.
+func hitURIs(r *blast.Results) map[string][]string {
+	uris := map[string][]string{}
+	for _, hit := range r.Results {
+		uris[hit.SeqHash] = hit.URIs
+	}
+	return uris
+}
+
+func TestFilterByDescription(t *testing.T) {
+	tests := []struct {
+		name     string
+		matching map[string]bool
+		want     map[string][]string
+	}{
+		{"none", map[string]bool{}, map[string][]string{"b": nil}},
+		{"some of a hit", map[string]bool{"a2": true}, map[string][]string{"a": {"a2"}, "b": nil}},
+		{"all", map[string]bool{"a1": true, "a2": true, "c1": true}, map[string][]string{"a": {"a1", "a2"}, "b": nil, "c": {"c1"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := filterResults()
+			r.FilterByDescription("promoter", test.matching)
+			if got := hitURIs(r); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("hits = %v, want %v", got, test.want)
+			}
+			if r.NumResults != len(test.want) || r.DescriptionFilter != "promoter" {
+				t.Errorf("NumResults, DescriptionFilter = %d, %q, want %d, promoter", r.NumResults, r.DescriptionFilter, len(test.want))
+			}
+		})
+	}
+}
+
+func TestFilterByRole(t *testing.T) {
+	roles := []map[string][]string{
+		{"a1": {"SO:0000167"}, "a2": {"SO:0000141"}},
+		nil,
+		{"c1": {"SO:0000316", "SO:0000141"}},
+	}
+	tests := []struct {
+		name    string
+		roles   []string
+		unknown map[int]bool
+		want    map[string][]string
+	}{
+		{"one", []string{"SO:0000167"}, nil, map[string][]string{"a": {"a1"}, "b": nil}},
+		{"two", []string{"SO:0000167", "SO:0000316"}, nil, map[string][]string{"a": {"a1"}, "b": nil, "c": {"c1"}}},
+		{"any of a component's", []string{"SO:0000141"}, nil, map[string][]string{"a": {"a2"}, "b": nil, "c": {"c1"}}},
+		{"unknown roles", []string{"SO:0000167"}, map[int]bool{2: true}, map[string][]string{"a": {"a1"}, "b": nil, "c": {"c1"}}},
+		{"no such role", []string{"SO:0000139"}, nil, map[string][]string{"b": nil}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := filterResults()
+			r.FilterByRole(test.roles, roles, test.unknown)
+			if got := hitURIs(r); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("hits = %v, want %v", got, test.want)
+			}
+			if r.NumResults != len(test.want) || !reflect.DeepEqual(r.RoleFilter, test.roles) {
+				t.Errorf("NumResults, RoleFilter = %d, %q, want %d, %q", r.NumResults, r.RoleFilter, len(test.want), test.roles)
+			}
+		})
+	}
+}
+
+func TestFilterByContainment(t *testing.T) {
+	tests := []struct {
+		name         string
+		containments []string
+		want         []string
+	}{
+		{"none", nil, []string{"a", "b", "c"}},
+		{"one", []string{blast.ContainmentPartialOverlap}, []string{"c"}},
+		{"two", []string{blast.ContainmentQueryContainsPart, blast.ContainmentPartContainsQuery}, []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := filterResults()
+			r.FilterByContainment(test.containments)
+			got := []string{}
+			for _, hit := range r.Results {
+				got = append(got, hit.SeqHash)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("hits = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package blast
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// minJSONVersion is the first blastn to write single file JSON, -outfmt
+// 15.
+var minJSONVersion = [3]int{2, 5, 0}
+
+// SupportsJSON reports whether a blastn of version, as Version gives it,
+// can write single file JSON output, which DecodeJSON parses quicker than
+// Decode does XML.
+func SupportsJSON(version string) bool {
+	parts := strings.Split(strings.TrimSuffix(version, "+"), ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return false
+		}
+		if n != minJSONVersion[i] {
+			return n > minJSONVersion[i]
+		}
+	}
+
+	return true
+}
+
+// jsonOutput is the part of blast's single file JSON output, one report
+// per query, that's read.
+type jsonOutput struct {
+	BlastOutput2 []struct {
+		Report jsonReport `json:"report"`
+	} `json:"BlastOutput2"`
+}
+
+type jsonReport struct {
+	Program      string `json:"program"`
+	Version      string `json:"version"`
+	Reference    string `json:"reference"`
+	SearchTarget struct {
+		DB string `json:"db"`
+	} `json:"search_target"`
+	Params  *jsonParams `json:"params"`
+	Results struct {
+		Search jsonSearch `json:"search"`
+	} `json:"results"`
+}
+
+type jsonParams struct {
+	Matrix      string  `json:"matrix"`
+	Expect      float64 `json:"expect"`
+	ScMatch     int     `json:"sc_match"`
+	ScMismatch  int     `json:"sc_mismatch"`
+	GapOpen     int     `json:"gap_open"`
+	GapExtend   int     `json:"gap_extend"`
+	Filter      string  `json:"filter"`
+	EntrezQuery string  `json:"entrez_query"`
+}
+
+type jsonSearch struct {
+	QueryID    string    `json:"query_id"`
+	QueryTitle string    `json:"query_title"`
+	QueryLen   int       `json:"query_len"`
+	Message    string    `json:"message"`
+	Hits       []jsonHit `json:"hits"`
+	Stat       struct {
+		DBNum    int     `json:"db_num"`
+		DBLen    int     `json:"db_len"`
+		HSPLen   int     `json:"hsp_len"`
+		EffSpace float64 `json:"eff_space"`
+		Kappa    float64 `json:"kappa"`
+		Lambda   float64 `json:"lambda"`
+		Entropy  float64 `json:"entropy"`
+	} `json:"stat"`
+}
+
+type jsonHit struct {
+	Num         int `json:"num"`
+	Description []struct {
+		ID        string `json:"id"`
+		Accession string `json:"accession"`
+		Title     string `json:"title"`
+	} `json:"description"`
+	Len  int       `json:"len"`
+	HSPs []jsonHSP `json:"hsps"`
+}
+
+type jsonHSP struct {
+	BitScore    float64 `json:"bit_score"`
+	Score       int     `json:"score"`
+	EValue      float64 `json:"evalue"`
+	Identity    int     `json:"identity"`
+	QueryFrom   int     `json:"query_from"`
+	QueryTo     int     `json:"query_to"`
+	QueryStrand string  `json:"query_strand"`
+	HitFrom     int     `json:"hit_from"`
+	HitTo       int     `json:"hit_to"`
+	HitStrand   string  `json:"hit_strand"`
+	AlignLen    int     `json:"align_len"`
+	Gaps        int     `json:"gaps"`
+	QuerySeq    string  `json:"qseq"`
+	HitSeq      string  `json:"hseq"`
+	Midline     string  `json:"midline"`
+}
+
+// strandFrame is the frame XML output gives for a strand in JSON output.
+func strandFrame(strand string) int {
+	if strand == "Minus" {
+		return -1
+	}
+	return 1
+}
+
+// DecodeJSON reads blast's single file JSON output (-outfmt 15) into the
+// same Results Decode reads its XML into. The output is read whole, so
+// blastn has to be stopped at maxHits itself with -max_target_seqs, and
+// the hits after maxHits are dropped if maxHits > 0.
+func DecodeJSON(r io.Reader, maxHits int) (*Results, error) {
+	out := jsonOutput{}
+	err := json.NewDecoder(r).Decode(&out)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse blast json: %v", err)
+	}
+	if len(out.BlastOutput2) == 0 {
+		return nil, errors.New("blast output has no reports")
+	}
+
+	first := out.BlastOutput2[0].Report
+	results := &Results{
+		ParserVersion: ParserVersion,
+		Program:       first.Program,
+		Version:       first.Version,
+		Reference:     first.Reference,
+		DB:            first.SearchTarget.DB,
+		QueryID:       first.Results.Search.QueryID,
+		QueryDef:      first.Results.Search.QueryTitle,
+		QueryLen:      first.Results.Search.QueryLen,
+		DBNum:         first.Results.Search.Stat.DBNum,
+		DBLen:         first.Results.Search.Stat.DBLen,
+	}
+
+	for i, output := range out.BlastOutput2 {
+		search := output.Report.Results.Search
+		stats := &Statistics{
+			DBNum:          search.Stat.DBNum,
+			DBLen:          search.Stat.DBLen,
+			HSPLen:         search.Stat.HSPLen,
+			EffectiveSpace: search.Stat.EffSpace,
+			Kappa:          search.Stat.Kappa,
+			Lambda:         search.Stat.Lambda,
+			Entropy:        search.Stat.Entropy,
+		}
+		iteration := Iteration{
+			Num:        i + 1,
+			QueryID:    search.QueryID,
+			QueryDef:   search.QueryTitle,
+			QueryLen:   search.QueryLen,
+			Hits:       len(search.Hits),
+			Message:    search.Message,
+			Statistics: stats,
+		}
+		results.Iterations = append(results.Iterations, iteration)
+
+		if p := output.Report.Params; i == 0 && p != nil {
+			results.Parameters = &Parameters{
+				Matrix:         p.Matrix,
+				Expect:         p.Expect,
+				Match:          p.ScMatch,
+				Mismatch:       p.ScMismatch,
+				GapOpen:        p.GapOpen,
+				GapExtend:      p.GapExtend,
+				Filter:         p.Filter,
+				EntrezQuery:    p.EntrezQuery,
+				HSPLen:         stats.HSPLen,
+				EffectiveSpace: stats.EffectiveSpace,
+				Kappa:          stats.Kappa,
+				Lambda:         stats.Lambda,
+				Entropy:        stats.Entropy,
+			}
+		}
+
+		for _, h := range search.Hits {
+			if maxHits > 0 && len(results.Results) >= maxHits {
+				results.Truncated = true
+				break
+			}
+			if len(h.HSPs) == 0 {
+				continue
+			}
+
+			// only the best alignment is kept, as from XML, and the
+			// defline is the sequence's hash
+			hsp := h.HSPs[0]
+			hit := Hit{
+				Num:       h.Num,
+				Len:       h.Len,
+				Iteration: iteration.Num,
+				HitStats: HitStats{
+					BitScore: hsp.BitScore,
+					Score:    hsp.Score,
+					EValue:   hsp.EValue,
+					Identity: hsp.Identity,
+					Gaps:     hsp.Gaps,
+					AlignLen: hsp.AlignLen,
+				},
+				QueryFrom:  hsp.QueryFrom,
+				QueryTo:    hsp.QueryTo,
+				HitFrom:    hsp.HitFrom,
+				HitTo:      hsp.HitTo,
+				QueryFrame: strandFrame(hsp.QueryStrand),
+				HitFrame:   strandFrame(hsp.QueryStrand) * strandFrame(hsp.HitStrand),
+				QuerySeq:   hsp.QuerySeq,
+				Midline:    hsp.Midline,
+				HitSeq:     hsp.HitSeq,
+			}
+			if len(h.Description) > 0 {
+				hit.ID = h.Description[0].ID
+				hit.Accession = h.Description[0].Accession
+				hit.SeqHash = h.Description[0].Title
+			}
+			hit.Strand = FrameStrand(hit.QueryFrame, hit.HitFrame)
+
+			results.Results = append(results.Results, hit)
+		}
+	}
+	results.summarizeIterations()
+
+	return results, nil
+}
+
+// DecodeOutput reads blast's output in whichever of XML (-outfmt 5) and
+// single file JSON (-outfmt 15) it's in, with Decode or DecodeJSON.
+func DecodeOutput(r io.Reader, maxHits int) (*Results, error) {
+	br, isJSON := sniffJSON(r)
+	if isJSON {
+		return DecodeJSON(br, maxHits)
+	}
+	return Decode(br, maxHits)
+}
+
+// DecodePartial is DecodeOutput for output blastn was killed partway
+// through writing, as Search leaves it when it stops at MaxHits. The hits
+// before the cut are kept and Truncated is set. JSON output is never cut
+// off, blastn stops itself.
+func DecodePartial(r io.Reader) (*Results, error) {
+	br, isJSON := sniffJSON(r)
+	if isJSON {
+		return DecodeJSON(br, 0)
+	}
+	return decode(br, 0, true)
+}
+
+// sniffJSON skips the whitespace at the start of r and reports whether
+// what follows is JSON.
+func sniffJSON(r io.Reader) (*bufio.Reader, bool) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil || !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			break
+		}
+		br.ReadByte()
+	}
+
+	b, err := br.Peek(1)
+	return br, err == nil && b[0] == '{'
+}
+
+// cutOff reports whether err is from XML ending early.
+func cutOff(err error) bool {
+	var syntaxErr *xml.SyntaxError
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		(errors.As(err, &syntaxErr) && syntaxErr.Msg == "unexpected EOF")
+}
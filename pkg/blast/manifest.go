@@ -0,0 +1,78 @@
+package blast
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Manifest records what a search was run against and how, so a hit list
+// quoted somewhere like a paper's supplement can say exactly what was
+// searched.
+type Manifest struct {
+	// Program and Version are the aligner that ran the search and the
+	// version it reported
+	Program string `json:"program"`
+	Version string `json:"version"`
+
+	// Args are the arguments the aligner was run with, other than the
+	// query
+	Args []string `json:"args"`
+
+	// DB is the db searched and DBVersion its version, empty for subject
+	// searches. DBSequences and DBLength are the number of sequences and
+	// residues in it, 0 if the aligner doesn't say or was stopped early.
+	DB          string `json:"db"`
+	DBVersion   string `json:"dbVersion,omitempty"`
+	DBSequences int    `json:"dbSequences"`
+	DBLength    int    `json:"dbLength"`
+
+	// Server is the build of synbioblast that ran the search
+	Server BuildInfo `json:"server"`
+
+	// Searched is when the search finished
+	Searched time.Time `json:"searched"`
+}
+
+// BuildInfo identifies a build of synbioblast.
+type BuildInfo struct {
+	// Version is the module version, (devel) for builds from a checkout
+	Version string `json:"version"`
+
+	// Revision is the commit it was built from, and Modified is set if
+	// there were uncommitted changes, when the build recorded them
+	Revision string `json:"revision,omitempty"`
+	Modified bool   `json:"modified,omitempty"`
+
+	GoVersion string `json:"goVersion"`
+}
+
+func (b BuildInfo) String() string {
+	s := b.Version
+	if b.Revision != "" {
+		s += " (" + b.Revision
+		if b.Modified {
+			s += ", modified"
+		}
+		s += ")"
+	}
+
+	return s + " built with " + b.GoVersion
+}
+
+// Lines returns the manifest as "name: value" lines, for formats with
+// nowhere to put it but a comment.
+func (m *Manifest) Lines() []string {
+	db := m.DB
+	if m.DBVersion != "" {
+		db += " version " + m.DBVersion
+	}
+
+	return []string{
+		fmt.Sprintf("Program: %s %s", m.Program, m.Version),
+		"Arguments: " + strings.Join(m.Args, " "),
+		fmt.Sprintf("Database: %s, %d sequences, %d residues", db, m.DBSequences, m.DBLength),
+		"Server: synbioblast " + m.Server.String(),
+		"Searched: " + m.Searched.Format(time.RFC3339),
+	}
+}
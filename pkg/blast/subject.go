@@ -0,0 +1,121 @@
+package blast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Subject is a sequence a query is searched against instead of a db, like
+// the other construct it's being compared with.
+type Subject struct {
+	Name     string
+	Sequence string
+}
+
+// ParseSubjects reads the sequences a query is to be searched against from
+// FASTA, or a single bare sequence. Each is checked and normalized like a
+// query, and named after its header, or its position if it has none. There
+// can be at most maxSequences of them, with at most maxLength residues all
+// together, unless those are 0.
+func ParseSubjects(fasta string, maxSequences, maxLength int) ([]Subject, error) {
+	fasta = strings.TrimSpace(strings.ReplaceAll(fasta, "\r\n", "\n"))
+	if fasta == "" {
+		return nil, errors.New("the subject has no sequences")
+	}
+
+	records := []string{fasta}
+	if strings.HasPrefix(fasta, ">") {
+		records = strings.Split(fasta, "\n>")
+		for i := 1; i < len(records); i++ {
+			records[i] = ">" + records[i]
+		}
+	}
+	if maxSequences > 0 && len(records) > maxSequences {
+		return nil, fmt.Errorf("the subject has %d sequences, at most %d can be searched", len(records), maxSequences)
+	}
+
+	subjects := []Subject{}
+	length := 0
+	seen := map[string]bool{}
+	for i, record := range records {
+		seq, input, err := NormalizeQuery(record, 0)
+		if err != nil {
+			return nil, fmt.Errorf("subject sequence %d: %v", i+1, err)
+		}
+		length += len(seq)
+		if maxLength > 0 && length > maxLength {
+			return nil, fmt.Errorf("the subject sequences are over %d residues long all together, which is the limit", maxLength)
+		}
+
+		// blastn gives the header back as the hit's def, which has to be
+		// one line and tell the subjects apart
+		name := strings.Join(strings.Fields(input.Header), " ")
+		if name == "" || seen[name] {
+			name = fmt.Sprintf("subject %d", i+1)
+		}
+		seen[name] = true
+
+		subjects = append(subjects, Subject{Name: name, Sequence: seq})
+	}
+
+	return subjects, nil
+}
+
+// SubjectDB is a blast db of subjects, made for one search and removed
+// afterwards.
+type SubjectDB struct {
+	// Dir is the db's directory, used as a Search's DBDir
+	Dir string
+
+	// Name is the db's name, passed to blastn as -db
+	Name string
+}
+
+// MakeSubjectDB builds subjects into a nucleotide blast db in a new
+// temporary directory with makeblastdb, which the caller must Remove. Call
+// NameSubjects on the results of searching it.
+func MakeSubjectDB(ctx context.Context, makeblastdb string, subjects []Subject) (*SubjectDB, error) {
+	dir, err := os.MkdirTemp("", "synbioblast-subject-")
+	if err != nil {
+		return nil, err
+	}
+	db := &SubjectDB{Dir: dir, Name: "subject"}
+
+	fasta := &strings.Builder{}
+	for _, s := range subjects {
+		fmt.Fprintf(fasta, ">%s\n%s\n", s.Name, s.Sequence)
+	}
+	in := filepath.Join(dir, "subject.fasta")
+	err = os.WriteFile(in, []byte(fasta.String()), 0600)
+	if err != nil {
+		db.Remove()
+		return nil, err
+	}
+
+	out, err := Command(ctx, makeblastdb, dir, "-dbtype", "nucl", "-in", in, "-out", filepath.Join(dir, db.Name)).CombinedOutput()
+	if err != nil {
+		db.Remove()
+		return nil, fmt.Errorf("makeblastdb failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return db, nil
+}
+
+// NameSubjects marks the results of a search of a SubjectDB of n subjects,
+// moving each hit's SeqHash, which is its subject's Name, to Subject.
+func (r *Results) NameSubjects(n int) {
+	r.Subjects = n
+	for i := range r.Results {
+		r.Results[i].Subject = r.Results[i].SeqHash
+		r.Results[i].SeqHash = ""
+	}
+}
+
+// Remove deletes the db.
+func (d *SubjectDB) Remove() error {
+	return os.RemoveAll(d.Dir)
+}
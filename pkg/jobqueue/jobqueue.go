@@ -0,0 +1,686 @@
+// Package jobqueue runs queries submitted to be fetched later, and keeps
+// them around once they've finished so their results can be.
+//
+// Jobs are run by a fixed number of workers, interactive jobs ahead of
+// batch ones, and each class in priority order. Running the query is left
+// to the Config's Run:
+//
+//	q, err := jobqueue.New(jobqueue.Config{
+//		Workers:           4,
+//		Size:              100,
+//		InteractiveWeight: 4,
+//		Store:             &jobstore.Store{Dir: "/var/synbioblast/jobs"},
+//		Run: func(ctx context.Context, j jobqueue.Job) (*blast.Results, error) {
+//			... search for j.Query with j.Options ...
+//		},
+//	})
+//	...
+//	j, err := q.Submit(ctx, jobqueue.Job{Query: query, Class: jobqueue.Batch})
+//	...
+//	j, err = q.Wait(ctx, j.ID)
+package jobqueue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/schnauzer/synbioblast/logging"
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/jobstore"
+)
+
+// Class is how a job is scheduled: interactive jobs have someone waiting
+// on the page, batch jobs come from the API, often hundreds at a time.
+// Each class has its own queue, so a library screen can't hold up the web
+// UI.
+type Class string
+
+const (
+	Interactive Class = "interactive"
+	Batch       Class = "batch"
+)
+
+type Status string
+
+const (
+	Queued  Status = "queued"
+	Running Status = "running"
+	Done    Status = "done"
+	Failed  Status = "failed"
+)
+
+// Job is a blast query submitted to be run in the background. Jobs are run
+// by the Queue's workers and kept around afterwards so results can be
+// fetched.
+type Job struct {
+	ID          string         `json:"id"`
+	Status      Status         `json:"status"`
+	Query       string         `json:"query"`
+	Description string         `json:"description,omitempty"`
+	Roles       []string       `json:"roles,omitempty"`
+	Containment []string       `json:"containment,omitempty"`
+	Options     align.Options  `json:"options"`
+	Input       *blast.Input   `json:"input,omitempty"`
+	RerunOf     string         `json:"rerunOf,omitempty"`
+	RequestID   string         `json:"requestId,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Submitted   time.Time      `json:"submitted"`
+	Finished    time.Time      `json:"finished"`
+	Results     *blast.Results `json:"results,omitempty"`
+
+	// Callback and Email are told when the job's finished. They're kept
+	// from anyone else with the job's id, so never written out.
+	Callback string `json:"-"`
+	Email    string `json:"-"`
+
+	// RawTruncated is set when blastn was stopped at its max hits, so the
+	// raw output kept for the job ends partway through
+	RawTruncated bool `json:"rawTruncated,omitempty"`
+
+	// Priority orders the job's class's queue, higher priority jobs are
+	// run first
+	Priority int   `json:"priority"`
+	Class    Class `json:"class"`
+
+	// Normalized is how long the query took to normalize before it was
+	// submitted
+	Normalized time.Duration `json:"-"`
+
+	// ErrorStatus is the http status Config.Failure gave Error, for the
+	// page to be shown with
+	ErrorStatus int `json:"-"`
+
+	// closed once the job is done or failed
+	done chan struct{}
+
+	// cancel kills the job's query while it's running
+	cancel context.CancelCauseFunc
+
+	// lastRead is when the job was last looked at, the least recently
+	// read jobs are the first removed to stay under Clean's maxBytes
+	lastRead time.Time
+}
+
+// LogArgs are the fields j's log lines are tagged with, including the
+// request that submitted it if it came over http.
+func (j *Job) LogArgs() []any {
+	if j.RequestID == "" {
+		return []any{"job", j.ID}
+	}
+
+	return []any{"job", j.ID, "request", j.RequestID}
+}
+
+var (
+	ErrQueueFull    = errors.New("job queue is full")
+	ErrShuttingDown = errors.New("server is shutting down")
+	ErrCancelled    = errors.New("job was cancelled")
+	ErrNotQueued    = errors.New("job isn't queued")
+	ErrFinished     = errors.New("job has already finished")
+)
+
+// Config is how a Queue runs its jobs.
+type Config struct {
+	// Workers is how many jobs run at once
+	Workers int
+
+	// Size is how many jobs of each class can wait to run before
+	// submissions are rejected with ErrQueueFull
+	Size int
+
+	// InteractiveWeight is how many interactive jobs are started for each
+	// batch job while both are waiting
+	InteractiveWeight int
+
+	// Store is where finished jobs are kept, they're only kept in memory
+	// if it's nil
+	Store *jobstore.Store
+
+	// Run runs a job's query. ctx is cancelled with ErrCancelled as its
+	// cause if the job is.
+	Run func(ctx context.Context, j Job) (*blast.Results, error)
+
+	// Failure turns the error a job failed with into the http status and
+	// message it's shown with, err.Error() if it's nil
+	Failure func(err error) (status int, msg string)
+
+	// Finished, if it's set, is called with each job once it's finished
+	// and been saved, before anyone waiting on it is told. It mustn't
+	// block.
+	Finished func(j Job)
+}
+
+// Queue is both the queue of pending jobs and the store of finished ones.
+type Queue struct {
+	config Config
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+
+	// pending are the queued jobs of each class in the order they'll
+	// run, highest priority first and then oldest first
+	pending map[Class][]*Job
+	// ready is signalled when a job is queued or the queue is closed
+	ready  *sync.Cond
+	closed bool
+
+	// streak is how many interactive jobs have been started since the
+	// last batch one
+	streak int
+
+	workers sync.WaitGroup
+}
+
+// New loads the finished jobs kept in c.Store, if there is one, and starts
+// c.Workers workers.
+func New(c Config) (*Queue, error) {
+	q := &Queue{
+		config:  c,
+		jobs:    make(map[string]*Job),
+		pending: make(map[Class][]*Job),
+	}
+	q.ready = sync.NewCond(&q.mu)
+
+	if c.Store != nil {
+		finished, err := Load(c.Store)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, j := range finished {
+			q.jobs[j.ID] = j
+		}
+		slog.Info("loaded finished jobs", "jobs", len(finished), "dir", c.Store.Dir)
+	}
+
+	q.workers.Add(c.Workers)
+	for i := 0; i < c.Workers; i++ {
+		go q.work()
+	}
+
+	return q, nil
+}
+
+// Load loads the finished jobs kept in s.
+func Load(s *jobstore.Store) ([]*Job, error) {
+	jobs, err := jobstore.Load[Job](s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, j := range jobs {
+		j.done = make(chan struct{})
+		close(j.done)
+		j.lastRead = j.Finished
+	}
+
+	return jobs, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// enqueue adds j to its class's queue behind the jobs of the same or higher
+// priority. q.mu must be held.
+func (q *Queue) enqueue(j *Job) {
+	pending := q.pending[j.Class]
+	i := sort.Search(len(pending), func(i int) bool {
+		return pending[i].Priority < j.Priority
+	})
+	q.pending[j.Class] = slices.Insert(pending, i, j)
+	q.ready.Signal()
+}
+
+// dequeue takes j off its class's queue. q.mu must be held.
+func (q *Queue) dequeue(j *Job) {
+	q.pending[j.Class] = slices.DeleteFunc(q.pending[j.Class], func(p *Job) bool {
+		return p == j
+	})
+}
+
+// next takes the job to run next, or returns nil if there are none. While
+// both classes have jobs waiting InteractiveWeight interactive jobs are
+// started for each batch one, so the web UI stays responsive without
+// starving the API. q.mu must be held.
+func (q *Queue) next() *Job {
+	interactive, batch := q.pending[Interactive], q.pending[Batch]
+
+	class := Batch
+	switch {
+	case len(interactive) == 0 && len(batch) == 0:
+		return nil
+	case len(interactive) == 0:
+	case len(batch) == 0 || q.streak < q.config.InteractiveWeight:
+		class = Interactive
+	}
+
+	if class == Interactive {
+		q.streak++
+	} else {
+		q.streak = 0
+	}
+
+	j := q.pending[class][0]
+	q.pending[class] = q.pending[class][1:]
+	return j
+}
+
+// Submit queues a job for j's query. Its ID, Status and Submitted are
+// filled in, and its Class is Batch if it's unset.
+func (q *Queue) Submit(ctx context.Context, j Job) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	j.ID = id
+	j.Status = Queued
+	j.Submitted = time.Now()
+	j.done = make(chan struct{})
+	j.lastRead = j.Submitted
+	if j.Class == "" {
+		j.Class = Batch
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return Job{}, ErrShuttingDown
+	}
+	if len(q.pending[j.Class]) >= q.config.Size {
+		return Job{}, ErrQueueFull
+	}
+
+	q.enqueue(&j)
+	q.jobs[id] = &j
+	logging.From(ctx).Info("queued job", "job", id)
+
+	return j, nil
+}
+
+// Get returns a copy of the job with the given id, so it can be read
+// without holding the lock.
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	j.lastRead = time.Now()
+
+	return *j, true
+}
+
+// Wait blocks until the job is finished or ctx is done.
+func (q *Queue) Wait(ctx context.Context, id string) (Job, error) {
+	j, ok := q.Get(id)
+	if !ok {
+		return Job{}, fmt.Errorf("no job with id %s", id)
+	}
+
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+
+	j, _ = q.Get(id)
+	return j, nil
+}
+
+// Update changes the finished job with the given id with f, and saves it
+// again. It returns the job as f left it, or false if there's no such job,
+// e.g. because it's been cleaned up.
+func (q *Queue) Update(id string, f func(j *Job)) (Job, bool) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	if !ok {
+		q.mu.Unlock()
+		return Job{}, false
+	}
+	f(j)
+	updated := *j
+	q.mu.Unlock()
+
+	q.save(updated)
+
+	return updated, true
+}
+
+// save keeps j in the store, if there is one.
+func (q *Queue) save(j Job) {
+	if q.config.Store == nil {
+		return
+	}
+
+	err := q.config.Store.Save(j.ID, &j)
+	if err != nil {
+		slog.With(j.LogArgs()...).Error("couldn't save job", "err", err)
+	}
+}
+
+// Shutdown stops accepting jobs and waits for the running ones to finish.
+// Jobs still in the queue are failed rather than started.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.ready.Broadcast()
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+
+	for {
+		q.mu.Lock()
+		j := q.next()
+		for j == nil && !q.closed {
+			q.ready.Wait()
+			j = q.next()
+		}
+		if j == nil {
+			q.mu.Unlock()
+			return
+		}
+
+		closed := q.closed
+		ctx, cancel := context.WithCancelCause(logging.With(context.Background(), j.LogArgs()...))
+		j.Status = Running
+		j.cancel = cancel
+		running := *j
+		q.mu.Unlock()
+
+		var results *blast.Results
+		err := ErrShuttingDown
+		if !closed {
+			results, err = q.config.Run(ctx, running)
+		}
+		if err != nil && context.Cause(ctx) == ErrCancelled {
+			err = ErrCancelled
+		}
+		cancel(nil)
+
+		q.finish(j, results, err)
+	}
+}
+
+// finish records how j turned out, and lets anyone waiting on it know.
+func (q *Queue) finish(j *Job, results *blast.Results, err error) {
+	q.mu.Lock()
+	finished := q.record(j, results, err)
+	q.mu.Unlock()
+
+	q.announce(j, finished)
+}
+
+// record sets how j turned out and returns a copy of it. It's called with
+// q.mu held, in the same critical section that took j off the queue or saw
+// its query return, so only one of a worker, Cancel and Drain ever gets to
+// finish a job.
+func (q *Queue) record(j *Job, results *blast.Results, err error) Job {
+	j.Finished = time.Now()
+	j.cancel = nil
+	if err != nil {
+		slog.With(j.LogArgs()...).Error("job failed", "err", err)
+		j.Status = Failed
+		if q.config.Failure != nil {
+			j.ErrorStatus, j.Error = q.config.Failure(err)
+		} else {
+			j.Error = err.Error()
+		}
+	} else {
+		j.Status = Done
+		j.Results = results
+		j.RawTruncated = results.Truncated
+	}
+
+	return *j
+}
+
+// announce saves a job record has finished, and lets anyone waiting on it
+// know.
+func (q *Queue) announce(j *Job, finished Job) {
+	q.save(finished)
+	if q.config.Finished != nil {
+		q.config.Finished(finished)
+	}
+
+	close(j.done)
+}
+
+// Active returns copies of the running jobs and the queued ones, the
+// interactive ones first and then the batch ones in the order they'll run.
+func (q *Queue) Active() (running, queued []Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	running, queued = []Job{}, []Job{}
+	for _, j := range q.jobs {
+		if j.Status == Running {
+			running = append(running, *j)
+		}
+	}
+	sort.Slice(running, func(a, b int) bool {
+		return running[a].Submitted.Before(running[b].Submitted)
+	})
+
+	for _, class := range []Class{Interactive, Batch} {
+		for _, j := range q.pending[class] {
+			queued = append(queued, *j)
+		}
+	}
+
+	return running, queued
+}
+
+// Cancel stops a job. A queued one is taken off the queue and a running
+// one has its query's context cancelled, either way it fails with
+// ErrCancelled.
+func (q *Queue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	if !ok {
+		q.mu.Unlock()
+		return Job{}, fmt.Errorf("no job with id %s", id)
+	}
+
+	switch {
+	case j.Status == Queued:
+		q.dequeue(j)
+		finished := q.record(j, nil, ErrCancelled)
+		q.mu.Unlock()
+		q.announce(j, finished)
+	case j.Status == Running:
+		j.cancel(ErrCancelled)
+		q.mu.Unlock()
+	default:
+		q.mu.Unlock()
+		return Job{}, ErrFinished
+	}
+
+	slog.With(j.LogArgs()...).Info("cancelled job")
+
+	return q.Wait(context.Background(), id)
+}
+
+// SetPriority moves a queued job to where its new priority puts it.
+func (q *Queue) SetPriority(id string, priority int) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("no job with id %s", id)
+	}
+	if j.Status != Queued {
+		return Job{}, ErrNotQueued
+	}
+
+	q.dequeue(j)
+	j.Priority = priority
+	q.enqueue(j)
+
+	return *j, nil
+}
+
+// Drain cancels every queued job, leaving the running ones be, and returns
+// how many there were.
+func (q *Queue) Drain() int {
+	q.mu.Lock()
+	var drained []*Job
+	var finished []Job
+	for class, pending := range q.pending {
+		for _, j := range pending {
+			drained = append(drained, j)
+			finished = append(finished, q.record(j, nil, ErrCancelled))
+		}
+		delete(q.pending, class)
+	}
+	q.mu.Unlock()
+
+	for i, j := range drained {
+		q.announce(j, finished[i])
+	}
+
+	slog.Info("drained job queue", "jobs", len(drained))
+
+	return len(drained)
+}
+
+// Cleaned is what Clean removed for one reason: "expired", "quota" or
+// "orphaned".
+type Cleaned struct {
+	Jobs  int
+	Bytes int64
+}
+
+// Clean removes finished jobs that are older than ttl, then the least
+// recently read ones until the stored jobs take up at most maxBytes. Files
+// left behind by jobs that were running when the server died go too. It
+// returns what was removed, by why, and how many bytes the jobs still
+// stored take up.
+func (q *Queue) Clean(ttl time.Duration, maxBytes int64) (cleaned map[string]Cleaned, stored int64, err error) {
+	type finishedJob struct {
+		id       string
+		finished time.Time
+		lastRead time.Time
+	}
+
+	cleaned = map[string]Cleaned{}
+	removed := func(reason string, freed int64) {
+		c := cleaned[reason]
+		c.Jobs++
+		c.Bytes += freed
+		cleaned[reason] = c
+	}
+
+	q.mu.Lock()
+	var finished []finishedJob
+	for id, j := range q.jobs {
+		if j.Status == Done || j.Status == Failed {
+			finished = append(finished, finishedJob{id, j.Finished, j.lastRead})
+		}
+	}
+	q.mu.Unlock()
+
+	store := q.config.Store
+	total := int64(0)
+	if store != nil {
+		sizes, err := store.Sizes()
+		if err != nil {
+			return cleaned, 0, err
+		}
+
+		for id, size := range sizes {
+			// checked now rather than up front, since jobs are added
+			// before their files are created
+			q.mu.Lock()
+			_, known := q.jobs[id]
+			q.mu.Unlock()
+			if known {
+				total += size
+				continue
+			}
+
+			freed, err := store.Remove(id)
+			if err != nil {
+				return cleaned, 0, err
+			}
+			removed("orphaned", freed)
+		}
+	}
+
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].lastRead.Before(finished[b].lastRead)
+	})
+
+	count := 0
+	freedTotal := int64(0)
+	for _, j := range finished {
+		var reason string
+		switch {
+		case ttl > 0 && time.Since(j.finished) > ttl:
+			reason = "expired"
+		case store != nil && maxBytes > 0 && total > maxBytes:
+			reason = "quota"
+		default:
+			continue
+		}
+
+		q.mu.Lock()
+		delete(q.jobs, j.id)
+		q.mu.Unlock()
+
+		freed := int64(0)
+		if store != nil {
+			var err error
+			freed, err = store.Remove(j.id)
+			if err != nil {
+				return cleaned, 0, err
+			}
+		}
+
+		total -= freed
+		freedTotal += freed
+		count++
+		removed(reason, freed)
+	}
+
+	if count > 0 {
+		slog.Info("cleaned up jobs", "removed", count, "freed", freedTotal, "stored", total)
+	}
+
+	return cleaned, total, nil
+}
@@ -0,0 +1,518 @@
+package jobqueue_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/jobqueue"
+	"github.com/schnauzer/synbioblast/pkg/jobstore"
+)
+
+// gate is a Run that holds each job until it's let through, so tests can
+// see which jobs are started when.
+type gate struct {
+	started chan string
+	release chan struct{}
+}
+
+func newGate() *gate {
+	return &gate{started: make(chan string, 100), release: make(chan struct{})}
+}
+
+func (g *gate) run(ctx context.Context, j jobqueue.Job) (*blast.Results, error) {
+	g.started <- j.Query
+	select {
+	case <-g.release:
+		return &blast.Results{Query: j.Query}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// next waits for the next job to be started and returns its query.
+func (g *gate) next(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case query := <-g.started:
+		return query
+	case <-time.After(10 * time.Second):
+		t.Fatal("no job was started")
+		return ""
+	}
+}
+
+// failure is a Config.Failure that shows every error as is.
+func failure(err error) (int, string) {
+	return 500, err.Error()
+}
+
+// newQueue starts a queue that's shut down when the test's done.
+func newQueue(t *testing.T, c jobqueue.Config) *jobqueue.Queue {
+	t.Helper()
+
+	if c.Failure == nil {
+		c.Failure = failure
+	}
+	q, err := jobqueue.New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		q.Drain()
+		q.Shutdown(context.Background())
+	})
+
+	return q
+}
+
+func submit(t *testing.T, q *jobqueue.Queue, j jobqueue.Job) jobqueue.Job {
+	t.Helper()
+
+	j, err := q.Submit(context.Background(), j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return j
+}
+
+func TestSubmit(t *testing.T) {
+	finished := make(chan jobqueue.Job, 1)
+	q := newQueue(t, jobqueue.Config{
+		Workers: 1,
+		Size:    10,
+		Run: func(ctx context.Context, j jobqueue.Job) (*blast.Results, error) {
+			return &blast.Results{Query: j.Query, NumResults: 1, Truncated: true}, nil
+		},
+		Finished: func(j jobqueue.Job) {
+			finished <- j
+		},
+	})
+
+	j := submit(t, q, jobqueue.Job{Query: "acgt", Callback: "https://example.com/hook"})
+	if j.ID == "" || j.Status != jobqueue.Queued || j.Class != jobqueue.Batch || j.Submitted.IsZero() {
+		t.Errorf("submitted job = %+v, want an id, queued, batch, with the time it was submitted", j)
+	}
+
+	j, err := q.Wait(context.Background(), j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Status != jobqueue.Done || j.Results == nil || j.Results.Query != "acgt" || !j.RawTruncated || j.Finished.IsZero() {
+		t.Errorf("finished job = %+v, want it done with its results, truncated", j)
+	}
+
+	select {
+	case f := <-finished:
+		if f.ID != j.ID || f.Status != jobqueue.Done || f.Callback != j.Callback {
+			t.Errorf("Finished was told about %+v, want %+v", f, j)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Finished wasn't called")
+	}
+
+	if _, ok := q.Get("missing"); ok {
+		t.Error("Get(missing) = true, want false")
+	}
+	if _, err := q.Wait(context.Background(), "missing"); err == nil {
+		t.Error("Wait(missing) = nil error, want one")
+	}
+}
+
+func TestFailure(t *testing.T) {
+	failed := errors.New("blastn exited with 2")
+	run := func(ctx context.Context, j jobqueue.Job) (*blast.Results, error) {
+		return nil, failed
+	}
+
+	tests := []struct {
+		name    string
+		failure func(error) (int, string)
+		status  int
+		msg     string
+	}{
+		{"failure", func(error) (int, string) { return 503, "try again later" }, 503, "try again later"},
+		{"no failure", nil, 0, failed.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := jobqueue.New(jobqueue.Config{Workers: 1, Size: 10, Run: run, Failure: test.failure})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Shutdown(context.Background())
+
+			j, err := q.Submit(context.Background(), jobqueue.Job{Query: "acgt"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			j, err = q.Wait(context.Background(), j.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j.Status != jobqueue.Failed || j.ErrorStatus != test.status || j.Error != test.msg {
+				t.Errorf("job is %s with %d %q, want failed with %d %q", j.Status, j.ErrorStatus, j.Error, test.status, test.msg)
+			}
+		})
+	}
+}
+
+func TestOrder(t *testing.T) {
+	g := newGate()
+	q := newQueue(t, jobqueue.Config{Workers: 1, Size: 10, InteractiveWeight: 2, Run: g.run})
+
+	// holds the worker up until everything else is queued
+	submit(t, q, jobqueue.Job{Query: "blocker"})
+	if query := g.next(t); query != "blocker" {
+		t.Fatalf("started %s, want blocker", query)
+	}
+
+	for _, j := range []jobqueue.Job{
+		{Query: "b1"},
+		{Query: "b2", Priority: 5},
+		{Query: "i1", Class: jobqueue.Interactive},
+		{Query: "i2", Class: jobqueue.Interactive},
+		{Query: "i3", Class: jobqueue.Interactive},
+		{Query: "i4", Class: jobqueue.Interactive, Priority: 1},
+	} {
+		submit(t, q, j)
+	}
+
+	_, queued := q.Active()
+	got := []string{}
+	for _, j := range queued {
+		got = append(got, j.Query)
+	}
+	if want := []string{"i4", "i1", "i2", "i3", "b2", "b1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %q, want %q", got, want)
+	}
+
+	got = []string{}
+	for i := 0; i < 6; i++ {
+		g.release <- struct{}{}
+		got = append(got, g.next(t))
+	}
+	g.release <- struct{}{}
+
+	// two interactive jobs for each batch one
+	if want := []string{"i4", "i1", "b2", "i2", "i3", "b1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("started %q, want %q", got, want)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	q := newQueue(t, jobqueue.Config{Size: 1})
+
+	submit(t, q, jobqueue.Job{Query: "acgt"})
+	_, err := q.Submit(context.Background(), jobqueue.Job{Query: "acgt"})
+	if err != jobqueue.ErrQueueFull {
+		t.Errorf("Submit to a full queue = %v, want %v", err, jobqueue.ErrQueueFull)
+	}
+
+	// the classes are queued separately
+	submit(t, q, jobqueue.Job{Query: "acgt", Class: jobqueue.Interactive})
+}
+
+func TestCancel(t *testing.T) {
+	g := newGate()
+	q := newQueue(t, jobqueue.Config{Workers: 1, Size: 10, Run: g.run})
+
+	running := submit(t, q, jobqueue.Job{Query: "running"})
+	g.next(t)
+	queued := submit(t, q, jobqueue.Job{Query: "queued"})
+
+	for _, id := range []string{queued.ID, running.ID} {
+		j, err := q.Cancel(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Status != jobqueue.Failed || j.Error != jobqueue.ErrCancelled.Error() {
+			t.Errorf("cancelled %s job is %s with %q, want failed with %q", j.Query, j.Status, j.Error, jobqueue.ErrCancelled)
+		}
+
+		_, err = q.Cancel(id)
+		if err != jobqueue.ErrFinished {
+			t.Errorf("cancelling the %s job again = %v, want %v", j.Query, err, jobqueue.ErrFinished)
+		}
+	}
+
+	if running, queued := q.Active(); len(running) != 0 || len(queued) != 0 {
+		t.Errorf("%d jobs running and %d queued after they were cancelled, want none", len(running), len(queued))
+	}
+	if _, err := q.Cancel("missing"); err == nil {
+		t.Error("Cancel(missing) = nil error, want one")
+	}
+}
+
+func TestCancelRace(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		var finished atomic.Int32
+		q := newQueue(t, jobqueue.Config{
+			Size: 100,
+			// slow enough for the cancels to catch Drain part way
+			Finished: func(jobqueue.Job) {
+				finished.Add(1)
+				time.Sleep(time.Millisecond)
+			},
+		})
+
+		var ids []string
+		for j := 0; j < 10; j++ {
+			ids = append(ids, submit(t, q, jobqueue.Job{Query: "acgt"}).ID)
+		}
+
+		// the same job cancelled twice at once, and every job cancelled
+		// while the queue's drained
+		var (
+			wg        sync.WaitGroup
+			cancelled atomic.Int32
+		)
+		for _, id := range append([]string{ids[0]}, ids...) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := q.Cancel(id)
+				if err == nil {
+					cancelled.Add(1)
+				} else if err != jobqueue.ErrFinished {
+					t.Errorf("Cancel = %v, want nil or %v", err, jobqueue.ErrFinished)
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cancelled.Add(int32(q.Drain()))
+		}()
+		wg.Wait()
+
+		if n := cancelled.Load(); n != 10 {
+			t.Fatalf("%d jobs cancelled or drained, want each of the 10 once", n)
+		}
+		if n := finished.Load(); n != 10 {
+			t.Fatalf("Finished called %d times, want once for each of the 10 jobs", n)
+		}
+	}
+}
+
+func TestSetPriority(t *testing.T) {
+	q := newQueue(t, jobqueue.Config{Size: 10})
+
+	a := submit(t, q, jobqueue.Job{Query: "a"})
+	submit(t, q, jobqueue.Job{Query: "b"})
+	c := submit(t, q, jobqueue.Job{Query: "c"})
+
+	c, err := q.SetPriority(c.ID, 10)
+	if err != nil || c.Priority != 10 {
+		t.Fatalf("SetPriority = %+v, %v, want priority 10", c, err)
+	}
+
+	_, queued := q.Active()
+	got := []string{}
+	for _, j := range queued {
+		got = append(got, j.Query)
+	}
+	if want := []string{"c", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %q, want %q", got, want)
+	}
+
+	_, err = q.Cancel(a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.SetPriority(a.ID, 10)
+	if err != jobqueue.ErrNotQueued {
+		t.Errorf("SetPriority of a cancelled job = %v, want %v", err, jobqueue.ErrNotQueued)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	g := newGate()
+	q := newQueue(t, jobqueue.Config{Workers: 1, Size: 10, Run: g.run})
+
+	running := submit(t, q, jobqueue.Job{Query: "running"})
+	g.next(t)
+	var queued []jobqueue.Job
+	for _, class := range []jobqueue.Class{jobqueue.Batch, jobqueue.Interactive, jobqueue.Batch} {
+		queued = append(queued, submit(t, q, jobqueue.Job{Query: "queued", Class: class}))
+	}
+
+	if n := q.Drain(); n != 3 {
+		t.Errorf("Drain() = %d, want 3", n)
+	}
+	for _, j := range queued {
+		j, _ := q.Get(j.ID)
+		if j.Status != jobqueue.Failed || j.Error != jobqueue.ErrCancelled.Error() {
+			t.Errorf("drained job is %s with %q, want failed with %q", j.Status, j.Error, jobqueue.ErrCancelled)
+		}
+	}
+
+	// the running job is left to finish
+	g.release <- struct{}{}
+	j, err := q.Wait(context.Background(), running.ID)
+	if err != nil || j.Status != jobqueue.Done {
+		t.Errorf("running job is %s, %v, want done", j.Status, err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	g := newGate()
+	q, err := jobqueue.New(jobqueue.Config{Workers: 1, Size: 10, Run: g.run, Failure: failure})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	running := submit(t, q, jobqueue.Job{Query: "running"})
+	g.next(t)
+	queued := submit(t, q, jobqueue.Job{Query: "queued"})
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- q.Shutdown(context.Background())
+	}()
+	for {
+		_, err = q.Submit(context.Background(), jobqueue.Job{Query: "late"})
+		if err == jobqueue.ErrShuttingDown {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	g.release <- struct{}{}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown = %v", err)
+	}
+
+	if j, _ := q.Get(running.ID); j.Status != jobqueue.Done {
+		t.Errorf("running job is %s, want done", j.Status)
+	}
+	if j, _ := q.Get(queued.ID); j.Status != jobqueue.Failed || j.Error != jobqueue.ErrShuttingDown.Error() {
+		t.Errorf("queued job is %s with %q, want failed with %q", j.Status, j.Error, jobqueue.ErrShuttingDown)
+	}
+}
+
+// finishedJobs runs a job for each query on a queue kept in store, and
+// returns them once they've finished.
+func finishedJobs(t *testing.T, store *jobstore.Store, queries ...string) (*jobqueue.Queue, []jobqueue.Job) {
+	t.Helper()
+
+	q := newQueue(t, jobqueue.Config{
+		Workers: 1,
+		Size:    10,
+		Store:   store,
+		Run: func(ctx context.Context, j jobqueue.Job) (*blast.Results, error) {
+			return &blast.Results{Query: j.Query}, nil
+		},
+	})
+
+	var finished []jobqueue.Job
+	for _, query := range queries {
+		j := submit(t, q, jobqueue.Job{Query: query})
+		j, err := q.Wait(context.Background(), j.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		finished = append(finished, j)
+	}
+
+	return q, finished
+}
+
+func TestStore(t *testing.T) {
+	store := &jobstore.Store{Dir: t.TempDir()}
+	q, finished := finishedJobs(t, store, "acgt")
+	id := finished[0].ID
+
+	j, ok := q.Update(id, func(j *jobqueue.Job) {
+		j.Results = &blast.Results{Query: j.Query, URIsUnavailable: true}
+	})
+	if !ok || !j.Results.URIsUnavailable {
+		t.Errorf("Update = %+v, %v, want the job as updated", j, ok)
+	}
+	if _, ok := q.Update("missing", func(*jobqueue.Job) {}); ok {
+		t.Error("Update(missing) = true, want false")
+	}
+
+	// a server started again has the finished jobs as they were left
+	reloaded := newQueue(t, jobqueue.Config{Size: 10, Store: store})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	j, err := reloaded.Wait(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Status != jobqueue.Done || j.Query != "acgt" || j.Results == nil || !j.Results.URIsUnavailable {
+		t.Errorf("reloaded job = %+v, want it done and updated", j)
+	}
+}
+
+func TestClean(t *testing.T) {
+	store := &jobstore.Store{Dir: t.TempDir()}
+	q, finished := finishedJobs(t, store, "read", "unread")
+	read, unread := finished[0].ID, finished[1].ID
+
+	// left behind by a job that was running when the server died
+	err := os.WriteFile(store.RawPath("crashed"), []byte("<BlastOutput>"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes, err := store.Sizes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleaned, stored, err := q.Clean(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]jobqueue.Cleaned{"orphaned": {1, sizes["crashed"]}}; !reflect.DeepEqual(cleaned, want) {
+		t.Errorf("Clean(0, 0) cleaned %+v, want %+v", cleaned, want)
+	}
+	if want := sizes[read] + sizes[unread]; stored != want {
+		t.Errorf("Clean(0, 0) left %d bytes stored, want %d", stored, want)
+	}
+
+	// the least recently read job goes first
+	time.Sleep(time.Millisecond)
+	q.Get(read)
+	cleaned, stored, err = q.Clean(0, sizes[read])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]jobqueue.Cleaned{"quota": {1, sizes[unread]}}; !reflect.DeepEqual(cleaned, want) {
+		t.Errorf("Clean under quota cleaned %+v, want %+v", cleaned, want)
+	}
+	if stored != sizes[read] {
+		t.Errorf("Clean under quota left %d bytes stored, want %d", stored, sizes[read])
+	}
+	if _, ok := q.Get(unread); ok {
+		t.Error("the unread job is still there")
+	}
+
+	cleaned, stored, err = q.Clean(time.Nanosecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]jobqueue.Cleaned{"expired": {1, sizes[read]}}; !reflect.DeepEqual(cleaned, want) {
+		t.Errorf("Clean with a ttl cleaned %+v, want %+v", cleaned, want)
+	}
+	if _, ok := q.Get(read); ok || stored != 0 {
+		t.Errorf("the expired job is still there, or %d bytes are still stored", stored)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(store.Dir, "*"))
+	if len(files) != 0 {
+		t.Errorf("files %q are left in the store", files)
+	}
+}
@@ -0,0 +1,146 @@
+// Package jobstore keeps finished jobs on disk, each as <id>.json next to
+// the raw blast output it was parsed from in <id>.xml. Keeping the raw
+// output means old results can be upgraded when the parser learns to
+// extract more:
+//
+//	s := &jobstore.Store{Dir: "/var/synbioblast/jobs"}
+//	raw, err := s.CreateRaw(id)
+//	... run blastn, teeing its output into raw ...
+//	err = s.Save(id, j)
+//
+// and when the server starts again:
+//
+//	jobs, err := jobstore.Load[job](s)
+package jobstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store is a directory of jobs.
+type Store struct {
+	Dir string
+}
+
+// JobPath is where the job id is kept.
+func (s *Store) JobPath(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// RawPath is where the raw output of the job id is kept.
+func (s *Store) RawPath(id string) string {
+	return filepath.Join(s.Dir, id+".xml")
+}
+
+// writeFile writes b to filename via a temporary file so a crash can't
+// leave a half written job behind.
+func writeFile(filename string, b []byte) error {
+	tmp := filename + ".tmp"
+	err := ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// Remove deletes a job's files, returning how many bytes that freed.
+func (s *Store) Remove(id string) (int64, error) {
+	freed := int64(0)
+	for _, name := range []string{s.JobPath(id), s.RawPath(id)} {
+		info, err := os.Stat(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return freed, err
+		}
+
+		err = os.Remove(name)
+		if err != nil {
+			return freed, err
+		}
+		freed += info.Size()
+	}
+
+	return freed, nil
+}
+
+// Sizes returns how many bytes each job's files take up, by ID.
+func (s *Store) Sizes() (map[string]int64, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := map[string]int64{}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if ext != ".json" && ext != ".xml" {
+			continue
+		}
+
+		info, err := e.Info()
+		if os.IsNotExist(err) {
+			// removed since it was listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sizes[strings.TrimSuffix(e.Name(), ext)] += info.Size()
+	}
+
+	return sizes, nil
+}
+
+// CreateRaw creates the file blast's raw output for a job is kept in.
+func (s *Store) CreateRaw(id string) (*os.File, error) {
+	return os.Create(s.RawPath(id))
+}
+
+// OpenRaw opens the raw output kept for a job.
+func (s *Store) OpenRaw(id string) (*os.File, error) {
+	return os.Open(s.RawPath(id))
+}
+
+// Save writes job, which is marshalled as json, as the job id.
+func (s *Store) Save(id string, job any) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(s.JobPath(id), b)
+}
+
+// Load reads every job in s into a J. One job that can't be parsed
+// shouldn't keep the server from starting, so those are logged and left on
+// disk to be looked at.
+func Load[J any](s *Store) ([]*J, error) {
+	files, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*J, 0, len(files))
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		j := new(J)
+		err = json.Unmarshal(b, j)
+		if err != nil {
+			slog.Error("couldn't parse stored job, skipping it", "file", f, "err", err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,402 @@
+// Package render turns Results into the forms they're served in besides
+// our own json: NCBI's single file JSON, the data the alignment viewer draws
+// from, and the text of their e-values and roles on the pages:
+//
+//	writeJSON(w, render.ToBlastJSON(results))
+//	writeJSON(w, render.NewViewer(results, "/static/", func(uri string) string { return uri }))
+package render
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+// FormatEValue formats an e-value the way blast's own reports do.
+func FormatEValue(evalue float64) string {
+	switch {
+	case evalue < 0:
+		return "n/a"
+	case evalue == 0:
+		return "0.0"
+	case evalue < 1e-3:
+		return strconv.FormatFloat(evalue, 'e', 0, 64)
+	}
+	return strconv.FormatFloat(evalue, 'g', 2, 64)
+}
+
+// BlastJSON is NCBI's single file JSON output format (blastn -outfmt 15),
+// so tools written against NCBI's schema can read our results as is.
+type BlastJSON struct {
+	BlastOutput2 []BlastJSONOutput `json:"BlastOutput2"`
+}
+
+type BlastJSONOutput struct {
+	Report BlastJSONReport `json:"report"`
+}
+
+type BlastJSONReport struct {
+	Program      string `json:"program"`
+	Version      string `json:"version"`
+	Reference    string `json:"reference"`
+	SearchTarget struct {
+		DB string `json:"db"`
+	} `json:"search_target"`
+	Params  *BlastJSONParams `json:"params,omitempty"`
+	Results struct {
+		Search BlastJSONSearch `json:"search"`
+	} `json:"results"`
+
+	// Manifest isn't NCBI's, it's here so the export says what was
+	// searched as well as our own json does
+	Manifest *blast.Manifest `json:"synbioblast_manifest,omitempty"`
+}
+
+type BlastJSONSearch struct {
+	QueryID    string         `json:"query_id"`
+	QueryTitle string         `json:"query_title,omitempty"`
+	QueryLen   int            `json:"query_len"`
+	Message    string         `json:"message,omitempty"`
+	Hits       []BlastJSONHit `json:"hits"`
+	Stat       struct {
+		DBNum    int     `json:"db_num"`
+		DBLen    int     `json:"db_len"`
+		HSPLen   int     `json:"hsp_len,omitempty"`
+		EffSpace float64 `json:"eff_space,omitempty"`
+		Kappa    float64 `json:"kappa,omitempty"`
+		Lambda   float64 `json:"lambda,omitempty"`
+		Entropy  float64 `json:"entropy,omitempty"`
+	} `json:"stat"`
+}
+
+type BlastJSONParams struct {
+	Matrix     string  `json:"matrix,omitempty"`
+	Expect     float64 `json:"expect"`
+	ScMatch    int     `json:"sc_match,omitempty"`
+	ScMismatch int     `json:"sc_mismatch,omitempty"`
+	GapOpen    int     `json:"gap_open"`
+	GapExtend  int     `json:"gap_extend"`
+	Filter     string  `json:"filter,omitempty"`
+}
+
+type BlastJSONHit struct {
+	Num         int                    `json:"num"`
+	Description []BlastJSONDescription `json:"description"`
+	Len         int                    `json:"len"`
+	HSPs        []BlastJSONHSP         `json:"hsps"`
+}
+
+type BlastJSONDescription struct {
+	ID        string `json:"id"`
+	Accession string `json:"accession"`
+	Title     string `json:"title"`
+}
+
+type BlastJSONHSP struct {
+	Num       int     `json:"num"`
+	BitScore  float64 `json:"bit_score"`
+	Score     int     `json:"score"`
+	EValue    float64 `json:"evalue"`
+	Identity  int     `json:"identity"`
+	QueryFrom int     `json:"query_from"`
+	QueryTo   int     `json:"query_to"`
+	HitFrom   int     `json:"hit_from"`
+	HitTo     int     `json:"hit_to"`
+
+	// nucleotide searches only
+	QueryStrand string `json:"query_strand,omitempty"`
+	HitStrand   string `json:"hit_strand,omitempty"`
+
+	AlignLen int    `json:"align_len"`
+	Gaps     int    `json:"gaps"`
+	QuerySeq string `json:"qseq"`
+	HitSeq   string `json:"hseq"`
+	Midline  string `json:"midline"`
+}
+
+// jsonStrand is how NCBI's JSON names the strand of a frame.
+func jsonStrand(frame int) string {
+	switch {
+	case frame > 0:
+		return "Plus"
+	case frame < 0:
+		return "Minus"
+	}
+	return ""
+}
+
+// ToBlastJSON converts the results to NCBI's JSON format. Each component
+// sharing a hit's sequence gets its own description, the same way NCBI
+// lists every accession of an identical sequence in nr.
+func ToBlastJSON(r *blast.Results) *BlastJSON {
+	report := BlastJSONReport{
+		Program:   r.Program,
+		Version:   r.Version,
+		Reference: r.Reference,
+		Manifest:  r.Manifest,
+	}
+	report.SearchTarget.DB = r.DB
+
+	search := &report.Results.Search
+	search.QueryID = r.QueryID
+	search.QueryTitle = r.QueryDef
+	search.QueryLen = r.QueryLen
+	search.Message = r.Message
+	search.Stat.DBNum = r.DBNum
+	search.Stat.DBLen = r.DBLen
+	if p := r.Parameters; p != nil {
+		report.Params = &BlastJSONParams{
+			Matrix:     p.Matrix,
+			Expect:     p.Expect,
+			ScMatch:    p.Match,
+			ScMismatch: p.Mismatch,
+			GapOpen:    p.GapOpen,
+			GapExtend:  p.GapExtend,
+			Filter:     p.Filter,
+		}
+		search.Stat.HSPLen = p.HSPLen
+		search.Stat.EffSpace = p.EffectiveSpace
+		search.Stat.Kappa, search.Stat.Lambda, search.Stat.Entropy = p.Kappa, p.Lambda, p.Entropy
+	}
+	search.Hits = make([]BlastJSONHit, len(r.Results))
+
+	for i, result := range r.Results {
+		hit := BlastJSONHit{Num: result.Num, Len: result.Len}
+
+		for _, uri := range result.URIs {
+			hit.Description = append(hit.Description, BlastJSONDescription{
+				ID:        uri,
+				Accession: result.SeqHash,
+				Title:     uri,
+			})
+		}
+		if len(hit.Description) == 0 {
+			hit.Description = []BlastJSONDescription{{
+				ID:        result.ID,
+				Accession: result.Accession,
+				Title:     result.SeqHash,
+			}}
+		}
+
+		hit.HSPs = []BlastJSONHSP{{
+			Num:         1,
+			BitScore:    result.BitScore,
+			Score:       result.Score,
+			EValue:      result.EValue,
+			Identity:    result.Identity,
+			QueryFrom:   result.QueryFrom,
+			QueryTo:     result.QueryTo,
+			HitFrom:     result.HitFrom,
+			HitTo:       result.HitTo,
+			QueryStrand: jsonStrand(result.QueryFrame),
+			HitStrand:   jsonStrand(result.QueryFrame * result.HitFrame),
+			AlignLen:    result.AlignLen,
+			Gaps:        result.Gaps,
+			QuerySeq:    result.QuerySeq,
+			HitSeq:      result.HitSeq,
+			Midline:     result.Midline,
+		}}
+
+		search.Hits[i] = hit
+	}
+
+	return &BlastJSON{BlastOutput2: []BlastJSONOutput{{Report: report}}}
+}
+
+// Viewer is what static/viewer.js needs to draw the hits against the
+// query: just the hit list and the coordinates of each alignment.
+type Viewer struct {
+	QueryLen int         `json:"queryLen"`
+	Hits     []ViewerHit `json:"hits"`
+
+	// Tracks is the number of rows the hits are stacked in
+	Tracks int `json:"tracks"`
+
+	// StaticPath is where the glyphs are served from
+	StaticPath string `json:"staticPath"`
+}
+
+type ViewerHit struct {
+	ID       string      `json:"id"`
+	Title    string      `json:"title"`
+	Len      int         `json:"len"`
+	BitScore float64     `json:"bitScore"`
+	EValue   float64     `json:"evalue"`
+	HSPs     []ViewerHSP `json:"hsps"`
+
+	// Glyph is the SBOL Visual glyph for the hit's role, if it has one
+	Glyph string `json:"glyph,omitempty"`
+
+	// Track is the row the hit is drawn in, counting from 0
+	Track int `json:"track"`
+}
+
+type ViewerHSP struct {
+	QueryFrom int    `json:"queryFrom"`
+	QueryTo   int    `json:"queryTo"`
+	HitFrom   int    `json:"hitFrom"`
+	HitTo     int    `json:"hitTo"`
+	Identity  int    `json:"identity"`
+	AlignLen  int    `json:"alignLen"`
+	Strand    string `json:"strand,omitempty"`
+}
+
+// NewViewer returns the results in the form used by the alignment
+// viewer, which finds the glyphs under staticPath. Each hit is titled with
+// title of its first component's URI, e.g. to rewrite it, or its sequence's
+// hash if it has none.
+func NewViewer(r *blast.Results, staticPath string, title func(uri string) string) *Viewer {
+	data := &Viewer{
+		QueryLen:   r.QueryLen,
+		Hits:       make([]ViewerHit, len(r.Results)),
+		StaticPath: staticPath,
+	}
+
+	for i, result := range r.Results {
+		hitTitle := result.SeqHash
+		if len(result.URIs) > 0 {
+			hitTitle = title(result.URIs[0])
+		}
+
+		data.Hits[i] = ViewerHit{
+			ID:       result.SeqHash,
+			Title:    hitTitle,
+			Len:      result.Len,
+			BitScore: result.BitScore,
+			EValue:   result.EValue,
+			HSPs: []ViewerHSP{{
+				QueryFrom: result.QueryFrom,
+				QueryTo:   result.QueryTo,
+				HitFrom:   result.HitFrom,
+				HitTo:     result.HitTo,
+				Identity:  result.Identity,
+				AlignLen:  result.AlignLen,
+				Strand:    result.Strand,
+			}},
+		}
+
+		if result.QueryTo < result.QueryFrom {
+			data.Hits[i].HSPs = splitAtOrigin(data.Hits[i].HSPs[0], r.QueryLen)
+		}
+
+		for _, role := range result.Roles {
+			if glyph := SBOLGlyph(role); glyph != "" {
+				data.Hits[i].Glyph = glyph
+				break
+			}
+		}
+	}
+	data.stackTracks()
+
+	return data
+}
+
+// stackTracks puts each hit in the first track where it doesn't overlap
+// another one, so hits along different parts of the query share a row and
+// the map shows how the query is put together. Hits are best first, so the
+// best ones get the top tracks.
+func (d *Viewer) stackTracks() {
+	var tracks [][]ViewerHSP
+	for i := range d.Hits {
+		hit := &d.Hits[i]
+
+		hit.Track = 0
+		for ; hit.Track < len(tracks); hit.Track++ {
+			if !overlapsAny(hit.HSPs, tracks[hit.Track]) {
+				break
+			}
+		}
+		if hit.Track == len(tracks) {
+			tracks = append(tracks, nil)
+		}
+		tracks[hit.Track] = append(tracks[hit.Track], hit.HSPs...)
+	}
+
+	d.Tracks = len(tracks)
+}
+
+// querySpan is where an HSP lies on the query, lowest position first since
+// translated minus frame alignments run backwards along it.
+func (h ViewerHSP) querySpan() (from, to int) {
+	if h.QueryTo < h.QueryFrom {
+		return h.QueryTo, h.QueryFrom
+	}
+
+	return h.QueryFrom, h.QueryTo
+}
+
+// overlapsAny reports whether any of a overlap any of b along the query.
+func overlapsAny(a, b []ViewerHSP) bool {
+	for _, x := range a {
+		xFrom, xTo := x.querySpan()
+		for _, y := range b {
+			yFrom, yTo := y.querySpan()
+			if xFrom <= yTo && yFrom <= xTo {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// splitAtOrigin splits an alignment across the origin of a circular query
+// into the parts before and after it, so the viewer can draw them. Where
+// the hit splits is estimated ignoring gaps.
+func splitAtOrigin(hsp ViewerHSP, queryLen int) []ViewerHSP {
+	before, after := hsp, hsp
+	n := queryLen - hsp.QueryFrom + 1
+
+	before.QueryTo = queryLen
+	after.QueryFrom = 1
+	if hsp.HitFrom <= hsp.HitTo {
+		before.HitTo = hsp.HitFrom + n - 1
+		after.HitFrom = hsp.HitFrom + n
+	} else {
+		before.HitTo = hsp.HitFrom - n + 1
+		after.HitFrom = hsp.HitFrom - n
+	}
+
+	return []ViewerHSP{before, after}
+}
+
+// sbolGlyphs are the SBOL Visual glyphs for the Sequence Ontology roles
+// they stand for, in static/glyphs.
+var sbolGlyphs = map[string]string{
+	"SO:0000167": "promoter",
+	"SO:0000316": "cds",
+	"SO:0000141": "terminator",
+	"SO:0000139": "rbs",
+	"SO:0000057": "operator",
+	"SO:0000627": "insulator",
+	"SO:0000296": "origin-of-replication",
+	"SO:0005850": "primer-binding-site",
+	"SO:0000374": "ribozyme",
+	"SO:0000804": "engineered-region",
+}
+
+// RoleTerm returns the Sequence Ontology term of a role given by the name
+// of its glyph, like "promoter", or by its term.
+func RoleTerm(role string) (string, bool) {
+	if soTerm.MatchString(role) {
+		return role, true
+	}
+
+	for term, name := range sbolGlyphs {
+		if strings.EqualFold(name, role) {
+			return term, true
+		}
+	}
+
+	return "", false
+}
+
+var soTerm = regexp.MustCompile(`^SO:[0-9]{7}$`)
+
+// SBOLGlyph returns the name of the glyph for a role, empty if there isn't
+// one.
+func SBOLGlyph(role string) string {
+	return sbolGlyphs[role]
+}
@@ -0,0 +1,153 @@
+// Package savedsearch keeps queries that are run again against every new
+// db in Redis, and works out which of each run's hits are new, so whoever
+// saved them can be alerted.
+//
+//	st := savedsearch.Store{Key: "savedSearches"}
+//	s, err := st.Get(redisPool, id)
+//	...
+//	results, err := search(s.Query, s.Options)
+//	...
+//	if alert := s.Record(results, dbVersion); alert != nil {
+//		... email or post the alert ...
+//	}
+//	err = st.Update(redisPool, s)
+package savedsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/mediocregopher/radix.v2/util"
+
+	"github.com/schnauzer/synbioblast/pkg/align"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+// Search is a query that is run again against every new db, alerting
+// whoever saved it by email or webhook when new hits turn up.
+type Search struct {
+	ID       string        `json:"id"`
+	Query    string        `json:"query"`
+	Input    *blast.Input  `json:"input,omitempty"`
+	Options  align.Options `json:"options"`
+	Created  time.Time     `json:"created"`
+	LastRun  time.Time     `json:"lastRun,omitempty"`
+	Alerts   int           `json:"alerts"`
+	LastHits int           `json:"lastHits"`
+
+	// MinIdentity is how identical to the query, in percent, a new hit
+	// has to be to alert about it
+	MinIdentity float64 `json:"minIdentity,omitempty"`
+
+	Email   string `json:"email,omitempty"`
+	Webhook string `json:"webhook,omitempty"`
+
+	// Digest saves the email alerts up to send together every
+	// -email.digestInterval
+	Digest bool `json:"digest,omitempty"`
+
+	// DBVersion is the db it was last run against, and Seen the hashes of
+	// the sequences it has found so far. The first run only fills in Seen,
+	// so alerts are about sequences added after the search was saved.
+	DBVersion string   `json:"dbVersion,omitempty"`
+	Seen      []string `json:"seen,omitempty"`
+}
+
+// Alert is what's posted to a saved search's webhook.
+type Alert struct {
+	SavedSearch string      `json:"savedSearch"`
+	DBVersion   string      `json:"dbVersion"`
+	Hits        []blast.Hit `json:"hits"`
+}
+
+// Record notes a run of s against db version, returning an Alert with the
+// hits it hasn't found before, or nil if there aren't any or it's the
+// first run.
+func (s *Search) Record(results *blast.Results, version string) *Alert {
+	seen := map[string]bool{}
+	for _, hash := range s.Seen {
+		seen[hash] = true
+	}
+
+	newHits := []blast.Hit{}
+	for _, hit := range results.Results {
+		if hit.IdentityPercent() < s.MinIdentity || seen[hit.SeqHash] {
+			continue
+		}
+		seen[hit.SeqHash] = true
+		s.Seen = append(s.Seen, hit.SeqHash)
+		newHits = append(newHits, hit)
+	}
+
+	baseline := s.DBVersion == ""
+	s.DBVersion = version
+	s.LastRun = time.Now()
+	s.LastHits = len(results.Results)
+
+	if baseline || len(newHits) == 0 {
+		return nil
+	}
+	s.Alerts++
+
+	return &Alert{SavedSearch: s.ID, DBVersion: version, Hits: newHits}
+}
+
+// Store keeps saved searches as JSON in a Redis hash by id. It holds no
+// connections, every method takes the client or pool to use.
+type Store struct {
+	Key string
+}
+
+// Get returns the saved search with id, or nil if there isn't one.
+func (st Store) Get(c util.Cmder, id string) (*Search, error) {
+	resp := c.Cmd("HGET", st.Key, id)
+	if resp.IsType(redis.Nil) {
+		return nil, nil
+	}
+
+	b, err := resp.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Search{}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse saved search %s: %v", id, err)
+	}
+
+	return s, nil
+}
+
+// Put saves s.
+func (st Store) Put(c util.Cmder, s *Search) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return c.Cmd("HSET", st.Key, s.ID, b).Err
+}
+
+// Update saves s unless it's been deleted since it was read, so a run
+// that finishes after the search was deleted doesn't bring it back.
+func (st Store) Update(c util.Cmder, s *Search) error {
+	exists, err := c.Cmd("HEXISTS", st.Key, s.ID).Int()
+	if err != nil || exists == 0 {
+		return err
+	}
+
+	return st.Put(c, s)
+}
+
+// Delete removes the saved search with id.
+func (st Store) Delete(c util.Cmder, id string) error {
+	return c.Cmd("HDEL", st.Key, id).Err
+}
+
+// IDs lists the ids of every saved search.
+func (st Store) IDs(c util.Cmder) ([]string, error) {
+	return c.Cmd("HKEYS", st.Key).List()
+}
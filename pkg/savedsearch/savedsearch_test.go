@@ -0,0 +1,139 @@
+package savedsearch_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix.v2/redis"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/savedsearch"
+)
+
+func hit(hash string, identity int) blast.Hit {
+	return blast.Hit{SeqHash: hash, HitStats: blast.HitStats{Identity: identity, AlignLen: 100}}
+}
+
+func hashes(hits []blast.Hit) []string {
+	got := []string{}
+	for _, hit := range hits {
+		got = append(got, hit.SeqHash)
+	}
+
+	return got
+}
+
+func TestRecord(t *testing.T) {
+	s := &savedsearch.Search{ID: "abc", MinIdentity: 90}
+
+	// the first run only records what's already there
+	alert := s.Record(&blast.Results{Results: []blast.Hit{hit("a", 100), hit("weak", 50)}}, "v1")
+	if alert != nil {
+		t.Errorf("first run alerted %+v, want nothing", alert)
+	}
+	if s.DBVersion != "v1" || s.LastHits != 2 || s.LastRun.IsZero() || !reflect.DeepEqual(s.Seen, []string{"a"}) {
+		t.Errorf("after the first run the search is %+v, want it run against v1 with a seen", s)
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		hits    []blast.Hit
+		alerted []string
+	}{
+		{"nothing new", "v2", []blast.Hit{hit("a", 100)}, nil},
+		{"new", "v3", []blast.Hit{hit("a", 100), hit("b", 95), hit("weak", 60)}, []string{"b"}},
+		{"found again", "v4", []blast.Hit{hit("b", 95)}, nil},
+		{"several", "v5", []blast.Hit{hit("c", 90), hit("d", 100), hit("c", 90)}, []string{"c", "d"}},
+	}
+
+	alerts := 0
+	for _, test := range tests {
+		alert := s.Record(&blast.Results{Results: test.hits}, test.version)
+		if test.alerted == nil {
+			if alert != nil {
+				t.Errorf("%s: alerted %+v, want nothing", test.name, alert)
+			}
+			continue
+		}
+
+		alerts++
+		if alert == nil {
+			t.Errorf("%s: no alert, want one about %q", test.name, test.alerted)
+			continue
+		}
+		if alert.SavedSearch != "abc" || alert.DBVersion != test.version || !reflect.DeepEqual(hashes(alert.Hits), test.alerted) {
+			t.Errorf("%s: alerted %+v, want %q against %s", test.name, alert, test.alerted, test.version)
+		}
+		if s.Alerts != alerts {
+			t.Errorf("%s: %d alerts counted, want %d", test.name, s.Alerts, alerts)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	client, err := redis.Dial("tcp", m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	st := savedsearch.Store{Key: "test:savedSearches"}
+
+	s, err := st.Get(client, "missing")
+	if s != nil || err != nil {
+		t.Errorf("Get(missing) = %+v, %v, want nil, nil", s, err)
+	}
+
+	want := &savedsearch.Search{ID: "abc", Query: "acgt", Email: "someone@example.com", Seen: []string{"a"}}
+	err = st.Put(client, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.Get(client, "abc")
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Get(abc) = %+v, %v, want %+v", got, err, want)
+	}
+	if !m.Exists("test:savedSearches") {
+		t.Error("saved searches aren't kept under the store's key")
+	}
+
+	ids, err := st.IDs(client)
+	if err != nil || !reflect.DeepEqual(ids, []string{"abc"}) {
+		t.Errorf("IDs = %q, %v, want [abc]", ids, err)
+	}
+
+	got.Alerts = 1
+	err = st.Update(client, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Get(client, "abc"); got.Alerts != 1 {
+		t.Errorf("updated search has %d alerts, want 1", got.Alerts)
+	}
+
+	err = st.Delete(client, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a run that finishes after the search was deleted leaves it deleted
+	err = st.Update(client, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := st.Get(client, "abc"); s != nil || err != nil {
+		t.Errorf("Get after Delete and Update = %+v, %v, want nil, nil", s, err)
+	}
+
+	m.HSet("test:savedSearches", "broken", "{")
+	if _, err := st.Get(client, "broken"); err == nil {
+		t.Error("Get of a broken search = nil error, want one")
+	}
+}
@@ -0,0 +1,53 @@
+// Package sequence puts sequences into the one canonical form they're
+// hashed, stored and searched in, so a part slurped from SynBioHub and the
+// same part pasted into a search end up with the same hash:
+//
+//	seq, rna := sequence.Normalize(elements, false)
+//	hash := st.Hash(seq)
+//
+// The slurper normalizes components as they're fetched, and the server
+// normalizes queries once they've been stripped of headers and numbering.
+package sequence
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Normalize returns seq in canonical form: lowercase, without whitespace,
+// and if it's RNA (protein being unset, and nucleotide codes only, with U's
+// and no T's) with T's for U's, since blastn and the dbs only know DNA. rna
+// reports whether it was RNA.
+func Normalize(seq string, protein bool) (normalized string, rna bool) {
+	seq = strings.ToLower(strings.Map(func(c rune) rune {
+		if unicode.IsSpace(c) {
+			return -1
+		}
+		return c
+	}, seq))
+
+	if protein || !isRNA(seq) {
+		return seq, false
+	}
+
+	return strings.ReplaceAll(seq, "u", "t"), true
+}
+
+// isRNA reports whether a lowercase seq is all nucleotide codes, with U's
+// and no T's.
+func isRNA(seq string) bool {
+	if !strings.ContainsRune(seq, 'u') || strings.ContainsRune(seq, 't') {
+		return false
+	}
+
+	for _, c := range seq {
+		if !strings.ContainsRune(rnaCodes, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rnaCodes are the IUPAC nucleotide codes with U for T.
+const rnaCodes = "acgurykswmbdhvn"
@@ -0,0 +1,103 @@
+package slurp
+
+import "strings"
+
+// ORF is an open reading frame found in a DNA sequence.
+type ORF struct {
+	// Start and End are where the ORF is on the sequence, counting from 1
+	// and including the stop codon. Start is after End on the minus strand.
+	Start, End int
+
+	// Protein is the ORF's translation, without the stop
+	Protein string
+}
+
+// FindORFs returns the ORFs in seq at least minCodons long, not counting
+// the stop codon, on both strands. Each runs from the first ATG after the
+// last stop in its frame to the next stop, so nested ORFs aren't returned
+// separately. seq isn't treated as circular, and ORFs running off its end
+// are left out.
+func FindORFs(seq string, minCodons int) []ORF {
+	seq = strings.ToLower(seq)
+	orfs := findStrand(seq, minCodons, func(from, to int) (int, int) {
+		return from + 1, to
+	})
+
+	revcomp := reverseComplement(seq)
+	orfs = append(orfs, findStrand(revcomp, minCodons, func(from, to int) (int, int) {
+		return len(seq) - from, len(seq) - to + 1
+	})...)
+
+	return orfs
+}
+
+// findStrand finds the ORFs on the plus strand of seq, placing each on the
+// original sequence with position, which is given the 0-based start and
+// exclusive end on seq.
+func findStrand(seq string, minCodons int, position func(from, to int) (int, int)) []ORF {
+	orfs := []ORF{}
+	for frame := 0; frame < 3; frame++ {
+		start := -1
+		for i := frame; i+3 <= len(seq); i += 3 {
+			codon := seq[i : i+3]
+			if start < 0 && codon == "atg" {
+				start = i
+			}
+			if start < 0 || !stopCodons[codon] {
+				continue
+			}
+
+			if (i-start)/3 >= minCodons {
+				orf := ORF{Protein: Translate(seq[start:i])}
+				orf.Start, orf.End = position(start, i+3)
+				orfs = append(orfs, orf)
+			}
+			start = -1
+		}
+	}
+
+	return orfs
+}
+
+var stopCodons = map[string]bool{"taa": true, "tag": true, "tga": true}
+
+// Translate translates seq with the standard genetic code, to lowercase
+// like the slurped protein sequences. Codons with anything but a, c, g and
+// t in them become x.
+func Translate(seq string) string {
+	protein := make([]byte, 0, len(seq)/3)
+	for i := 0; i+3 <= len(seq); i += 3 {
+		aa, ok := geneticCode[strings.ToLower(seq[i:i+3])]
+		if !ok {
+			aa = 'x'
+		}
+		protein = append(protein, aa)
+	}
+
+	return string(protein)
+}
+
+// geneticCode is the standard genetic code, stops as *.
+var geneticCode = map[string]byte{}
+
+func init() {
+	const bases = "tcag"
+	const aminoAcids = "ffllssssyy**cc*wllllppppqqqqrrrriiimttttnnkkssrrvvvvaaaaddeegggg"
+	for i, aa := range aminoAcids {
+		codon := string([]byte{bases[i/16], bases[i/4%4], bases[i%4]})
+		geneticCode[codon] = byte(aa)
+	}
+}
+
+var complement = strings.NewReplacer(
+	"a", "t", "t", "a", "c", "g", "g", "c", "u", "a",
+)
+
+func reverseComplement(seq string) string {
+	b := []byte(complement.Replace(seq))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+
+	return string(b)
+}
@@ -0,0 +1,53 @@
+package slurp_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/schnauzer/synbioblast/pkg/slurp"
+)
+
+func TestFindORFs(t *testing.T) {
+	tests := []struct {
+		name      string
+		seq       string
+		minCodons int
+		orfs      []slurp.ORF
+	}{
+		{"plus", "ccatgaaataacc", 2, []slurp.ORF{{Start: 3, End: 11, Protein: "mk"}}},
+		{"minus", "ttatttcat", 2, []slurp.ORF{{Start: 9, End: 1, Protein: "mk"}}},
+		{"uppercase", "ATGAAATAA", 2, []slurp.ORF{{Start: 1, End: 9, Protein: "mk"}}},
+		{"too short", "atgaaataa", 3, []slurp.ORF{}},
+		{"nested", "atgatgaaatag", 1, []slurp.ORF{{Start: 1, End: 12, Protein: "mmk"}}},
+		{"runs off the end", "atgaaaaaa", 1, []slurp.ORF{}},
+		{"one after another", "atgaaataaatgccctga", 1, []slurp.ORF{
+			{Start: 1, End: 9, Protein: "mk"},
+			{Start: 10, End: 18, Protein: "mp"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			orfs := slurp.FindORFs(test.seq, test.minCodons)
+			if !reflect.DeepEqual(orfs, test.orfs) {
+				t.Errorf("FindORFs(%s, %d) = %+v, want %+v", test.seq, test.minCodons, orfs, test.orfs)
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	tests := map[string]string{
+		"atgaaataa": "mk*",
+		"ATGTGG":    "mw",
+		"atgnnntga": "mx*",
+		"atgaa":     "m",
+		"":          "",
+	}
+
+	for seq, want := range tests {
+		if got := slurp.Translate(seq); got != want {
+			t.Errorf("Translate(%q) = %q, want %q", seq, got, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package slurp
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/schnauzer/synbioblast/pkg/sequence"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+// soPrefix starts the Sequence Ontology roles, the only ones the slurper
+// keeps.
+const soPrefix = "http://identifiers.org/so/"
+
+// sbolDocument is the part of an SBOL2 RDF/XML document components are
+// read from.
+type sbolDocument struct {
+	XMLName              xml.Name        `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# RDF"`
+	ComponentDefinitions []sbolComponent `xml:"http://sbols.org/v2# ComponentDefinition"`
+	Sequences            []sbolSequence  `xml:"http://sbols.org/v2# Sequence"`
+}
+
+type sbolResource struct {
+	Resource string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# resource,attr"`
+}
+
+type sbolComponent struct {
+	About       string         `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Title       string         `xml:"http://purl.org/dc/terms/ title"`
+	Description string         `xml:"http://purl.org/dc/terms/ description"`
+	Created     string         `xml:"http://purl.org/dc/terms/ created"`
+	Roles       []sbolResource `xml:"http://sbols.org/v2# role"`
+	Sequences   []sbolResource `xml:"http://sbols.org/v2# sequence"`
+}
+
+type sbolSequence struct {
+	About    string       `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Elements string       `xml:"http://sbols.org/v2# elements"`
+	Encoding sbolResource `xml:"http://sbols.org/v2# encoding"`
+}
+
+// ParseSBOL reads the component definitions with sequences out of an SBOL2
+// RDF/XML document, such as one downloaded from SynBioHub, in the same form
+// Parse gives components fetched from an endpoint. Components without a
+// sequence, like abstract designs, are left out, and only the first
+// sequence of a component with several is used.
+func ParseSBOL(r io.Reader) ([]store.Component, error) {
+	doc := sbolDocument{}
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse sbol: %v", err)
+	}
+
+	sequences := map[string]sbolSequence{}
+	for _, s := range doc.Sequences {
+		sequences[s.About] = s
+	}
+
+	components := []store.Component{}
+	for _, cd := range doc.ComponentDefinitions {
+		if len(cd.Sequences) == 0 {
+			continue
+		}
+		s, ok := sequences[cd.Sequences[0].Resource]
+		if !ok {
+			return nil, fmt.Errorf("%s's sequence %s isn't in the document", cd.About, cd.Sequences[0].Resource)
+		}
+		if cd.About == "" {
+			return nil, errors.New("a component definition has no rdf:about uri")
+		}
+
+		c := store.Component{
+			URI:         cd.About,
+			Title:       strings.TrimSpace(cd.Title),
+			Description: strings.TrimSpace(cd.Description),
+			Protein:     s.Encoding.Resource == ProteinEncoding,
+		}
+		for _, role := range cd.Roles {
+			if strings.HasPrefix(role.Resource, soPrefix) && !slices.Contains(c.Roles, role.Resource) {
+				c.Roles = append(c.Roles, role.Resource)
+			}
+		}
+		if cd.Created != "" {
+			c.Created, err = parseSparqlTime(cd.Created)
+			if err != nil {
+				return nil, fmt.Errorf("%s has a bad dcterms:created: %v", cd.About, err)
+			}
+		}
+
+		c.Sequence, c.RNA = sequence.Normalize(s.Elements, c.Protein)
+		if c.Sequence == "" {
+			return nil, fmt.Errorf("%s has an empty sequence", cd.About)
+		}
+
+		components = append(components, c)
+	}
+
+	if len(components) == 0 {
+		return nil, errors.New("the sbol has no component definitions with sequences")
+	}
+
+	return components, nil
+}
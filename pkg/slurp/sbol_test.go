@@ -0,0 +1,100 @@
+package slurp_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/slurp"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+// sbol wraps component definitions and sequences in an SBOL2 document.
+func sbol(body string) string {
+	return `<?xml version="1.0" ?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmlns:dcterms="http://purl.org/dc/terms/"
+	xmlns:sbol="http://sbols.org/v2#">` + body + `</rdf:RDF>`
+}
+
+const (
+	rbs = `
+<sbol:ComponentDefinition rdf:about="https://synbiohub.org/public/igem/BBa_B0034/1">
+	<dcterms:title> BBa_B0034 </dcterms:title>
+	<dcterms:description>RBS (Elowitz 1999)</dcterms:description>
+	<dcterms:created>2017-03-06T15:00:00.000Z</dcterms:created>
+	<sbol:role rdf:resource="http://identifiers.org/so/SO:0000139"/>
+	<sbol:role rdf:resource="http://identifiers.org/so/SO:0000139"/>
+	<sbol:role rdf:resource="http://wiki.synbiohub.org/wiki/Terms/igem#partType/RBS"/>
+	<sbol:sequence rdf:resource="https://synbiohub.org/public/igem/BBa_B0034_sequence/1"/>
+</sbol:ComponentDefinition>
+<sbol:Sequence rdf:about="https://synbiohub.org/public/igem/BBa_B0034_sequence/1">
+	<sbol:elements>AAAG AGGA
+	GAAA</sbol:elements>
+	<sbol:encoding rdf:resource="http://www.chem.qmul.ac.uk/iubmb/misc/naseq.html"/>
+</sbol:Sequence>`
+
+	protein = `
+<sbol:ComponentDefinition rdf:about="https://synbiohub.org/public/igem/gfp/1">
+	<sbol:sequence rdf:resource="https://synbiohub.org/public/igem/gfp_sequence/1"/>
+</sbol:ComponentDefinition>
+<sbol:Sequence rdf:about="https://synbiohub.org/public/igem/gfp_sequence/1">
+	<sbol:elements>MSKGEE</sbol:elements>
+	<sbol:encoding rdf:resource="http://www.chem.qmul.ac.uk/iupac/AminoAcid/"/>
+</sbol:Sequence>`
+
+	abstract = `
+<sbol:ComponentDefinition rdf:about="https://synbiohub.org/public/igem/design/1">
+	<dcterms:title>design</dcterms:title>
+</sbol:ComponentDefinition>`
+)
+
+func TestParseSBOL(t *testing.T) {
+	components, err := slurp.ParseSBOL(strings.NewReader(sbol(rbs + protein + abstract)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []store.Component{
+		{
+			URI:         "https://synbiohub.org/public/igem/BBa_B0034/1",
+			Title:       "BBa_B0034",
+			Description: "RBS (Elowitz 1999)",
+			Created:     time.Date(2017, 3, 6, 15, 0, 0, 0, time.UTC),
+			Roles:       []string{"http://identifiers.org/so/SO:0000139"},
+			Sequence:    "aaagaggagaaa",
+		},
+		{
+			URI:      "https://synbiohub.org/public/igem/gfp/1",
+			Sequence: "mskgee",
+			Protein:  true,
+		},
+	}
+	if !reflect.DeepEqual(components, want) {
+		t.Errorf("ParseSBOL = %+v, want %+v", components, want)
+	}
+}
+
+func TestParseSBOLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"not xml", "ACGT"},
+		{"no sequences", sbol(abstract)},
+		{"missing sequence", sbol(strings.Replace(rbs, `<sbol:Sequence rdf:about="https://synbiohub.org/public/igem/BBa_B0034_sequence/1">`,
+			`<sbol:Sequence rdf:about="https://synbiohub.org/public/igem/other/1">`, 1))},
+		{"empty sequence", sbol(strings.Replace(protein, "MSKGEE", " ", 1))},
+		{"bad created", sbol(strings.Replace(rbs, "2017-03-06T15:00:00.000Z", "March 2017", 1))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := slurp.ParseSBOL(strings.NewReader(test.doc))
+			if err == nil {
+				t.Error("ParseSBOL = nil error, want one")
+			}
+		})
+	}
+}
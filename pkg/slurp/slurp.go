@@ -0,0 +1,796 @@
+// Package slurp fetches component definitions and their sequences from a
+// SynBioHub SPARQL endpoint, a page at a time in order of creation:
+//
+//	sizer := slurp.NewBatchSizer(100, 10, 1000, 5*time.Second)
+//	endpoint := slurp.Endpoint{URL: "https://synbiohub.org/sparql", Timeout: time.Minute}
+//	body, contentType, err := endpoint.Fetch(ctx, offset, sizer.Limit())
+//	...
+//	defer body.Close()
+//	components := make(chan store.Component)
+//	go func() {
+//		invalid, duplicates, err = slurp.Parse(ctx, body, contentType, components)
+//	}()
+//	for c := range components {
+//		...
+//	}
+//
+// The components can then be added to a store.Store as they're sent.
+// ParseSBOL reads them out of an SBOL document instead, like one
+// downloaded from SynBioHub.
+package slurp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knakk/sparql"
+	"github.com/schnauzer/synbioblast/pkg/sequence"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+// paginated with a scollable cursor as per:
+// http://blog.mynarz.net/2016/06/on-generating-sparql.html
+const query = `
+# tag: fetch
+PREFIX dcterms: <http://purl.org/dc/terms/>
+PREFIX sbol: <http://sbols.org/v2#>
+
+SELECT
+	?uri
+	?elements
+	?created
+	?title
+	?description
+	?encoding
+	?role{{if .Service}}
+	?serviceTitle
+	?serviceDescription
+	?serviceRole{{end}}
+{{range .Graphs}}FROM <{{.}}>
+{{end}}WHERE {
+	{
+		SELECT
+			?uri
+			?elements
+			?created
+			?title
+			?description
+			?encoding
+			?role{{if .Service}}
+			?serviceTitle
+			?serviceDescription
+			?serviceRole{{end}}
+		WHERE {
+			?uri a sbol:ComponentDefinition .
+			?uri sbol:sequence ?sequenceUri .
+			?sequenceUri sbol:elements ?elements .
+			OPTIONAL { ?sequenceUri sbol:encoding ?encoding . }
+			?uri dcterms:created ?created .
+			OPTIONAL { ?uri dcterms:title ?title . }
+			OPTIONAL { ?uri dcterms:description ?description . }
+			OPTIONAL {
+				?uri sbol:role ?role .
+				FILTER(STRSTARTS(STR(?role), "http://identifiers.org/so/"))
+			}{{if .Service}}
+			OPTIONAL {
+				SERVICE SILENT <{{.Service}}> {
+					OPTIONAL { ?uri dcterms:title ?serviceTitle . }
+					OPTIONAL { ?uri dcterms:description ?serviceDescription . }
+					OPTIONAL {
+						?uri sbol:role ?serviceRole .
+						FILTER(STRSTARTS(STR(?serviceRole), "http://identifiers.org/so/"))
+					}
+				}
+			}{{end}}
+		} ORDER BY ASC(str(?created))
+	}
+}
+LIMIT {{.Limit}} OFFSET {{.Offset}}
+`
+
+type queryParams struct {
+	Limit, Offset int
+	Graphs        []string
+	Service       string
+}
+
+// ProteinEncoding is the SBOL encoding of amino acid sequences, which go in
+// the protein db rather than blastn's.
+const ProteinEncoding = "http://www.chem.qmul.ac.uk/iupac/AminoAcid/"
+
+// The SPARQL results formats Fetch asks for, JSON first since it's simpler
+// to parse. Endpoints that don't do JSON return XML.
+const (
+	ResultsJSON = "application/sparql-results+json"
+	ResultsXML  = "application/sparql-results+xml"
+)
+
+// Endpoint is a SynBioHub SPARQL endpoint to fetch components from.
+type Endpoint struct {
+	URL string
+
+	// Graph is SynBioHub's graph parameter, which picks the public graph
+	// or a user's private one. It's left off if empty.
+	Graph string
+
+	// Graphs are the named graphs to query, merged into one so their
+	// components are paged through together. The endpoint's default graph
+	// is queried if there are none.
+	Graphs []string
+
+	// Service is a second SPARQL endpoint to fill in the titles,
+	// descriptions and roles of components missing them from, through
+	// SERVICE federation. Components it doesn't know about, or it being
+	// down, don't stop them being fetched.
+	Service string
+
+	// Timeout is how long to wait for a query's results to start arriving,
+	// no limit if 0. Virtuoso runs the whole query before sending anything,
+	// so this is how long the query can take.
+	Timeout time.Duration
+}
+
+// ErrTimeout is wrapped by the errors Fetch returns when a query timed out,
+// waiting for it or in Virtuoso.
+var ErrTimeout = errors.New("query timed out")
+
+// StatusError is an endpoint responding with an error.
+type StatusError struct {
+	StatusCode int
+
+	// RetryAfter is how long the endpoint asked to be left alone for, 0 if
+	// it didn't say.
+	RetryAfter time.Duration
+
+	// Message is the start of the response body, which Virtuoso puts its
+	// error in.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("sparql endpoint returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Unwrap makes errors.Is(err, ErrTimeout) true for gateway timeouts and
+// Virtuoso's own timeout errors.
+func (e *StatusError) Unwrap() error {
+	if e.StatusCode == http.StatusGatewayTimeout || virtuosoTimeout(e.Message) {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// RateLimited returns whether the endpoint is limiting how often it's
+// queried, rather than struggling with how big the queries are.
+func (e *StatusError) RateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// Overloaded returns whether the endpoint turned the query away to protect
+// itself, so it should be left alone for a while.
+func (e *StatusError) Overloaded() bool {
+	return e.RateLimited() || e.StatusCode == http.StatusServiceUnavailable
+}
+
+// virtuosoTimeouts are in the errors Virtuoso gives when a query runs out
+// of time, or it estimates it would.
+var virtuosoTimeouts = []string{"SR171", "S1T00", "S1TAT", "timed out", "estimated execution time"}
+
+func virtuosoTimeout(message string) bool {
+	for _, t := range virtuosoTimeouts {
+		if strings.Contains(message, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusError returns the error for a response that isn't results.
+func statusError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	e := &StatusError{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(b)),
+	}
+
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		if secs, err := strconv.Atoi(after); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(after); err == nil {
+			e.RetryAfter = time.Until(t)
+		}
+	}
+
+	return e
+}
+
+// iriExcluded are the characters SPARQL doesn't allow in an IRIREF.
+const iriExcluded = "<>\"{}|^`\\ \t\n\r"
+
+// cancelBody cancels its request's context once it's closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Fetch returns the body of the SPARQL results for limit components starting
+// at offset, in order of creation, and their content type to give to Parse.
+// The body is read as it arrives, so ctx has to last until it's been parsed,
+// and the caller has to close it. Errors from the endpoint are a
+// *StatusError, and timeouts wrap ErrTimeout.
+func (e Endpoint) Fetch(ctx context.Context, offset, limit int) (body io.ReadCloser, contentType string, err error) {
+	for _, iri := range append([]string{e.Service}, e.Graphs...) {
+		if strings.ContainsAny(iri, iriExcluded) {
+			return nil, "", fmt.Errorf("%q can't go in a query as an IRI", iri)
+		}
+	}
+
+	buf := bytes.NewBufferString(query)
+	bank := sparql.LoadBank(buf)
+
+	q, err := bank.Prepare("fetch", &queryParams{
+		Limit:   limit,
+		Offset:  offset,
+		Graphs:  e.Graphs,
+		Service: e.Service,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("couldn't prepare query: %v", err)
+	}
+
+	vals := url.Values{}
+	vals.Add("query", q)
+	if e.Graph != "" {
+		vals.Add("graph", e.Graph)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var timer *time.Timer
+	if e.Timeout > 0 {
+		timer = time.AfterFunc(e.Timeout, cancel)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.URL, strings.NewReader(vals.Encode()))
+	if err != nil {
+		cancel()
+		return nil, "", fmt.Errorf("couldn't prepare request: %v", err)
+	}
+	req.Header.Add("Accept", ResultsJSON+", "+ResultsXML+";q=0.9, */*;q=0.1")
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	timedOut := timer != nil && !timer.Stop()
+	switch {
+	case timedOut:
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, "", fmt.Errorf("%w: no results after %v", ErrTimeout, e.Timeout)
+	case err != nil:
+		cancel()
+		return nil, "", fmt.Errorf("couldn't make request: %v", err)
+	case resp.StatusCode != http.StatusOK:
+		err = statusError(resp)
+		resp.Body.Close()
+		cancel()
+		return nil, "", err
+	case resp.Header.Get("X-SQL-State") != "":
+		// Virtuoso sends what it found before hitting its own limit as if
+		// it were everything, saying so only in headers
+		resp.Body.Close()
+		cancel()
+		return nil, "", fmt.Errorf("%w: virtuoso returned partial results, %s: %s",
+			ErrTimeout, resp.Header.Get("X-SQL-State"), resp.Header.Get("X-SQL-Message"))
+	}
+
+	return cancelBody{resp.Body, cancel}, resp.Header.Get("Content-Type"), nil
+}
+
+// result is a row of the SPARQL Query Results XML Format:
+// https://www.w3.org/TR/rdf-sparql-XMLres/
+type result struct {
+	Bindings []binding `xml:"binding"`
+}
+
+// binding is one variable of a result, bound to exactly one of a uri, a
+// literal or a blank node.
+type binding struct {
+	Name    string   `xml:"name,attr"`
+	URI     *string  `xml:"uri"`
+	Literal *literal `xml:"literal"`
+	BNode   *string  `xml:"bnode"`
+}
+
+type literal struct {
+	Datatype string `xml:"datatype,attr"`
+	Lang     string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// The kinds of RDF term a variable can be bound to.
+const (
+	termURI     = "uri"
+	termLiteral = "literal"
+	termBNode   = "bnode"
+)
+
+// term is the value a variable is bound to in a row of results.
+type term struct {
+	Type     string
+	Value    string
+	Datatype string
+}
+
+// row is a result's bindings by variable name. Unbound variables, such
+// as unmatched OPTIONALs, are missing.
+type row map[string]term
+
+func (r result) row() row {
+	terms := row{}
+	for _, b := range r.Bindings {
+		switch {
+		case b.URI != nil:
+			terms[b.Name] = term{Type: termURI, Value: *b.URI}
+		case b.Literal != nil:
+			terms[b.Name] = term{Type: termLiteral, Value: b.Literal.Value, Datatype: b.Literal.Datatype}
+		case b.BNode != nil:
+			terms[b.Name] = term{Type: termBNode, Value: *b.BNode}
+		}
+	}
+
+	return terms
+}
+
+// uri returns the uri name is bound to, an error if it's bound to
+// something else, or if it's unbound and required.
+func (r row) uri(name string, required bool) (string, error) {
+	t, ok := r[name]
+	switch {
+	case !ok && required:
+		return "", fmt.Errorf("no %s", name)
+	case !ok:
+		return "", nil
+	case t.Type != termURI:
+		return "", fmt.Errorf("%s is a %s, not a uri", name, t.Type)
+	}
+
+	return t.Value, nil
+}
+
+// literal returns the literal name is bound to, an error if it's bound to
+// something else, or if it's unbound and required. Literals of any
+// datatype are taken as they're written.
+func (r row) literal(name string, required bool) (string, error) {
+	t, ok := r[name]
+	switch {
+	case !ok && required:
+		return "", fmt.Errorf("no %s", name)
+	case !ok:
+		return "", nil
+	case t.Type != termLiteral:
+		return "", fmt.Errorf("%s is a %s, not a literal", name, t.Type)
+	}
+
+	return t.Value, nil
+}
+
+const (
+	xsdDateTime = "http://www.w3.org/2001/XMLSchema#dateTime"
+	xsdDate     = "http://www.w3.org/2001/XMLSchema#date"
+	xsdString   = "http://www.w3.org/2001/XMLSchema#string"
+)
+
+// time returns the time name is bound to, which has to be an xsd:dateTime
+// or xsd:date, or an untyped or string literal in the same format.
+func (r row) time(name string) (time.Time, error) {
+	value, err := r.literal(name, true)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	switch datatype := r[name].Datatype; datatype {
+	case xsdDate:
+		return time.Parse("2006-01-02", strings.TrimSpace(value))
+	case xsdDateTime, xsdString, "":
+		return parseSparqlTime(strings.TrimSpace(value))
+	default:
+		return time.Time{}, fmt.Errorf("%s is a %s, not a date", name, datatype)
+	}
+}
+
+func parseSparqlTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		// xsd:dateTime's timezone is optional, Virtuoso often leaves it off
+		t, err = time.Parse("2006-01-02T15:04:05.999999999", s)
+	}
+	return t, err
+}
+
+// component returns the component a row of results describes.
+func (r row) component() (store.Component, error) {
+	c := store.Component{}
+
+	var err error
+	c.URI, err = r.uri("uri", true)
+	if err != nil {
+		return c, err
+	}
+
+	encoding, err := r.uri("encoding", false)
+	if err != nil {
+		return c, err
+	}
+	c.Protein = encoding == ProteinEncoding
+
+	role, err := r.uri("role", false)
+	if err != nil {
+		return c, err
+	}
+
+	c.Title, err = r.literal("title", false)
+	if err != nil {
+		return c, err
+	}
+	c.Description, err = r.literal("description", false)
+	if err != nil {
+		return c, err
+	}
+
+	// metadata from Endpoint.Service only fills in what the endpoint
+	// itself doesn't have, and being federated isn't trusted enough to
+	// make a row invalid
+	if role == "" {
+		role, _ = r.uri("serviceRole", false)
+	}
+	if role != "" {
+		c.Roles = []string{role}
+	}
+	if c.Title == "" {
+		c.Title, _ = r.literal("serviceTitle", false)
+	}
+	if c.Description == "" {
+		c.Description, _ = r.literal("serviceDescription", false)
+	}
+
+	c.Created, err = r.time("created")
+	if err != nil {
+		return c, err
+	}
+
+	elements, err := r.literal("elements", true)
+	if err != nil {
+		return c, err
+	}
+	c.Sequence, c.RNA = sequence.Normalize(elements, c.Protein)
+	if c.Sequence == "" {
+		return c, errors.New("empty sequence")
+	}
+
+	return c, nil
+}
+
+// jsonResults is the SPARQL 1.1 Query Results JSON Format:
+// https://www.w3.org/TR/sparql11-results-json/
+type jsonResults struct {
+	Head struct {
+		Vars []string `json:"vars"`
+	} `json:"head"`
+	Results struct {
+		Bindings []map[string]jsonTerm `json:"bindings"`
+	} `json:"results"`
+}
+
+type jsonTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Datatype string `json:"datatype"`
+	Lang     string `json:"xml:lang"`
+}
+
+func (t jsonTerm) term() term {
+	switch t.Type {
+	case "typed-literal":
+		// Virtuoso still uses the type from the W3C note the
+		// recommendation replaced
+		return term{Type: termLiteral, Value: t.Value, Datatype: t.Datatype}
+	case "bnode":
+		return term{Type: termBNode, Value: t.Value}
+	default:
+		return term{Type: t.Type, Value: t.Value, Datatype: t.Datatype}
+	}
+}
+
+// parseJSON reads JSON results from r, calling each with every row as it's
+// read until it returns an error.
+func parseJSON(r io.Reader, each func(row) error) error {
+	d := json.NewDecoder(r)
+	err := expectDelim(d, '{')
+	if err != nil {
+		return err
+	}
+	for d.More() {
+		key, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("couldn't parse json: %v", err)
+		}
+		if key != "results" {
+			err = skipValue(d)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = expectDelim(d, '{')
+		if err != nil {
+			return err
+		}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return fmt.Errorf("couldn't parse json: %v", err)
+			}
+			if key != "bindings" {
+				err = skipValue(d)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			err = expectDelim(d, '[')
+			if err != nil {
+				return err
+			}
+			for d.More() {
+				bindings := map[string]jsonTerm{}
+				err = d.Decode(&bindings)
+				if err != nil {
+					return fmt.Errorf("couldn't parse json: %v", err)
+				}
+
+				terms := row{}
+				for name, t := range bindings {
+					terms[name] = t.term()
+				}
+				err = each(terms)
+				if err != nil {
+					return err
+				}
+			}
+			err = expectDelim(d, ']')
+			if err != nil {
+				return err
+			}
+		}
+		err = expectDelim(d, '}')
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(d, '}')
+}
+
+func expectDelim(d *json.Decoder, delim json.Delim) error {
+	tok, err := d.Token()
+	if err != nil {
+		return fmt.Errorf("couldn't parse json: %v", err)
+	}
+	if tok != delim {
+		return fmt.Errorf("couldn't parse json: expected %v, got %v", delim, tok)
+	}
+
+	return nil
+}
+
+func skipValue(d *json.Decoder) error {
+	var skip json.RawMessage
+	err := d.Decode(&skip)
+	if err != nil {
+		return fmt.Errorf("couldn't parse json: %v", err)
+	}
+
+	return nil
+}
+
+// parseXML reads XML results from r, calling each with every row as it's
+// read until it returns an error.
+func parseXML(r io.Reader, each func(row) error) error {
+	d := xml.NewDecoder(r)
+	root := true
+	for {
+		tok, err := d.Token()
+		if err == io.EOF && !root {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't parse xml: %v", err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root {
+			if start.Name.Local != "sparql" {
+				return fmt.Errorf("couldn't parse xml: expected <sparql>, got <%s>", start.Name.Local)
+			}
+			root = false
+			continue
+		}
+		if start.Name.Local != "result" {
+			continue
+		}
+
+		res := result{}
+		err = d.DecodeElement(&res, &start)
+		if err != nil {
+			return fmt.Errorf("couldn't parse xml: %v", err)
+		}
+		err = each(res.row())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isJSON returns whether contentType is a JSON results format. Anything
+// else, including no content type, is taken to be XML.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == ResultsJSON || mediaType == "application/json"
+}
+
+// Parse reads results fetched with Fetch, sending the components in them
+// to components, and closes components when it's done. It returns how many
+// more components couldn't be used, and how many rows repeated a component
+// already in the page, which SynBioHub returns when sameAs inflation or
+// several roles join a component more than once. The roles of all a
+// component's rows are merged, the rest is taken from its first, so
+// components are only sent once the whole page is read.
+//
+// The offset of the next page is the number sent plus invalid, counting
+// each component once. That's fewer than the rows fetched if there were
+// duplicates, so some of the page might be fetched again, but a component
+// is never skipped for its duplicates having been counted in its place.
+//
+// A row that can't be used is logged and skipped, an error means the rest
+// of the results couldn't be read or ctx was done. What was read before it
+// is still sent, bar ctx being done, and is still good.
+func Parse(ctx context.Context, r io.Reader, contentType string, components chan<- store.Component) (invalid, duplicates int, err error) {
+	defer close(components)
+
+	parse := parseXML
+	if isJSON(contentType) {
+		parse = parseJSON
+	}
+
+	page := []store.Component{}
+	seen := map[string]int{}
+	err = parse(r, func(terms row) error {
+		c, err := terms.component()
+		if c.URI != "" {
+			if i, ok := seen[c.URI]; ok {
+				slog.Debug("merging duplicate row", "uri", c.URI)
+				duplicates++
+				if i >= 0 && err == nil {
+					for _, role := range c.Roles {
+						if !slices.Contains(page[i].Roles, role) {
+							page[i].Roles = append(page[i].Roles, role)
+						}
+					}
+				}
+				return nil
+			}
+			seen[c.URI] = -1
+		}
+		if err != nil {
+			slog.Warn("skipping component that can't be used", "uri", c.URI, "err", err)
+			invalid++
+			return nil
+		}
+
+		seen[c.URI] = len(page)
+		page = append(page, c)
+		return ctx.Err()
+	})
+
+	for _, c := range page {
+		select {
+		case components <- c:
+		case <-ctx.Done():
+			return invalid, duplicates, ctx.Err()
+		}
+	}
+
+	return invalid, duplicates, err
+}
+
+// BatchSizer picks how many components to ask for in each query. It grows
+// the page size while queries come back quickly and shrinks it when they're
+// slow or failing, so fast endpoints aren't held back and slow ones aren't
+// pushed into timing out.
+type BatchSizer struct {
+	limit    int
+	min, max int
+	target   time.Duration
+}
+
+// NewBatchSizer returns a BatchSizer starting at limit and staying between
+// min and max, aiming for queries that take target.
+func NewBatchSizer(limit, min, max int, target time.Duration) *BatchSizer {
+	b := &BatchSizer{min: min, max: max, target: target}
+	b.set(limit, "initial value")
+	return b
+}
+
+// Limit returns how many components to ask for in the next query.
+func (b *BatchSizer) Limit() int {
+	return b.limit
+}
+
+func (b *BatchSizer) set(limit int, reason string) {
+	if limit < b.min {
+		limit = b.min
+	}
+	if limit > b.max {
+		limit = b.max
+	}
+
+	if limit != b.limit {
+		slog.Info("adjusting result limit", "from", b.limit, "to", limit, "reason", reason)
+	}
+	b.limit = limit
+}
+
+// Succeeded records a query that took d to complete.
+func (b *BatchSizer) Succeeded(d time.Duration) {
+	switch {
+	case d > b.target:
+		b.set(b.limit/2, fmt.Sprintf("query took %v, over target of %v", d, b.target))
+	case d < b.target/2:
+		b.set(b.limit+b.limit/2, fmt.Sprintf("query took %v, well under target of %v", d, b.target))
+	}
+}
+
+// Failed records a query that errored out, which is usually a timeout. Being
+// rate limited isn't down to the page size, and smaller pages would only
+// mean more queries, so that leaves it as it is.
+func (b *BatchSizer) Failed(err error) {
+	var se *StatusError
+	if errors.As(err, &se) && se.RateLimited() {
+		return
+	}
+
+	b.set(b.limit/2, fmt.Sprintf("query failed: %v", err))
+}
@@ -0,0 +1,294 @@
+package slurp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/slurp"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+const jsonResults = `{
+	"head": {"vars": ["uri", "elements", "created", "title", "role"]},
+	"results": {"bindings": [
+		{
+			"uri": {"type": "uri", "value": "https://synbiohub.org/public/igem/BBa_B0034/1"},
+			"elements": {"type": "literal", "value": "AAAGAGGAGAAA"},
+			"created": {"type": "typed-literal", "datatype": "http://www.w3.org/2001/XMLSchema#dateTime", "value": "2017-03-06T15:00:00"},
+			"title": {"type": "literal", "value": "BBa_B0034"},
+			"role": {"type": "uri", "value": "http://identifiers.org/so/SO:0000139"}
+		},
+		{
+			"uri": {"type": "uri", "value": "https://synbiohub.org/public/igem/BBa_B0034/1"},
+			"elements": {"type": "literal", "value": "AAAGAGGAGAAA"},
+			"created": {"type": "typed-literal", "datatype": "http://www.w3.org/2001/XMLSchema#dateTime", "value": "2017-03-06T15:00:00"},
+			"role": {"type": "uri", "value": "http://identifiers.org/so/SO:0000141"}
+		},
+		{
+			"uri": {"type": "uri", "value": "https://synbiohub.org/public/igem/empty/1"},
+			"elements": {"type": "literal", "value": " "},
+			"created": {"type": "literal", "value": "2017-03-07T15:00:00Z"}
+		},
+		{
+			"uri": {"type": "uri", "value": "https://synbiohub.org/public/igem/rna/1"},
+			"elements": {"type": "literal", "value": "ACGU"},
+			"created": {"type": "typed-literal", "datatype": "http://www.w3.org/2001/XMLSchema#date", "value": "2017-03-08"}
+		}
+	]}
+}`
+
+const xmlResults = `<?xml version="1.0"?>
+<sparql xmlns="http://www.w3.org/2005/sparql-results#">
+	<head><variable name="uri"/><variable name="elements"/><variable name="created"/></head>
+	<results>
+		<result>
+			<binding name="uri"><uri>https://synbiohub.org/public/igem/BBa_B0034/1</uri></binding>
+			<binding name="elements"><literal>aaagaggagaaa</literal></binding>
+			<binding name="created"><literal datatype="http://www.w3.org/2001/XMLSchema#dateTime">2017-03-06T15:00:00Z</literal></binding>
+		</result>
+		<result>
+			<binding name="uri"><bnode>b0</bnode></binding>
+			<binding name="elements"><literal>acgt</literal></binding>
+			<binding name="created"><literal>2017-03-06T15:00:00Z</literal></binding>
+		</result>
+	</results>
+</sparql>`
+
+func parse(t *testing.T, body, contentType string) ([]store.Component, int, int) {
+	t.Helper()
+
+	components := make(chan store.Component)
+	var (
+		invalid, duplicates int
+		err                 error
+	)
+	done := make(chan struct{})
+	go func() {
+		invalid, duplicates, err = slurp.Parse(context.Background(), strings.NewReader(body), contentType, components)
+		close(done)
+	}()
+
+	got := []store.Component{}
+	for c := range components {
+		got = append(got, c)
+	}
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return got, invalid, duplicates
+}
+
+func TestParse(t *testing.T) {
+	rbs := store.Component{
+		URI:      "https://synbiohub.org/public/igem/BBa_B0034/1",
+		Title:    "BBa_B0034",
+		Created:  time.Date(2017, 3, 6, 15, 0, 0, 0, time.UTC),
+		Roles:    []string{"http://identifiers.org/so/SO:0000139", "http://identifiers.org/so/SO:0000141"},
+		Sequence: "aaagaggagaaa",
+	}
+	rna := store.Component{
+		URI:      "https://synbiohub.org/public/igem/rna/1",
+		Created:  time.Date(2017, 3, 8, 0, 0, 0, 0, time.UTC),
+		Sequence: "acgt",
+		RNA:      true,
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		components  []store.Component
+		invalid     int
+		duplicates  int
+	}{
+		{"json", jsonResults, slurp.ResultsJSON + "; charset=UTF-8", []store.Component{rbs, rna}, 1, 1},
+		{"xml", xmlResults, slurp.ResultsXML, []store.Component{{URI: rbs.URI, Created: rbs.Created, Sequence: rbs.Sequence}}, 1, 0},
+		{"no content type", xmlResults, "", []store.Component{{URI: rbs.URI, Created: rbs.Created, Sequence: rbs.Sequence}}, 1, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			components, invalid, duplicates := parse(t, test.body, test.contentType)
+			if !reflect.DeepEqual(components, test.components) {
+				t.Errorf("components = %+v, want %+v", components, test.components)
+			}
+			if invalid != test.invalid || duplicates != test.duplicates {
+				t.Errorf("%d invalid and %d duplicates, want %d and %d", invalid, duplicates, test.invalid, test.duplicates)
+			}
+		})
+	}
+}
+
+func TestParseBroken(t *testing.T) {
+	for _, body := range []string{`{"results": {"bindings": [`, `<html>`} {
+		components := make(chan store.Component)
+		go func() {
+			for range components {
+			}
+		}()
+		_, _, err := slurp.Parse(context.Background(), strings.NewReader(body), "", components)
+		if err == nil {
+			t.Errorf("Parse(%q) = nil error, want one", body)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	var query, graph string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, graph = r.FormValue("query"), r.FormValue("graph")
+		w.Header().Set("Content-Type", slurp.ResultsJSON)
+		io.WriteString(w, jsonResults)
+	}))
+	defer srv.Close()
+
+	e := slurp.Endpoint{
+		URL:     srv.URL,
+		Graph:   "https://synbiohub.org/public",
+		Graphs:  []string{"https://synbiohub.org/public/igem"},
+		Timeout: time.Minute,
+	}
+	body, contentType, err := e.Fetch(context.Background(), 200, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	b, _ := io.ReadAll(body)
+
+	if contentType != slurp.ResultsJSON || string(b) != jsonResults {
+		t.Errorf("Fetch returned %s %q, want the endpoint's results", contentType, b)
+	}
+	for _, want := range []string{"LIMIT 100 OFFSET 200", "FROM <https://synbiohub.org/public/igem>"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query %s doesn't have %q", query, want)
+		}
+	}
+	if strings.Contains(query, "SERVICE") {
+		t.Errorf("query %s is federated without a Service", query)
+	}
+	if graph != e.Graph {
+		t.Errorf("graph = %q, want %q", graph, e.Graph)
+	}
+
+	e.Graphs = []string{"https://synbiohub.org/> } DROP ALL {"}
+	_, _, err = e.Fetch(context.Background(), 0, 100)
+	if err == nil {
+		t.Error("Fetch with a graph that isn't an IRI = nil error, want one")
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		timeout     bool
+		status      int
+		retryAfter  time.Duration
+		rateLimited bool
+		overloaded  bool
+	}{
+		{"rate limited", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}, false, http.StatusTooManyRequests, 30 * time.Second, true, true},
+		{"unavailable", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, false, http.StatusServiceUnavailable, 0, false, true},
+		{"gateway timeout", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}, true, http.StatusGatewayTimeout, 0, false, false},
+		{"virtuoso timeout", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "Virtuoso S1T00 Error SR171: Transaction timed out")
+		}, true, http.StatusInternalServerError, 0, false, false},
+		{"partial results", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-SQL-State", "S1TAT")
+			w.Header().Set("X-SQL-Message", "anytime query")
+			io.WriteString(w, jsonResults)
+		}, true, 0, 0, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(test.handler)
+			defer srv.Close()
+
+			_, _, err := slurp.Endpoint{URL: srv.URL}.Fetch(context.Background(), 0, 100)
+			if err == nil {
+				t.Fatal("Fetch = nil error, want one")
+			}
+			if errors.Is(err, slurp.ErrTimeout) != test.timeout {
+				t.Errorf("Fetch = %v, want timeout %v", err, test.timeout)
+			}
+
+			se := &slurp.StatusError{}
+			if !errors.As(err, &se) {
+				if test.status != 0 {
+					t.Errorf("Fetch = %v, want a *StatusError", err)
+				}
+				return
+			}
+			if se.StatusCode != test.status || se.RetryAfter != test.retryAfter {
+				t.Errorf("status %d retrying after %v, want %d after %v", se.StatusCode, se.RetryAfter, test.status, test.retryAfter)
+			}
+			if se.RateLimited() != test.rateLimited || se.Overloaded() != test.overloaded {
+				t.Errorf("rate limited %v and overloaded %v, want %v and %v", se.RateLimited(), se.Overloaded(), test.rateLimited, test.overloaded)
+			}
+		})
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	_, _, err := slurp.Endpoint{URL: srv.URL, Timeout: 10 * time.Millisecond}.Fetch(context.Background(), 0, 100)
+	if !errors.Is(err, slurp.ErrTimeout) {
+		t.Errorf("Fetch from an endpoint that never answers = %v, want %v", err, slurp.ErrTimeout)
+	}
+}
+
+func TestBatchSizer(t *testing.T) {
+	b := slurp.NewBatchSizer(100, 10, 200, 10*time.Second)
+
+	steps := []struct {
+		name  string
+		step  func()
+		limit int
+	}{
+		{"on target", func() { b.Succeeded(7 * time.Second) }, 100},
+		{"quick", func() { b.Succeeded(time.Second) }, 150},
+		{"quick up to max", func() { b.Succeeded(time.Second) }, 200},
+		{"slow", func() { b.Succeeded(time.Minute) }, 100},
+		{"rate limited", func() { b.Failed(&slurp.StatusError{StatusCode: http.StatusTooManyRequests}) }, 100},
+		{"failed", func() { b.Failed(slurp.ErrTimeout) }, 50},
+		{"failed down to min", func() {
+			for i := 0; i < 5; i++ {
+				b.Failed(slurp.ErrTimeout)
+			}
+		}, 10},
+	}
+
+	for _, step := range steps {
+		step.step()
+		if b.Limit() != step.limit {
+			t.Errorf("%s: limit = %d, want %d", step.name, b.Limit(), step.limit)
+		}
+	}
+}
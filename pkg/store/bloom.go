@@ -0,0 +1,163 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Bloom is a Bloom filter of sequence hashes. It can say a hash definitely
+// isn't in the dedup set without asking Redis, so components with new
+// sequences skip checking for themselves. It never forgets, so hashes
+// deleted from the set are false positives, which only cost the Redis
+// check. It's safe for concurrent use.
+type Bloom struct {
+	mu   sync.RWMutex
+	bits []uint64
+	k    uint64
+	n    int
+}
+
+// NewBloom returns an empty Bloom filter sized to hold capacity hashes with
+// a false positive rate of fpRate. It carries on working past capacity,
+// just with more false positives.
+func NewBloom(capacity int, fpRate float64) *Bloom {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	m := math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(capacity) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+
+	return &Bloom{
+		bits: make([]uint64, (uint64(m)+63)/64),
+		k:    uint64(k),
+	}
+}
+
+// locations returns the bits hash sets, by double hashing two halves of it.
+// Sequence hashes are digests, so they're already well mixed.
+func (b *Bloom) locations(hash string) []uint64 {
+	raw, err := hex.DecodeString(hash[strings.LastIndex(hash, "-")+1:])
+	if err != nil || len(raw) < 16 {
+		h := fnv.New128a()
+		h.Write([]byte(hash))
+		raw = h.Sum(nil)
+	}
+	h1 := binary.BigEndian.Uint64(raw[:8])
+	h2 := binary.BigEndian.Uint64(raw[8:16]) | 1
+
+	m := uint64(len(b.bits)) * 64
+	locations := make([]uint64, b.k)
+	for i := range locations {
+		locations[i] = (h1 + uint64(i)*h2) % m
+	}
+
+	return locations
+}
+
+// Add adds hash to the filter.
+func (b *Bloom) Add(hash string) {
+	locations := b.locations(hash)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, l := range locations {
+		b.bits[l/64] |= 1 << (l % 64)
+	}
+	b.n++
+}
+
+// MayContain returns false if hash was never added, and true if it
+// probably was.
+func (b *Bloom) MayContain(hash string) bool {
+	locations := b.locations(hash)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, l := range locations {
+		if b.bits[l/64]&(1<<(l%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns how many hashes have been added, counting any added twice
+// twice.
+func (b *Bloom) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.n
+}
+
+// LoadBloom returns a Bloom filter of the hashes in the dedup set, with room
+// for it to double in size at fpRate false positives.
+func (s *Store) LoadBloom(client *redis.Client, fpRate float64) (*Bloom, error) {
+	n, err := s.Count(client)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't count dedup set: %v", err)
+	}
+
+	b := NewBloom(max(2*n, minBloomCapacity), fpRate)
+
+	cursor := "0"
+	for {
+		resp, err := client.Cmd("SSCAN", s.Keys.Dedup, cursor, "COUNT", 1000).Array()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't scan dedup set: %v", err)
+		}
+		if len(resp) != 2 {
+			return nil, fmt.Errorf("SSCAN returned %d values, expected 2", len(resp))
+		}
+
+		cursor, err = resp[0].Str()
+		if err != nil {
+			return nil, err
+		}
+		hashes, err := resp[1].List()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hash := range hashes {
+			b.Add(hash)
+		}
+
+		if cursor == "0" {
+			return b, nil
+		}
+	}
+}
+
+// minBloomCapacity keeps filters of small or empty stores from filling up
+// straight away.
+const minBloomCapacity = 1 << 20
+
+// stored returns whether uri is already stored under hash, only asking Redis
+// if the Bloom filter says the hash might be there. Without a filter it
+// says no, and the caller writes everything as it always has.
+func (s *Store) stored(client *redis.Client, hash, uri string) (bool, error) {
+	if s.Bloom == nil || !s.Bloom.MayContain(hash) {
+		return false, nil
+	}
+
+	stored, err := client.Cmd("SISMEMBER", s.Keys.SeqSetPrefix+":"+hash, uri).Int()
+	if err != nil {
+		return false, fmt.Errorf("couldn't check sequence set: %v", err)
+	}
+
+	return stored == 1, nil
+}
@@ -0,0 +1,273 @@
+package store
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/schnauzer/synbioblast/pkg/sequence"
+)
+
+// tempSuffix marks fastas being written. They don't end in .fasta, so
+// builddb.sh never picks one up half written.
+const tempSuffix = ".tmp"
+
+// writeAtomic writes b to filename through a temporary file renamed over
+// it, so a crash leaves the old file or the new one, never part of one.
+// With sync set the file and its directory are fsynced, otherwise that's
+// left to SyncFastas.
+func writeAtomic(filename string, b []byte, sync bool) error {
+	dir := filepath.Dir(filename)
+	f, err := os.CreateTemp(dir, filepath.Base(filename)+tempSuffix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), filename)
+	if err != nil {
+		return err
+	}
+
+	if sync {
+		return syncPath(dir)
+	}
+	return nil
+}
+
+// syncPath fsyncs a file or directory.
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
+
+// SyncFastas fsyncs the fastas written since it was last called, and their
+// directories, when SyncPerBatch is set. Call it before recording a batch as
+// done, so a crash can't lose fastas the cursor has gone past.
+func (s *Store) SyncFastas() error {
+	s.mu.Lock()
+	unsynced := s.unsynced
+	s.unsynced = nil
+	s.mu.Unlock()
+
+	dirs := map[string]bool{}
+	for _, filename := range unsynced {
+		err := syncPath(filename)
+		if err != nil {
+			return err
+		}
+		dirs[filepath.Dir(filename)] = true
+	}
+	for dir := range dirs {
+		err := syncPath(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BadFasta is a fasta that can't go into the blast db.
+type BadFasta struct {
+	Path    string
+	Hash    string
+	Problem string
+
+	// URIs are the components using the sequence, which a fasta can be
+	// written for again by slurping them again
+	URIs []string
+}
+
+// VerifyFastas checks every fasta is whole: a header naming the hash it's
+// stored under, a sequence that hashes to it, and a final newline. It also
+// finds temporary files left by writes that never finished. With repair
+// set the bad files are removed, so they can't poison makeblastdb, leaving
+// Redis as it is; their fastas are written again when their components are
+// next slurped.
+func (s *Store) VerifyFastas(client *redis.Client, repair bool) ([]BadFasta, error) {
+	bad := []BadFasta{}
+	for _, protein := range []bool{false, true} {
+		err := filepath.WalkDir(s.Dir(protein), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+
+			problem := ""
+			hash := ""
+			switch {
+			case strings.Contains(d.Name(), ".fasta"+tempSuffix):
+				problem = "temporary file left by an unfinished write"
+			case strings.HasSuffix(d.Name(), ".fasta"):
+				hash = strings.TrimSuffix(d.Name(), ".fasta")
+				fasta, err := ioutil.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				problem = fastaProblem(hash, string(fasta), protein)
+			}
+			if problem == "" {
+				return nil
+			}
+
+			b := BadFasta{Path: path, Hash: hash, Problem: problem}
+			if hash != "" {
+				uris, err := s.URIs(client, []string{hash})
+				if err != nil {
+					return err
+				}
+				b.URIs = uris[0]
+			}
+			bad = append(bad, b)
+
+			if repair {
+				return os.Remove(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return bad, err
+		}
+	}
+
+	return bad, nil
+}
+
+// fastaProblem returns what's wrong with a fasta stored under hash, or ""
+// if nothing is. Sequences hashed by any of the Hashers are whole, as are
+// those hashed before they were normalized; moving them is Rehash's job.
+func fastaProblem(hash, fasta string, protein bool) string {
+	if !strings.HasSuffix(fasta, "\n") {
+		return "truncated, no final newline"
+	}
+
+	lines := strings.Split(strings.TrimSpace(fasta), "\n")
+	if lines[0] != ">"+hash {
+		return fmt.Sprintf("header is %q, not >%s", lines[0], hash)
+	}
+	if len(lines) < 2 {
+		return "truncated, no sequence"
+	}
+
+	raw := strings.Join(lines[1:], "")
+	normalized, _ := sequence.Normalize(raw, protein)
+	for _, h := range Hashers {
+		if h.Hash(raw) == hash || h.Hash(normalized) == hash {
+			return ""
+		}
+	}
+	return "truncated or corrupt, the sequence doesn't hash to its name"
+}
+
+// FastaPath returns where the fasta of the sequence stored under hash goes,
+// sharded by the first two pairs of hex digits of the hash like git
+// objects, ab/cd/abcd....fasta, so no one directory gets millions of files.
+func (s *Store) FastaPath(hash string, protein bool) string {
+	digest := hash[strings.LastIndex(hash, "-")+1:]
+	if len(digest) < 4 {
+		return s.flatPath(hash, protein)
+	}
+
+	return filepath.Join(s.Dir(protein), digest[:2], digest[2:4], hash+".fasta")
+}
+
+// flatPath is where fastas went before they were sharded.
+func (s *Store) flatPath(hash string, protein bool) string {
+	return filepath.Join(s.Dir(protein), hash+".fasta")
+}
+
+// readFasta reads the fasta stored under hash, from where it was written
+// before sharding if it hasn't been moved yet.
+func (s *Store) readFasta(hash string, protein bool) ([]byte, error) {
+	fasta, err := ioutil.ReadFile(s.FastaPath(hash, protein))
+	if os.IsNotExist(err) {
+		fasta, err = ioutil.ReadFile(s.flatPath(hash, protein))
+	}
+	return fasta, err
+}
+
+// removeFasta removes the fasta stored under hash, wherever it is.
+func (s *Store) removeFasta(hash string, protein bool) error {
+	for _, path := range []string{s.FastaPath(hash, protein), s.flatPath(hash, protein)} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkFastas calls fn with the path and hash of every fasta in dir, sharded
+// or not, in lexical order.
+func WalkFastas(dir string, fn func(path, hash string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".fasta") {
+			return nil
+		}
+
+		return fn(path, strings.TrimSuffix(d.Name(), ".fasta"), d)
+	})
+}
+
+// Shard moves fastas written before sharding to their sharded paths,
+// returning how many it moved. It's safe to run while the slurper and
+// servers are running, they look in both places.
+func (s *Store) Shard() (int, error) {
+	moved := 0
+	for _, protein := range []bool{false, true} {
+		entries, err := os.ReadDir(s.Dir(protein))
+		if err != nil {
+			return moved, err
+		}
+
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".fasta") {
+				continue
+			}
+			hash := strings.TrimSuffix(e.Name(), ".fasta")
+			to := s.FastaPath(hash, protein)
+			if to == s.flatPath(hash, protein) {
+				continue
+			}
+
+			err = os.MkdirAll(filepath.Dir(to), 0755)
+			if err != nil {
+				return moved, err
+			}
+			err = os.Rename(s.flatPath(hash, protein), to)
+			if err != nil {
+				return moved, err
+			}
+			moved++
+		}
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Hasher is a digest sequences can be hashed with. A sequence's hash is its
+// only identity, naming its fasta and identifying it in blast hits, so a
+// store has to use one hasher throughout; Rehash moves a store over to
+// another.
+type Hasher struct {
+	// Name is what -sequences.hash calls it
+	Name string
+
+	// Prefix starts every hash it makes, versioning them so which hasher
+	// made a hash can be told from the hash. SHA1's are unprefixed, being
+	// from before there was a choice.
+	Prefix string
+
+	new func() hash.Hash
+}
+
+// The hashers there are. SHA256 is the default, SHA1 is what stores
+// slurped before there was a choice use.
+var (
+	SHA1   = &Hasher{Name: "sha1", new: sha1.New}
+	SHA256 = &Hasher{Name: "sha256", Prefix: "v2-", new: sha256.New}
+
+	Hashers = map[string]*Hasher{
+		SHA1.Name:   SHA1,
+		SHA256.Name: SHA256,
+	}
+)
+
+// Hash returns the hash seq is stored under. seq should be in the form
+// sequence.Normalize puts it in.
+func (h *Hasher) Hash(seq string) string {
+	d := h.new()
+	io.WriteString(d, seq)
+	return fmt.Sprintf("%s%x", h.Prefix, d.Sum(nil))
+}
+
+// hashPattern matches a hash from any of the Hashers.
+var hashPattern = func() *regexp.Regexp {
+	patterns := []string{}
+	for _, h := range Hashers {
+		patterns = append(patterns, fmt.Sprintf("%s[0-9a-f]{%d}", regexp.QuoteMeta(h.Prefix), h.new().Size()*2))
+	}
+	sort.Strings(patterns)
+
+	return regexp.MustCompile("^(" + strings.Join(patterns, "|") + ")$")
+}()
+
+// ValidHash returns whether hash could have been made by one of the
+// Hashers, which also makes it safe to use in a path.
+func ValidHash(hash string) bool {
+	return hashPattern.MatchString(hash)
+}
+
+// hasherOf returns the Hasher that made hash, or nil if none could have.
+func hasherOf(hash string) *Hasher {
+	for _, h := range Hashers {
+		if len(hash) == len(h.Prefix)+h.new().Size()*2 && strings.HasPrefix(hash, h.Prefix) {
+			return h
+		}
+	}
+	return nil
+}
+
+// hasherSample is how many stored hashes CheckHasher looks at.
+const hasherSample = 20
+
+// CheckHasher checks a sample of the sequences already stored were hashed
+// with the store's Hasher, since searches and lookups of a store hashed
+// with another find nothing. Run Rehash to move it over.
+func (s *Store) CheckHasher(client *redis.Client) error {
+	hasher := s.Hasher
+	if hasher == nil {
+		hasher = SHA1
+	}
+
+	hashes, err := client.Cmd("SRANDMEMBER", s.Keys.Dedup, hasherSample).List()
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		h := hasherOf(hash)
+		if h != nil && h != hasher {
+			return fmt.Errorf("the stored sequences are hashed with %s, not %s, rehash them or hash with %s", h.Name, hasher.Name, h.Name)
+		}
+	}
+
+	return nil
+}
+
+// Hash returns the hash seq is stored under with the store's Hasher.
+func (s *Store) Hash(seq string) string {
+	if s.Hasher == nil {
+		return SHA1.Hash(seq)
+	}
+	return s.Hasher.Hash(seq)
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Measurement is what's recorded about each sequence when it's stored, so
+// it doesn't take reading its fasta.
+type Measurement struct {
+	Length int `json:"length,omitempty"`
+
+	// GC is the percentage of the sequence's a, c, g and t bases that are
+	// g or c, 0 for proteins
+	GC float64 `json:"gc,omitempty"`
+}
+
+// Measure returns the length and GC content of seq.
+func Measure(seq string, protein bool) Measurement {
+	m := Measurement{Length: len(seq)}
+	if protein {
+		return m
+	}
+
+	acgt, gc := 0, 0
+	for i := 0; i < len(seq); i++ {
+		switch seq[i] {
+		case 'g', 'c', 'G', 'C':
+			gc++
+			acgt++
+		case 'a', 't', 'A', 'T':
+			acgt++
+		}
+	}
+	if acgt > 0 {
+		m.GC = 100 * float64(gc) / float64(acgt)
+	}
+
+	return m
+}
+
+// lengthBuckets are the lower bounds of the length histogram's buckets.
+var lengthBuckets = []int{0, 100, 200, 500, 1000, 2000, 5000, 10000, 20000, 50000, 100000}
+
+func lengthBucket(length int) int {
+	i := sort.SearchInts(lengthBuckets, length+1) - 1
+	return lengthBuckets[i]
+}
+
+// record records the measurements of a sequence newly stored under hash.
+// A sequence already measured is left alone, so it's only counted in the
+// histogram once when MeasureAll and the slurper both get to it.
+func (s *Store) record(client *redis.Client, hash, seq string, protein bool) error {
+	m := Measure(seq, protein)
+
+	set, err := client.Cmd("HSETNX", s.Keys.Lengths, hash, m.Length).Int()
+	if err != nil {
+		return fmt.Errorf("couldn't record length: %v", err)
+	}
+	if set == 0 {
+		return nil
+	}
+	if !protein {
+		err = client.Cmd("HSET", s.Keys.GC, hash, strconv.FormatFloat(m.GC, 'f', 2, 64)).Err
+		if err != nil {
+			return fmt.Errorf("couldn't record gc content: %v", err)
+		}
+	}
+
+	err = client.Cmd("HINCRBY", s.Keys.LengthHistogram, lengthBucket(m.Length), 1).Err
+	if err != nil {
+		return fmt.Errorf("couldn't update length histogram: %v", err)
+	}
+	return nil
+}
+
+// forget removes the measurements of a sequence no longer stored under
+// hash.
+func (s *Store) forget(client *redis.Client, hash string) error {
+	resp := client.Cmd("HGET", s.Keys.Lengths, hash)
+	if resp.IsType(redis.Nil) {
+		return nil
+	}
+	length, err := resp.Int()
+	if err != nil {
+		return err
+	}
+
+	err = client.Cmd("HINCRBY", s.Keys.LengthHistogram, lengthBucket(length), -1).Err
+	if err != nil {
+		return fmt.Errorf("couldn't update length histogram: %v", err)
+	}
+	err = client.Cmd("HDEL", s.Keys.Lengths, hash).Err
+	if err != nil {
+		return err
+	}
+	return client.Cmd("HDEL", s.Keys.GC, hash).Err
+}
+
+// Measurements returns the measurements of the sequences stored under
+// hashes, in a couple of round trips however many there are. Sequences
+// stored before they were measured have a zero Length until MeasureAll is
+// run.
+func (s *Store) Measurements(client *redis.Client, hashes []string) ([]Measurement, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	lengths, err := hashFields(client, s.Keys.Lengths, [][]string{hashes})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't look up lengths: %v", err)
+	}
+	gcs, err := hashFields(client, s.Keys.GC, [][]string{hashes})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't look up gc content: %v", err)
+	}
+
+	measurements := make([]Measurement, len(hashes))
+	for i, hash := range hashes {
+		measurements[i].Length, _ = strconv.Atoi(lengths[0][hash])
+		measurements[i].GC, _ = strconv.ParseFloat(gcs[0][hash], 64)
+	}
+
+	return measurements, nil
+}
+
+// LengthBucket is a bar of the length histogram, the number of sequences
+// at least Min long and shorter than Max, or of any length above Min if
+// Max is 0.
+type LengthBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max,omitempty"`
+	Count int `json:"count"`
+}
+
+// LengthHistogram returns the number of sequences stored in each range of
+// lengths, shortest first.
+func (s *Store) LengthHistogram(client *redis.Client) ([]LengthBucket, error) {
+	counts, err := client.Cmd("HGETALL", s.Keys.LengthHistogram).Map()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]LengthBucket, len(lengthBuckets))
+	for i, min := range lengthBuckets {
+		buckets[i].Min = min
+		if i+1 < len(lengthBuckets) {
+			buckets[i].Max = lengthBuckets[i+1]
+		}
+		buckets[i].Count, _ = strconv.Atoi(counts[strconv.Itoa(min)])
+	}
+
+	return buckets, nil
+}
+
+// MeasureAll measures the sequences stored before sequences were measured
+// as they were stored, returning how many it measured. It's safe to run
+// with the slurper running, and again if it fails part way.
+func (s *Store) MeasureAll(client *redis.Client) (int, error) {
+	measured := 0
+	for _, protein := range []bool{false, true} {
+		err := WalkFastas(s.Dir(protein), func(path, hash string, d fs.DirEntry) error {
+			// checked first to save reading the fastas of the measured
+			exists, err := client.Cmd("HEXISTS", s.Keys.Lengths, hash).Int()
+			if err != nil || exists == 1 {
+				return err
+			}
+
+			fasta, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			lines := strings.Split(strings.TrimSpace(string(fasta)), "\n")
+
+			err = s.record(client, hash, strings.Join(lines[1:], ""), protein)
+			if err != nil {
+				return err
+			}
+			measured++
+			return nil
+		})
+		if err != nil {
+			return measured, err
+		}
+	}
+
+	return measured, nil
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// WriteSnapshot writes the uris of the components using every stored
+// sequence to w, a hash and a uri per line separated by a tab. builddb.sh
+// keeps one with each db it builds, so searches of that db later on find
+// the components it was built with. It returns the number of sequences
+// written.
+func (s *Store) WriteSnapshot(client *redis.Client, w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	n := 0
+
+	cursor := "0"
+	for {
+		resp, err := client.Cmd("SSCAN", s.Keys.Dedup, cursor, "COUNT", 1000).Array()
+		if err != nil {
+			return n, fmt.Errorf("couldn't scan dedup set: %v", err)
+		}
+		if len(resp) != 2 {
+			return n, fmt.Errorf("SSCAN returned %d values, expected 2", len(resp))
+		}
+
+		cursor, err = resp[0].Str()
+		if err != nil {
+			return n, err
+		}
+		hashes, err := resp[1].List()
+		if err != nil {
+			return n, err
+		}
+
+		// a partial snapshot would quietly lose components, so any failed
+		// lookup fails the lot
+		uris, err := s.URIs(client, hashes)
+		if err != nil {
+			return n, err
+		}
+		for i, hash := range hashes {
+			for _, uri := range uris[i] {
+				fmt.Fprintf(bw, "%s\t%s\n", hash, uri)
+			}
+		}
+		n += len(hashes)
+
+		if cursor == "0" {
+			return n, bw.Flush()
+		}
+	}
+}
+
+// ReadSnapshot reads what WriteSnapshot wrote, returning the uris using
+// each hash.
+func ReadSnapshot(r io.Reader) (map[string][]string, error) {
+	uris := map[string][]string{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		hash, uri, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok || hash == "" || uri == "" {
+			return nil, fmt.Errorf("line %d isn't a hash and a uri", line)
+		}
+		uris[hash] = append(uris[hash], uri)
+	}
+
+	return uris, scanner.Err()
+}
@@ -0,0 +1,1018 @@
+// Package store is where the slurper keeps the components it fetches from
+// SynBioHub and where the query server looks them up again.
+//
+// Each distinct sequence is written once, as a fasta file named after its
+// hash, see Hasher, that buildblastdb turns into the blast db. Redis maps each hash
+// to the components using it, and keeps their roles, a text index of their
+// titles and descriptions, and a feed of newly ingested components:
+//
+//	s := &store.Store{FastaDir: "fastas", ProteinDir: "proteins", Keys: store.DefaultKeys, Hasher: store.SHA256}
+//	added, err := s.Add(client, &store.Component{URI: uri, Sequence: seq, Created: created})
+//	...
+//	uris, err := s.URIs(client, []string{s.Hash(seq)})
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/schnauzer/synbioblast/pkg/sequence"
+	"github.com/schnauzer/synbioblast/textindex"
+)
+
+// Keys are the Redis keys the store is kept under.
+type Keys struct {
+	// Dedup is the set of the hashes of every sequence seen
+	Dedup string
+	// SeqSetPrefix prefixes the set of component uris using each hash
+	SeqSetPrefix string
+	// Stats is the hash of slurp statistics
+	Stats string
+	// Feed is the list of newly ingested components
+	Feed string
+	// Roles is the hash of component uris to their Sequence Ontology
+	// roles, space separated
+	Roles string
+	// Sources is the hash of component uris to the SynBioHub instance they
+	// were slurped from
+	Sources string
+	// TextPrefix prefixes the text index's keys
+	TextPrefix string
+	// RNA is the hash of the uris of RNA components, whose sequences are
+	// stored with their U's as T's
+	RNA string
+	// ORFPrefix prefixes the set of DNA component uris with an open reading
+	// frame translating to each protein hash
+	ORFPrefix string
+	// Cursor is how far through SynBioHub the slurper has got
+	Cursor string
+	// Lengths is the hash of sequence hashes to the sequence's length
+	Lengths string
+	// GC is the hash of nucleotide sequence hashes to the sequence's GC
+	// content, as a percentage
+	GC string
+	// LengthHistogram is the hash of the lower bounds of ranges of lengths
+	// to how many sequences are that long
+	LengthHistogram string
+}
+
+// DefaultKeys are the keys the binaries use unless configured otherwise.
+var DefaultKeys = Keys{
+	Dedup:           "sequenceHashSet",
+	SeqSetPrefix:    "sequence",
+	Stats:           "stats",
+	Feed:            "feed",
+	Roles:           "roles",
+	Sources:         "sources",
+	TextPrefix:      "text",
+	RNA:             "rna",
+	ORFPrefix:       "orf",
+	Cursor:          "sequenceoffset",
+	Lengths:         "lengths",
+	GC:              "gc",
+	LengthHistogram: "lengthHistogram",
+}
+
+// Store is a sequence store on disk and in Redis. It holds no connections,
+// every method takes the client to use, so it's safe to share.
+type Store struct {
+	// FastaDir and ProteinDir are where nucleotide and amino acid
+	// sequences are written, they go into separate blast dbs
+	FastaDir   string
+	ProteinDir string
+
+	Keys Keys
+
+	// Hasher is what sequences are hashed with, SHA1 if it's nil
+	Hasher *Hasher
+
+	// SyncPerBatch leaves fsyncing the fastas WriteFasta writes to
+	// SyncFastas, which is quicker than fsyncing each as it's written
+	SyncPerBatch bool
+
+	mu       sync.Mutex
+	unsynced []string
+
+	// Bloom, if set, lets Add and AddORF skip components that are
+	// already stored after one Redis check, rather than writing them all
+	// again, and skip the check for sequences it's never seen. Everything
+	// adding to the dedup set through the Store adds to it.
+	Bloom *Bloom
+}
+
+// Component is a SynBioHub component definition and its sequence.
+type Component struct {
+	URI         string
+	Sequence    string
+	Created     time.Time
+	Title       string
+	Description string
+	Protein     bool
+
+	// Roles are the component's Sequence Ontology roles, if it has any
+	Roles []string
+
+	// Source is the web address of the SynBioHub instance the component
+	// was slurped from, e.g. https://synbiohub.org, or ExternalSource
+	Source string
+
+	// RNA is set for RNA components, whose Sequence has T's for U's
+	RNA bool
+}
+
+// ExternalSource is the Source of components uploaded straight to the query
+// server rather than slurped from SynBioHub.
+const ExternalSource = "external"
+
+// Dir returns the directory fastas of the given kind are written to.
+func (s *Store) Dir(protein bool) string {
+	if protein {
+		return s.ProteinDir
+	}
+	return s.FastaDir
+}
+
+// WriteFasta writes the fasta for c's sequence to its FastaPath, returning
+// whether it's a new file and its size. It's written atomically and
+// fsynced, unless SyncPerBatch is set. A copy from before sharding is
+// removed, so the blast db doesn't get it twice.
+func (s *Store) WriteFasta(c *Component) (created bool, size int, err error) {
+	hash := s.Hash(c.Sequence)
+	filename := s.FastaPath(hash, c.Protein)
+
+	file := []byte(fmt.Sprintf(">%s\n%s\n", hash, c.Sequence))
+
+	_, statErr := os.Stat(filename)
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return false, 0, err
+	}
+	err = writeAtomic(filename, file, !s.SyncPerBatch)
+	if err != nil {
+		return false, 0, err
+	}
+
+	flat := s.flatPath(hash, c.Protein)
+	if flat != filename {
+		err = os.Remove(flat)
+		if err == nil {
+			statErr = nil
+		} else if !os.IsNotExist(err) {
+			return false, 0, err
+		}
+	}
+	if s.SyncPerBatch {
+		s.mu.Lock()
+		s.unsynced = append(s.unsynced, filename)
+		s.mu.Unlock()
+	}
+
+	return os.IsNotExist(statErr), len(file), nil
+}
+
+// Add records c in Redis, returning false if it had already been added.
+// With a Bloom filter a component already added is left as it is, rather
+// than having its source and text written again, bar any roles it didn't
+// have yet. It doesn't write the
+// fasta, see WriteFasta.
+func (s *Store) Add(client *redis.Client, c *Component) (added bool, err error) {
+	hash := s.Hash(c.Sequence)
+
+	stored, err := s.stored(client, hash, c.URI)
+	if err != nil {
+		return false, err
+	}
+	if stored {
+		// its rows can be split across pages, so it may have come with
+		// roles the page it was added from didn't have
+		return false, s.addRoles(client, c)
+	}
+
+	err = s.addHash(client, hash, c.Sequence, c.Protein)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := client.Cmd("SADD", s.Keys.SeqSetPrefix+":"+hash, c.URI).Int()
+	if err != nil {
+		return false, fmt.Errorf("couldn't add uri to sequence set: %v", err)
+	}
+
+	if n > 0 {
+		b, err := json.Marshal(FeedEntry{
+			Hash:     hash,
+			URI:      c.URI,
+			Created:  c.Created,
+			Ingested: time.Now(),
+		})
+		if err != nil {
+			return false, err
+		}
+
+		err = client.Cmd("RPUSH", s.Keys.Feed, b).Err
+		if err != nil {
+			return false, fmt.Errorf("couldn't append to feed: %v", err)
+		}
+	}
+
+	err = s.addRoles(client, c)
+	if err != nil {
+		return false, err
+	}
+
+	if c.Source != "" {
+		err = client.Cmd("HSET", s.Keys.Sources, c.URI, c.Source).Err
+		if err != nil {
+			return false, fmt.Errorf("couldn't record source: %v", err)
+		}
+	}
+
+	if c.RNA {
+		err = client.Cmd("HSET", s.Keys.RNA, c.URI, 1).Err
+		if err != nil {
+			return false, fmt.Errorf("couldn't record component as rna: %v", err)
+		}
+	}
+
+	err = textindex.Add(client, s.Keys.TextPrefix, textindex.Part{
+		URI:         c.URI,
+		Title:       c.Title,
+		Description: c.Description,
+	})
+	if err != nil {
+		return false, fmt.Errorf("couldn't index title and description: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// addRoles records c's roles, keeping any it was stored with before, since
+// a component's rows can be split across pages. Redis is only written to
+// if there's a role it doesn't have yet.
+func (s *Store) addRoles(client *redis.Client, c *Component) error {
+	if len(c.Roles) == 0 {
+		return nil
+	}
+
+	resp := client.Cmd("HGET", s.Keys.Roles, c.URI)
+	if resp.Err != nil {
+		return fmt.Errorf("couldn't look up roles: %v", resp.Err)
+	}
+	previous, _ := resp.Str()
+	roles := strings.Fields(previous)
+
+	changed := false
+	for _, role := range c.Roles {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	err := client.Cmd("HSET", s.Keys.Roles, c.URI, strings.Join(roles, " ")).Err
+	if err != nil {
+		return fmt.Errorf("couldn't record roles: %v", err)
+	}
+
+	return nil
+}
+
+// AddORF records that the DNA component uri has an open reading frame
+// translating to protein, so protein searches finding the translation find
+// the component. The translation is stored like a protein component using
+// the uri, but the uri's role, source and text are left to its own Add,
+// and Delete leaves them alone. It returns false if it had already been
+// added. It doesn't write the fasta, see WriteFasta.
+func (s *Store) AddORF(client *redis.Client, uri, protein string) (added bool, err error) {
+	hash := s.Hash(protein)
+
+	stored, err := s.stored(client, hash, uri)
+	if err != nil || stored {
+		return false, err
+	}
+
+	err = s.addHash(client, hash, protein, true)
+	if err != nil {
+		return false, err
+	}
+
+	err = client.Cmd("SADD", s.Keys.ORFPrefix+":"+hash, uri).Err
+	if err != nil {
+		return false, fmt.Errorf("couldn't add uri to orf set: %v", err)
+	}
+
+	n, err := client.Cmd("SADD", s.Keys.SeqSetPrefix+":"+hash, uri).Int()
+	if err != nil {
+		return false, fmt.Errorf("couldn't add uri to sequence set: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// addHash adds the hash of seq to the dedup set, measuring seq if it's new.
+func (s *Store) addHash(client *redis.Client, hash, seq string, protein bool) error {
+	n, err := client.Cmd("SADD", s.Keys.Dedup, hash).Int()
+	if err != nil {
+		return fmt.Errorf("couldn't add hash to dedup set: %v", err)
+	}
+	if s.Bloom != nil {
+		s.Bloom.Add(hash)
+	}
+
+	if n > 0 {
+		return s.record(client, hash, seq, protein)
+	}
+	return nil
+}
+
+// RecordSlurp updates the slurp statistics after a batch that added newURIs
+// components, the newest of them created at newest.
+func (s *Store) RecordSlurp(client *redis.Client, newURIs int, newest time.Time) error {
+	err := client.Cmd("HINCRBY", s.Keys.Stats, "uris", newURIs).Err
+	if err != nil {
+		return fmt.Errorf("couldn't update uri count: %v", err)
+	}
+
+	err = client.Cmd("HSET", s.Keys.Stats, "lastSlurp", time.Now().Format(time.RFC3339)).Err
+	if err != nil {
+		return fmt.Errorf("couldn't update last slurp time: %v", err)
+	}
+
+	// slurpers for other instances share the statistics, so a batch
+	// older than theirs leaves the newest alone
+	recorded, err := s.NewestCreated(client)
+	if err != nil {
+		return err
+	}
+	if newest.After(recorded) {
+		err = client.Cmd("HSET", s.Keys.Stats, "newestCreated", newest.UTC().Format(time.RFC3339)).Err
+		if err != nil {
+			return fmt.Errorf("couldn't update newest creation time: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// RecordUpload counts newURIs components uploaded to the query server in
+// the statistics, leaving the slurp's times alone.
+func (s *Store) RecordUpload(client *redis.Client, newURIs int) error {
+	err := client.Cmd("HINCRBY", s.Keys.Stats, "uris", newURIs).Err
+	if err != nil {
+		return fmt.Errorf("couldn't update uri count: %v", err)
+	}
+
+	return nil
+}
+
+// NewestCreated returns when the newest component stored was created in
+// SynBioHub, zero if none have been. How long ago that was is how far
+// behind SynBioHub the index is, at most.
+func (s *Store) NewestCreated(client *redis.Client) (time.Time, error) {
+	resp := client.Cmd("HGET", s.Keys.Stats, "newestCreated")
+	if resp.IsType(redis.Nil) {
+		return time.Time{}, nil
+	}
+
+	created, err := resp.Str()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	t, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("couldn't parse newest creation time: %v", err)
+	}
+	return t, nil
+}
+
+// PartialError is returned by URIs and Roles when only some of the lookups
+// failed. The results of the rest are still returned, those of the failed
+// ones are nil.
+type PartialError struct {
+	// Failed are the indexes of the lookups that failed
+	Failed []int
+	// Err is why the first of them failed
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d lookups failed, the first with: %v", len(e.Failed), e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PartialError) add(i int, err error) {
+	if e.Err == nil {
+		e.Err = err
+	}
+	e.Failed = append(e.Failed, i)
+}
+
+// pipeResp returns the next pipelined response. An error means the
+// connection failed, taking the rest of the responses with it, so the
+// pipeline is cleared. Errors from Redis for just the one command are left
+// in the response.
+func pipeResp(client *redis.Client) (*redis.Resp, error) {
+	resp := client.PipeResp()
+	if resp.IsType(redis.IOErr) {
+		client.PipeClear()
+		return nil, resp.Err
+	}
+
+	return resp, nil
+}
+
+// URIs returns the uris of the components using each of hashes, in one
+// round trip. If only some of them couldn't be looked up the error is a
+// *PartialError.
+func (s *Store) URIs(client *redis.Client, hashes []string) ([][]string, error) {
+	for _, hash := range hashes {
+		client.PipeAppend("SMEMBERS", s.Keys.SeqSetPrefix+":"+hash)
+	}
+
+	uris := make([][]string, len(hashes))
+	partial := &PartialError{}
+	for i := range hashes {
+		resp, err := pipeResp(client)
+		if err != nil {
+			return nil, err
+		}
+
+		uris[i], err = resp.List()
+		if err != nil {
+			partial.add(i, err)
+		}
+	}
+
+	if len(partial.Failed) > 0 {
+		return uris, partial
+	}
+	return uris, nil
+}
+
+// Roles returns the distinct Sequence Ontology roles, e.g. SO:0000167, of
+// each group of component uris, in one round trip. If only some groups'
+// couldn't be looked up the error is a *PartialError.
+func (s *Store) Roles(client *redis.Client, uris [][]string) ([][]string, error) {
+	for _, group := range uris {
+		if len(group) == 0 {
+			continue
+		}
+
+		args := []interface{}{s.Keys.Roles}
+		for _, uri := range group {
+			args = append(args, uri)
+		}
+		client.PipeAppend("HMGET", args...)
+	}
+
+	roles := make([][]string, len(uris))
+	partial := &PartialError{}
+	for i, group := range uris {
+		if len(group) == 0 {
+			continue
+		}
+
+		resp, err := pipeResp(client)
+		if err != nil {
+			return nil, err
+		}
+
+		resps, err := resp.Array()
+		if err != nil {
+			partial.add(i, err)
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, resp := range resps {
+			value, err := resp.Str()
+			if err != nil {
+				// components slurped before roles were have none
+				continue
+			}
+			for _, role := range splitRoles(value) {
+				if !seen[role] {
+					seen[role] = true
+					roles[i] = append(roles[i], role)
+				}
+			}
+		}
+	}
+
+	if len(partial.Failed) > 0 {
+		return roles, partial
+	}
+	return roles, nil
+}
+
+// Sources returns the SynBioHub instance each of a group of component uris
+// was slurped from, by uri, for each group in one round trip. Components
+// slurped before sources were recorded are left out. If only some groups
+// couldn't be looked up the error is a *PartialError.
+func (s *Store) Sources(client *redis.Client, uris [][]string) ([]map[string]string, error) {
+	return hashFields(client, s.Keys.Sources, uris)
+}
+
+// RNA returns which of each of a group of component uris are RNA, for each
+// group in one round trip. If only some groups couldn't be looked up the
+// error is a *PartialError.
+func (s *Store) RNA(client *redis.Client, uris [][]string) ([]map[string]bool, error) {
+	fields, err := hashFields(client, s.Keys.RNA, uris)
+
+	rna := make([]map[string]bool, len(fields))
+	for i, group := range fields {
+		rna[i] = map[string]bool{}
+		for uri := range group {
+			rna[i][uri] = true
+		}
+	}
+
+	return rna, err
+}
+
+// ComponentRoles returns the Sequence Ontology roles of each of a group of
+// component uris, by uri, for each group in one round trip. Components
+// without a role are left out. If only some groups couldn't be looked up
+// the error is a *PartialError.
+func (s *Store) ComponentRoles(client *redis.Client, uris [][]string) ([]map[string][]string, error) {
+	fields, err := hashFields(client, s.Keys.Roles, uris)
+
+	roles := make([]map[string][]string, len(fields))
+	for i, group := range fields {
+		roles[i] = map[string][]string{}
+		for uri, value := range group {
+			roles[i][uri] = splitRoles(value)
+		}
+	}
+
+	return roles, err
+}
+
+// splitRoles returns the terms, e.g. SO:0000167, of the roles recorded for
+// a component: their uris, space separated. Components slurped before they
+// could have more than one have a single uri.
+func splitRoles(value string) []string {
+	roles := strings.Fields(value)
+	for i, role := range roles {
+		roles[i] = role[strings.LastIndex(role, "/")+1:]
+	}
+	return roles
+}
+
+// hashFields gets the fields of the hash at key named by each group of
+// uris, for each group in one round trip. Missing fields are left out.
+func hashFields(client *redis.Client, key string, uris [][]string) ([]map[string]string, error) {
+	for _, group := range uris {
+		if len(group) == 0 {
+			continue
+		}
+
+		args := []interface{}{key}
+		for _, uri := range group {
+			args = append(args, uri)
+		}
+		client.PipeAppend("HMGET", args...)
+	}
+
+	values := make([]map[string]string, len(uris))
+	partial := &PartialError{}
+	for i, group := range uris {
+		if len(group) == 0 {
+			continue
+		}
+
+		resp, err := pipeResp(client)
+		if err != nil {
+			return nil, err
+		}
+
+		resps, err := resp.Array()
+		if err != nil {
+			partial.add(i, err)
+			continue
+		}
+
+		for j, resp := range resps {
+			value, err := resp.Str()
+			if err != nil || j >= len(group) {
+				continue
+			}
+
+			if values[i] == nil {
+				values[i] = map[string]string{}
+			}
+			values[i][group[j]] = value
+		}
+	}
+
+	if len(partial.Failed) > 0 {
+		return values, partial
+	}
+	return values, nil
+}
+
+// Count returns the number of distinct sequences stored.
+func (s *Store) Count(client *redis.Client) (int, error) {
+	return client.Cmd("SCARD", s.Keys.Dedup).Int()
+}
+
+// scan calls fn with every key matching pattern, a batch at a time.
+func scan(client *redis.Client, pattern string, fn func(keys []string) error) error {
+	cursor := "0"
+	for {
+		resp, err := client.Cmd("SCAN", cursor, "MATCH", pattern, "COUNT", 1000).Array()
+		if err != nil {
+			return err
+		}
+		if len(resp) != 2 {
+			return fmt.Errorf("SCAN returned %d values, expected 2", len(resp))
+		}
+
+		cursor, err = resp[0].Str()
+		if err != nil {
+			return err
+		}
+		keys, err := resp[1].List()
+		if err != nil {
+			return err
+		}
+
+		err = fn(keys)
+		if err != nil {
+			return err
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// Hashes returns the hashes of the sequences a component uses, usually
+// just one. There's no index from uris to hashes, so this scans every
+// sequence's set and is only meant for occasional lookups.
+func (s *Store) Hashes(client *redis.Client, uri string) ([]string, error) {
+	var hashes []string
+	err := scan(client, s.Keys.SeqSetPrefix+":*", func(keys []string) error {
+		for _, key := range keys {
+			client.PipeAppend("SISMEMBER", key, uri)
+		}
+
+		for _, key := range keys {
+			member, err := client.PipeResp().Int()
+			if err != nil {
+				client.PipeClear()
+				return err
+			}
+			if member == 1 {
+				hashes = append(hashes, strings.TrimPrefix(key, s.Keys.SeqSetPrefix+":"))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// Delete removes a sequence, its fastas and the components using it,
+// returning their uris. Their feed entries are left alone, the feed is a
+// history of what was ingested.
+func (s *Store) Delete(client *redis.Client, hash string) ([]string, error) {
+	key := s.Keys.SeqSetPrefix + ":" + hash
+
+	uris, err := client.Cmd("SMEMBERS", key).List()
+	if err != nil {
+		return nil, err
+	}
+
+	// components only here for an orf belong to another sequence
+	orfKey := s.Keys.ORFPrefix + ":" + hash
+	orfURIs, err := client.Cmd("SMEMBERS", orfKey).List()
+	if err != nil {
+		return nil, err
+	}
+	orfs := map[string]bool{}
+	for _, uri := range orfURIs {
+		orfs[uri] = true
+	}
+
+	components := 0
+	for _, uri := range uris {
+		if orfs[uri] {
+			continue
+		}
+		components++
+
+		err = client.Cmd("HDEL", s.Keys.Roles, uri).Err
+		if err != nil {
+			return nil, fmt.Errorf("couldn't remove role of %s: %v", uri, err)
+		}
+
+		err = client.Cmd("HDEL", s.Keys.Sources, uri).Err
+		if err != nil {
+			return nil, fmt.Errorf("couldn't remove source of %s: %v", uri, err)
+		}
+
+		err = client.Cmd("HDEL", s.Keys.RNA, uri).Err
+		if err != nil {
+			return nil, fmt.Errorf("couldn't remove rna flag of %s: %v", uri, err)
+		}
+
+		err = textindex.Remove(client, s.Keys.TextPrefix, uri)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't remove %s from the text index: %v", uri, err)
+		}
+	}
+
+	err = client.Cmd("DEL", key, orfKey).Err
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Cmd("SREM", s.Keys.Dedup, hash).Err
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.forget(client, hash)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't remove measurements: %v", err)
+	}
+
+	err = client.Cmd("HINCRBY", s.Keys.Stats, "uris", -components).Err
+	if err != nil {
+		return nil, fmt.Errorf("couldn't update uri count: %v", err)
+	}
+
+	for _, protein := range []bool{false, true} {
+		err = s.removeFasta(hash, protein)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return uris, nil
+}
+
+// Rekey moves every sequence's set of uris from under the current prefix to
+// prefix, returning how many were moved. Anything reading or writing the
+// store with the old prefix has to be stopped first, and started again with
+// the new one.
+func (s *Store) Rekey(client *redis.Client, prefix string) (int, error) {
+	if prefix == s.Keys.SeqSetPrefix {
+		return 0, nil
+	}
+
+	old := s.Keys.SeqSetPrefix + ":"
+	if strings.HasPrefix(prefix+":", old) {
+		// the scan would find the moved keys again
+		return 0, fmt.Errorf("the new prefix can't start with %q", old)
+	}
+
+	moved := 0
+	err := scan(client, old+"*", func(keys []string) error {
+		for _, key := range keys {
+			renamed, err := client.Cmd("RENAMENX", key, prefix+":"+strings.TrimPrefix(key, old)).Int()
+			if err != nil {
+				return err
+			}
+			if renamed == 0 {
+				return fmt.Errorf("can't move %s, %s:%s already exists", key, prefix, strings.TrimPrefix(key, old))
+			}
+			moved++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return moved, err
+	}
+
+	s.Keys.SeqSetPrefix = prefix
+	return moved, nil
+}
+
+// Rehash moves the sequences whose fastas aren't named after the hash of
+// the form sequence.Normalize now puts them in, to the store's Hasher's hash
+// of that, merging them with any sequences already there. That covers
+// those slurped before it stripped whitespace or turned RNA into DNA, and
+// migrating the store to another Hasher. It returns how many were moved.
+// It's safe to run again if it fails part way, but not while the slurper
+// is running.
+func (s *Store) Rehash(client *redis.Client) (int, error) {
+	moved := 0
+	for _, protein := range []bool{false, true} {
+		// the fastas to move are listed first, as moving them adds
+		// more to walk through
+		hashes := map[string]string{}
+		err := WalkFastas(s.Dir(protein), func(path, hash string, d fs.DirEntry) error {
+			hashes[hash] = path
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+
+		for hash, path := range hashes {
+			fasta, err := ioutil.ReadFile(path)
+			if err != nil {
+				return moved, err
+			}
+			lines := strings.Split(strings.TrimSpace(string(fasta)), "\n")
+			seq, rna := sequence.Normalize(strings.Join(lines[1:], ""), protein)
+			if s.Hash(seq) == hash {
+				continue
+			}
+
+			err = s.move(client, hash, &Component{Sequence: seq, Protein: protein, RNA: rna})
+			if err != nil {
+				return moved, fmt.Errorf("couldn't move %s: %v", hash, err)
+			}
+			moved++
+		}
+	}
+
+	return moved, nil
+}
+
+// move moves the sequence stored under hash to c's hash. The new fasta is
+// written before anything else and the old one removed last, so a move that
+// fails part way is found and finished by the next Rehash.
+func (s *Store) move(client *redis.Client, hash string, c *Component) error {
+	_, _, err := s.WriteFasta(c)
+	if err != nil {
+		return err
+	}
+	to := s.Hash(c.Sequence)
+
+	uris, err := client.Cmd("SMEMBERS", s.Keys.SeqSetPrefix+":"+hash).List()
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range []string{s.Keys.SeqSetPrefix, s.Keys.ORFPrefix} {
+		err = client.Cmd("SUNIONSTORE", prefix+":"+to, prefix+":"+to, prefix+":"+hash).Err
+		if err != nil {
+			return err
+		}
+		err = client.Cmd("DEL", prefix+":"+hash).Err
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.RNA {
+		for _, uri := range uris {
+			err = client.Cmd("HSET", s.Keys.RNA, uri, 1).Err
+			if err != nil {
+				return fmt.Errorf("couldn't record %s as rna: %v", uri, err)
+			}
+		}
+	}
+
+	err = s.addHash(client, to, c.Sequence, c.Protein)
+	if err != nil {
+		return err
+	}
+	err = client.Cmd("SREM", s.Keys.Dedup, hash).Err
+	if err != nil {
+		return err
+	}
+	err = s.forget(client, hash)
+	if err != nil {
+		return fmt.Errorf("couldn't remove measurements: %v", err)
+	}
+
+	return s.removeFasta(hash, c.Protein)
+}
+
+// Cursor returns how many components the slurper has fetched from
+// SynBioHub, which is where it carries on from.
+func (s *Store) Cursor(client *redis.Client) (int, error) {
+	resp := client.Cmd("GET", s.Keys.Cursor)
+	if resp.IsType(redis.Nil) {
+		return 0, nil
+	}
+
+	return resp.Int()
+}
+
+// SetCursor sets where the slurper carries on from, 0 to fetch everything
+// again. Components already stored are skipped as they're seen again.
+func (s *Store) SetCursor(client *redis.Client, offset int) error {
+	return client.Cmd("SET", s.Keys.Cursor, offset).Err
+}
+
+// Sequence is a deduplicated sequence and the components that use it.
+type Sequence struct {
+	Hash     string   `json:"hash"`
+	Sequence string   `json:"sequence"`
+	URIs     []string `json:"uris"`
+}
+
+// Sequence returns the sequence stored under hash, or nil if there isn't
+// one. Protein sequences are only looked for if withProtein is set.
+func (s *Store) Sequence(client *redis.Client, hash string, withProtein bool) (*Sequence, error) {
+	kinds := []bool{false}
+	if withProtein {
+		kinds = append(kinds, true)
+	}
+
+	var fasta []byte
+	err := os.ErrNotExist
+	for _, protein := range kinds {
+		fasta, err = s.readFasta(hash, protein)
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// the hash is on the first line and the sequence on the rest
+	lines := strings.Split(strings.TrimSpace(string(fasta)), "\n")
+	seq := &Sequence{
+		Hash:     hash,
+		Sequence: strings.Join(lines[1:], ""),
+	}
+
+	seq.URIs, err = client.Cmd("SMEMBERS", s.Keys.SeqSetPrefix+":"+hash).List()
+	if err != nil {
+		return nil, err
+	}
+
+	return seq, nil
+}
+
+// FeedEntry is one newly ingested component. Entries are json in a Redis
+// list so their index in the list can be used as a cursor.
+type FeedEntry struct {
+	Hash     string    `json:"hash"`
+	URI      string    `json:"uri"`
+	Created  time.Time `json:"created"`
+	Ingested time.Time `json:"ingested"`
+}
+
+// FeedPage is a page of the feed. Next is the cursor to pass as since to
+// get the entries after this page.
+type FeedPage struct {
+	Entries []FeedEntry `json:"entries"`
+	Next    int         `json:"next"`
+}
+
+// Feed returns up to limit entries starting at the since'th one ever
+// ingested. A negative since counts back from the newest entry.
+func (s *Store) Feed(client *redis.Client, since, limit int) (*FeedPage, error) {
+	if since < 0 {
+		total, err := client.Cmd("LLEN", s.Keys.Feed).Int()
+		if err != nil {
+			return nil, err
+		}
+
+		since += total
+		if since < 0 {
+			since = 0
+		}
+	}
+
+	raw, err := client.Cmd("LRANGE", s.Keys.Feed, since, since+limit-1).ListBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &FeedPage{
+		Entries: make([]FeedEntry, len(raw)),
+		Next:    since + len(raw),
+	}
+	for i, b := range raw {
+		err = json.Unmarshal(b, &page.Entries[i])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse feed entry %d: %v", since+i, err)
+		}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,282 @@
+package store_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher *store.Hasher
+		seq    string
+		want   string
+	}{
+		{"sha1", store.SHA1, "acgt", "9482340281b5fc8f2a298dbbd6b82fe42159b6c5"},
+		{"sha1 empty", store.SHA1, "", "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+		{"sha256", store.SHA256, "acgt", "v2-48752e49fec8b5ae25860bf7c4f4a0f01cc3f12e142ba4bff4914e4f68374cde"},
+		{"sha256 empty", store.SHA256, "", "v2-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.hasher.Hash(test.seq); got != test.want {
+				t.Errorf("Hash(%q) = %q, want %q", test.seq, got, test.want)
+			}
+			if !store.ValidHash(test.want) {
+				t.Errorf("ValidHash(%q) = false, want true", test.want)
+			}
+		})
+	}
+}
+
+func TestStoreHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher *store.Hasher
+		want   string
+	}{
+		{"unset is sha1", nil, store.SHA1.Hash("acgt")},
+		{"sha1", store.SHA1, store.SHA1.Hash("acgt")},
+		{"sha256", store.SHA256, store.SHA256.Hash("acgt")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &store.Store{Hasher: test.hasher}
+			if got := s.Hash("acgt"); got != test.want {
+				t.Errorf("Hash = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidHash(t *testing.T) {
+	tests := []struct {
+		hash string
+		want bool
+	}{
+		{"9482340281b5fc8f2a298dbbd6b82fe42159b6c5", true},
+		{"v2-48752e49fec8b5ae25860bf7c4f4a0f01cc3f12e142ba4bff4914e4f68374cde", true},
+		{"", false},
+		{"9482340281b5fc8f2a298dbbd6b82fe42159b6c", false},
+		{"9482340281B5FC8F2A298DBBD6B82FE42159B6C5", false},
+		{"v2-9482340281b5fc8f2a298dbbd6b82fe42159b6c5", false},
+		{"48752e49fec8b5ae25860bf7c4f4a0f01cc3f12e142ba4bff4914e4f68374cde", false},
+		{"../9482340281b5fc8f2a298dbbd6b82fe42159b6c5", false},
+	}
+
+	for _, test := range tests {
+		if got := store.ValidHash(test.hash); got != test.want {
+			t.Errorf("ValidHash(%q) = %v, want %v", test.hash, got, test.want)
+		}
+	}
+}
+
+func TestFastaPath(t *testing.T) {
+	s := &store.Store{FastaDir: "fastas", ProteinDir: "proteins"}
+	tests := []struct {
+		hash    string
+		protein bool
+		want    string
+	}{
+		{"9482340281b5fc8f2a298dbbd6b82fe42159b6c5", false, "fastas/94/82/9482340281b5fc8f2a298dbbd6b82fe42159b6c5.fasta"},
+		{"9482340281b5fc8f2a298dbbd6b82fe42159b6c5", true, "proteins/94/82/9482340281b5fc8f2a298dbbd6b82fe42159b6c5.fasta"},
+		{"v2-48752e49fec8b5ae25860bf7c4f4a0f01cc3f12e142ba4bff4914e4f68374cde", false, "fastas/48/75/v2-48752e49fec8b5ae25860bf7c4f4a0f01cc3f12e142ba4bff4914e4f68374cde.fasta"},
+		{"abc", false, "fastas/abc.fasta"},
+	}
+
+	for _, test := range tests {
+		if got := s.FastaPath(test.hash, test.protein); got != filepath.FromSlash(test.want) {
+			t.Errorf("FastaPath(%q, %v) = %q, want %q", test.hash, test.protein, got, test.want)
+		}
+	}
+}
+
+// TestKeys checks Add writes everything under the store's Keys, and only
+// there.
+func TestKeys(t *testing.T) {
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	client, err := redis.Dial("tcp", m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	keys := store.Keys{
+		Dedup:           "test:dedup",
+		SeqSetPrefix:    "test:seq",
+		Stats:           "test:stats",
+		Feed:            "test:feed",
+		Roles:           "test:roles",
+		Sources:         "test:sources",
+		TextPrefix:      "test:text",
+		RNA:             "test:rna",
+		ORFPrefix:       "test:orf",
+		Cursor:          "test:cursor",
+		Lengths:         "test:lengths",
+		GC:              "test:gc",
+		LengthHistogram: "test:lengthHistogram",
+	}
+	s := &store.Store{Keys: keys, Hasher: store.SHA256}
+	c := &store.Component{
+		URI:      "https://synbiohub.org/public/igem/BBa_B0034/1",
+		Sequence: "aaagaggagaaa",
+		Created:  time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+		Title:    "RBS",
+		Roles:    []string{"http://identifiers.org/so/SO:0000139", "http://identifiers.org/so/SO:0000316"},
+		Source:   "https://synbiohub.org",
+		RNA:      true,
+	}
+	hash := s.Hash(c.Sequence)
+
+	added, err := s.Add(client, c)
+	if err != nil || !added {
+		t.Fatalf("Add = %v, %v, want true, nil", added, err)
+	}
+	added, err = s.Add(client, c)
+	if err != nil || added {
+		t.Fatalf("Add again = %v, %v, want false, nil", added, err)
+	}
+
+	tests := []struct {
+		name string
+		cmd  string
+		args []interface{}
+		want string
+	}{
+		{"dedup", "SISMEMBER", []interface{}{keys.Dedup, hash}, "1"},
+		{"sequence set", "SISMEMBER", []interface{}{keys.SeqSetPrefix + ":" + hash, c.URI}, "1"},
+		{"roles", "HGET", []interface{}{keys.Roles, c.URI}, strings.Join(c.Roles, " ")},
+		{"source", "HGET", []interface{}{keys.Sources, c.URI}, c.Source},
+		{"rna", "HGET", []interface{}{keys.RNA, c.URI}, "1"},
+		{"feed", "LLEN", []interface{}{keys.Feed}, "1"},
+		{"length", "HGET", []interface{}{keys.Lengths, hash}, "12"},
+	}
+	for _, test := range tests {
+		resp := client.Cmd(test.cmd, test.args...)
+		got, err := resp.Str()
+		if n, intErr := resp.Int(); intErr == nil {
+			got, err = strconv.Itoa(n), nil
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: %s %q = %q, %v, want %q", test.name, test.cmd, test.args, got, err, test.want)
+		}
+	}
+
+	stored, err := client.Cmd("KEYS", "*").List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range stored {
+		if !strings.HasPrefix(key, "test:") {
+			t.Errorf("%q isn't one of the store's keys", key)
+		}
+	}
+
+	uris, err := s.URIs(client, []string{hash, s.Hash("cccc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uris) != 2 || len(uris[0]) != 1 || uris[0][0] != c.URI || len(uris[1]) != 0 {
+		t.Errorf("URIs = %q, want [[%s] []]", uris, c.URI)
+	}
+}
+
+// TestAddRoles checks roles a component comes with when it's added again,
+// as it is when its rows are split across pages, are kept along with those
+// it was first added with, with a Bloom filter or without.
+func TestAddRoles(t *testing.T) {
+	for _, bloom := range []bool{false, true} {
+		t.Run("bloom "+strconv.FormatBool(bloom), func(t *testing.T) {
+			m, err := miniredis.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			client, err := redis.Dial("tcp", m.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			s := &store.Store{Keys: store.DefaultKeys, Hasher: store.SHA256}
+			if bloom {
+				s.Bloom = store.NewBloom(1000, 0.01)
+			}
+			c := store.Component{
+				URI:      "https://synbiohub.org/public/igem/BBa_R0010/1",
+				Sequence: "caatacgcaaaccgcctctcc",
+				Roles:    []string{"http://identifiers.org/so/SO:0000167"},
+			}
+			added, err := s.Add(client, &c)
+			if err != nil || !added {
+				t.Fatalf("Add = %v, %v, want true, nil", added, err)
+			}
+
+			c.Roles = []string{"http://identifiers.org/so/SO:0000057", "http://identifiers.org/so/SO:0000167"}
+			added, err = s.Add(client, &c)
+			if err != nil || added {
+				t.Fatalf("Add again = %v, %v, want false, nil", added, err)
+			}
+
+			roles, err := s.ComponentRoles(client, [][]string{{c.URI}})
+			if want := []string{"SO:0000167", "SO:0000057"}; err != nil || !reflect.DeepEqual(roles[0][c.URI], want) {
+				t.Errorf("roles = %q, %v, want %q", roles[0][c.URI], err, want)
+			}
+		})
+	}
+}
+
+func TestCheckHasher(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored []string
+		hasher *store.Hasher
+		err    bool
+	}{
+		{"empty", nil, store.SHA256, false},
+		{"same", []string{store.SHA256.Hash("acgt")}, store.SHA256, false},
+		{"unset is sha1", []string{store.SHA1.Hash("acgt")}, nil, false},
+		{"sha1 stored", []string{store.SHA1.Hash("acgt")}, store.SHA256, true},
+		{"sha256 stored", []string{store.SHA256.Hash("acgt")}, store.SHA1, true},
+		{"some of each", []string{store.SHA256.Hash("acgt"), store.SHA1.Hash("cccc")}, store.SHA256, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := miniredis.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			client, err := redis.Dial("tcp", m.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			s := &store.Store{Keys: store.DefaultKeys, Hasher: test.hasher}
+			for _, hash := range test.stored {
+				client.Cmd("SADD", s.Keys.Dedup, hash)
+			}
+
+			err = s.CheckHasher(client)
+			if (err != nil) != test.err {
+				t.Errorf("CheckHasher = %v, want an error: %v", err, test.err)
+			}
+		})
+	}
+}
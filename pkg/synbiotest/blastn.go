@@ -0,0 +1,133 @@
+package synbiotest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+)
+
+// ResultsXML returns blastn's XML output (-outfmt 5) for a search of query
+// that hit each of the sequences hashed, all over its whole length, in the
+// order given. query should be as blast.NormalizeQuery leaves it.
+func ResultsXML(query string, hashes ...string) []byte {
+	b := &strings.Builder{}
+	b.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE BlastOutput PUBLIC "-//NCBI//NCBI BlastOutput/EN" "http://www.ncbi.nlm.nih.gov/dtd/NCBI_BlastOutput.dtd">
+<BlastOutput>
+  <BlastOutput_program>blastn</BlastOutput_program>
+  <BlastOutput_version>BLASTN 2.7.1+</BlastOutput_version>
+  <BlastOutput_reference>Stub blastn for synbiotest</BlastOutput_reference>
+  <BlastOutput_db>synbioblast</BlastOutput_db>
+  <BlastOutput_query-ID>Query_1</BlastOutput_query-ID>
+  <BlastOutput_query-def>No definition line</BlastOutput_query-def>
+`)
+	fmt.Fprintf(b, "  <BlastOutput_query-len>%d</BlastOutput_query-len>\n", len(query))
+	b.WriteString(`  <BlastOutput_param>
+    <Parameters>
+      <Parameters_expect>10</Parameters_expect>
+      <Parameters_sc-match>1</Parameters_sc-match>
+      <Parameters_sc-mismatch>-2</Parameters_sc-mismatch>
+      <Parameters_gap-open>0</Parameters_gap-open>
+      <Parameters_gap-extend>0</Parameters_gap-extend>
+      <Parameters_filter>L;m;</Parameters_filter>
+    </Parameters>
+  </BlastOutput_param>
+  <BlastOutput_iterations>
+    <Iteration>
+      <Iteration_iter-num>1</Iteration_iter-num>
+      <Iteration_query-ID>Query_1</Iteration_query-ID>
+      <Iteration_query-def>No definition line</Iteration_query-def>
+`)
+	fmt.Fprintf(b, "      <Iteration_query-len>%d</Iteration_query-len>\n", len(query))
+	b.WriteString("      <Iteration_hits>\n")
+	for i, hash := range hashes {
+		fmt.Fprintf(b, `        <Hit>
+          <Hit_num>%[1]d</Hit_num>
+          <Hit_id>gnl|BL_ORD_ID|%[2]d</Hit_id>
+          <Hit_def>%[3]s</Hit_def>
+          <Hit_accession>%[2]d</Hit_accession>
+          <Hit_len>%[4]d</Hit_len>
+          <Hit_hsps>
+            <Hsp>
+              <Hsp_num>1</Hsp_num>
+              <Hsp_bit-score>%[5]g</Hsp_bit-score>
+              <Hsp_score>%[4]d</Hsp_score>
+              <Hsp_evalue>1e-10</Hsp_evalue>
+              <Hsp_query-from>1</Hsp_query-from>
+              <Hsp_query-to>%[4]d</Hsp_query-to>
+              <Hsp_hit-from>1</Hsp_hit-from>
+              <Hsp_hit-to>%[4]d</Hsp_hit-to>
+              <Hsp_query-frame>1</Hsp_query-frame>
+              <Hsp_hit-frame>1</Hsp_hit-frame>
+              <Hsp_identity>%[4]d</Hsp_identity>
+              <Hsp_positive>%[4]d</Hsp_positive>
+              <Hsp_gaps>0</Hsp_gaps>
+              <Hsp_align-len>%[4]d</Hsp_align-len>
+              <Hsp_qseq>%[6]s</Hsp_qseq>
+              <Hsp_hseq>%[6]s</Hsp_hseq>
+              <Hsp_midline>%[7]s</Hsp_midline>
+            </Hsp>
+          </Hit_hsps>
+        </Hit>
+`, i+1, i, hash, len(query), float64(len(query))*1.8, query, strings.Repeat("|", len(query)))
+	}
+	b.WriteString("      </Iteration_hits>\n")
+	fmt.Fprintf(b, `      <Iteration_stat>
+        <Statistics>
+          <Statistics_db-num>%d</Statistics_db-num>
+          <Statistics_db-len>%d</Statistics_db-len>
+          <Statistics_hsp-len>0</Statistics_hsp-len>
+          <Statistics_eff-space>0</Statistics_eff-space>
+          <Statistics_kappa>0.46</Statistics_kappa>
+          <Statistics_lambda>1.28</Statistics_lambda>
+          <Statistics_entropy>0.85</Statistics_entropy>
+        </Statistics>
+      </Iteration_stat>
+`, len(hashes), len(hashes)*len(query))
+	if len(hashes) == 0 {
+		fmt.Fprintf(b, "      <Iteration_message>%s</Iteration_message>\n", blast.NoHitsMessage)
+	}
+	b.WriteString(`    </Iteration>
+  </BlastOutput_iterations>
+</BlastOutput>
+`)
+
+	return []byte(b.String())
+}
+
+// StubBlastn writes a script that stands in for blastn, answering -version
+// like blastn 2.7.1 and any search, whose query it reads and ignores, with
+// output, e.g. from ResultsXML. It returns the script's path, to run as
+// the blastn binary.
+func StubBlastn(t testing.TB, output []byte) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "output")
+	err := os.WriteFile(outputPath, output, 0644)
+	if err != nil {
+		t.Fatalf("couldn't write stub blastn output: %v", err)
+	}
+
+	script := fmt.Sprintf(`#!/bin/sh
+if [ "$1" = "-version" ]; then
+	echo "blastn: 2.7.1+"
+	echo " Package: blast 2.7.1, build Oct 18 2017 19:57:24"
+	exit 0
+fi
+cat >/dev/null
+cat '%s'
+`, strings.ReplaceAll(outputPath, "'", `'\''`))
+
+	blastn := filepath.Join(dir, "blastn")
+	err = os.WriteFile(blastn, []byte(script), 0755)
+	if err != nil {
+		t.Fatalf("couldn't write stub blastn: %v", err)
+	}
+
+	return blastn
+}
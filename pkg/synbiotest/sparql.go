@@ -0,0 +1,146 @@
+package synbiotest
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/slurp"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+// SPARQLServer stands in for a SynBioHub SPARQL endpoint, answering the
+// slurper's query with pages of canned components as SPARQL JSON results.
+type SPARQLServer struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	components []store.Component
+	queries    []string
+
+	// Fail, if set, is the status every query gets instead of results
+	Fail int
+}
+
+// NewSPARQLServer serves components, which are paged through in the order
+// given, so they should be in order of creation like SynBioHub's. It's
+// closed when the test ends.
+func NewSPARQLServer(t testing.TB, components []store.Component) *SPARQLServer {
+	s := &SPARQLServer{components: components}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Endpoint returns the endpoint to slurp the server from.
+func (s *SPARQLServer) Endpoint() slurp.Endpoint {
+	return slurp.Endpoint{URL: s.URL + "/sparql", Timeout: 10 * time.Second}
+}
+
+// Add adds components to the end of those served, as if they'd just been
+// created.
+func (s *SPARQLServer) Add(components ...store.Component) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.components = append(s.components, components...)
+}
+
+// Queries returns the queries the server has been sent, in order.
+func (s *SPARQLServer) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.queries...)
+}
+
+// pageClause is where the slurper's query says which page it wants.
+var pageClause = regexp.MustCompile(`LIMIT ([0-9]+) OFFSET ([0-9]+)`)
+
+func (s *SPARQLServer) serve(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("query")
+	m := pageClause.FindStringSubmatch(query)
+	if m == nil {
+		http.Error(w, "the query has no LIMIT and OFFSET", http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(m[1])
+	offset, _ := strconv.Atoi(m[2])
+
+	s.mu.Lock()
+	s.queries = append(s.queries, query)
+	page := []store.Component{}
+	if offset < len(s.components) {
+		page = s.components[offset:min(offset+limit, len(s.components))]
+	}
+	s.mu.Unlock()
+
+	if s.Fail != 0 {
+		http.Error(w, "failing as told to", s.Fail)
+		return
+	}
+
+	w.Header().Set("Content-Type", slurp.ResultsJSON)
+	json.NewEncoder(w).Encode(sparqlResults(page))
+}
+
+type sparqlTerm struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Datatype string `json:"datatype,omitempty"`
+}
+
+type sparqlPage struct {
+	Head struct {
+		Vars []string `json:"vars"`
+	} `json:"head"`
+	Results struct {
+		Bindings []map[string]sparqlTerm `json:"bindings"`
+	} `json:"results"`
+}
+
+// sparqlResults is a page of components as SynBioHub's Virtuoso returns
+// them.
+func sparqlResults(components []store.Component) sparqlPage {
+	page := sparqlPage{}
+	page.Head.Vars = []string{"uri", "elements", "created", "title", "description", "encoding", "role"}
+	page.Results.Bindings = []map[string]sparqlTerm{}
+
+	for _, c := range components {
+		encoding := "http://www.chem.qmul.ac.uk/iubmb/misc/naseq.html"
+		if c.Protein {
+			encoding = slurp.ProteinEncoding
+		}
+
+		b := map[string]sparqlTerm{
+			"uri":      {Type: "uri", Value: c.URI},
+			"elements": {Type: "literal", Value: c.Sequence},
+			"created":  {Type: "typed-literal", Value: c.Created.UTC().Format(time.RFC3339), Datatype: "http://www.w3.org/2001/XMLSchema#dateTime"},
+			"encoding": {Type: "uri", Value: encoding},
+		}
+		if c.Title != "" {
+			b["title"] = sparqlTerm{Type: "literal", Value: c.Title}
+		}
+		if c.Description != "" {
+			b["description"] = sparqlTerm{Type: "literal", Value: c.Description}
+		}
+		if len(c.Roles) == 0 {
+			page.Results.Bindings = append(page.Results.Bindings, b)
+		}
+		// a row per role, as the join gives
+		for _, role := range c.Roles {
+			row := maps.Clone(b)
+			row["role"] = sparqlTerm{Type: "uri", Value: role}
+			page.Results.Bindings = append(page.Results.Bindings, row)
+		}
+	}
+
+	return page
+}
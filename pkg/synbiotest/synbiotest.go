@@ -0,0 +1,217 @@
+// Package synbiotest runs the whole pipeline, slurping components from a
+// SPARQL endpoint, building them into a db and searching it, without any
+// of the infrastructure it usually needs, so it can be exercised with go
+// test. SynBioHub is stood in for by a SPARQLServer, Redis by miniredis,
+// and blastn by a script printing canned XML:
+//
+//	sparql := synbiotest.NewSPARQLServer(t, components)
+//	st, client := synbiotest.NewStore(t)
+//	_, err := synbiotest.Slurp(ctx, st, client, sparql.Endpoint(), sparql.URL, 10)
+//	db := synbiotest.BuildDB(t, st)
+//	blastn := synbiotest.StubBlastn(t, synbiotest.ResultsXML(query, st.Hash(components[0].Sequence)))
+//	results, err := synbiotest.Search(ctx, st, client, blastn, db, query)
+package synbiotest
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/schnauzer/synbioblast/config"
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/slurp"
+	"github.com/schnauzer/synbioblast/pkg/store"
+)
+
+// NewStore returns a Store with its keys as the flags default them, backed
+// by a miniredis and fasta directories that go away when the test ends.
+func NewStore(t testing.TB) (*store.Store, *redis.Client) {
+	t.Helper()
+
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("couldn't start miniredis: %v", err)
+	}
+	t.Cleanup(m.Close)
+
+	client, err := redis.Dial("tcp", m.Addr())
+	if err != nil {
+		t.Fatalf("couldn't dial miniredis: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	st := config.Store()
+	st.FastaDir = filepath.Join(t.TempDir(), "fastas")
+	st.ProteinDir = filepath.Join(t.TempDir(), "proteins")
+
+	return st, client
+}
+
+// Slurp fetches every component e has, pageSize at a time, and stores
+// them as from source the way the slurper does, less the ORFs. It returns
+// how many components were new.
+func Slurp(ctx context.Context, st *store.Store, client *redis.Client, e slurp.Endpoint, source string, pageSize int) (int, error) {
+	added := 0
+	for offset := 0; ; {
+		body, contentType, err := e.Fetch(ctx, offset, pageSize)
+		if err != nil {
+			return added, err
+		}
+
+		pageCtx, cancel := context.WithCancel(ctx)
+		components := make(chan store.Component)
+		var invalid int
+		var parseErr error
+		parsed := make(chan struct{})
+		go func() {
+			invalid, _, parseErr = slurp.Parse(pageCtx, body, contentType, components)
+			close(parsed)
+		}()
+
+		processed, newURIs, err := process(client, st, source, components)
+		cancel()
+		<-parsed
+		body.Close()
+		if err == nil {
+			err = parseErr
+		}
+		added += newURIs
+		if err != nil {
+			return added, err
+		}
+
+		// duplicates don't move the cursor, see slurp.Parse
+		distinct := processed + invalid
+		if distinct == 0 {
+			return added, nil
+		}
+		offset += distinct
+	}
+}
+
+// process stores the components of a page, as the slurper's process does,
+// returning how many there were and how many were new.
+func process(client *redis.Client, st *store.Store, source string, components <-chan store.Component) (processed, newURIs int, err error) {
+	var newest time.Time
+	for c := range components {
+		processed++
+		c.Source = source
+
+		_, _, err = st.WriteFasta(&c)
+		if err != nil {
+			return processed, newURIs, fmt.Errorf("couldn't write fasta: %v", err)
+		}
+		added, err := st.Add(client, &c)
+		if err != nil {
+			return processed, newURIs, err
+		}
+		if added {
+			newURIs++
+		}
+
+		if c.Created.After(newest) {
+			newest = c.Created
+		}
+	}
+
+	err = st.SyncFastas()
+	if err != nil {
+		return processed, newURIs, fmt.Errorf("couldn't sync fastas: %v", err)
+	}
+
+	return processed, newURIs, st.RecordSlurp(client, newURIs, newest)
+}
+
+// DB is a db BuildDB built.
+type DB struct {
+	// Dir is the directory it's in, passed to blastn as BLASTDB, and Name
+	// what it's called, passed as -db
+	Dir  string
+	Name string
+
+	// Fasta is every sequence in it, which is all there really is to it
+	Fasta string
+}
+
+// BuildDB stands in for builddb.sh, putting every fasta st has written in
+// a versioned db named like the ones it builds, with a manifest pointing
+// at it. The stub blastn doesn't read it, but it's there for tests of
+// what does, and the server finds it where it expects.
+func BuildDB(t testing.TB, st *store.Store) *DB {
+	t.Helper()
+
+	fasta := &strings.Builder{}
+	err := filepath.WalkDir(st.FastaDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".fasta") {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		fasta.Write(b)
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("couldn't read fastas: %v", err)
+	}
+
+	db := &DB{
+		Dir:   t.TempDir(),
+		Name:  *config.BlastDBName + "-" + time.Now().UTC().Format("20060102T150405Z"),
+		Fasta: fasta.String(),
+	}
+	files := map[string]string{
+		db.Name + ".fasta":               db.Fasta,
+		db.Name + ".nin":                 "",
+		*config.BlastDBName + ".current": db.Name + "\n",
+	}
+	for name, contents := range files {
+		err = os.WriteFile(filepath.Join(db.Dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("couldn't write %s: %v", name, err)
+		}
+	}
+
+	return db
+}
+
+// Search runs query through blastn against db, as the query server does,
+// and looks up the components of each hit in st.
+func Search(ctx context.Context, st *store.Store, client *redis.Client, blastn string, db *DB, query string) (*blast.Results, error) {
+	seq, input, err := blast.NormalizeQuery(query, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := blast.Search{
+		Binary:  blastn,
+		DBDir:   db.Dir,
+		Args:    []string{"-db", db.Name, "-outfmt", "5"},
+		Timeout: time.Minute,
+	}.Run(ctx, seq)
+	if err != nil {
+		return results, err
+	}
+	results.Query = seq
+	results.Input = input
+
+	hashes := make([]string, len(results.Results))
+	for i, hit := range results.Results {
+		hashes[i] = hit.SeqHash
+	}
+	uris, err := st.URIs(client, hashes)
+	if err != nil {
+		return results, fmt.Errorf("couldn't look up components: %v", err)
+	}
+	for i := range results.Results {
+		results.Results[i].URIs = uris[i]
+	}
+	results.NumResults = len(results.Results)
+
+	return results, nil
+}
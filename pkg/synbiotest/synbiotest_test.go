@@ -0,0 +1,114 @@
+package synbiotest_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schnauzer/synbioblast/pkg/blast"
+	"github.com/schnauzer/synbioblast/pkg/store"
+	"github.com/schnauzer/synbioblast/pkg/synbiotest"
+)
+
+// TestPipeline slurps components from the fake endpoint a few at a time,
+// builds a db of them and searches it.
+func TestPipeline(t *testing.T) {
+	created := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	components := []store.Component{
+		{
+			URI:      "https://synbiohub.org/public/igem/BBa_R0010/1",
+			Sequence: "caatacgcaaaccgcctctccccgcgcgttggccgattcattaatgcag",
+			Created:  created,
+			Title:    "LacI sensitive, CAP independent promoter",
+			Roles:    []string{"http://identifiers.org/so/SO:0000167", "http://identifiers.org/so/SO:0000057"},
+		},
+		{
+			URI:      "https://synbiohub.org/public/igem/BBa_B0034/1",
+			Sequence: "aaagaggagaaa",
+			Created:  created.Add(time.Hour),
+			Title:    "RBS",
+			Roles:    []string{"http://identifiers.org/so/SO:0000139"},
+		},
+		{
+			URI:      "https://synbiohub.org/public/igem/BBa_R0010/2",
+			Sequence: "caatacgcaaaccgcctctccccgcgcgttggccgattcattaatgcag",
+			Created:  created.Add(2 * time.Hour),
+		},
+		{
+			URI:      "https://synbiohub.org/public/igem/BBa_B0015/1",
+			Sequence: "ccaggcatcaaataaaacgaaaggctcagtcgaaagactgggcctttcgttttatctgttgtttgtcggtgaacgctctc",
+			Created:  created.Add(3 * time.Hour),
+			Roles:    []string{"http://identifiers.org/so/SO:0000141"},
+		},
+	}
+
+	ctx := context.Background()
+	sparql := synbiotest.NewSPARQLServer(t, components)
+	st, client := synbiotest.NewStore(t)
+
+	added, err := synbiotest.Slurp(ctx, st, client, sparql.Endpoint(), sparql.URL, 2)
+	if err != nil || added != len(components) {
+		t.Fatalf("Slurp = %d, %v, want %d, nil", added, err, len(components))
+	}
+	if n := len(sparql.Queries()); n != 3 {
+		t.Errorf("slurped in %d queries, want 3", n)
+	}
+
+	added, err = synbiotest.Slurp(ctx, st, client, sparql.Endpoint(), sparql.URL, 2)
+	if err != nil || added != 0 {
+		t.Errorf("Slurp again = %d, %v, want 0, nil", added, err)
+	}
+
+	promoter := components[0]
+	roles, err := st.ComponentRoles(client, [][]string{{promoter.URI}})
+	if want := []string{"SO:0000167", "SO:0000057"}; err != nil || !reflect.DeepEqual(roles[0][promoter.URI], want) {
+		t.Errorf("roles of %s = %q, %v, want %q", promoter.URI, roles[0][promoter.URI], err, want)
+	}
+
+	db := synbiotest.BuildDB(t, st)
+	hash := st.Hash(promoter.Sequence)
+	if n := strings.Count(db.Fasta, ">"); n != 3 {
+		t.Errorf("db has %d sequences, want 3", n)
+	}
+	if !strings.Contains(db.Fasta, ">"+hash+"\n") {
+		t.Errorf("db is missing %s", hash)
+	}
+
+	query := strings.ToUpper(promoter.Sequence)
+	seq, _, err := blast.NormalizeQuery(query, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blastn := synbiotest.StubBlastn(t, synbiotest.ResultsXML(seq, hash))
+
+	results, err := synbiotest.Search(ctx, st, client, blastn, db, query)
+	if err != nil {
+		t.Fatalf("Search = %v", err)
+	}
+	if results.NumResults != 1 || results.Results[0].SeqHash != hash {
+		t.Fatalf("results = %+v, want one hit on %s", results.Results, hash)
+	}
+	uris := results.Results[0].URIs
+	if want := []string{promoter.URI, components[2].URI}; !sameURIs(uris, want) {
+		t.Errorf("hit's components = %q, want %q", uris, want)
+	}
+}
+
+// sameURIs reports whether a and b have the same uris, in any order.
+func sameURIs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, uri := range a {
+		seen[uri] = true
+	}
+	for _, uri := range b {
+		if !seen[uri] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+// Package webhook posts JSON to URLs users have given us, signed so the
+// receivers can check it came from us, and only ever to hosts on the
+// internet so they can't be used to reach into the server's own network.
+//
+//	s := webhook.Sender{Secret: secret}
+//	err := s.Validate(ctx, hook)
+//	...
+//	err = s.Post(ctx, hook, v)
+//
+// X-Synbioblast-Timestamp is when a webhook was sent in Unix seconds, and
+// X-Synbioblast-Signature is "sha256=" and the hex HMAC-SHA256 of the
+// timestamp, a ".", and the body, see Sign, so old requests can't be
+// replayed.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ErrDisabled is returned for webhooks when there's no secret to sign them
+// with.
+var ErrDisabled = errors.New("can't be used, callbacks and webhooks are disabled on this server")
+
+// Sender validates and posts webhooks. Its zero value has them disabled.
+type Sender struct {
+	// Secret signs each webhook's body, they're disabled without one
+	Secret string
+
+	// Client posts the webhooks, by default one that only connects to
+	// public addresses, see PublicAddr
+	Client *http.Client
+}
+
+// PublicAddr reports whether ip is somewhere on the internet, rather than
+// the server itself or a network it's on that webhooks could reach into.
+func PublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// Validate checks hook is an http or https URL whose host only resolves to
+// public addresses.
+func (s Sender) Validate(ctx context.Context, hook string) error {
+	if s.Secret == "" {
+		return ErrDisabled
+	}
+
+	u, err := url.Parse(hook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("must be an http or https URL")
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("host %s couldn't be resolved", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !PublicAddr(addr) {
+			return fmt.Errorf("host %s isn't on the internet", u.Hostname())
+		}
+	}
+
+	return nil
+}
+
+// dialPublic refuses to connect to anything but public addresses, so
+// webhooks whose hosts have been pointed somewhere else since they were
+// checked, or that redirect there, can't reach the server's own network.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	addr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !PublicAddr(addr.Addr()) {
+		return fmt.Errorf("%s isn't a public address", addr.Addr())
+	}
+
+	return nil
+}
+
+// publicClient posts webhooks directly, never through a proxy, which would
+// be the one dialled, see dialPublic.
+var publicClient = func() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{Timeout: 30 * time.Second, Control: dialPublic}).DialContext
+
+	return &http.Client{Timeout: 30 * time.Second, Transport: t}
+}()
+
+// Sign returns the X-Synbioblast-Signature of a webhook with body sent at
+// timestamp, for receivers to compare with the one they were sent.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Post posts v to hook as JSON, signed with the Sender's Secret.
+func (s Sender) Post(ctx context.Context, hook string, v interface{}) error {
+	if s.Secret == "" {
+		return ErrDisabled
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Synbioblast-Timestamp", timestamp)
+	req.Header.Set("X-Synbioblast-Signature", Sign(s.Secret, timestamp, b))
+
+	client := s.Client
+	if client == nil {
+		client = publicClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/schnauzer/synbioblast/pkg/webhook"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"::ffff:8.8.8.8", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"192.168.0.1", false},
+		{"169.254.169.254", false},
+		{"::ffff:127.0.0.1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+	}
+
+	for _, test := range tests {
+		if got := webhook.PublicAddr(netip.MustParseAddr(test.addr)); got != test.public {
+			t.Errorf("PublicAddr(%s) = %v, want %v", test.addr, got, test.public)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := webhook.Sender{Secret: "secret"}
+
+	tests := []struct {
+		hook    string
+		wantErr bool
+	}{
+		{"https://8.8.8.8/hook", false},
+		{"http://8.8.8.8:8080/hook", false},
+		{"ftp://8.8.8.8/hook", true},
+		{"/hook", true},
+		{"https://127.0.0.1/hook", true},
+		{"http://[::1]/hook", true},
+		{"https://10.0.0.1/hook", true},
+	}
+
+	for _, test := range tests {
+		err := s.Validate(context.Background(), test.hook)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Validate(%s) = %v, want error %v", test.hook, err, test.wantErr)
+		}
+	}
+
+	err := webhook.Sender{}.Validate(context.Background(), "https://8.8.8.8/hook")
+	if err != webhook.ErrDisabled {
+		t.Errorf("Validate without a secret = %v, want %v", err, webhook.ErrDisabled)
+	}
+}
+
+func TestPost(t *testing.T) {
+	var (
+		header http.Header
+		body   []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+
+	s := webhook.Sender{Secret: "secret", Client: srv.Client()}
+	err := s.Post(context.Background(), srv.URL+"/hook", map[string]string{"id": "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := map[string]string{}
+	if err := json.Unmarshal(body, &v); err != nil || v["id"] != "abc" {
+		t.Errorf("posted %s, want the value as JSON", body)
+	}
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	timestamp := header.Get("X-Synbioblast-Timestamp")
+	if want := webhook.Sign("secret", timestamp, body); header.Get("X-Synbioblast-Signature") != want {
+		t.Errorf("signature = %q, want %q", header.Get("X-Synbioblast-Signature"), want)
+	}
+	if webhook.Sign("other", timestamp, body) == webhook.Sign("secret", timestamp, body) {
+		t.Error("signatures with different secrets match")
+	}
+
+	err = s.Post(context.Background(), srv.URL+"/gone", nil)
+	if err == nil || !strings.Contains(err.Error(), "410") {
+		t.Errorf("Post to a hook that's gone = %v, want its status", err)
+	}
+
+	err = webhook.Sender{}.Post(context.Background(), srv.URL+"/hook", nil)
+	if err != webhook.ErrDisabled {
+		t.Errorf("Post without a secret = %v, want %v", err, webhook.ErrDisabled)
+	}
+}
+
+func TestPostPrivate(t *testing.T) {
+	posted := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	}))
+	defer srv.Close()
+
+	// the default client won't connect to the test server on localhost
+	err := webhook.Sender{Secret: "secret"}.Post(context.Background(), srv.URL, nil)
+	if err == nil || posted {
+		t.Errorf("Post to localhost = %v, want it refused", err)
+	}
+	if errors.Is(err, webhook.ErrDisabled) {
+		t.Errorf("Post to localhost = %v, want it refused as private", err)
+	}
+}
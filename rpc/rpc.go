@@ -0,0 +1,282 @@
+// Package rpc defines the gRPC interface to SynBioBLAST.
+//
+// There's no protoc step: messages are plain Go structs sent with a JSON
+// codec registered under the "json" content subtype, so non-Go clients
+// need to send "application/grpc+json". Go clients should use
+// NewSearchClient, which sets this up for them.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+// SubmitRequest queues a new search.
+type SubmitRequest struct {
+	Sequence string `json:"sequence"`
+
+	// Description limits the hits to components whose title or description
+	// contain all of these words.
+	Description string `json:"description,omitempty"`
+
+	// Roles limits the hits to components with one of these roles, by
+	// glyph name like "promoter" or Sequence Ontology term.
+	Roles []string `json:"roles,omitempty"`
+
+	// Containment limits the hits to those overlapping the query in one of
+	// these ways, e.g. "query-contains-part".
+	Containment []string `json:"containment,omitempty"`
+
+	// MaxRecords is how many FASTA records Sequence may have, each searched
+	// separately, 1 if it's 0.
+	MaxRecords int `json:"maxRecords,omitempty"`
+
+	// Threads is the number of threads blastn may use, the server's
+	// default if 0.
+	Threads int `json:"threads,omitempty"`
+
+	// Aligner is "blastn" (the default), "diamond" for protein searches or
+	// "vsearch" for quick searches for close matches, if the server has
+	// them.
+	Aligner string `json:"aligner,omitempty"`
+
+	// Matrix, GapOpen and GapExtend are the scoring matrix and gap costs of
+	// protein searches, blastp's defaults if they're unset.
+	Matrix    string `json:"matrix,omitempty"`
+	GapOpen   int    `json:"gapOpen,omitempty"`
+	GapExtend int    `json:"gapExtend,omitempty"`
+
+	// Circular searches the sequence as a circular one, e.g. a plasmid.
+	Circular bool `json:"circular,omitempty"`
+
+	// Clustered searches the representatives of clusters of near-identical
+	// sequences and expands their hits to the rest of their clusters, if
+	// the server's db was clustered.
+	Clustered bool `json:"clustered,omitempty"`
+
+	// Subject is FASTA of sequences to search instead of the db, if the
+	// server has makeblastdb.
+	Subject string `json:"subject,omitempty"`
+
+	// DBVersion searches an older version of the db the server has kept,
+	// resolving hits to the components it was built with.
+	DBVersion string `json:"dbVersion,omitempty"`
+
+	// Callback is a URL the server posts to once the job has finished, so
+	// there's no need to poll.
+	Callback string `json:"callback,omitempty"`
+
+	// Email is an address mailed once the job has finished, if the server
+	// has a mail server.
+	Email string `json:"email,omitempty"`
+}
+
+// SubmitResponse identifies the job created by Submit.
+type SubmitResponse struct {
+	JobID string `json:"jobId"`
+}
+
+// GetResultRequest asks for the current state of a job.
+type GetResultRequest struct {
+	JobID string `json:"jobId"`
+}
+
+// StreamHitsRequest asks for the hits of a job as they become available.
+type StreamHitsRequest struct {
+	JobID string `json:"jobId"`
+}
+
+// Job is the state of a submitted search. Hits is only set once Status is
+// "done".
+type Job struct {
+	ID        string        `json:"id"`
+	Status    string        `json:"status"`
+	Query     string        `json:"query"`
+	Error     string        `json:"error,omitempty"`
+	Submitted time.Time     `json:"submitted"`
+	Finished  time.Time     `json:"finished"`
+	Duration  time.Duration `json:"duration"`
+	Hits      []*Hit        `json:"hits,omitempty"`
+}
+
+// Hit is a single database sequence matching the query.
+type Hit struct {
+	SeqHash  string   `json:"seqHash"`
+	BitScore float64  `json:"bitScore"`
+	Score    int      `json:"score"`
+	EValue   float64  `json:"evalue"`
+	QuerySeq string   `json:"querySeq"`
+	Midline  string   `json:"midline"`
+	HitSeq   string   `json:"hitSeq"`
+	URIs     []string `json:"uris"`
+
+	// Strand is "plus" or "minus" for nucleotide hits
+	Strand string `json:"strand,omitempty"`
+
+	// SimilarityClass is the server's badge for how identical the hit is
+	SimilarityClass string `json:"similarityClass,omitempty"`
+}
+
+// SearchServer is implemented by the query server.
+type SearchServer interface {
+	Submit(context.Context, *SubmitRequest) (*SubmitResponse, error)
+	GetResult(context.Context, *GetResultRequest) (*Job, error)
+	StreamHits(*StreamHitsRequest, Search_StreamHitsServer) error
+}
+
+// Search_StreamHitsServer is the server side of a StreamHits call.
+type Search_StreamHitsServer interface {
+	Send(*Hit) error
+	grpc.ServerStream
+}
+
+// RegisterSearchServer registers srv with s.
+func RegisterSearchServer(s *grpc.Server, srv SearchServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "synbioblast.Search",
+	HandlerType: (*SearchServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Submit", Handler: submitHandler},
+		{MethodName: "GetResult", Handler: getResultHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "StreamHits", Handler: streamHitsHandler, ServerStreams: true},
+	},
+}
+
+func submitHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := &SubmitRequest{}
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServer).Submit(ctx, in)
+	}
+
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/synbioblast.Search/Submit"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServer).Submit(ctx, req.(*SubmitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func getResultHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := &GetResultRequest{}
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SearchServer).GetResult(ctx, in)
+	}
+
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/synbioblast.Search/GetResult"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SearchServer).GetResult(ctx, req.(*GetResultRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func streamHitsHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := &StreamHitsRequest{}
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(SearchServer).StreamHits(in, &streamHitsServer{stream})
+}
+
+type streamHitsServer struct {
+	grpc.ServerStream
+}
+
+func (s *streamHitsServer) Send(h *Hit) error {
+	return s.ServerStream.SendMsg(h)
+}
+
+// SearchClient is the client side of the Search service.
+type SearchClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewSearchClient returns a client using cc, which must not be shared with
+// protobuf services since every call is sent with the json codec.
+func NewSearchClient(cc *grpc.ClientConn) *SearchClient {
+	return &SearchClient{cc}
+}
+
+// Submit queues a search and returns its job ID.
+func (c *SearchClient) Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error) {
+	out := &SubmitResponse{}
+	opts = append(opts, grpc.CallContentSubtype("json"))
+	err := c.cc.Invoke(ctx, "/synbioblast.Search/Submit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetResult returns the current state of a job.
+func (c *SearchClient) GetResult(ctx context.Context, in *GetResultRequest, opts ...grpc.CallOption) (*Job, error) {
+	out := &Job{}
+	opts = append(opts, grpc.CallContentSubtype("json"))
+	err := c.cc.Invoke(ctx, "/synbioblast.Search/GetResult", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StreamHits waits for a job to finish and receives its hits one at a time.
+// Recv returns io.EOF after the last hit.
+func (c *SearchClient) StreamHits(ctx context.Context, in *StreamHitsRequest, opts ...grpc.CallOption) (*HitStream, error) {
+	opts = append(opts, grpc.CallContentSubtype("json"))
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], "/synbioblast.Search/StreamHits", opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &HitStream{stream}, nil
+}
+
+// HitStream is the client side of a StreamHits call.
+type HitStream struct {
+	grpc.ClientStream
+}
+
+// Recv returns the next hit.
+func (s *HitStream) Recv() (*Hit, error) {
+	h := &Hit{}
+	if err := s.ClientStream.RecvMsg(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
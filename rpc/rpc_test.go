@@ -0,0 +1,149 @@
+package rpc_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/schnauzer/synbioblast/rpc"
+)
+
+// searchServer has one job, "abc", which has found hits for whatever was
+// last submitted.
+type searchServer struct {
+	submitted *rpc.SubmitRequest
+}
+
+func (s *searchServer) Submit(ctx context.Context, req *rpc.SubmitRequest) (*rpc.SubmitResponse, error) {
+	if req.Sequence == "" {
+		return nil, status.Error(codes.InvalidArgument, "sequence is required")
+	}
+	s.submitted = req
+
+	return &rpc.SubmitResponse{JobID: "abc"}, nil
+}
+
+func (s *searchServer) hits() []*rpc.Hit {
+	return []*rpc.Hit{
+		{SeqHash: "h1", BitScore: 50, QuerySeq: s.submitted.Sequence, URIs: []string{"https://synbiohub.org/public/igem/BBa_B0034/1"}},
+		{SeqHash: "h2", BitScore: 40, Strand: "minus"},
+	}
+}
+
+func (s *searchServer) GetResult(ctx context.Context, req *rpc.GetResultRequest) (*rpc.Job, error) {
+	if req.JobID != "abc" {
+		return nil, status.Error(codes.NotFound, "no job with id "+req.JobID)
+	}
+
+	return &rpc.Job{ID: "abc", Status: "done", Query: s.submitted.Sequence, Hits: s.hits()}, nil
+}
+
+func (s *searchServer) StreamHits(req *rpc.StreamHitsRequest, stream rpc.Search_StreamHitsServer) error {
+	for _, hit := range s.hits() {
+		err := stream.Send(hit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func dial(t *testing.T) *rpc.SearchClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	rpc.RegisterSearchServer(s, &searchServer{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	return rpc.NewSearchClient(cc)
+}
+
+func TestSearch(t *testing.T) {
+	c := dial(t)
+	ctx := context.Background()
+
+	resp, err := c.Submit(ctx, &rpc.SubmitRequest{Sequence: "acgt", Roles: []string{"promoter"}, MaxRecords: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.JobID != "abc" {
+		t.Errorf("Submit = %q, want abc", resp.JobID)
+	}
+
+	j, err := c.GetResult(ctx, &rpc.GetResultRequest{JobID: resp.JobID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Status != "done" || j.Query != "acgt" || len(j.Hits) != 2 || j.Hits[0].QuerySeq != "acgt" || len(j.Hits[0].URIs) != 1 {
+		t.Errorf("GetResult = %+v, want acgt's two hits", j)
+	}
+
+	stream, err := c.StreamHits(ctx, &rpc.StreamHitsRequest{JobID: resp.JobID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes []string
+	for {
+		hit, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hit.SeqHash)
+	}
+	if len(hashes) != 2 || hashes[0] != "h1" || hashes[1] != "h2" {
+		t.Errorf("streamed %q, want [h1 h2]", hashes)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := dial(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"invalid", func() error {
+			_, err := c.Submit(ctx, &rpc.SubmitRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"not found", func() error {
+			_, err := c.GetResult(ctx, &rpc.GetResultRequest{JobID: "missing"})
+			return err
+		}, codes.NotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call()
+			if status.Code(err) != test.code {
+				t.Errorf("err = %v, want code %s", err, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package sdnotify tells systemd how a daemon started with Type=notify is
+// doing, so it knows when the daemon is ready and can restart it if it stops
+// pinging the watchdog.
+//
+// Everything is a no-op when systemd didn't ask for notifications, so the
+// daemons run the same way outside of it.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// States that can be sent with Notify.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends state to systemd, e.g. Ready once the daemon is up. It does
+// nothing if $NOTIFY_SOCKET isn't set.
+func Notify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+
+	// a leading @ means an abstract socket
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns how often systemd expects to hear Watchdog from
+// this process, which is half of WatchdogSec= to leave some slack. It's 0 if
+// the watchdog isn't enabled.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	// WATCHDOG_PID is set when the variables might have been inherited by
+	// a child they weren't meant for
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
@@ -0,0 +1,91 @@
+// Draws the hits of a query as bars along the query sequence, like the
+// graphic summary on NCBI's BLAST results page. Expects the data from
+// /api/v1/search?format=viewer.
+function drawViewer(container, data, zoom) {
+    var svgNS = "http://www.w3.org/2000/svg";
+    var width = 800 * zoom;
+    var margin = 10;
+    var rowHeight = 14;
+    var axisHeight = 30;
+
+    // formatted like blast's own reports, vsearch hits have no e-value
+    var formatEValue = function(evalue) {
+        if (evalue < 0) {
+            return "n/a";
+        }
+        if (evalue == 0) {
+            return "0.0";
+        }
+        return evalue < 1e-3 ? evalue.toExponential(0) : evalue.toPrecision(2);
+    };
+
+    var scale = function(pos) {
+        return margin + (pos - 1) * (width - 2 * margin) / Math.max(data.queryLen, 1);
+    };
+
+    // the best hit gets the darkest bar
+    var maxScore = 0;
+    data.hits.forEach(function(hit) {
+        maxScore = Math.max(maxScore, hit.bitScore);
+    });
+
+    var svg = document.createElementNS(svgNS, "svg");
+    svg.setAttribute("width", width);
+    svg.setAttribute("height", axisHeight + data.tracks * rowHeight + margin);
+
+    var axis = document.createElementNS(svgNS, "rect");
+    axis.setAttribute("x", scale(1));
+    axis.setAttribute("y", 5);
+    axis.setAttribute("width", scale(data.queryLen) - scale(1));
+    axis.setAttribute("height", 8);
+    axis.setAttribute("fill", "#888");
+    svg.appendChild(axis);
+
+    var label = document.createElementNS(svgNS, "text");
+    label.setAttribute("x", scale(data.queryLen));
+    label.setAttribute("y", 25);
+    label.setAttribute("text-anchor", "end");
+    label.setAttribute("font-size", "10");
+    label.textContent = data.queryLen + " bp";
+    svg.appendChild(label);
+
+    // hits that don't overlap share a track, see stackTracks
+    data.hits.forEach(function(hit) {
+        hit.hsps.forEach(function(hsp) {
+            var bar = document.createElementNS(svgNS, "rect");
+            var from = Math.min(hsp.queryFrom, hsp.queryTo);
+            var to = Math.max(hsp.queryFrom, hsp.queryTo);
+
+            bar.setAttribute("x", scale(from));
+            bar.setAttribute("y", axisHeight + hit.track * rowHeight);
+            bar.setAttribute("width", Math.max(scale(to) - scale(from), 1));
+            bar.setAttribute("height", rowHeight - 4);
+            // minus strand hits are blue so they stand out
+            bar.setAttribute("fill", hsp.strand == "minus" ? "rgb(0, 0, 200)" : "rgb(200, 0, 0)");
+            bar.setAttribute("fill-opacity", 0.25 + 0.75 * hit.bitScore / Math.max(maxScore, 1));
+
+            var title = document.createElementNS(svgNS, "title");
+            title.textContent = hit.title + "\nquery " + hsp.queryFrom + "-" + hsp.queryTo +
+                ", hit " + hsp.hitFrom + "-" + hsp.hitTo + (hsp.strand ? " (" + hsp.strand + ")" : "") +
+                "\n" + hsp.identity + "/" + hsp.alignLen + " identical, e-value " + formatEValue(hit.evalue);
+            bar.appendChild(title);
+
+            svg.appendChild(bar);
+
+            // the hit's SBOL Visual glyph sits at the start of its bar
+            if (hit.glyph) {
+                var glyph = document.createElementNS(svgNS, "image");
+                glyph.setAttribute("href", data.staticPath + "glyphs/" + hit.glyph + ".svg");
+                glyph.setAttribute("x", scale(from));
+                glyph.setAttribute("y", axisHeight + hit.track * rowHeight);
+                glyph.setAttribute("width", rowHeight - 4);
+                glyph.setAttribute("height", rowHeight - 4);
+                glyph.appendChild(title.cloneNode(true));
+                svg.appendChild(glyph);
+            }
+        });
+    });
+
+    container.innerHTML = "";
+    container.appendChild(svg);
+}
@@ -0,0 +1,150 @@
+// Package textindex is a tiny inverted index of component names and
+// descriptions kept in Redis, so searches can be narrowed down to parts
+// whose description mentions something.
+//
+// Every word of a component's text gets a set at <prefix>:word:<word>
+// holding the URIs of the components that use it, and the text itself is
+// kept in a hash at <prefix>:text:<uri>.
+package textindex
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Part is an indexed component.
+type Part struct {
+	URI         string `json:"uri"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// words too common to be worth a set of their own
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+	"it": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "with": true,
+}
+
+// Tokens splits s into the lowercased, de-duplicated words that get indexed.
+func Tokens(s string) []string {
+	seen := map[string]bool{}
+	var tokens []string
+
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, f := range fields {
+		if len(f) < 2 || stopWords[f] || seen[f] {
+			continue
+		}
+		seen[f] = true
+		tokens = append(tokens, f)
+	}
+
+	return tokens
+}
+
+func wordKey(prefix, word string) string {
+	return prefix + ":word:" + word
+}
+
+func textKey(prefix, uri string) string {
+	return prefix + ":text:" + uri
+}
+
+// Add indexes the title and description of a component.
+func Add(client *redis.Client, prefix string, p Part) error {
+	if p.Title == "" && p.Description == "" {
+		return nil
+	}
+
+	err := client.Cmd("HMSET", textKey(prefix, p.URI), "title", p.Title, "description", p.Description).Err
+	if err != nil {
+		return err
+	}
+
+	for _, word := range Tokens(p.Title + " " + p.Description) {
+		err = client.Cmd("SADD", wordKey(prefix, word), p.URI).Err
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove takes a component out of the index.
+func Remove(client *redis.Client, prefix, uri string) error {
+	text, err := client.Cmd("HGETALL", textKey(prefix, uri)).Map()
+	if err != nil {
+		return err
+	}
+
+	for _, word := range Tokens(text["title"] + " " + text["description"]) {
+		err = client.Cmd("SREM", wordKey(prefix, word), uri).Err
+		if err != nil {
+			return err
+		}
+	}
+
+	return client.Cmd("DEL", textKey(prefix, uri)).Err
+}
+
+// Matching returns the URIs of components whose text contains every word
+// of query.
+func Matching(client *redis.Client, prefix, query string) (map[string]bool, error) {
+	words := Tokens(query)
+	if len(words) == 0 {
+		return map[string]bool{}, nil
+	}
+
+	keys := make([]interface{}, len(words))
+	for i, word := range words {
+		keys[i] = wordKey(prefix, word)
+	}
+
+	uris, err := client.Cmd("SINTER", keys...).List()
+	if err != nil {
+		return nil, err
+	}
+
+	matching := make(map[string]bool, len(uris))
+	for _, uri := range uris {
+		matching[uri] = true
+	}
+
+	return matching, nil
+}
+
+// Search returns the components whose text contains every word of query,
+// up to limit of them.
+func Search(client *redis.Client, prefix, query string, limit int) ([]Part, error) {
+	matching, err := Matching(client, prefix, query)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []Part{}
+	for uri := range matching {
+		if len(parts) >= limit {
+			break
+		}
+
+		text, err := client.Cmd("HGETALL", textKey(prefix, uri)).Map()
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, Part{
+			URI:         uri,
+			Title:       text["title"],
+			Description: text["description"],
+		})
+	}
+
+	return parts, nil
+}
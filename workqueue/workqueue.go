@@ -0,0 +1,269 @@
+// Package workqueue hands blast queries from the query server to
+// synbioblast-worker processes through Redis, so searches can run on other
+// machines.
+//
+// Tasks are pushed onto <prefix>:pending. A worker moves a task to
+// <prefix>:processing while it runs it and keeps <prefix>:lease:<id> alive
+// until it pushes the result onto <prefix>:results:<server>, the list of the
+// server that pushed the task, so each server only gets results for its own
+// tasks. If a worker dies its
+// lease expires and the Reaper puts the task back on the pending list for
+// another worker to pick up.
+package workqueue
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// Task is a blastn run. Args are passed straight to blastn, which reads
+// Sequence from stdin. Server is who's waiting for the result, see
+// NextResult.
+type Task struct {
+	ID       string   `json:"id"`
+	Sequence string   `json:"sequence"`
+	Args     []string `json:"args"`
+	Server   string   `json:"server,omitempty"`
+}
+
+// Result is what came out of running a Task. Error is set if blastn couldn't
+// be run at all, otherwise ExitCode is its exit status. Truncated is set if
+// Stdout was cut off at the worker's limit.
+type Result struct {
+	ID        string `json:"id"`
+	Worker    string `json:"worker"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	ExitCode  int    `json:"exitCode"`
+	Error     string `json:"error,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// ResultsTTL is how long a server's results list is kept after a result is
+// last pushed onto it, so the results of a server that's gone don't pile up.
+const ResultsTTL = time.Hour
+
+func pendingKey(prefix string) string {
+	return prefix + ":pending"
+}
+
+func processingKey(prefix string) string {
+	return prefix + ":processing"
+}
+
+// resultsKey is server's results list. Tasks from servers that predate
+// per-server lists have no Server, and share the old list.
+func resultsKey(prefix, server string) string {
+	if server == "" {
+		return prefix + ":results"
+	}
+	return prefix + ":results:" + server
+}
+
+func leaseKey(prefix, id string) string {
+	return prefix + ":lease:" + id
+}
+
+func workersKey(prefix string) string {
+	return prefix + ":workers"
+}
+
+// Push queues a task.
+func Push(client *redis.Client, prefix string, t Task) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	return client.Cmd("LPUSH", pendingKey(prefix), b).Err
+}
+
+// Cancel removes a task nobody has started yet. It's fine if one already
+// has, their result will just be ignored.
+func Cancel(client *redis.Client, prefix string, t Task) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	return client.Cmd("LREM", pendingKey(prefix), 1, b).Err
+}
+
+// Take waits up to timeout for a task and moves it to the processing list.
+// It returns nil if there wasn't one. raw must be passed to Finish.
+func Take(client *redis.Client, prefix string, timeout time.Duration) (t *Task, raw string, err error) {
+	resp := client.Cmd("BRPOPLPUSH", pendingKey(prefix), processingKey(prefix), int(timeout.Seconds()))
+	if resp.IsType(redis.Nil) {
+		return nil, "", nil
+	}
+
+	raw, err = resp.Str()
+	if err != nil {
+		return nil, "", err
+	}
+
+	t = &Task{}
+	err = json.Unmarshal([]byte(raw), t)
+	if err != nil {
+		// leaving it would have the reaper hand it out forever
+		client.Cmd("LREM", processingKey(prefix), 1, raw)
+		return nil, "", fmt.Errorf("dropped malformed task %q: %v", raw, err)
+	}
+
+	return t, raw, nil
+}
+
+// Lease claims or renews the claim on a task for ttl.
+func Lease(client *redis.Client, prefix, id, worker string, ttl time.Duration) error {
+	return client.Cmd("SET", leaseKey(prefix, id), worker, "PX", int64(ttl/time.Millisecond)).Err
+}
+
+// Finish hands back the result of a task taken with Take to the server that
+// pushed it.
+func Finish(client *redis.Client, prefix string, t *Task, raw string, r Result) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	results := resultsKey(prefix, t.Server)
+	client.PipeAppend("LPUSH", results, b)
+	client.PipeAppend("PEXPIRE", results, int64(ResultsTTL/time.Millisecond))
+	client.PipeAppend("LREM", processingKey(prefix), 1, raw)
+	client.PipeAppend("DEL", leaseKey(prefix, r.ID))
+	for i := 0; i < 4; i++ {
+		err = client.PipeResp().Err
+		if err != nil {
+			client.PipeClear()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NextResult waits up to timeout for one of server's tasks to finish. It
+// returns nil if none did.
+func NextResult(client *redis.Client, prefix, server string, timeout time.Duration) (*Result, error) {
+	resp := client.Cmd("BRPOP", resultsKey(prefix, server), int(timeout.Seconds()))
+	if resp.IsType(redis.Nil) {
+		return nil, nil
+	}
+
+	// BRPOP replies with the key and the value
+	reply, err := resp.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 2 {
+		return nil, fmt.Errorf("unexpected BRPOP reply %q", reply)
+	}
+
+	r := &Result{}
+	err = json.Unmarshal([]byte(reply[1]), r)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Heartbeat records that a worker is alive.
+func Heartbeat(client *redis.Client, prefix, worker string) error {
+	return client.Cmd("ZADD", workersKey(prefix), time.Now().Unix(), worker).Err
+}
+
+// LiveWorkers counts the workers that sent a heartbeat in the last ttl, and
+// forgets the ones that didn't.
+func LiveWorkers(client *redis.Client, prefix string, ttl time.Duration) (int, error) {
+	cutoff := time.Now().Add(-ttl).Unix()
+
+	err := client.Cmd("ZREMRANGEBYSCORE", workersKey(prefix), "-inf", cutoff).Err
+	if err != nil {
+		return 0, err
+	}
+
+	return client.Cmd("ZCARD", workersKey(prefix)).Int()
+}
+
+// Reaper puts tasks whose worker stopped renewing its lease back on the
+// pending list.
+type Reaper struct {
+	Prefix string
+	TTL    time.Duration
+
+	// when each task in the processing list was first seen without a
+	// lease. A worker takes a task before leasing it, so tasks get a
+	// grace period before they count as abandoned.
+	unleased map[string]time.Time
+}
+
+// Reap requeues abandoned tasks and returns how many there were.
+func (r *Reaper) Reap(client *redis.Client) (int, error) {
+	if r.unleased == nil {
+		r.unleased = map[string]time.Time{}
+	}
+
+	processing, err := client.Cmd("LRANGE", processingKey(r.Prefix), 0, -1).List()
+	if err != nil {
+		return 0, err
+	}
+
+	stillProcessing := map[string]bool{}
+	requeued := 0
+	for _, raw := range processing {
+		stillProcessing[raw] = true
+
+		t := Task{}
+		err = json.Unmarshal([]byte(raw), &t)
+		if err != nil {
+			client.Cmd("LREM", processingKey(r.Prefix), 1, raw)
+			continue
+		}
+
+		leased, err := client.Cmd("EXISTS", leaseKey(r.Prefix, t.ID)).Int()
+		if err != nil {
+			return requeued, err
+		}
+		if leased == 1 {
+			delete(r.unleased, raw)
+			continue
+		}
+
+		first, ok := r.unleased[raw]
+		if !ok {
+			r.unleased[raw] = time.Now()
+			continue
+		}
+		if time.Since(first) < r.TTL {
+			continue
+		}
+
+		// only whoever manages to remove it puts it back, in case there's
+		// more than one reaper
+		removed, err := client.Cmd("LREM", processingKey(r.Prefix), 1, raw).Int()
+		if err != nil {
+			return requeued, err
+		}
+		if removed == 1 {
+			// the right end is where workers take from, so it's next
+			err = client.Cmd("RPUSH", pendingKey(r.Prefix), raw).Err
+			if err != nil {
+				return requeued, err
+			}
+			requeued++
+		}
+		delete(r.unleased, raw)
+	}
+
+	for raw := range r.unleased {
+		if !stillProcessing[raw] {
+			delete(r.unleased, raw)
+		}
+	}
+
+	return requeued, nil
+}
@@ -0,0 +1,179 @@
+package workqueue_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix.v2/redis"
+
+	"github.com/schnauzer/synbioblast/workqueue"
+)
+
+func dial(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	m, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	client, err := redis.Dial("tcp", m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return m, client
+}
+
+func TestRoundTrip(t *testing.T) {
+	m, client := dial(t)
+
+	tasks := []workqueue.Task{
+		{ID: "1", Sequence: "acgt", Args: []string{"-outfmt", "5"}, Server: "a"},
+		{ID: "2", Sequence: "tttt", Server: "b"},
+	}
+	for _, task := range tasks {
+		err := workqueue.Push(client, "test", task)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range tasks {
+		task, raw, err := workqueue.Take(client, "test", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task == nil || !reflect.DeepEqual(*task, want) {
+			t.Fatalf("Take = %+v, want %+v, oldest first", task, want)
+		}
+
+		err = workqueue.Lease(client, "test", task.ID, "worker", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = workqueue.Finish(client, "test", task, raw, workqueue.Result{ID: task.ID, Worker: "worker", Stdout: "<xml>"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Exists("test:lease:" + task.ID) {
+			t.Errorf("task %s's lease is left after it finished", task.ID)
+		}
+	}
+
+	// each server only gets its own results
+	for _, task := range tasks {
+		r, err := workqueue.NextResult(client, "test", task.Server, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r == nil || r.ID != task.ID || r.Stdout != "<xml>" {
+			t.Errorf("server %s's result = %+v, want task %s's", task.Server, r, task.ID)
+		}
+	}
+
+	if processing, _ := m.List("test:processing"); len(processing) != 0 {
+		t.Errorf("%q still processing, want none", processing)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	_, client := dial(t)
+
+	task := workqueue.Task{ID: "1", Sequence: "acgt"}
+	err := workqueue.Push(client, "test", task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = workqueue.Cancel(client, "test", task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := workqueue.Take(client, "test", time.Second)
+	if err != nil || got != nil {
+		t.Errorf("Take after Cancel = %+v, %v, want nothing", got, err)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	m, client := dial(t)
+
+	m.Lpush("test:pending", "{")
+	_, _, err := workqueue.Take(client, "test", time.Second)
+	if err == nil {
+		t.Error("Take of a malformed task = nil error, want one")
+	}
+	if processing, _ := m.List("test:processing"); len(processing) != 0 {
+		t.Errorf("malformed task left processing: %q", processing)
+	}
+}
+
+func TestReaper(t *testing.T) {
+	m, client := dial(t)
+
+	for _, id := range []string{"leased", "abandoned"} {
+		err := workqueue.Push(client, "test", workqueue.Task{ID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = workqueue.Take(client, "test", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := workqueue.Lease(client, "test", "leased", "worker", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &workqueue.Reaper{Prefix: "test", TTL: time.Millisecond}
+
+	// tasks get a grace period to be leased in
+	n, err := r.Reap(client)
+	if err != nil || n != 0 {
+		t.Errorf("first Reap = %d, %v, want 0", n, err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	n, err = r.Reap(client)
+	if err != nil || n != 1 {
+		t.Errorf("Reap = %d, %v, want 1", n, err)
+	}
+	task, _, err := workqueue.Take(client, "test", time.Second)
+	if err != nil || task == nil || task.ID != "abandoned" {
+		t.Errorf("Take after Reap = %+v, %v, want the abandoned task", task, err)
+	}
+
+	// and one whose worker died
+	m.FastForward(time.Minute)
+	r.Reap(client)
+	time.Sleep(2 * time.Millisecond)
+	n, err = r.Reap(client)
+	if err != nil || n != 2 {
+		t.Errorf("Reap after the lease expired = %d, %v, want 2", n, err)
+	}
+}
+
+func TestLiveWorkers(t *testing.T) {
+	m, client := dial(t)
+
+	for _, worker := range []string{"a", "b"} {
+		err := workqueue.Heartbeat(client, "test", worker)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.ZAdd("test:workers", float64(time.Now().Add(-time.Hour).Unix()), "dead")
+
+	n, err := workqueue.LiveWorkers(client, "test", time.Minute)
+	if err != nil || n != 2 {
+		t.Errorf("LiveWorkers = %d, %v, want 2", n, err)
+	}
+	if members, _ := m.ZMembers("test:workers"); len(members) != 2 {
+		t.Errorf("workers %q are remembered, want the dead one forgotten", members)
+	}
+}